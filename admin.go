@@ -0,0 +1,365 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AdminAuth middleware requires a Bearer token matching ADMIN_TOKEN.
+// Admin endpoints are disabled entirely when no token is configured.
+func (s *Server) AdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			JSONError(w, "Admin endpoints are disabled", http.StatusForbidden)
+			return
+		}
+		if !s.validAdminToken(r) {
+			JSONError(w, "Invalid admin token", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validAdminToken reports whether r carries ADMIN_TOKEN as a Bearer token
+func (s *Server) validAdminToken(r *http.Request) bool {
+	if s.adminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1
+}
+
+// AdminModelRequest represents a request to change the active model
+type AdminModelRequest struct {
+	Model string `json:"model"`
+}
+
+// AdminModelResponse reports the active model and the models it may be switched to
+type AdminModelResponse struct {
+	Model         string   `json:"model"`
+	AllowedModels []string `json:"allowed_models"`
+}
+
+// writeJSON writes an uncompressed JSON response
+func writeJSON(w http.ResponseWriter, data interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(data)
+}
+
+// AdminModelHandler handles GET and POST /admin/model
+func (s *Server) AdminModelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		bodyBytes, err := readRequestBody(w, r, s.bodyReadTimeout)
+		if err != nil {
+			writeBodyReadError(w, err)
+			return
+		}
+
+		var req AdminModelRequest
+		if err := json.Unmarshal(bodyBytes, &req); err != nil {
+			JSONDecodeError(w, bodyBytes, err)
+			return
+		}
+		if err := s.client.SetModel(req.Model); err != nil {
+			JSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("[%s] Active model changed to %s", requestIDFromContext(r.Context()), s.client.Model())
+	}
+
+	resp := AdminModelResponse{
+		Model:         s.client.Model(),
+		AllowedModels: s.client.AllowedModels,
+	}
+	if err := writeJSON(w, resp); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// AdminCacheFlushRequest optionally limits a flush to keys with the given prefix
+type AdminCacheFlushRequest struct {
+	Prefix string `json:"prefix"`
+}
+
+// AdminCacheFlushResponse reports how many cache entries were evicted
+type AdminCacheFlushResponse struct {
+	Evicted int `json:"evicted"`
+}
+
+// AdminCacheFlushHandler handles POST /admin/cache/flush
+func (s *Server) AdminCacheFlushHandler(w http.ResponseWriter, r *http.Request) {
+	bodyBytes, err := readRequestBody(w, r, s.bodyReadTimeout)
+	if err != nil {
+		writeBodyReadError(w, err)
+		return
+	}
+
+	var req AdminCacheFlushRequest
+	if len(strings.TrimSpace(string(bodyBytes))) > 0 {
+		if err := json.Unmarshal(bodyBytes, &req); err != nil {
+			JSONDecodeError(w, bodyBytes, err)
+			return
+		}
+	}
+
+	if s.client.Cache == nil {
+		JSONError(w, "Cache is disabled", http.StatusConflict)
+		return
+	}
+
+	evicted, err := s.client.Cache.Flush(r.Context(), req.Prefix)
+	if err != nil {
+		log.Printf("Error flushing cache: %v", err)
+		JSONError(w, "Failed to flush cache", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[%s] Flushed %d cache entries (prefix %q)", requestIDFromContext(r.Context()), evicted, req.Prefix)
+
+	if err := writeJSON(w, AdminCacheFlushResponse{Evicted: evicted}); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// AdminTaxonomyValidateResponse lists the problems found in a taxonomy
+type AdminTaxonomyValidateResponse struct {
+	Valid    bool              `json:"valid"`
+	Problems []ValidationError `json:"problems"`
+}
+
+// AdminTaxonomyValidateHandler handles POST /admin/taxonomy/validate. It lints
+// the uploaded taxonomy locally without calling the model.
+func (s *Server) AdminTaxonomyValidateHandler(w http.ResponseWriter, r *http.Request) {
+	bodyBytes, err := readRequestBody(w, r, s.bodyReadTimeout)
+	if err != nil {
+		writeBodyReadError(w, err)
+		return
+	}
+
+	var taxonomy Taxonomy
+	if err := json.Unmarshal(bodyBytes, &taxonomy); err != nil {
+		JSONDecodeError(w, bodyBytes, err)
+		return
+	}
+
+	problems := taxonomy.Validate()
+	if problems == nil {
+		problems = []ValidationError{}
+	}
+	if err := writeJSON(w, AdminTaxonomyValidateResponse{Valid: len(problems) == 0, Problems: problems}); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// redactedValue replaces secrets in admin responses
+const redactedValue = "[redacted]"
+
+// AdminConfigSettings is the JSON form of the settings PATCH /admin/config may change
+type AdminConfigSettings struct {
+	SummarizeTimeout     string  `json:"summarize_timeout"`
+	ClassifyTimeout      string  `json:"classify_timeout"`
+	DraftTimeout         string  `json:"draft_timeout"`
+	SummarizeTemperature float64 `json:"summarize_temperature"`
+	ClassifyTemperature  float64 `json:"classify_temperature"`
+	DraftTemperature     float64 `json:"draft_temperature"`
+	ReviewThreshold      float64 `json:"review_threshold"`
+	NetMaxRetries        int     `json:"net_max_retries"`
+	ServerMaxRetries     int     `json:"server_max_retries"`
+	RateLimitMaxRetries  int     `json:"rate_limit_max_retries"`
+	RetryAfterMax        string  `json:"retry_after_max"`
+}
+
+// adminConfigSettings converts runtime settings to their JSON form
+func adminConfigSettings(s RuntimeSettings) AdminConfigSettings {
+	return AdminConfigSettings{
+		SummarizeTimeout:     s.SummarizeTimeout.String(),
+		ClassifyTimeout:      s.ClassifyTimeout.String(),
+		DraftTimeout:         s.DraftTimeout.String(),
+		SummarizeTemperature: s.SummarizeTemperature,
+		ClassifyTemperature:  s.ClassifyTemperature,
+		DraftTemperature:     s.DraftTemperature,
+		ReviewThreshold:      s.ReviewThreshold,
+		NetMaxRetries:        s.Retry.NetMaxRetries,
+		ServerMaxRetries:     s.Retry.ServerMaxRetries,
+		RateLimitMaxRetries:  s.Retry.RateLimitMaxRetries,
+		RetryAfterMax:        s.Retry.MaxRetryAfter.String(),
+	}
+}
+
+// AdminConfigPatch changes some runtime settings; omitted fields keep their value
+type AdminConfigPatch struct {
+	SummarizeTimeout     *string  `json:"summarize_timeout"`
+	ClassifyTimeout      *string  `json:"classify_timeout"`
+	DraftTimeout         *string  `json:"draft_timeout"`
+	SummarizeTemperature *float64 `json:"summarize_temperature"`
+	ClassifyTemperature  *float64 `json:"classify_temperature"`
+	DraftTemperature     *float64 `json:"draft_temperature"`
+	ReviewThreshold      *float64 `json:"review_threshold"`
+	NetMaxRetries        *int     `json:"net_max_retries"`
+	ServerMaxRetries     *int     `json:"server_max_retries"`
+	RateLimitMaxRetries  *int     `json:"rate_limit_max_retries"`
+	RetryAfterMax        *string  `json:"retry_after_max"`
+}
+
+// apply writes the patched fields into s
+func (p AdminConfigPatch) apply(s *RuntimeSettings) error {
+	for _, d := range []struct {
+		name  string
+		value *string
+		dst   *time.Duration
+	}{
+		{"summarize_timeout", p.SummarizeTimeout, &s.SummarizeTimeout},
+		{"classify_timeout", p.ClassifyTimeout, &s.ClassifyTimeout},
+		{"draft_timeout", p.DraftTimeout, &s.DraftTimeout},
+		{"retry_after_max", p.RetryAfterMax, &s.Retry.MaxRetryAfter},
+	} {
+		if d.value == nil {
+			continue
+		}
+		parsed, err := time.ParseDuration(strings.TrimSpace(*d.value))
+		if err != nil {
+			return fmt.Errorf("%s must be a duration such as \"30s\"", d.name)
+		}
+		*d.dst = parsed
+	}
+	setFloat := func(dst *float64, value *float64) {
+		if value != nil {
+			*dst = *value
+		}
+	}
+	setFloat(&s.SummarizeTemperature, p.SummarizeTemperature)
+	setFloat(&s.ClassifyTemperature, p.ClassifyTemperature)
+	setFloat(&s.DraftTemperature, p.DraftTemperature)
+	setFloat(&s.ReviewThreshold, p.ReviewThreshold)
+	setInt := func(dst *int, value *int) {
+		if value != nil {
+			*dst = *value
+		}
+	}
+	setInt(&s.Retry.NetMaxRetries, p.NetMaxRetries)
+	setInt(&s.Retry.ServerMaxRetries, p.ServerMaxRetries)
+	setInt(&s.Retry.RateLimitMaxRetries, p.RateLimitMaxRetries)
+	return nil
+}
+
+// AdminConfigResponse is the effective configuration of the server. Settings
+// may be changed with PATCH /admin/config; everything else is fixed at startup.
+type AdminConfigResponse struct {
+	DefaultProvider    string   `json:"default_provider"`
+	Providers          []string `json:"providers"`
+	UpstreamURL        string   `json:"upstream_url"`
+	APIKey             string   `json:"api_key"`
+	Model              string   `json:"model"`
+	AllowedModels      []string `json:"allowed_models"`
+	BodyReadTimeout    string   `json:"body_read_timeout"`
+	MaxDraftCandidates int      `json:"max_draft_candidates"`
+	MaxInputTokens     int      `json:"max_input_tokens"`
+	// KeyConcurrencyLimits is the number of API keys with a concurrency limit
+	KeyConcurrencyLimits int                 `json:"key_concurrency_limits"`
+	Features             map[string]bool     `json:"features"`
+	Settings             AdminConfigSettings `json:"settings"`
+}
+
+// adminConfig reports the effective configuration with the API key redacted
+func (s *Server) adminConfig() AdminConfigResponse {
+	resp := AdminConfigResponse{
+		DefaultProvider:    s.defaultProvider,
+		Providers:          s.providerNames(),
+		UpstreamURL:        s.client.BaseURL,
+		Model:              s.client.Model(),
+		AllowedModels:      s.client.AllowedModels,
+		BodyReadTimeout:    s.bodyReadTimeout.String(),
+		MaxDraftCandidates: s.maxDraftCandidates,
+		MaxInputTokens:     s.client.MaxInputTokens,
+		Features: map[string]bool{
+			CompareSummarize:    s.operationEnabled(CompareSummarize),
+			CompareClassify:     s.operationEnabled(CompareClassify),
+			CompareDraft:        s.operationEnabled(CompareDraft),
+			"cache":             s.client.Cache != nil,
+			"batch_dedup":       s.batchDedup != nil,
+			"debug_capture":     s.debug != nil,
+			"strict_json":       s.strictJSON,
+			"sniff_body":        s.sniffBody,
+			"prompt_override":   s.allowPromptOverride,
+			"include_prompt":    s.includePromptEnabled,
+			"stream_fallback":   s.client.StreamFallback,
+			"summary_plaintext": s.client.SummarizePlaintext,
+		},
+		Settings: adminConfigSettings(s.client.Settings()),
+	}
+	if s.client.APIKey != "" {
+		resp.APIKey = redactedValue
+	}
+	if s.keyConcurrency != nil {
+		resp.KeyConcurrencyLimits = len(s.keyConcurrency.sems)
+	}
+	return resp
+}
+
+// settingsClients returns the default client followed by every other
+// configured provider whose runtime settings can be updated
+func (s *Server) settingsClients() []*DeepseekClient {
+	clients := []*DeepseekClient{s.client}
+	for _, name := range s.providerNames() {
+		if c, ok := s.providers[name].(*DeepseekClient); ok && c != s.client {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+// AdminConfigHandler handles GET and PATCH /admin/config. A PATCH validates
+// every field against every provider before applying any, so a rejected
+// update changes nothing.
+func (s *Server) AdminConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPatch {
+		bodyBytes, err := readRequestBody(w, r, s.bodyReadTimeout)
+		if err != nil {
+			writeBodyReadError(w, err)
+			return
+		}
+
+		// Unknown fields are always rejected: they name settings that
+		// cannot be changed at runtime
+		var patch AdminConfigPatch
+		if err := decodeStrictJSON(bodyBytes, &patch); err != nil {
+			JSONDecodeError(w, bodyBytes, err)
+			return
+		}
+		// Validate against every provider first so a patch one of them
+		// rejects is applied to none
+		clients := s.settingsClients()
+		for _, c := range clients {
+			next := c.Settings()
+			if err := patch.apply(&next); err != nil {
+				JSONError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := c.validateSettings(next); err != nil {
+				JSONError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		var settings RuntimeSettings
+		for _, c := range clients {
+			updated, err := c.UpdateSettings(patch.apply)
+			if err != nil {
+				JSONError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if c == s.client {
+				settings = updated
+			}
+		}
+		log.Printf("[%s] Runtime settings updated: %+v", requestIDFromContext(r.Context()), adminConfigSettings(settings))
+	}
+
+	if err := writeJSON(w, s.adminConfig()); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdminConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		status   int
+		classify time.Duration // classify timeout on every provider afterwards
+	}{
+		{"read", http.MethodGet, "", http.StatusOK, 30 * time.Second},
+		{"valid update", http.MethodPatch, `{"classify_timeout":"10s"}`, http.StatusOK, 10 * time.Second},
+		{"invalid value", http.MethodPatch, `{"classify_timeout":"soon"}`, http.StatusBadRequest, 30 * time.Second},
+		{"immutable field", http.MethodPatch, `{"model":"other"}`, http.StatusBadRequest, 30 * time.Second},
+		{"rejected by one provider", http.MethodPatch, `{"classify_timeout":"1h"}`, http.StatusBadRequest, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLASSIFY_TIMEOUT", "30s")
+			t.Setenv("OPENAI_API_KEY", "openai-key")
+			s := newTestServer(t, replying(""))
+			if len(s.providers) != 2 {
+				t.Fatalf("providers = %v, want deepseek and openai", s.providerNames())
+			}
+
+			req := httptest.NewRequest(tt.method, "/admin/config", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			s.AdminConfigHandler(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if rec.Code == http.StatusOK {
+				var resp AdminConfigResponse
+				decodeResponse(t, rec, &resp)
+				if resp.APIKey != redactedValue {
+					t.Errorf("api_key = %q, want it redacted", resp.APIKey)
+				}
+				if resp.Settings.ClassifyTimeout != tt.classify.String() {
+					t.Errorf("classify_timeout = %q, want %s", resp.Settings.ClassifyTimeout, tt.classify)
+				}
+			}
+			for _, name := range s.providerNames() {
+				if got := s.providers[name].(*DeepseekClient).Settings().ClassifyTimeout; got != tt.classify {
+					t.Errorf("%s classify timeout = %v, want %v", name, got, tt.classify)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminModel(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		model  string
+	}{
+		{"read", http.MethodGet, "", http.StatusOK, "deepseek-chat"},
+		{"switch", http.MethodPost, `{"model":"deepseek-reasoner"}`, http.StatusOK, "deepseek-reasoner"},
+		{"disallowed model", http.MethodPost, `{"model":"gpt-4"}`, http.StatusBadRequest, "deepseek-chat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying("A summary.")
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			s.AdminModelHandler(rec, httptest.NewRequest(tt.method, "/admin/model", strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if got := s.client.Model(); got != tt.model {
+				t.Errorf("model = %q, want %q", got, tt.model)
+			}
+			if _, err := s.client.SummarizeEmail(context.Background(), "The launch moves to Friday.", SummarizeOptions{}); err != nil {
+				t.Fatalf("SummarizeEmail: %v", err)
+			}
+			if got := upstream.body(0)["model"]; got != tt.model {
+				t.Errorf("upstream model = %v, want %q", got, tt.model)
+			}
+		})
+	}
+}
+
+func TestModelPinnedPerRequest(t *testing.T) {
+	t.Setenv("BATCH_DEDUP_ENABLED", "false")
+	tests := []struct {
+		name  string
+		calls int
+		serve func(s *Server, rec *httptest.ResponseRecorder)
+		reply func(n int) *http.Response
+	}{
+		{"batch items", 3, func(s *Server, rec *httptest.ResponseRecorder) {
+			s.PinModel(http.HandlerFunc(s.ClassifyHandler)).ServeHTTP(rec, postJSON("/classify", `{"emails":[{"id":"1","content":"The server is down again"},{"id":"2","content":"Lunch on Friday?"},{"id":"3","content":"Invoice 42 is overdue"}]}`))
+		}, func(int) *http.Response {
+			return chatReply(`{"labels":[{"label":"urgent","score":0.9}]}`)
+		}},
+		{"retries", 2, func(s *Server, rec *httptest.ResponseRecorder) {
+			s.PinModel(http.HandlerFunc(s.SummarizeHandler)).ServeHTTP(rec, postJSON("/summarize", `{"body":"The launch moves to Friday."}`))
+		}, func(n int) *http.Response {
+			if n == 1 {
+				return newResponse(http.StatusServiceUnavailable, `{"error":"overloaded"}`)
+			}
+			return chatReply("The launch moves to Friday.")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s *Server
+			upstream := &fakeUpstream{reply: func(n int, _ *http.Request, _ map[string]interface{}) (*http.Response, error) {
+				// Switch the active model while the request is in flight
+				if n == 1 {
+					if err := s.client.SetModel("deepseek-reasoner"); err != nil {
+						t.Errorf("SetModel: %v", err)
+					}
+				}
+				return tt.reply(n), nil
+			}}
+			s = newTestServer(t, upstream)
+			s.client.backoffBase = time.Millisecond
+			rec := httptest.NewRecorder()
+			tt.serve(s, rec)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+			}
+			if upstream.calls() != tt.calls {
+				t.Fatalf("upstream calls = %d, want %d", upstream.calls(), tt.calls)
+			}
+			for i := 0; i < tt.calls; i++ {
+				if got := upstream.body(i)["model"]; got != "deepseek-chat" {
+					t.Errorf("call %d model = %v, want the model the request started with", i, got)
+				}
+			}
+
+			// The next request picks up the new model
+			tt.serve(s, httptest.NewRecorder())
+			if got := upstream.body(tt.calls)["model"]; got != "deepseek-reasoner" {
+				t.Errorf("next request model = %v, want deepseek-reasoner", got)
+			}
+		})
+	}
+}
+
+func TestSetModelConcurrentReads(t *testing.T) {
+	c := newTestClient(t, replying(""))
+	models := []string{"deepseek-chat", "deepseek-reasoner"}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				if got := c.Model(); got != models[0] && got != models[1] {
+					t.Errorf("Model() = %q during an update", got)
+					return
+				}
+			}
+		}()
+	}
+	for j := 0; j < 1000; j++ {
+		if err := c.SetModel(models[j%2]); err != nil {
+			t.Fatalf("SetModel: %v", err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestAdminCacheFlush(t *testing.T) {
+	tests := []struct {
+		name    string
+		cache   string
+		token   string
+		body    string
+		status  int
+		evicted int
+		calls   int // upstream calls after repeating the classification
+	}{
+		{"all entries", "true", "secret", "", http.StatusOK, 3, 4},
+		{"prefix", "true", "secret", `{"prefix":"classify:"}`, http.StatusOK, 2, 4},
+		{"prefix without matches", "true", "secret", `{"prefix":"summarize:"}`, http.StatusOK, 0, 2},
+		{"wrong token", "true", "guess", "", http.StatusUnauthorized, 0, 2},
+		{"cache disabled", "false", "secret", "", http.StatusConflict, 0, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CACHE_ENABLED", tt.cache)
+			t.Setenv("BATCH_DEDUP_ENABLED", "false")
+			t.Setenv("ADMIN_TOKEN", "secret")
+			upstream := replying(`{"labels":[{"label":"urgent","score":0.9}]}`)
+			s := newTestServer(t, upstream)
+			classify := func() {
+				t.Helper()
+				rec := httptest.NewRecorder()
+				s.ClassifyHandler(rec, postJSON("/classify", `{"emails":[{"id":"1","content":"The server is down again"},{"id":"2","content":"Lunch on Friday?"}]}`))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("classify status = %d", rec.Code)
+				}
+			}
+			classify()
+			if s.client.Cache != nil {
+				if err := s.client.Cache.Set(context.Background(), "other:entry", []byte("{}")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/cache/flush", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rec := httptest.NewRecorder()
+			s.AdminAuth(http.HandlerFunc(s.AdminCacheFlushHandler)).ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusOK {
+				var resp AdminCacheFlushResponse
+				decodeResponse(t, rec, &resp)
+				if resp.Evicted != tt.evicted {
+					t.Errorf("evicted = %d, want %d", resp.Evicted, tt.evicted)
+				}
+			}
+
+			classify()
+			if got := upstream.calls(); got != tt.calls {
+				t.Errorf("upstream calls = %d, want %d", got, tt.calls)
+			}
+		})
+	}
+}
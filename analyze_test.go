@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeHandler(t *testing.T) {
+	summary := func() (*http.Response, error) { return chatReply("Launch moves to Friday."), nil }
+	tests := []struct {
+		name     string
+		replies  []func() (*http.Response, error)
+		calls    int
+		label    string
+		fallback bool
+	}{
+		{
+			name: "combined",
+			replies: []func() (*http.Response, error){func() (*http.Response, error) {
+				return chatReply(`{"summary":"Launch moves to Friday.","labels":[{"label":"urgent","score":0.9}]}`), nil
+			}},
+			calls: 1,
+			label: "urgent",
+		},
+		{
+			name: "combined in a code fence",
+			replies: []func() (*http.Response, error){func() (*http.Response, error) {
+				return chatReply("```json\n{\"summary\":\"Launch moves to Friday.\",\"labels\":[{\"label\":\"urgent\",\"score\":0.9}]}\n```"), nil
+			}},
+			calls: 1,
+			label: "urgent",
+		},
+		{
+			name:     "unparseable envelope",
+			replies:  []func() (*http.Response, error){func() (*http.Response, error) { return chatReply("The launch is urgent."), nil }, summary, labelsReply},
+			calls:    3,
+			label:    "urgent",
+			fallback: true,
+		},
+		{
+			name: "envelope without labels",
+			replies: []func() (*http.Response, error){func() (*http.Response, error) {
+				return chatReply(`{"summary":"Launch moves to Friday.","labels":[]}`), nil
+			}, summary, labelsReply},
+			calls:    3,
+			label:    "urgent",
+			fallback: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := scripted(tt.replies...)
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			s.AnalyzeHandler(rec, httptest.NewRequest(http.MethodPost, "/analyze", strings.NewReader("The launch moves to Friday because QA found a bug.")))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+			}
+			var resp AnalyzeResponse
+			decodeResponse(t, rec, &resp)
+			if resp.Summary != "Launch moves to Friday." {
+				t.Errorf("summary = %q", resp.Summary)
+			}
+			if len(resp.Labels) == 0 || resp.Labels[0].Label != tt.label {
+				t.Errorf("labels = %+v, want %s first", resp.Labels, tt.label)
+			}
+			if got := upstream.calls(); got != tt.calls {
+				t.Errorf("upstream calls = %d, want %d", got, tt.calls)
+			}
+			warned := resp.Metadata != nil && len(resp.Metadata.Warnings) > 0
+			if warned != tt.fallback {
+				t.Errorf("metadata = %+v, want a fallback warning: %v", resp.Metadata, tt.fallback)
+			}
+		})
+	}
+}
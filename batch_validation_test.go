@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestValidateBatchEmails(t *testing.T) {
+	tests := []struct {
+		name   string
+		emails []EmailRequest
+		want   []ValidationError
+	}{
+		{"valid", []EmailRequest{{ID: "1", Content: "a"}, {ID: "2", Content: "b"}}, nil},
+		{"missing id", []EmailRequest{{ID: " ", Content: "a"}}, []ValidationError{{Index: 0, Field: "id", Message: "Email ID is required"}}},
+		{"empty content", []EmailRequest{{ID: "1", Content: "\n"}}, []ValidationError{{Index: 0, Field: "content", Message: "Email content is required"}}},
+		{
+			name:   "several problems",
+			emails: []EmailRequest{{ID: "1", Content: "a"}, {ID: "", Content: ""}, {ID: "1", Content: "c"}, {ID: "3", Content: ""}},
+			want: []ValidationError{
+				{Index: 1, Field: "id", Message: "Email ID is required"},
+				{Index: 1, Field: "content", Message: "Email content is required"},
+				{Index: 2, Field: "id", Message: `Duplicate email ID "1" (first used at index 0)`},
+				{Index: 3, Field: "content", Message: "Email content is required"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateBatchEmails(tt.emails); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateBatchEmails() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyValidationErrors(t *testing.T) {
+	upstream := replying(`{"labels":[{"label":"urgent","score":0.9}]}`)
+	s := newTestServer(t, upstream)
+	rec := httptest.NewRecorder()
+	s.ClassifyHandler(rec, postJSON("/classify", `{"emails":[{"id":"","content":"a"},{"id":"2","content":""},{"id":"","content":" "}]}`))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	var resp ErrorResponse
+	decodeResponse(t, rec, &resp)
+	want := []ValidationError{
+		{Index: 0, Field: "id", Message: "Email ID is required"},
+		{Index: 1, Field: "content", Message: "Email content is required"},
+		{Index: 2, Field: "id", Message: "Email ID is required"},
+		{Index: 2, Field: "content", Message: "Email content is required"},
+	}
+	if !reflect.DeepEqual(resp.Errors, want) {
+		t.Errorf("errors = %+v, want %+v", resp.Errors, want)
+	}
+	if resp.Message != "4 invalid email(s) in batch" {
+		t.Errorf("message = %q", resp.Message)
+	}
+	if upstream.calls() != 0 {
+		t.Errorf("invalid batch called upstream")
+	}
+}
+
+func TestClassifyDuplicateIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		emails  string
+		status  int
+		message string
+	}{
+		{"unique", `[{"id":"a","content":"The server is down again"},{"id":"b","content":"Lunch on Friday?"}]`, http.StatusOK, ""},
+		{"shared id", `[{"id":"a","content":"The server is down again"},{"id":"a","content":"Lunch on Friday?"}]`, http.StatusBadRequest, `Duplicate email ID(s) in batch: "a"`},
+		{"ids trimmed", `[{"id":"a","content":"The server is down again"},{"id":" a ","content":"Lunch on Friday?"}]`, http.StatusBadRequest, `Duplicate email ID(s) in batch: "a"`},
+		{"several shared ids", `[{"id":"a","content":"x"},{"id":"b","content":"y"},{"id":"b","content":"z"},{"id":"a","content":"w"},{"id":"a","content":"v"}]`, http.StatusBadRequest, `Duplicate email ID(s) in batch: "b", "a"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying(`{"labels":[{"label":"urgent","score":0.9}]}`)
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			s.ClassifyHandler(rec, postJSON("/classify", `{"emails":`+tt.emails+`}`))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusOK {
+				return
+			}
+			var resp ErrorResponse
+			decodeResponse(t, rec, &resp)
+			if resp.Message != tt.message {
+				t.Errorf("message = %q, want %q", resp.Message, tt.message)
+			}
+			if upstream.calls() != 0 {
+				t.Errorf("batch with duplicate IDs called upstream")
+			}
+		})
+	}
+}
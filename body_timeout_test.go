@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stallingReader returns data and then blocks until release is closed
+type stallingReader struct {
+	data    string
+	release chan struct{}
+}
+
+func (r *stallingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		<-r.release
+		return 0, io.EOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestReadRequestBodyTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		stall   bool
+		wantErr error
+	}{
+		{"complete body", false, nil},
+		{"slow body", true, errBodyReadTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body io.Reader = strings.NewReader("The server is down")
+			if tt.stall {
+				release := make(chan struct{})
+				t.Cleanup(func() { close(release) })
+				body = &stallingReader{data: "The server", release: release}
+			}
+			// A recorder has no read deadline, so the goroutine fallback applies
+			req := httptest.NewRequest(http.MethodPost, "/summarize", body)
+			start := time.Now()
+			got, err := readRequestBody(httptest.NewRecorder(), req, 50*time.Millisecond)
+			if err != tt.wantErr {
+				t.Fatalf("readRequestBody error = %v, want %v", err, tt.wantErr)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("readRequestBody took %v, want it bounded by the timeout", elapsed)
+			}
+			if err == nil && string(got) != "The server is down" {
+				t.Errorf("body = %q", got)
+			}
+		})
+	}
+}
+
+func TestSummarizeSlowBodyOverConnection(t *testing.T) {
+	t.Setenv("BODY_READ_TIMEOUT", "100ms")
+	s := newTestServer(t, replying("A summary."))
+	srv := httptest.NewServer(http.HandlerFunc(s.SummarizeHandler))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Promise 100 bytes but send only a few, then stall
+	fmt.Fprintf(conn, "POST /summarize HTTP/1.1\r\nHost: test\r\nContent-Type: text/plain\r\nContent-Length: 100\r\n\r\nThe server")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read response: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusRequestTimeout)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCacheTTL is how long cached responses stay valid
+const defaultCacheTTL = time.Hour
+
+// defaultCacheMaxEntries bounds the in-memory cache size
+const defaultCacheMaxEntries = 10000
+
+// ResponseCache stores serialized model responses keyed by content hash
+type ResponseCache interface {
+	// Get returns the cached value for key and whether it was found
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key
+	Set(ctx context.Context, key string, value []byte) error
+	// Flush removes all entries whose key starts with prefix ("" for all) and
+	// returns how many were removed
+	Flush(ctx context.Context, prefix string) (int, error)
+}
+
+// StaleCache is implemented by caches that keep entries for a while after
+// they expire, so they can still be served while the upstream is unavailable
+type StaleCache interface {
+	// GetStale returns the value for key even if it has expired, as long
+	// as it is still retained
+	GetStale(ctx context.Context, key string) ([]byte, bool, error)
+}
+
+// memoryCacheEntry is a single cached value in the LRU list
+type memoryCacheEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// MemoryCache is an in-process LRU ResponseCache with a fixed TTL
+type MemoryCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+	metrics    *CacheMetrics
+
+	// staleTTL keeps expired entries this much longer for GetStale; 0 drops
+	// them on expiry
+	staleTTL time.Duration
+}
+
+// NewMemoryCache creates a new MemoryCache instance. Capacity and expiry
+// evictions are recorded in metrics when it is non-nil.
+func NewMemoryCache(ttl time.Duration, maxEntries int, metrics *CacheMetrics) *MemoryCache {
+	return &MemoryCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		metrics:    metrics,
+	}
+}
+
+// recordEviction counts one evicted entry
+func (m *MemoryCache) recordEviction() {
+	if m.metrics != nil {
+		m.metrics.RecordEvictions(1)
+	}
+}
+
+// Get returns the cached value for key if present and not expired
+func (m *MemoryCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	return m.get(ctx, key, false)
+}
+
+// GetStale returns the cached value for key if present, including an expired
+// value still kept for staleTTL
+func (m *MemoryCache) GetStale(ctx context.Context, key string) ([]byte, bool, error) {
+	return m.get(ctx, key, true)
+}
+
+// get looks up key, dropping entries past their stale window
+func (m *MemoryCache) get(ctx context.Context, key string, allowStale bool) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := elem.Value.(*memoryCacheEntry)
+	now := time.Now()
+	if now.After(entry.expiresAt.Add(m.staleTTL)) {
+		m.order.Remove(elem)
+		delete(m.entries, key)
+		m.recordEviction()
+		return nil, false, nil
+	}
+	if now.After(entry.expiresAt) && !allowStale {
+		return nil, false, nil
+	}
+	m.order.MoveToFront(elem)
+	return entry.value, true, nil
+}
+
+// Set stores value under key, evicting the least recently used entry when full
+func (m *MemoryCache) Set(ctx context.Context, key string, value []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expiresAt := time.Now().Add(m.ttl)
+	if elem, ok := m.entries[key]; ok {
+		entry := elem.Value.(*memoryCacheEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		m.order.MoveToFront(elem)
+		return nil
+	}
+
+	m.entries[key] = m.order.PushFront(&memoryCacheEntry{key: key, value: value, expiresAt: expiresAt})
+	for m.maxEntries > 0 && m.order.Len() > m.maxEntries {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryCacheEntry).key)
+		m.recordEviction()
+	}
+	return nil
+}
+
+// Flush removes all entries whose key starts with prefix and returns how many were removed
+func (m *MemoryCache) Flush(ctx context.Context, prefix string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if prefix == "" {
+		n := m.order.Len()
+		m.entries = make(map[string]*list.Element)
+		m.order.Init()
+		return n, nil
+	}
+
+	n := 0
+	for key, elem := range m.entries {
+		if strings.HasPrefix(key, prefix) {
+			m.order.Remove(elem)
+			delete(m.entries, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// newResponseCacheFromEnv builds the response cache from CACHE_* settings,
+// recording hits, misses and evictions in metrics. It returns nil when
+// caching is disabled.
+func newResponseCacheFromEnv(metrics *CacheMetrics) ResponseCache {
+	if !envBool("CACHE_ENABLED", false) {
+		return nil
+	}
+	cache := NewMemoryCache(
+		envDuration("CACHE_TTL", defaultCacheTTL),
+		envInt("CACHE_MAX_ENTRIES", defaultCacheMaxEntries),
+		metrics,
+	)
+	cache.staleTTL = envDuration("CACHE_STALE_TTL", 0)
+	return &instrumentedCache{ResponseCache: cache, metrics: metrics}
+}
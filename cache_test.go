@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassificationCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		enabled bool
+		calls   int
+		hits    int64
+	}{
+		{"disabled by default", "", false, 2, 0},
+		{"enabled serves repeats from cache", "true", true, 1, 1},
+		{"explicitly disabled", "false", false, 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("CACHE_ENABLED", tt.env)
+			}
+			t.Setenv("BATCH_DEDUP_ENABLED", "false")
+			upstream := replying(`{"labels":[{"label":"urgent","score":0.9}]}`)
+			s := newTestServer(t, upstream)
+			if got := s.client.Cache != nil; got != tt.enabled {
+				t.Fatalf("cache configured = %v, want %v", got, tt.enabled)
+			}
+
+			for i := 0; i < 2; i++ {
+				rec := httptest.NewRecorder()
+				s.ClassifyHandler(rec, postJSON("/classify", `{"emails":[{"id":"1","content":"The server is down again"}]}`))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %d", rec.Code)
+				}
+			}
+			if upstream.calls() != tt.calls {
+				t.Errorf("upstream calls = %d, want %d", upstream.calls(), tt.calls)
+			}
+			if hits := s.cacheMetrics.Snapshot().Hits; hits != tt.hits {
+				t.Errorf("cache hits = %d, want %d", hits, tt.hits)
+			}
+		})
+	}
+}
+
+// downCache is a ResponseCache whose backend is unreachable
+type downCache struct{}
+
+var errCacheDown = errors.New("dial tcp 127.0.0.1:6379: connection refused")
+
+func (downCache) Get(context.Context, string) ([]byte, bool, error) { return nil, false, errCacheDown }
+func (downCache) Set(context.Context, string, []byte) error         { return errCacheDown }
+func (downCache) Flush(context.Context, string) (int, error)        { return 0, errCacheDown }
+
+func TestCacheBackendDown(t *testing.T) {
+	logs := captureLog(t)
+	t.Setenv("BATCH_DEDUP_ENABLED", "false")
+	upstream := replying(`{"labels":[{"label":"urgent","score":0.9}]}`)
+	s := newTestServer(t, upstream)
+	s.client.Cache = &instrumentedCache{ResponseCache: downCache{}, metrics: s.cacheMetrics}
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		s.ClassifyHandler(rec, postJSON("/classify", `{"emails":[{"id":"1","content":"The server is down again"}]}`))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+		}
+		var resp BatchClassifyResponse
+		decodeResponse(t, rec, &resp)
+		if len(resp.Results) != 1 || resp.Results[0].Error != "" || len(resp.Results[0].Labels) == 0 || resp.Results[0].Labels[0].Label != "urgent" {
+			t.Fatalf("results = %+v, want urgent from upstream", resp.Results)
+		}
+	}
+	if upstream.calls() != 2 {
+		t.Errorf("upstream calls = %d, want 2", upstream.calls())
+	}
+	snap := s.cacheMetrics.Snapshot()
+	if snap.Misses != 2 || snap.Hits != 0 || snap.Errors != 4 {
+		t.Errorf("cache metrics = %+v, want 2 misses and 4 errors (a get and a set per request)", snap)
+	}
+	if !strings.Contains(logs.String(), "cache get failed") {
+		t.Errorf("logs = %q, want a cache warning", logs.String())
+	}
+}
+
+// blockingCache is a ResponseCache whose lookups hang until release is closed,
+// ignoring the context like an unresponsive network backend
+type blockingCache struct{ release chan struct{} }
+
+func (b blockingCache) Get(context.Context, string) ([]byte, bool, error) {
+	<-b.release
+	return nil, false, nil
+}
+func (b blockingCache) Set(context.Context, string, []byte) error  { return nil }
+func (b blockingCache) Flush(context.Context, string) (int, error) { return 0, nil }
+
+func TestCacheHonorsDeadline(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		cache   func(m *CacheMetrics) ResponseCache
+	}{
+		{"blocking backend", 20 * time.Millisecond, func(*CacheMetrics) ResponseCache { return blockingCache{release: make(chan struct{})} }},
+		{"expired before lookup", -time.Second, func(m *CacheMetrics) ResponseCache { return NewMemoryCache(time.Hour, 0, m) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying(`{"labels":[{"label":"urgent","score":0.9}]}`)
+			c := newTestClient(t, upstream)
+			metrics := &CacheMetrics{}
+			backend := tt.cache(metrics)
+			if b, ok := backend.(blockingCache); ok {
+				defer close(b.release)
+			}
+			c.Cache = &instrumentedCache{ResponseCache: backend, metrics: metrics}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			start := time.Now()
+			_, err := c.ClassifyEmailsBatch(ctx, []EmailRequest{{ID: "1", Content: "The server is down again"}}, ClassifyOptions{})
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("ClassifyEmailsBatch error = %v, want deadline exceeded", err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("returned after %v, want promptly", elapsed)
+			}
+			if upstream.calls() != 0 {
+				t.Errorf("upstream calls = %d, want 0 after the deadline", upstream.calls())
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// errUnsupportedCharset is returned for request bodies in a charset we cannot decode
+var errUnsupportedCharset = errors.New("unsupported charset")
+
+// defaultRequestCharset is assumed when a request's Content-Type names no charset
+var defaultRequestCharset = "utf-8"
+
+// windows1252High maps bytes 0x80-0x9F of Windows-1252 to Unicode. Bytes the
+// code page leaves undefined map to the matching C1 control, as browsers do.
+var windows1252High = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '\u008D', 'Ž', '\u008F',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '\u009D', 'ž', 'Ÿ',
+}
+
+// normalizeCharset returns the canonical name of a supported charset, or ""
+func normalizeCharset(name string) string {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "utf-8", "utf8", "us-ascii", "ascii":
+		return "utf-8"
+	case "iso-8859-1", "iso8859-1", "iso_8859-1", "latin1", "l1":
+		return "iso-8859-1"
+	case "windows-1252", "cp1252", "x-cp1252":
+		return "windows-1252"
+	}
+	return ""
+}
+
+// decodeBody transcodes body to UTF-8 using the charset parameter of
+// contentType, falling back to defaultRequestCharset when there is none
+func decodeBody(body []byte, contentType string) ([]byte, error) {
+	charset := defaultRequestCharset
+	if _, params, err := mime.ParseMediaType(contentType); err == nil && params["charset"] != "" {
+		charset = params["charset"]
+	}
+
+	switch normalizeCharset(charset) {
+	case "utf-8":
+		return body, nil
+	case "iso-8859-1":
+		return decodeSingleByte(body, func(b byte) rune { return rune(b) }), nil
+	case "windows-1252":
+		return decodeSingleByte(body, func(b byte) rune {
+			if b >= 0x80 && b <= 0x9F {
+				return windows1252High[b-0x80]
+			}
+			return rune(b)
+		}), nil
+	}
+	return nil, fmt.Errorf("%w %q", errUnsupportedCharset, charset)
+}
+
+// decodeSingleByte transcodes a single-byte encoded body to UTF-8
+func decodeSingleByte(body []byte, decode func(byte) rune) []byte {
+	out := make([]byte, 0, len(body))
+	for _, b := range body {
+		if b < utf8.RuneSelf {
+			out = append(out, b)
+			continue
+		}
+		out = utf8.AppendRune(out, decode(b))
+	}
+	return out
+}
+
+// configureRequestCharset applies DEFAULT_REQUEST_CHARSET, keeping UTF-8 when
+// it names a charset we cannot decode
+func configureRequestCharset() {
+	charset := envString("DEFAULT_REQUEST_CHARSET", defaultRequestCharset)
+	if normalizeCharset(charset) == "" {
+		log.Printf("Unsupported DEFAULT_REQUEST_CHARSET %q, using %s", charset, defaultRequestCharset)
+		return
+	}
+	defaultRequestCharset = charset
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		want        string
+		wantErr     error
+	}{
+		{"no charset is utf-8", []byte("Café à midi"), "text/plain", "Café à midi", nil},
+		{"utf-8", []byte("Café à midi"), "text/plain; charset=UTF-8", "Café à midi", nil},
+		{"windows-1252 accents", []byte("Caf\xe9 \xe0 midi"), "text/plain; charset=windows-1252", "Café à midi", nil},
+		{"windows-1252 punctuation", []byte("\x93Quote\x94 \x96 \x80"), "text/plain; charset=cp1252", "“Quote” – €", nil},
+		{"iso-8859-1", []byte("Na\xefve \x93"), "text/plain; charset=ISO-8859-1", "Naïve \u0093", nil},
+		{"json with charset", []byte("{\"body\":\"Gr\xfc\xdfe\"}"), "application/json; charset=latin1", `{"body":"Grüße"}`, nil},
+		{"unsupported", []byte("x"), "text/plain; charset=shift_jis", "", errUnsupportedCharset},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeBody(tt.body, tt.contentType)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("decodeBody error = %v, want %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("decodeBody = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeWindows1252Body(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		status      int
+	}{
+		{"windows-1252", "text/plain; charset=windows-1252", http.StatusOK},
+		{"unsupported", "text/plain; charset=koi8-r", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying("Réunion déplacée à vendredi.")
+			s := newTestServer(t, upstream)
+			req := httptest.NewRequest(http.MethodPost, "/summarize", bytes.NewReader([]byte("La r\xe9union est d\xe9plac\xe9e \xe0 vendredi \x96 merci.")))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			s.SummarizeHandler(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := upstream.messages(0); !strings.Contains(got, "La réunion est déplacée à vendredi – merci.") {
+				t.Errorf("prompt = %q, want the transcoded email", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operations /compare can run
+const (
+	CompareSummarize = "summarize"
+	CompareClassify  = "classify"
+	CompareDraft     = "draft"
+)
+
+// CompareRequest asks for one operation to be run on the same content with two models
+type CompareRequest struct {
+	Content   string   `json:"content"`
+	Models    []string `json:"models"`
+	Operation string   `json:"operation"`
+}
+
+// CompareResult is one model's output and how long it took
+type CompareResult struct {
+	Model      string      `json:"model"`
+	Output     interface{} `json:"output,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	DurationMs int64       `json:"duration_ms"`
+}
+
+// CompareResponse holds both models' results in request order
+type CompareResponse struct {
+	Operation string          `json:"operation"`
+	Results   []CompareResult `json:"results"`
+}
+
+// Validate checks the request, defaulting the operation to summarize
+func (req *CompareRequest) Validate(client LLMClient) error {
+	if strings.TrimSpace(req.Content) == "" {
+		return errors.New("content is required")
+	}
+	if len(req.Models) != 2 {
+		return errors.New("exactly two models are required")
+	}
+	for _, model := range req.Models {
+		if !client.AllowsModel(model) {
+			return fmt.Errorf("model %q is not allowed", model)
+		}
+	}
+	switch req.Operation {
+	case "":
+		req.Operation = CompareSummarize
+	case CompareSummarize, CompareClassify, CompareDraft:
+	default:
+		return fmt.Errorf("operation must be %s, %s or %s", CompareSummarize, CompareClassify, CompareDraft)
+	}
+	return nil
+}
+
+// CompareHandler handles POST /compare. Both models run concurrently; a failure
+// of one is reported in its result without discarding the other.
+func (s *Server) CompareHandler(w http.ResponseWriter, r *http.Request) {
+	bodyBytes, err := readRequestBody(w, r, s.bodyReadTimeout)
+	if err != nil {
+		writeBodyReadError(w, err)
+		return
+	}
+
+	var req CompareRequest
+	if err := s.decodeJSON(bodyBytes, &req); err != nil {
+		JSONDecodeError(w, bodyBytes, err)
+		return
+	}
+
+	client, err := s.clientFor(r)
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(client); err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !s.operationEnabled(req.Operation) {
+		JSONError(w, fmt.Sprintf("Operation %q is not enabled", req.Operation), http.StatusNotFound)
+		return
+	}
+	if s.refuseSensitive(w, r, req.Content) {
+		return
+	}
+
+	resp := CompareResponse{
+		Operation: req.Operation,
+		Results:   make([]CompareResult, len(req.Models)),
+	}
+	errs := make([]error, len(req.Models))
+	var wg sync.WaitGroup
+	for i, model := range req.Models {
+		wg.Add(1)
+		go func(i int, model string) {
+			defer wg.Done()
+			start := time.Now()
+			output, err := runCompareOperation(r, client, req.Operation, model, req.Content)
+			resp.Results[i] = CompareResult{
+				Model:      model,
+				Output:     output,
+				DurationMs: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				log.Printf("[%s] Compare %s with %s failed: %v", requestIDFromContext(r.Context()), req.Operation, model, err)
+				resp.Results[i].Output = nil
+				resp.Results[i].Error = err.Error()
+				errs[i] = err
+			}
+		}(i, model)
+	}
+	wg.Wait()
+
+	if errs[0] != nil && errs[1] != nil {
+		writeUpstreamError(w, "Both models failed", errs[0])
+		return
+	}
+	for _, result := range resp.Results {
+		if classification, ok := result.Output.(*ClassifyResponse); ok {
+			s.recordLabels(classification.Labels...)
+		}
+	}
+
+	if err := writeEncodedJSON(w, resp); err != nil {
+		log.Printf("Error writing response: %v", err)
+		JSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// runCompareOperation runs operation on content with model in place of the active model
+func runCompareOperation(r *http.Request, client LLMClient, operation, model, content string) (interface{}, error) {
+	ctx := withModel(r.Context(), model)
+	switch operation {
+	case CompareClassify:
+		results, err := client.ClassifyEmailsBatch(ctx, []EmailRequest{{ID: "compare", Content: content}}, ClassifyOptions{})
+		if err != nil {
+			return nil, err
+		}
+		// Batch classification reports per-email failures in the result
+		if results[0].Error != "" {
+			return nil, errors.New(results[0].Error)
+		}
+		if len(results[0].Labels) == 0 {
+			return nil, errors.New("classification returned no labels")
+		}
+		return &ClassifyResponse{Labels: results[0].Labels}, nil
+	case CompareDraft:
+		return client.DraftReply(ctx, content, DraftOptions{N: 1})
+	default:
+		return client.SummarizeEmail(ctx, content, SummarizeOptions{})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// compareUpstream answers each model with its own reply, or a 500 when the
+// reply is empty. Every call waits until both models have been called, so the
+// test hangs up to a timeout unless the two run concurrently.
+func compareUpstream(t *testing.T, replies map[string]string) *fakeUpstream {
+	var once sync.Once
+	both := make(chan struct{})
+	var mu sync.Mutex
+	seen := 0
+	return &fakeUpstream{reply: func(_ int, _ *http.Request, body map[string]interface{}) (*http.Response, error) {
+		mu.Lock()
+		seen++
+		if seen == 2 {
+			once.Do(func() { close(both) })
+		}
+		mu.Unlock()
+		select {
+		case <-both:
+		case <-time.After(2 * time.Second):
+			t.Errorf("models were not called concurrently")
+		}
+		reply := replies[body["model"].(string)]
+		if reply == "" {
+			return newResponse(http.StatusInternalServerError, `{"message":"boom","code":500}`), nil
+		}
+		return chatReply(reply), nil
+	}}
+}
+
+func TestCompareHandler(t *testing.T) {
+	type result struct {
+		Model  string          `json:"model"`
+		Output json.RawMessage `json:"output"`
+		Error  string          `json:"error"`
+	}
+	tests := []struct {
+		name    string
+		body    string
+		replies map[string]string
+		status  int
+		want    []string // substring expected in each result's output, "" for an error
+	}{
+		{
+			name:    "summarize",
+			body:    `{"content":"The launch moves to Friday.","models":["deepseek-chat","deepseek-reasoner"]}`,
+			replies: map[string]string{"deepseek-chat": "Chat summary.", "deepseek-reasoner": "Reasoner summary."},
+			status:  http.StatusOK,
+			want:    []string{`"summary":"Chat summary."`, `"summary":"Reasoner summary."`},
+		},
+		{
+			name:    "classify",
+			body:    `{"content":"The server is down again","models":["deepseek-reasoner","deepseek-chat"],"operation":"classify"}`,
+			replies: map[string]string{"deepseek-chat": `{"labels":[{"label":"urgent","score":0.9}]}`, "deepseek-reasoner": `{"labels":[{"label":"spam","score":0.6}]}`},
+			status:  http.StatusOK,
+			want:    []string{`"label":"spam"`, `"label":"urgent"`},
+		},
+		{
+			name:    "one model fails",
+			body:    `{"content":"The launch moves to Friday.","models":["deepseek-chat","deepseek-reasoner"]}`,
+			replies: map[string]string{"deepseek-chat": "Chat summary."},
+			status:  http.StatusOK,
+			want:    []string{`"summary":"Chat summary."`, ""},
+		},
+		{
+			name:    "both fail",
+			body:    `{"content":"The launch moves to Friday.","models":["deepseek-chat","deepseek-reasoner"]}`,
+			replies: map[string]string{},
+			status:  http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SERVER_MAX_RETRIES", "0")
+			captureLog(t)
+			upstream := compareUpstream(t, tt.replies)
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			s.CompareHandler(rec, postJSON("/compare", tt.body))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if upstream.calls() != 2 {
+				t.Errorf("upstream calls = %d, want 2", upstream.calls())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Operation string   `json:"operation"`
+				Results   []result `json:"results"`
+			}
+			decodeResponse(t, rec, &resp)
+			var req CompareRequest
+			json.Unmarshal([]byte(tt.body), &req)
+			if len(resp.Results) != len(tt.want) {
+				t.Fatalf("results = %+v, want %d", resp.Results, len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := resp.Results[i]
+				if got.Model != req.Models[i] {
+					t.Errorf("result %d model = %q, want %q", i, got.Model, req.Models[i])
+				}
+				if want == "" {
+					if got.Error == "" || len(got.Output) != 0 {
+						t.Errorf("result %d = %+v, want an error without output", i, got)
+					}
+				} else if !strings.Contains(string(got.Output), want) {
+					t.Errorf("result %d output = %s, want %s", i, got.Output, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompareValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"no content", `{"content":" ","models":["deepseek-chat","deepseek-reasoner"]}`},
+		{"one model", `{"content":"x","models":["deepseek-chat"]}`},
+		{"model not allowed", `{"content":"x","models":["deepseek-chat","gpt-4o"]}`},
+		{"unknown operation", `{"content":"x","models":["deepseek-chat","deepseek-reasoner"],"operation":"translate"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying("unused")
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			s.CompareHandler(rec, postJSON("/compare", tt.body))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400 (body %q)", rec.Code, rec.Body.String())
+			}
+			if upstream.calls() != 0 {
+				t.Errorf("invalid request called upstream")
+			}
+		})
+	}
+}
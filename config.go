@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envString returns the trimmed value of an environment variable, or def if unset
+func envString(name, def string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt returns a positive integer environment variable, or def if unset or invalid
+func envInt(name string, def int) int {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Printf("Invalid %s %q, using default %d", name, v, def)
+		return def
+	}
+	return n
+}
+
+// envNonNegativeInt returns a non-negative integer environment variable, or def if unset or invalid
+func envNonNegativeInt(name string, def int) int {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("Invalid %s %q, using default %d", name, v, def)
+		return def
+	}
+	return n
+}
+
+// envDuration returns a positive duration environment variable, or def if unset or invalid
+func envDuration(name string, def time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s %q, using default %v", name, v, def)
+		return def
+	}
+	return d
+}
+
+// envFloat returns a non-negative float environment variable, or def if unset or invalid
+func envFloat(name string, def float64) float64 {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		log.Printf("Invalid %s %q, using default %v", name, v, def)
+		return def
+	}
+	return f
+}
+
+// envBool returns a boolean environment variable, or def if unset or invalid
+func envBool(name string, def bool) bool {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Invalid %s %q, using default %v", name, v, def)
+		return def
+	}
+	return b
+}
+
+// envList returns a comma-separated environment variable as a list of trimmed,
+// non-empty values, or def if unset
+func envList(name string, def []string) []string {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// loadSecret returns the value of the environment variable name or, when it is
+// unset, the trimmed contents of the file named by name_FILE. It returns ""
+// with no error when neither is set.
+func loadSecret(name string) (string, error) {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v, nil
+	}
+	path := strings.TrimSpace(os.Getenv(name + "_FILE"))
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE %q: %w", name, path, err)
+	}
+	v := strings.TrimSpace(string(data))
+	if v == "" {
+		return "", fmt.Errorf("%s_FILE %q is empty", name, path)
+	}
+	return v, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSecret(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte("  file-key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(dir, "empty")
+	if err := os.WriteFile(emptyFile, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		env     string
+		file    string
+		want    string
+		wantErr string
+	}{
+		{"env", " env-key ", "", "env-key", ""},
+		{"file", "", keyFile, "file-key", ""},
+		{"env wins over file", "env-key", keyFile, "env-key", ""},
+		{"missing both", "", "", "", ""},
+		{"unreadable file", "", filepath.Join(dir, "missing"), "", "failed to read DEEPSEEK_API_KEY_FILE"},
+		{"empty file", "", emptyFile, "", "is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEEPSEEK_API_KEY", tt.env)
+			t.Setenv("DEEPSEEK_API_KEY_FILE", tt.file)
+			got, err := loadSecret("DEEPSEEK_API_KEY")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("loadSecret error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadSecret: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("loadSecret = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNewServerRequiresAPIKey runs NewServer in a child process because it
+// exits through log.Fatal when no key is configured
+func TestNewServerRequiresAPIKey(t *testing.T) {
+	if os.Getenv("TEST_NEW_SERVER_CHILD") == "1" {
+		NewServer()
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestNewServerRequiresAPIKey$")
+	cmd.Env = append(os.Environ(), "TEST_NEW_SERVER_CHILD=1", "DEEPSEEK_API_KEY=", "DEEPSEEK_API_KEY_FILE=")
+	out, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.Success() {
+		t.Fatalf("NewServer without a key: err = %v, want a non-zero exit", err)
+	}
+	if want := "DEEPSEEK_API_KEY or DEEPSEEK_API_KEY_FILE environment variable is required"; !strings.Contains(string(out), want) {
+		t.Errorf("output = %q, want it to contain %q", out, want)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for debug capture
+const (
+	defaultDebugCaptureSize = 100
+	maxDebugBodyBytes       = 64 << 10
+)
+
+// emailAddressPattern matches email addresses, redacted from debug captures
+var emailAddressPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+
+// UpstreamExchange is one model call made while serving a captured request
+type UpstreamExchange struct {
+	Request    string `json:"request"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Response   string `json:"response,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// DebugCapture is the redacted detail of one sampled request
+type DebugCapture struct {
+	RequestID  string             `json:"request_id"`
+	Time       time.Time          `json:"time"`
+	Method     string             `json:"method"`
+	Path       string             `json:"path"`
+	Input      string             `json:"input"`
+	Upstream   []UpstreamExchange `json:"upstream"`
+	StatusCode int                `json:"status_code"`
+	Response   string             `json:"response"`
+
+	mu sync.Mutex
+}
+
+// addUpstream records a model call; safe for concurrent use
+func (d *DebugCapture) addUpstream(exchange UpstreamExchange) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Upstream = append(d.Upstream, exchange)
+}
+
+// debugCaptureKey is the context key under which the active capture is stored
+type debugCaptureKey struct{}
+
+// debugCaptureFromContext returns the capture for a sampled request, or nil
+func debugCaptureFromContext(ctx context.Context) *DebugCapture {
+	if ctx == nil {
+		return nil
+	}
+	d, _ := ctx.Value(debugCaptureKey{}).(*DebugCapture)
+	return d
+}
+
+// DebugRecorder samples requests and keeps the most recent captures in a ring buffer
+type DebugRecorder struct {
+	mu       sync.Mutex
+	rate     float64
+	rng      *rand.Rand
+	captures []*DebugCapture
+	next     int
+	full     bool
+	redact   []SensitivePattern
+}
+
+// NewDebugRecorder creates a recorder sampling rate (0-1) of requests and
+// keeping the last size captures. The seed makes sampling deterministic.
+func NewDebugRecorder(rate float64, size int, seed int64) *DebugRecorder {
+	var redact []SensitivePattern
+	for _, spec := range defaultSensitivePatterns {
+		name, expr, _ := strings.Cut(spec, "=")
+		redact = append(redact, newSensitivePattern(name, regexp.MustCompile(expr)))
+	}
+	redact = append(redact, SensitivePattern{Name: "email", Pattern: emailAddressPattern})
+	return &DebugRecorder{
+		rate:     rate,
+		rng:      rand.New(rand.NewSource(seed)),
+		captures: make([]*DebugCapture, size),
+		redact:   redact,
+	}
+}
+
+// newDebugRecorderFromEnv builds the recorder from DEBUG_* env vars. It returns
+// nil when DEBUG_SAMPLE_RATE is 0.
+func newDebugRecorderFromEnv() *DebugRecorder {
+	rate := envFloat("DEBUG_SAMPLE_RATE", 0)
+	if rate == 0 {
+		return nil
+	}
+	if rate > 1 {
+		log.Printf("DEBUG_SAMPLE_RATE %v is above 1, sampling every request", rate)
+		rate = 1
+	}
+	seed := time.Now().UnixNano()
+	if v := envNonNegativeInt("DEBUG_SAMPLE_SEED", 0); v > 0 {
+		seed = int64(v)
+	}
+	return NewDebugRecorder(rate, envInt("DEBUG_CAPTURE_SIZE", defaultDebugCaptureSize), seed)
+}
+
+// sample reports whether the next request should be captured
+func (d *DebugRecorder) sample() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rng.Float64() < d.rate
+}
+
+// store adds a finished capture, overwriting the oldest when full
+func (d *DebugRecorder) store(capture *DebugCapture) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.captures[d.next] = capture
+	d.next = (d.next + 1) % len(d.captures)
+	if d.next == 0 {
+		d.full = true
+	}
+}
+
+// Captures returns the stored captures, oldest first
+func (d *DebugRecorder) Captures() []*DebugCapture {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := []*DebugCapture{}
+	if d.full {
+		out = append(out, d.captures[d.next:]...)
+	}
+	return append(out, d.captures[:d.next]...)
+}
+
+// redactText masks regulated data and email addresses and caps the length
+func (d *DebugRecorder) redactText(text string) string {
+	if len(text) > maxDebugBodyBytes {
+		text = text[:maxDebugBodyBytes] + "[... truncated ...]"
+	}
+	for _, p := range d.redact {
+		text = p.ReplaceAllString(text, "[REDACTED:"+p.Name+"]")
+	}
+	return text
+}
+
+// captureBody decodes a captured body, decompressing it when encoding says so
+func captureBody(data []byte, encoding string) string {
+	var reader io.Reader
+	var err error
+	switch encoding {
+	case encodingGzip:
+		reader, err = gzip.NewReader(bytes.NewReader(data))
+	case encodingDeflate:
+		reader, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		return string(data)
+	}
+	if err == nil {
+		if plain, err := io.ReadAll(reader); err == nil {
+			return string(plain)
+		}
+	}
+	return string(data)
+}
+
+// captureWriter tees the response body and status for a capture
+type captureWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+	cw.statusCode = code
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	cw.body.Write(p)
+	return cw.ResponseWriter.Write(p)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Middleware captures sampled requests: the input, every upstream exchange and
+// the final response, all redacted. It passes other requests straight through.
+func (d *DebugRecorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Admin responses are never captured; they include the captures themselves
+		if strings.HasPrefix(r.URL.Path, "/admin/") || !d.sample() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		capture := &DebugCapture{
+			RequestID: requestIDFromContext(r.Context()),
+			Time:      time.Now(),
+			Method:    r.Method,
+			Path:      r.URL.RequestURI(),
+		}
+		var input bytes.Buffer
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.TeeReader(r.Body, &input), r.Body}
+		cw := &captureWriter{ResponseWriter: w, statusCode: http.StatusOK}
+
+		next.ServeHTTP(cw, r.WithContext(context.WithValue(r.Context(), debugCaptureKey{}, capture)))
+
+		capture.Input = d.redactText(captureBody(input.Bytes(), r.Header.Get("Content-Encoding")))
+		capture.StatusCode = cw.statusCode
+		capture.Response = d.redactText(captureBody(cw.body.Bytes(), cw.Header().Get("Content-Encoding")))
+		capture.mu.Lock()
+		for i := range capture.Upstream {
+			capture.Upstream[i].Request = d.redactText(capture.Upstream[i].Request)
+			capture.Upstream[i].Response = d.redactText(capture.Upstream[i].Response)
+		}
+		capture.mu.Unlock()
+		d.store(capture)
+	})
+}
+
+// AdminDebugCapturesHandler handles GET /admin/debug/captures
+func (s *Server) AdminDebugCapturesHandler(w http.ResponseWriter, r *http.Request) {
+	if s.debug == nil {
+		JSONError(w, "Debug capture is disabled", http.StatusConflict)
+		return
+	}
+	if err := writeJSON(w, s.debug.Captures()); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// recordUpstream adds a model call to the capture in ctx, if any
+func recordUpstream(ctx context.Context, request []byte, statusCode int, response []byte, err error) {
+	capture := debugCaptureFromContext(ctx)
+	if capture == nil {
+		return
+	}
+	exchange := UpstreamExchange{Request: string(request), StatusCode: statusCode, Response: string(response)}
+	if err != nil {
+		exchange.Error = fmt.Sprint(err)
+	}
+	capture.addUpstream(exchange)
+}
+
+// includePromptHeader asks /classify to return the prompt behind each result
+const includePromptHeader = "X-Include-Prompt"
+
+// PromptDebug carries the messages sent to the model for one result
+type PromptDebug struct {
+	Prompt []chatMessage `json:"prompt"`
+}
+
+// promptDebug wraps captured messages for a response, or returns nil when
+// there are none so the field is omitted
+func promptDebug(messages []chatMessage) *PromptDebug {
+	if len(messages) == 0 {
+		return nil
+	}
+	return &PromptDebug{Prompt: messages}
+}
+
+// promptCapture receives the messages of the model call made with its context
+type promptCapture struct {
+	messages []chatMessage
+}
+
+// promptCaptureKey is the context key under which the prompt capture is stored
+type promptCaptureKey struct{}
+
+// withPromptCapture returns a copy of ctx that records the prompt of the next
+// model call in the returned capture
+func withPromptCapture(ctx context.Context) (context.Context, *promptCapture) {
+	capture := &promptCapture{}
+	return context.WithValue(ctx, promptCaptureKey{}, capture), capture
+}
+
+// promptCaptureFromContext returns the prompt capture in ctx, or nil
+func promptCaptureFromContext(ctx context.Context) *promptCapture {
+	capture, _ := ctx.Value(promptCaptureKey{}).(*promptCapture)
+	return capture
+}
+
+// recordPrompt stores the messages of a model call in the capture in ctx, if any
+func recordPrompt(ctx context.Context, messages []chatMessage) {
+	if capture := promptCaptureFromContext(ctx); capture != nil {
+		capture.messages = messages
+	}
+}
+
+// includePrompt reports whether the request may see its prompts: the
+// X-Include-Prompt header must be true, INCLUDE_PROMPT_ENABLED set and the
+// request must carry the admin token. Otherwise the header is ignored.
+func (s *Server) includePrompt(r *http.Request) bool {
+	if !s.includePromptEnabled {
+		return false
+	}
+	if include, err := strconv.ParseBool(r.Header.Get(includePromptHeader)); err != nil || !include {
+		return false
+	}
+	return s.validAdminToken(r)
+}
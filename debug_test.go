@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDebugCapture(t *testing.T) {
+	upstream := replying("Ana asked to move the launch to Friday.")
+	s := newTestServer(t, upstream)
+	s.debug = NewDebugRecorder(1, 2, 1)
+	handler := s.debug.Middleware(http.HandlerFunc(s.SummarizeHandler))
+
+	for _, body := range []string{"first", "second", "third"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, postJSON("/summarize?max_words=20", `{"body":"`+body+`: ana@example.com asks to move the launch to Friday."}`))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	s.AdminDebugCapturesHandler(rec, httptest.NewRequest(http.MethodGet, "/admin/debug/captures", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("captures status = %d", rec.Code)
+	}
+	var captures []*DebugCapture
+	if err := json.Unmarshal(rec.Body.Bytes(), &captures); err != nil {
+		t.Fatalf("decode captures: %v", err)
+	}
+	// The ring buffer keeps the last two, oldest first
+	if len(captures) != 2 {
+		t.Fatalf("captures = %d, want 2", len(captures))
+	}
+	for i, want := range []string{"second", "third"} {
+		c := captures[i]
+		if !strings.HasPrefix(c.Input, `{"body":"`+want) {
+			t.Errorf("capture %d input = %q, want the %s request", i, c.Input, want)
+		}
+		if strings.Contains(c.Input, "ana@example.com") || !strings.Contains(c.Input, "[REDACTED:email]") {
+			t.Errorf("capture %d input = %q, want the address redacted", i, c.Input)
+		}
+		if c.Method != http.MethodPost || c.Path != "/summarize?max_words=20" || c.StatusCode != http.StatusOK {
+			t.Errorf("capture %d = %s %s %d", i, c.Method, c.Path, c.StatusCode)
+		}
+		if len(c.Upstream) != 1 || c.Upstream[0].StatusCode != http.StatusOK || !strings.Contains(c.Upstream[0].Request, "[REDACTED:email]") {
+			t.Errorf("capture %d upstream = %+v, want one redacted exchange", i, c.Upstream)
+		}
+		if !strings.Contains(c.Response, "Ana asked to move the launch to Friday.") {
+			t.Errorf("capture %d response = %q, want the decoded summary", i, c.Response)
+		}
+	}
+}
+
+func TestDebugSampling(t *testing.T) {
+	tests := []struct {
+		name string
+		rate float64
+		want func(sampled int) bool
+	}{
+		{"never", 0, func(n int) bool { return n == 0 }},
+		{"always", 1, func(n int) bool { return n == 100 }},
+		{"half", 0.5, func(n int) bool { return n > 30 && n < 70 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := NewDebugRecorder(tt.rate, 1, 42), NewDebugRecorder(tt.rate, 1, 42)
+			sampled := 0
+			for i := 0; i < 100; i++ {
+				got := a.sample()
+				if got != b.sample() {
+					t.Fatalf("sample %d differs between recorders with the same seed", i)
+				}
+				if got {
+					sampled++
+				}
+			}
+			if !tt.want(sampled) {
+				t.Errorf("sampled %d of 100 at rate %v", sampled, tt.rate)
+			}
+		})
+	}
+}
+
+func TestDebugCaptureSkipsAdmin(t *testing.T) {
+	d := NewDebugRecorder(1, 5, 1)
+	handler := d.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/admin/debug/captures", nil))
+	if got := d.Captures(); len(got) != 0 {
+		t.Errorf("captures = %d, want admin requests skipped", len(got))
+	}
+}
+
+func TestIncludePrompt(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		header  string
+		token   string
+		want    bool
+	}{
+		{"enabled with header and admin token", true, "true", "secret", true},
+		{"header without the flag", false, "true", "secret", false},
+		{"flag without the header", true, "", "secret", false},
+		{"header set to false", true, "false", "secret", false},
+		{"wrong token", true, "true", "guess", false},
+		{"no token", true, "true", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ADMIN_TOKEN", "secret")
+			t.Setenv("INCLUDE_PROMPT_ENABLED", strconv.FormatBool(tt.enabled))
+			s := newTestServer(t, replying(`{"labels":[{"label":"urgent","score":0.9}]}`))
+			req := postJSON("/classify", `{"emails":[{"id":"1","content":"The server is down again"},{"id":"2","content":"Lunch on Thursday?"}]}`)
+			if tt.header != "" {
+				req.Header.Set(includePromptHeader, tt.header)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			s.ClassifyHandler(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+			}
+			var resp BatchClassifyResponse
+			decodeResponse(t, rec, &resp)
+			for i, result := range resp.Results {
+				if got := result.Debug != nil; got != tt.want {
+					t.Fatalf("result %d _debug present = %v, want %v", i, got, tt.want)
+				}
+			}
+			if !tt.want {
+				return
+			}
+			for i, result := range resp.Results {
+				if result.Debug == nil || len(result.Debug.Prompt) != 2 {
+					t.Fatalf("result %d debug = %+v, want system and user messages", i, result.Debug)
+				}
+				if got := result.Debug.Prompt[0]; got.Role != "system" || !strings.Contains(got.Content, classifyJSONInstruction) {
+					t.Errorf("result %d system message = %+v", i, got)
+				}
+			}
+			if user := resp.Results[1].Debug.Prompt[1].Content; !strings.Contains(user, "Lunch on Thursday?") {
+				t.Errorf("second result user message = %q, want its own email", user)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultBatchDedupTTL is how long a batch result is replayed for an
+// identical resubmission
+const defaultBatchDedupTTL = 30 * time.Second
+
+// batchDedupMaxEntries bounds the remembered batch results
+const batchDedupMaxEntries = 1000
+
+// batchDedupHeader tells the client its batch result was replayed
+const batchDedupHeader = "X-Batch-Dedup"
+
+// newBatchDedupFromEnv builds the batch dedup store from BATCH_DEDUP_*
+// settings. It returns nil when deduplication is disabled.
+func newBatchDedupFromEnv() ResponseCache {
+	if !envBool("BATCH_DEDUP_ENABLED", true) {
+		return nil
+	}
+	return NewMemoryCache(envDuration("BATCH_DEDUP_TTL", defaultBatchDedupTTL), batchDedupMaxEntries, nil)
+}
+
+// batchDedupKey hashes everything that shapes a batch response: the path and
+// query, the provider and the entire body
+func batchDedupKey(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.URL.Path + "?" + r.URL.RawQuery + "\n"))
+	h.Write([]byte(strings.ToLower(strings.TrimSpace(r.Header.Get(providerHeader))) + "\n"))
+	h.Write(body)
+	return "batch:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// replayBatch writes the remembered response for key and returns true when
+// an identical batch was answered within the dedup window
+func (s *Server) replayBatch(w http.ResponseWriter, r *http.Request, key string) bool {
+	if s.batchDedup == nil {
+		return false
+	}
+	raw, ok, err := s.batchDedup.Get(r.Context(), key)
+	if err != nil || !ok {
+		return false
+	}
+	log.Printf("[%s] Replaying result of an identical batch submitted within the dedup window", requestIDFromContext(r.Context()))
+	w.Header().Set(batchDedupHeader, "hit")
+	if err := writeEncodedJSON(w, json.RawMessage(raw)); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+	return true
+}
+
+// rememberBatch stores a batch response for replay to identical submissions
+func (s *Server) rememberBatch(r *http.Request, key string, response interface{}) {
+	if s.batchDedup == nil {
+		return
+	}
+	raw, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+	if err := s.batchDedup.Set(r.Context(), key, raw); err != nil {
+		log.Printf("[%s] Warning: failed to remember batch result: %v", requestIDFromContext(r.Context()), err)
+	}
+}
+
+// batchComplete reports whether every email got a model result
+func batchComplete(results []BatchClassificationResult) bool {
+	for _, result := range results {
+		if result.Error != "" || result.Degraded || result.Stale {
+			return false
+		}
+	}
+	return true
+}
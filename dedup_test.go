@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClassifyBatchDedup(t *testing.T) {
+	upstream := replying(`{"labels":[{"label":"urgent","score":0.9}]}`)
+	s := newTestServer(t, upstream)
+	if s.batchDedup == nil {
+		t.Fatal("batchDedup is nil with the default configuration")
+	}
+	body := `{"emails":[{"id":"1","content":"Please reply today"},{"id":"2","content":"Server is down"}]}`
+
+	first := httptest.NewRecorder()
+	s.ClassifyHandler(first, postJSON("/classify", body))
+	if first.Code != http.StatusOK {
+		t.Fatalf("first status = %d", first.Code)
+	}
+	if got := first.Header().Get("X-Batch-Dedup"); got == "hit" {
+		t.Errorf("first X-Batch-Dedup = %q, want a miss", got)
+	}
+	calls := upstream.calls()
+	if calls != 2 {
+		t.Fatalf("upstream calls after first batch = %d, want 2", calls)
+	}
+
+	second := httptest.NewRecorder()
+	s.ClassifyHandler(second, postJSON("/classify", body))
+	if got := second.Header().Get("X-Batch-Dedup"); got != "hit" {
+		t.Errorf("second X-Batch-Dedup = %q, want hit", got)
+	}
+	if upstream.calls() != calls {
+		t.Errorf("upstream calls after replay = %d, want %d", upstream.calls(), calls)
+	}
+	var a, b BatchClassifyResponse
+	decodeResponse(t, first, &a)
+	decodeResponse(t, second, &b)
+	if len(b.Results) != 2 || b.Results[0].Labels[0].Label != a.Results[0].Labels[0].Label {
+		t.Errorf("replayed results = %+v, want %+v", b.Results, a.Results)
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Setenv("BATCH_DEDUP_ENABLED", "false")
+		if s := newTestServer(t, upstream); s.batchDedup != nil {
+			t.Error("batchDedup is set with BATCH_DEDUP_ENABLED=false")
+		}
+	})
+}
@@ -2,56 +2,471 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"math/rand"
+	"net"
 	"net/http"
 	"os"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 )
 
+// Doer sends an HTTP request and returns its response. *http.Client satisfies
+// it; tests can supply canned responses without a real server.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
 // DeepseekClient handles communication with the Deepseek API
 type DeepseekClient struct {
-	BaseURL    string
-	APIKey     string
-	HTTPClient *http.Client
-	Model      string
+	BaseURL string
+	APIKey  string
+	// HTTPClient sends every upstream request; NewDeepseekClient sets an
+	// *http.Client with the configured timeout
+	HTTPClient Doer
+	// ErrorRate tracks upstream failures; when degraded, classify and draft
+	// return fallback responses without calling the model. nil disables it.
+	ErrorRate *ErrorRateTracker
+	// RateLimits tracks the upstream's rate-limit headers and, with
+	// PROACTIVE_THROTTLE, delays calls while its quota is running low
+	RateLimits *UpstreamRateLimits
+	// ClassifyFallbackLabel is the label returned for classification while degraded
+	ClassifyFallbackLabel string
+	// DegradedDraftText is the draft returned while degraded
+	DegradedDraftText string
+	// Per-operation minimum content length in characters; shorter content
+	// gets a canned response without a model call. 0 disables the check.
+	SummarizeMinContentLength int
+	ClassifyMinContentLength  int
+	DraftMinContentLength     int
+	// ExtraParams are merged into every chat request body
+	ExtraParams map[string]interface{}
+	// FallbackLargeModel is retried once when the upstream rejects a request
+	// for exceeding the model's context length; empty disables the retry
+	FallbackLargeModel string
+	// StreamFallback switches to a streamed request, accumulated into a full
+	// response, when the upstream rejects non-streaming requests. Once
+	// detected for a model, later requests to that model stream from the start.
+	StreamFallback bool
+	// UpstreamHeaders are added to every outgoing request
+	UpstreamHeaders map[string]string
+	// Cache stores classification results by content hash; nil (the default) disables caching
+	Cache ResponseCache
+	// AllowedModels lists the models the active model may be switched to
+	AllowedModels []string
+	// MaxInputTokens is the token budget email content is truncated to
+	MaxInputTokens int
+	// ContextWindows maps model names to their context window in tokens
+	ContextWindows map[string]int
+	// CompletionTokens is reserved in the context window for the model's reply
+	CompletionTokens int
+	// JSONStrictness controls how forcefully the classify prompt demands pure JSON
+	JSONStrictness string
+	// RepairJSON fixes near-JSON classification output, such as trailing
+	// commas or single quotes, before giving up on it
+	RepairJSON bool
+	// BackoffJitter randomizes retry delays to avoid synchronized retries
+	BackoffJitter bool
+	// BoilerplatePatterns strip conversational prefaces from summaries and drafts
+	BoilerplatePatterns []*regexp.Regexp
+	// PostProcessors replaces the default summary and draft post-processing
+	// chains when POSTPROCESS is set; nil keeps the defaults
+	PostProcessors PostProcessChain
+	// ScratchpadDelimiter marks the final answer in summaries and drafts;
+	// anything before its first occurrence is model reasoning and is dropped
+	ScratchpadDelimiter *regexp.Regexp
+	// SummarizePlaintext strips markdown and HTML from summaries
+	SummarizePlaintext bool
+	// LengthExpansions is how many times a summary or draft cut off by the
+	// token limit is requested again with twice the max_tokens, up to MaxTokensCap
+	LengthExpansions int
+	MaxTokensCap     int
+	// RetryEmptyResults re-runs a summary or draft once when the result is
+	// shorter than MinResultLength characters
+	RetryEmptyResults bool
+	MinResultLength   int
+	// IncludeContentHash adds the SHA-256 of the processed content to response metadata
+	IncludeContentHash bool
+	// DraftIncludeSalutation asks drafts to open with a greeting and close with a sign-off
+	DraftIncludeSalutation bool
+	// StripTracking removes tracking pixels and tracking URL parameters from email content
+	StripTracking bool
+	// DisclaimerPatterns detect legal disclaimer footers removed from email content
+	DisclaimerPatterns []*regexp.Regexp
+	// ClassifyMinLabels and ClassifyMaxLabels bound the labels kept per email;
+	// the fallback label fills in below the minimum
+	ClassifyMinLabels int
+	ClassifyMaxLabels int
+	// LabelSynonyms maps normalized label names the model may return, such as
+	// translations, to canonical labels; nil disables normalization
+	LabelSynonyms map[string]string
+	// LabelRemap maps normalized names of retired labels to the labels that
+	// replace them; nil disables remapping
+	LabelRemap map[string]string
+	// DraftReviewThreshold is the draft confidence below which a draft is
+	// flagged as needing human review
+	DraftReviewThreshold float64
+	// BatchEmailTimeout bounds the classification of each email of a batch,
+	// reported as that email's error when exceeded; 0 disables it
+	BatchEmailTimeout time.Duration
+	// BatchWorkers is the number of emails of a batch classified at once
+	BatchWorkers int
+	// ClassifyChoices is the number of completions requested per classification
+	ClassifyChoices int
+	// AggregateClassifyChoices merges labels across choices (union, max score)
+	// instead of using only the first
+	AggregateClassifyChoices bool
+
+	// settings holds the configuration admins may change at runtime
+	settings atomic.Pointer[RuntimeSettings]
+	model    atomic.Pointer[string]
+	rngMu    sync.Mutex
+	rng      *rand.Rand
+	// backoffBase is the delay before the first retry, doubled for each one after
+	backoffBase time.Duration
+
+	// streamOnly holds the models whose upstream has rejected a
+	// non-streaming request, as model name -> struct{}
+	streamOnly sync.Map
+}
+
+// defaultModel is the chat model used when DEEPSEEK_MODEL is not set
+const defaultModel = "deepseek-chat"
+
+// defaultTimeout is the global upstream timeout used when no per-operation timeout is set
+const defaultTimeout = 30 * time.Second
+
+// defaultDialTimeout bounds establishing a TCP connection to the upstream
+const defaultDialTimeout = 3 * time.Second
+
+// defaultBatchWorkers is the number of emails of a /classify batch classified at once
+const defaultBatchWorkers = 4
+
+// newUpstreamTransport returns the transport for upstream calls. Connecting
+// (UPSTREAM_DIAL_TIMEOUT) is bounded separately from waiting for response
+// headers (UPSTREAM_RESPONSE_HEADER_TIMEOUT, unbounded by default), so a dead
+// host fails fast while a slow model still has until the client timeout.
+func newUpstreamTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{
+		Timeout:   envDuration("UPSTREAM_DIAL_TIMEOUT", defaultDialTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+	transport.DialContext = dialer.DialContext
+	transport.ResponseHeaderTimeout = envDuration("UPSTREAM_RESPONSE_HEADER_TIMEOUT", 0)
+	return transport
 }
 
+// Default per-operation sampling temperatures: deterministic classification,
+// mostly stable summaries and varied drafts
+const (
+	defaultSummarizeTemperature = 0.3
+	defaultClassifyTemperature  = 0.0
+	defaultDraftTemperature     = 0.7
+)
+
 // NewDeepseekClient creates a new DeepseekClient instance
 func NewDeepseekClient(baseURL, apiKey string) *DeepseekClient {
-	model := os.Getenv("DEEPSEEK_MODEL")
-	if strings.TrimSpace(model) == "" {
-		model = "deepseek-chat"
+	model := envString("DEEPSEEK_MODEL", defaultModel)
+	allowedModels := envList("ALLOWED_MODELS", []string{"deepseek-chat", "deepseek-reasoner"})
+	if !containsString(allowedModels, model) {
+		allowedModels = append(allowedModels, model)
+	}
+	jsonStrictness := strings.ToLower(strings.TrimSpace(os.Getenv("CLASSIFY_JSON_STRICTNESS")))
+	switch jsonStrictness {
+	case "":
+		jsonStrictness = JSONStrictnessNormal
+	case JSONStrictnessNormal, JSONStrictnessStrict:
+	default:
+		log.Printf("Invalid CLASSIFY_JSON_STRICTNESS %q, using %q", jsonStrictness, JSONStrictnessNormal)
+		jsonStrictness = JSONStrictnessNormal
+	}
+	summarizeTimeout := envDuration("SUMMARIZE_TIMEOUT", defaultTimeout)
+	classifyTimeout := envDuration("CLASSIFY_TIMEOUT", defaultTimeout)
+	draftTimeout := envDuration("DRAFT_TIMEOUT", defaultTimeout)
+	// The per-attempt client timeout must not cut off the longest operation
+	httpTimeout := defaultTimeout
+	for _, d := range []time.Duration{summarizeTimeout, classifyTimeout, draftTimeout} {
+		if d > httpTimeout {
+			httpTimeout = d
+		}
+	}
+	jitterSeed := time.Now().UnixNano()
+	if v := envNonNegativeInt("BACKOFF_JITTER_SEED", 0); v > 0 {
+		jitterSeed = int64(v)
 	}
 	// Trim API key to remove any whitespace/newlines that might cause header issues
 	apiKey = strings.TrimSpace(apiKey)
-	return &DeepseekClient{
+	c := &DeepseekClient{
 		BaseURL: baseURL,
 		APIKey:  apiKey,
 		HTTPClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Transport: newUpstreamTransport(),
+			Timeout:   httpTimeout,
 		},
-		Model: model,
+		UpstreamHeaders:          parseUpstreamHeaders(os.Getenv("UPSTREAM_HEADERS")),
+		ExtraParams:              parseExtraParams(os.Getenv("DEEPSEEK_EXTRA_PARAMS")),
+		ErrorRate:                newErrorRateTrackerFromEnv(),
+		RateLimits:               newUpstreamRateLimitsFromEnv(),
+		ClassifyFallbackLabel:    envString("CLASSIFY_FALLBACK_LABEL", defaultClassifyFallback),
+		DegradedDraftText:        envString("DEGRADED_DRAFT_TEXT", defaultDegradedDraftText),
+		AllowedModels:            allowedModels,
+		MaxInputTokens:           envInt("MAX_INPUT_TOKENS", defaultMaxInputTokens),
+		ContextWindows:           parseContextWindows(os.Getenv("MODEL_CONTEXT_WINDOWS")),
+		CompletionTokens:         envInt("COMPLETION_TOKEN_RESERVE", defaultCompletionTokens),
+		JSONStrictness:           jsonStrictness,
+		RepairJSON:               envBool("CLASSIFY_JSON_REPAIR", false),
+		BackoffJitter:            envBool("BACKOFF_JITTER", false),
+		IncludeContentHash:       envBool("INCLUDE_CONTENT_HASH", false),
+		SummarizePlaintext:       envBool("SUMMARIZE_PLAINTEXT", false),
+		FallbackLargeModel:       envString("FALLBACK_LARGE_MODEL", ""),
+		StreamFallback:           envBool("UPSTREAM_STREAM_FALLBACK", false),
+		RetryEmptyResults:        envBool("RETRY_EMPTY_RESULTS", false),
+		LengthExpansions:         envNonNegativeInt("LENGTH_EXPANSIONS", 0),
+		MaxTokensCap:             envInt("MAX_TOKENS_CAP", defaultMaxTokensCap),
+		MinResultLength:          envInt("MIN_RESULT_LENGTH", 1),
+		BoilerplatePatterns:      compileBoilerplatePatterns(),
+		ScratchpadDelimiter:      compileScratchpadDelimiter(),
+		StripTracking:            envBool("STRIP_TRACKING", false),
+		DisclaimerPatterns:       compileDisclaimerPatterns(),
+		DraftIncludeSalutation:   envBool("DRAFT_INCLUDE_SALUTATION", false),
+		ClassifyChoices:          envInt("CLASSIFY_CHOICES", 1),
+		BatchEmailTimeout:        envDuration("BATCH_EMAIL_TIMEOUT", 0),
+		BatchWorkers:             envInt("BATCH_WORKERS", defaultBatchWorkers),
+		LabelSynonyms:            loadLabelSynonyms(),
+		LabelRemap:               loadLabelRemap(),
+		ClassifyMinLabels:        envNonNegativeInt("CLASSIFY_MIN_LABELS", 0),
+		ClassifyMaxLabels:        envInt("CLASSIFY_MAX_LABELS", 1),
+		AggregateClassifyChoices: envBool("CLASSIFY_AGGREGATE_CHOICES", false),
+		rng:                      rand.New(rand.NewSource(jitterSeed)),
+		backoffBase:              time.Second,
+	}
+	if c.ClassifyMinLabels > c.ClassifyMaxLabels {
+		log.Printf("CLASSIFY_MIN_LABELS %d exceeds CLASSIFY_MAX_LABELS %d, using %d", c.ClassifyMinLabels, c.ClassifyMaxLabels, c.ClassifyMaxLabels)
+		c.ClassifyMinLabels = c.ClassifyMaxLabels
+	}
+	c.PostProcessors = c.newPostProcessorsFromEnv()
+	c.SummarizeMinContentLength = minContentLength("SUMMARIZE")
+	c.ClassifyMinContentLength = minContentLength("CLASSIFY")
+	c.DraftMinContentLength = minContentLength("DRAFT")
+	c.DraftReviewThreshold = envFloat("DRAFT_REVIEW_THRESHOLD", defaultDraftReviewThreshold)
+	if c.DraftReviewThreshold > 1 {
+		log.Printf("DRAFT_REVIEW_THRESHOLD %v exceeds 1, using %v", c.DraftReviewThreshold, defaultDraftReviewThreshold)
+		c.DraftReviewThreshold = defaultDraftReviewThreshold
+	}
+	c.settings.Store(&RuntimeSettings{
+		SummarizeTimeout:     summarizeTimeout,
+		ClassifyTimeout:      classifyTimeout,
+		DraftTimeout:         draftTimeout,
+		SummarizeTemperature: envFloat("SUMMARIZE_TEMPERATURE", defaultSummarizeTemperature),
+		ClassifyTemperature:  envFloat("CLASSIFY_TEMPERATURE", defaultClassifyTemperature),
+		DraftTemperature:     envFloat("DRAFT_TEMPERATURE", defaultDraftTemperature),
+		ReviewThreshold:      envFloat("CLASSIFY_REVIEW_THRESHOLD", 0),
+		Retry:                newRetryPolicyFromEnv(),
+	})
+	c.model.Store(&model)
+	return c
+}
+
+// Model returns the currently active chat model
+func (c *DeepseekClient) Model() string {
+	if m := c.model.Load(); m != nil {
+		return *m
 	}
+	return defaultModel
+}
+
+// SetModel switches the active chat model if it is in AllowedModels.
+// Requests already in flight keep the model they started with.
+func (c *DeepseekClient) SetModel(model string) error {
+	model = strings.TrimSpace(model)
+	if !containsString(c.AllowedModels, model) {
+		return fmt.Errorf("model %q is not allowed", model)
+	}
+	c.model.Store(&model)
+	return nil
+}
+
+// AllowsModel reports whether model is in the client's allowlist
+func (c *DeepseekClient) AllowsModel(model string) bool {
+	return containsString(c.AllowedModels, model)
+}
+
+// modelKey is the context key under which a per-call model override is stored
+type modelKey struct{}
+
+// withModel returns a context whose model calls use model instead of the active one
+func withModel(ctx context.Context, model string) context.Context {
+	return context.WithValue(ctx, modelKey{}, model)
+}
+
+// modelFor returns the model overridden in ctx, or the active model
+func (c *DeepseekClient) modelFor(ctx context.Context) string {
+	if ctx != nil {
+		if model, ok := ctx.Value(modelKey{}).(string); ok && model != "" {
+			return model
+		}
+	}
+	return c.Model()
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ResponseMetadata carries details about how a model response was produced
+type ResponseMetadata struct {
+	FinishReason string   `json:"finish_reason,omitempty"`
+	ContentHash  string   `json:"content_hash,omitempty"`
+	Degraded     bool     `json:"degraded,omitempty"`
+	Warnings     []string `json:"warnings,omitempty"`
+	// ProcessedAt is when the response was produced, in RFC 3339
+	ProcessedAt string `json:"processed_at,omitempty"`
+	// UpstreamLatencyMS is the time spent waiting on the upstream API
+	UpstreamLatencyMS int64 `json:"upstream_latency_ms"`
+}
+
+// finishReasonLength is the finish reason reported when output hit the token limit
+const finishReasonLength = "length"
+
+// newResponseMetadata builds metadata for a choice, warning on truncated output
+func newResponseMetadata(choice chatChoice) *ResponseMetadata {
+	meta := &ResponseMetadata{FinishReason: choice.FinishReason}
+	if choice.FinishReason == finishReasonLength {
+		meta.Warnings = append(meta.Warnings, "output reached the model's length limit and may be cut off")
+	}
+	return meta
+}
+
+// contentHash returns the hex SHA-256 of content
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// responseMetadata builds metadata for a choice generated from content
+func (c *DeepseekClient) responseMetadata(ctx context.Context, choice chatChoice, content string) *ResponseMetadata {
+	meta := newResponseMetadata(choice)
+	meta.ProcessedAt = processedAt()
+	meta.UpstreamLatencyMS = upstreamLatency(ctx).Milliseconds()
+	if c.IncludeContentHash {
+		meta.ContentHash = contentHash(content)
+	}
+	return meta
+}
+
+// StructuredEmail is an email supplied as separate header fields and body
+type StructuredEmail struct {
+	Subject string `json:"subject"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Date    string `json:"date"`
+	Body    string `json:"body"`
+}
+
+// maxHeaderFieldLength bounds each structured header field
+const maxHeaderFieldLength = 1000
+
+// Validate checks that the body is present and header fields are single, short lines
+func (e StructuredEmail) Validate() error {
+	if strings.TrimSpace(e.Body) == "" {
+		return fmt.Errorf("body is required")
+	}
+	fields := []struct{ name, value string }{
+		{"subject", e.Subject}, {"from", e.From}, {"to", e.To}, {"date", e.Date},
+	}
+	for _, f := range fields {
+		if len(f.value) > maxHeaderFieldLength {
+			return fmt.Errorf("%s must be at most %d characters", f.name, maxHeaderFieldLength)
+		}
+		if strings.ContainsAny(f.value, "\r\n") {
+			return fmt.Errorf("%s must be a single line", f.name)
+		}
+	}
+	return nil
+}
+
+// Format renders the email with labeled headers followed by the body
+func (e StructuredEmail) Format() string {
+	var b strings.Builder
+	for _, h := range []struct{ label, value string }{
+		{"Subject", e.Subject}, {"From", e.From}, {"To", e.To}, {"Date", e.Date},
+	} {
+		if v := strings.TrimSpace(h.value); v != "" {
+			fmt.Fprintf(&b, "%s: %s\n", h.label, v)
+		}
+	}
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString(strings.TrimSpace(e.Body))
+	return b.String()
+}
+
+// SummarizeOptions holds per-request summarization settings
+type SummarizeOptions struct {
+	// MaxWords caps the summary length in words; 0 means no cap
+	MaxWords int
+	// SplitHistory summarizes the latest message and quoted history separately
+	SplitHistory bool
+	// IncludeHighlights also returns the input sentences the summary draws on
+	IncludeHighlights bool
 }
 
 // SummaryResponse represents the response from the summarize endpoint
 type SummaryResponse struct {
-	Summary string `json:"summary"`
+	Summary string `json:"summary,omitempty"`
+	// LatestSummary and HistorySummary are set instead of Summary when
+	// the latest message and quoted history are summarized separately
+	LatestSummary  string `json:"latest_summary,omitempty"`
+	HistorySummary string `json:"history_summary,omitempty"`
+	// Highlights are verbatim input sentences the summary was drawn from
+	Highlights []string          `json:"highlights,omitempty"`
+	Metadata   *ResponseMetadata `json:"metadata,omitempty"`
 }
 
 // ClassificationLabel represents a classification label
 type ClassificationLabel struct {
 	Label string  `json:"label"`
 	Score float64 `json:"score"`
+	// Rationale explains the label when requested with IncludeRationale
+	Rationale string `json:"rationale,omitempty"`
+}
+
+// ClassifyOptions holds per-request classification settings
+type ClassifyOptions struct {
+	// IncludeRationale asks the model to explain each label
+	IncludeRationale bool
 }
 
 // ClassifyResponse represents the response from the classify endpoint
 type ClassifyResponse struct {
 	Labels []ClassificationLabel `json:"labels"`
+	// Degraded is true when the fallback label was returned without calling the model
+	Degraded bool `json:"-"`
+	// ShortMessage is true when the content was too short to classify and
+	// shortMessageLabel was returned without calling the model
+	ShortMessage bool `json:"-"`
 }
 
 // EmailRequest represents a single email in the batch request
@@ -62,13 +477,35 @@ type EmailRequest struct {
 
 // BatchClassificationResult represents the classification result for a single email in batch
 type BatchClassificationResult struct {
-	ID     string                 `json:"id"`
+	ID     string                `json:"id"`
 	Labels []ClassificationLabel `json:"labels"`
+	// Cached is true when the labels were served from the response cache
+	Cached bool `json:"-"`
+	// Degraded is true when the fallback label was served instead of a model result
+	Degraded bool `json:"-"`
+	// Stale is true when expired cached labels were served while degraded
+	Stale bool `json:"-"`
+	// ShortMessage is true when shortMessageLabel was served for content too short to classify
+	ShortMessage bool `json:"-"`
+	// Error describes why this email could not be classified; its labels are empty
+	Error string `json:"error,omitempty"`
+	// Prompt holds the messages sent to the model when prompt capture is on
+	Prompt []chatMessage `json:"-"`
 }
 
 // DraftResponse represents the response from the draft endpoint
 type DraftResponse struct {
-	Draft string `json:"draft"`
+	Draft  string   `json:"draft,omitempty"`
+	Drafts []string `json:"drafts,omitempty"`
+	// DraftHTML and DraftsHTML hold sanitized HTML in the html and both formats
+	DraftHTML  string            `json:"draft_html,omitempty"`
+	DraftsHTML []string          `json:"drafts_html,omitempty"`
+	Metadata   *ResponseMetadata `json:"metadata,omitempty"`
+	// Confidence and NeedsHumanReview assess the first draft when
+	// DraftOptions.IncludeConfidence is set
+	Confidence       *float64 `json:"confidence,omitempty"`
+	NeedsHumanReview *bool    `json:"needs_human_review,omitempty"`
+	ReplyHeaders
 }
 
 // APIError represents an error response from the API
@@ -81,10 +518,131 @@ func (e *APIError) Error() string {
 	return fmt.Sprintf("API error %d: %s", e.Code, e.Message)
 }
 
-// makeRequest performs an HTTP request with retries
-func (c *DeepseekClient) makeRequest(method, endpoint string, body io.Reader, maxRetries int) (*http.Response, error) {
+// parseUpstreamHeaders parses comma-separated Key=Value pairs. Authorization is
+// ignored because the client always sets it from the API key.
+func parseUpstreamHeaders(spec string) map[string]string {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key = http.CanonicalHeaderKey(strings.TrimSpace(key))
+		if !ok || key == "" {
+			log.Printf("Ignoring malformed UPSTREAM_HEADERS entry %q", pair)
+			continue
+		}
+		if key == "Authorization" {
+			log.Printf("Ignoring Authorization in UPSTREAM_HEADERS")
+			continue
+		}
+		headers[key] = strings.TrimSpace(value)
+	}
+	return headers
+}
+
+// degraded reports whether the upstream error rate calls for fallback responses
+func (c *DeepseekClient) degraded(ctx context.Context) bool {
+	if c.ErrorRate == nil || !c.ErrorRate.Degraded() {
+		return false
+	}
+	rate, n := c.ErrorRate.ErrorRate()
+	c.logf(ctx, "Serving degraded response (error rate %.0f%% over %d calls)", rate*100, n)
+	return true
+}
+
+// withTimeout bounds ctx by d; a non-positive d leaves ctx unchanged
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// logf logs a client-side message prefixed with the request ID carried by ctx
+func (c *DeepseekClient) logf(ctx context.Context, format string, args ...interface{}) {
+	log.Printf("[%s] "+format, append([]interface{}{requestIDFromContext(ctx)}, args...)...)
+}
+
+// backoffDelay returns the delay before the given retry attempt (1-based).
+// Without jitter the delay is exponential: 1s, 2s, 4s. With jitter enabled
+// it is drawn uniformly from [0, exponential delay] ("full jitter").
+func (c *DeepseekClient) backoffDelay(attempt int) time.Duration {
+	backoff := time.Duration(1<<uint(attempt-1)) * c.backoffBase
+	if !c.BackoffJitter || c.rng == nil {
+		return backoff
+	}
+	c.rngMu.Lock()
+	defer c.rngMu.Unlock()
+	return time.Duration(c.rng.Int63n(int64(backoff) + 1))
+}
+
+// gzipReadCloser decompresses a response body and closes both readers
+type gzipReadCloser struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (g *gzipReadCloser) Close() error {
+	g.Reader.Close()
+	return g.body.Close()
+}
+
+// decompressResponse replaces a gzip-encoded response body with a decoding reader
+func decompressResponse(resp *http.Response) error {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return nil
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body = &gzipReadCloser{Reader: gz, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	return nil
+}
+
+// ContextLengthError is returned when the upstream rejects a request for
+// exceeding the model's context length
+type ContextLengthError struct {
+	Model string
+	Body  string
+}
+
+func (e *ContextLengthError) Error() string {
+	return fmt.Sprintf("context length of model %s exceeded: %s", e.Model, e.Body)
+}
+
+// contextLengthPattern matches the context-length messages of OpenAI-compatible APIs
+var contextLengthPattern = regexp.MustCompile(`(?i)context_length_exceeded|maximum context length|context length`)
+
+// isContextLengthError reports whether an upstream error response rejects
+// the request for exceeding the context length
+func isContextLengthError(status int, body []byte) bool {
+	return status == http.StatusBadRequest && contextLengthPattern.Match(body)
+}
+
+// RateLimitError is returned when the upstream still responds 429 after retries
+type RateLimitError struct {
+	// RetryAfter is the upstream's Retry-After header value, if any
+	RetryAfter string
+	Body       string
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter != "" {
+		return fmt.Sprintf("rate limited by upstream (retry after %s): %s", e.RetryAfter, e.Body)
+	}
+	return fmt.Sprintf("rate limited by upstream: %s", e.Body)
+}
+
+// makeRequest performs an HTTP request to the upstream with the client's
+// headers, retrying failures through doWithRetry
+func (c *DeepseekClient) makeRequest(ctx context.Context, method, endpoint string, body io.Reader) (*http.Response, error) {
 	url := fmt.Sprintf("%s%s", c.BaseURL, endpoint)
-	log.Printf("Making request to: %s %s", method, url)
+	c.logf(ctx, "Making request to: %s %s", method, url)
 
 	// Read body content once so we can reuse it on retries
 	var bodyBytes []byte
@@ -96,48 +654,47 @@ func (c *DeepseekClient) makeRequest(method, endpoint string, body io.Reader, ma
 		}
 	}
 
-	var lastErr error
-	for attempt := 0; attempt <= maxRetries; attempt++ {
-		if attempt > 0 {
-			// Exponential backoff: 1s, 2s, 4s
-			backoff := time.Duration(1<<uint(attempt-1)) * time.Second
-			time.Sleep(backoff)
-		}
-
+	resp, err := c.doWithRetry(ctx, func() (*http.Response, error) {
 		// Create a new reader for each retry attempt
 		var bodyReader io.Reader
 		if bodyBytes != nil {
 			bodyReader = bytes.NewReader(bodyBytes)
 		}
 
-		req, err := http.NewRequest(method, url, bodyReader)
+		req, err := http.NewRequestWithContext(ctx, method, url, bodyReader)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
+			return nil, &permanentError{fmt.Errorf("failed to create request: %w", err)}
+		}
+
+		// Static and forwarded headers first so they can never replace Authorization
+		for key, value := range c.UpstreamHeaders {
+			req.Header.Set(key, value)
+		}
+		for key, values := range forwardedHeadersFromContext(ctx) {
+			req.Header[key] = values
 		}
 
 		// Default to JSON; callers can override with their body if needed
 		req.Header.Set("Content-Type", "application/json")
+		// Ask for gzip explicitly; the transport then leaves decoding to us
+		req.Header.Set("Accept-Encoding", "gzip")
 		// Trim API key again before setting header to ensure no invalid characters
 		apiKey := strings.TrimSpace(c.APIKey)
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
 
 		resp, err := c.HTTPClient.Do(req)
 		if err != nil {
-			lastErr = fmt.Errorf("request to %s failed: %w", url, err)
-			continue
+			return nil, err
 		}
-
-		// Retry on 5xx errors
-		if resp.StatusCode >= 500 && resp.StatusCode < 600 && attempt < maxRetries {
-			resp.Body.Close()
-			lastErr = fmt.Errorf("server error %d from %s", resp.StatusCode, url)
-			continue
+		if err := bufferResponse(resp); err != nil {
+			return nil, err
 		}
-
 		return resp, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("request to %s: %w", url, err)
 	}
-
-	return nil, fmt.Errorf("request to %s failed after %d retries: %w", url, maxRetries, lastErr)
+	return resp, nil
 }
 
 // DeepSeek chat request/response (OpenAI compatible shape)
@@ -147,9 +704,76 @@ type chatMessage struct {
 }
 
 type chatRequest struct {
-	Model    string        `json:"model"`
-	Messages []chatMessage `json:"messages"`
-	Stream   bool          `json:"stream,omitempty"`
+	Model       string        `json:"model"`
+	Messages    []chatMessage `json:"messages"`
+	Stream      bool          `json:"stream,omitempty"`
+	Temperature *float64      `json:"temperature,omitempty"`
+	N           int           `json:"n,omitempty"`
+	// MaxTokens bounds the completion; 0 leaves the provider default
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// Seed asks the provider for reproducible sampling; support is best-effort
+	Seed *int `json:"seed,omitempty"`
+	// FixedTemperature keeps Temperature even when the caller asked for another
+	FixedTemperature bool `json:"-"`
+	// ExtraParams are merged into the request body for provider features
+	// the fixed fields don't cover; they never replace a field set above
+	ExtraParams map[string]interface{} `json:"-"`
+}
+
+// reservedChatParams are core request fields extra params may not override
+var reservedChatParams = map[string]bool{
+	"model":       true,
+	"messages":    true,
+	"stream":      true,
+	"n":           true,
+	"temperature": true,
+}
+
+// MarshalJSON encodes the request with ExtraParams merged in
+func (r chatRequest) MarshalJSON() ([]byte, error) {
+	type plain chatRequest
+	raw, err := json.Marshal(plain(r))
+	if err != nil || len(r.ExtraParams) == 0 {
+		return raw, err
+	}
+
+	var merged map[string]interface{}
+	if err := json.Unmarshal(raw, &merged); err != nil {
+		return nil, err
+	}
+	for key, value := range r.ExtraParams {
+		if reservedChatParams[key] {
+			continue
+		}
+		if _, exists := merged[key]; !exists {
+			merged[key] = value
+		}
+	}
+	return json.Marshal(merged)
+}
+
+// validateExtraParams drops keys that would override core request fields
+func validateExtraParams(params map[string]interface{}) map[string]interface{} {
+	for key := range params {
+		if reservedChatParams[key] {
+			log.Printf("Ignoring extra param %q: it would override a core request field", key)
+			delete(params, key)
+		}
+	}
+	return params
+}
+
+// parseExtraParams parses a JSON object of passthrough request params
+func parseExtraParams(spec string) map[string]interface{} {
+	if strings.TrimSpace(spec) == "" {
+		return nil
+	}
+	var params map[string]interface{}
+	if err := json.Unmarshal([]byte(spec), &params); err != nil {
+		log.Printf("Ignoring invalid DEEPSEEK_EXTRA_PARAMS: %v", err)
+		return nil
+	}
+	return validateExtraParams(params)
 }
 
 type chatChoice struct {
@@ -160,21 +784,74 @@ type chatChoice struct {
 
 type chatResponse struct {
 	Choices []chatChoice `json:"choices"`
+	Usage   chatUsage    `json:"usage"`
 }
 
-// SummarizeEmail sends email content to the summarize endpoint
-func (c *DeepseekClient) SummarizeEmail(content string) (*SummaryResponse, error) {
-	// Build prompt
-	reqBody := chatRequest{
-		Model: c.Model,
-		Messages: []chatMessage{
-			{Role: "system", Content: "You are an assistant that summarizes emails. Return a concise summary in plain text."},
-			{Role: "user", Content: fmt.Sprintf("Summarize this email (HTML allowed):\n\n%s", content)},
-		},
+// chatUsage is the token usage the provider reports for a completion
+type chatUsage struct {
+	TotalTokens int `json:"total_tokens"`
+}
+
+// temperature returns a pointer to t for use in a chatRequest
+func temperature(t float64) *float64 {
+	return &t
+}
+
+// chat sends a chat completion request and returns a response with at least one choice.
+// A temperature set on the inbound request replaces the operation default
+// unless the request's temperature is fixed, and a seed and extra params set on it are passed through; the caller's
+// extra params take precedence over DEEPSEEK_EXTRA_PARAMS.
+func (c *DeepseekClient) chat(ctx context.Context, reqBody chatRequest) (*chatResponse, error) {
+	if t, ok := temperatureFromContext(ctx); ok && !reqBody.FixedTemperature {
+		reqBody.Temperature = temperature(t)
+	}
+	if seed, ok := seedFromContext(ctx); ok {
+		reqBody.Seed = &seed
+	}
+	reqBody.Model = c.modelFor(ctx)
+	if requestParams := extraParamsFromContext(ctx); len(c.ExtraParams) > 0 || len(requestParams) > 0 {
+		merged := make(map[string]interface{}, len(c.ExtraParams)+len(requestParams)+len(reqBody.ExtraParams))
+		for _, params := range []map[string]interface{}{c.ExtraParams, requestParams, reqBody.ExtraParams} {
+			for key, value := range params {
+				merged[key] = value
+			}
+		}
+		reqBody.ExtraParams = merged
+	}
+	recordPrompt(ctx, reqBody.Messages)
+	cr, err := c.sendChat(ctx, reqBody)
+	var streamErr *StreamingRequiredError
+	if errors.As(err, &streamErr) && c.StreamFallback {
+		c.logf(ctx, "Upstream requires streaming for model %s, switching to streamed requests", reqBody.Model)
+		c.streamOnly.Store(reqBody.Model, struct{}{})
+		cr, err = c.sendChat(ctx, reqBody)
+	}
+	var lengthErr *ContextLengthError
+	if errors.As(err, &lengthErr) && c.FallbackLargeModel != "" && reqBody.Model != c.FallbackLargeModel {
+		c.logf(ctx, "Model %s rejected the request for exceeding its context length, retrying with %s", reqBody.Model, c.FallbackLargeModel)
+		reqBody.Model = c.FallbackLargeModel
+		cr, err = c.sendChat(ctx, reqBody)
 	}
-	raw, _ := json.Marshal(reqBody)
-	resp, err := c.makeRequest("POST", "/v1/chat/completions", bytes.NewReader(raw), 3)
+	return cr, err
+}
+
+// sendChat makes one chat completion call with reqBody as given, streamed
+// and accumulated once the upstream is known to require streaming for its model
+func (c *DeepseekClient) sendChat(ctx context.Context, reqBody chatRequest) (*chatResponse, error) {
+	if _, ok := c.streamOnly.Load(reqBody.Model); ok && c.StreamFallback {
+		reqBody.Stream = true
+	}
+	raw, err := json.Marshal(reqBody)
 	if err != nil {
+		return nil, fmt.Errorf("failed to encode chat request: %w", err)
+	}
+	defer recordUpstreamLatency(ctx, time.Now())
+	resp, err := c.makeRequest(ctx, "POST", "/v1/chat/completions", bytes.NewReader(raw))
+	if c.ErrorRate != nil {
+		c.ErrorRate.Record(err != nil || resp.StatusCode >= 500)
+	}
+	if err != nil {
+		recordUpstream(ctx, raw, 0, nil, err)
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
 	defer resp.Body.Close()
@@ -182,6 +859,21 @@ func (c *DeepseekClient) SummarizeEmail(content string) (*SummaryResponse, error
 	if resp.StatusCode != http.StatusOK {
 		// Read response body for error details
 		bodyBytes, readErr := io.ReadAll(resp.Body)
+		recordUpstream(ctx, raw, resp.StatusCode, bodyBytes, readErr)
+
+		// Rate limits survive retries as a typed error so handlers can pass them on
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return nil, &RateLimitError{
+				RetryAfter: resp.Header.Get("Retry-After"),
+				Body:       string(bodyBytes),
+			}
+		}
+		if isContextLengthError(resp.StatusCode, bodyBytes) {
+			return nil, &ContextLengthError{Model: reqBody.Model, Body: string(bodyBytes)}
+		}
+		if !reqBody.Stream && isStreamingRequiredError(resp.StatusCode, bodyBytes) {
+			return nil, &StreamingRequiredError{Model: reqBody.Model, Body: string(bodyBytes)}
+		}
 		errorMsg := fmt.Sprintf("unexpected status code: %d", resp.StatusCode)
 		if readErr == nil && len(bodyBytes) > 0 {
 			errorMsg = fmt.Sprintf("unexpected status code: %d, response: %s", resp.StatusCode, string(bodyBytes))
@@ -193,162 +885,1049 @@ func (c *DeepseekClient) SummarizeEmail(content string) (*SummaryResponse, error
 			return nil, &apiErr
 		}
 
-		return nil, fmt.Errorf(errorMsg)
+		return nil, errors.New(errorMsg)
 	}
 
-	var cr chatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&cr); err != nil {
+	respBytes, err := io.ReadAll(resp.Body)
+	recordUpstream(ctx, raw, resp.StatusCode, respBytes, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chat response: %w", err)
+	}
+	cr := &chatResponse{}
+	if reqBody.Stream {
+		cr, err = parseStreamedChat(respBytes)
+	} else {
+		err = json.Unmarshal(respBytes, cr)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("failed to decode chat response: %w", err)
 	}
+	recordUpstreamTokens(ctx, cr.Usage.TotalTokens)
 	if len(cr.Choices) == 0 {
 		return nil, fmt.Errorf("no choices returned from model")
 	}
-	return &SummaryResponse{Summary: strings.TrimSpace(cr.Choices[0].Message.Content)}, nil
+	return cr, nil
 }
 
-// ClassifyEmail sends email content to the classify endpoint
-func (c *DeepseekClient) ClassifyEmail(content string) (*ClassifyResponse, error) {
-	// Instruct model to output strict JSON with single best label
+// defaultMaxTokensCap bounds max_tokens when expanding length-truncated output
+const defaultMaxTokensCap = 8192
+
+// chatExpanding is chat for free-text output: while a choice is cut off by
+// the token limit it asks again with twice the max_tokens, at most
+// LengthExpansions times and never above MaxTokensCap. If an expansion
+// fails the truncated response is kept. With expansions enabled the first
+// request sets max_tokens explicitly, so the limit being doubled is the one
+// the upstream applied rather than an unknown provider default.
+func (c *DeepseekClient) chatExpanding(ctx context.Context, reqBody chatRequest) (*chatResponse, error) {
+	if c.LengthExpansions > 0 {
+		reqBody.MaxTokens = c.maxTokens(reqBody)
+	}
+	cr, err := c.chat(ctx, reqBody)
+	for expansion := 0; err == nil && expansion < c.LengthExpansions && truncatedByLength(cr); expansion++ {
+		limit := c.maxTokens(reqBody)
+		next := min(limit*2, c.MaxTokensCap)
+		if next <= limit {
+			break
+		}
+		c.logf(ctx, "Output reached the length limit of %d tokens, retrying with max_tokens %d", limit, next)
+		reqBody.MaxTokens = next
+		expanded, expandErr := c.chat(ctx, reqBody)
+		if expandErr != nil {
+			if ctx.Err() != nil {
+				return nil, expandErr
+			}
+			c.logf(ctx, "Expanded request failed, keeping the truncated output: %v", expandErr)
+			break
+		}
+		cr = expanded
+	}
+	return cr, err
+}
+
+// truncatedByLength reports whether any choice stopped at the token limit
+func truncatedByLength(cr *chatResponse) bool {
+	for _, choice := range cr.Choices {
+		if choice.FinishReason == finishReasonLength {
+			return true
+		}
+	}
+	return false
+}
+
+// maxTokens returns the completion limit reqBody runs with: its own
+// max_tokens, one set in the extra params, or the completion reserve
+func (c *DeepseekClient) maxTokens(reqBody chatRequest) int {
+	if reqBody.MaxTokens > 0 {
+		return reqBody.MaxTokens
+	}
+	if n, ok := c.ExtraParams["max_tokens"].(float64); ok && n > 0 {
+		return int(n)
+	}
+	return c.CompletionTokens
+}
+
+// Ping checks that the provider is reachable and accepts the API key by
+// listing its models. It does not count towards the upstream error rate.
+func (c *DeepseekClient) Ping(ctx context.Context) error {
+	resp, err := c.makeRequest(ctx, "GET", "/v1/models", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SummarizeEmail sends email content to the summarize endpoint
+func (c *DeepseekClient) SummarizeEmail(ctx context.Context, content string, opts SummarizeOptions) (*SummaryResponse, error) {
+	if contentTooShort(content, c.SummarizeMinContentLength) {
+		return shortSummary(content, c.SummarizeMinContentLength), nil
+	}
+	ctx, cancel := withTimeout(ctx, c.Settings().SummarizeTimeout)
+	defer cancel()
+	content = c.fitContent(ctx, content)
+
+	if opts.SplitHistory {
+		return c.summarizeSplitHistory(ctx, content, opts)
+	}
+	if opts.IncludeHighlights {
+		return c.summarizeWithHighlights(ctx, content, opts)
+	}
+
+	summary, choice, err := c.summarizeText(ctx, content, systemPrompt(ctx, summarizeSystemPrompt, ""), opts.MaxWords)
+	if err != nil {
+		return nil, err
+	}
+	return &SummaryResponse{
+		Summary:  summary,
+		Metadata: c.responseMetadata(ctx, choice, content),
+	}, nil
+}
+
+// summarizeSystemPrompt asks for a concise plain-text summary
+const summarizeSystemPrompt = "You are an assistant that summarizes emails. Return a concise summary in plain text."
+
+// historySystemPrompt asks for a brief summary of quoted thread history
+const historySystemPrompt = "You are an assistant that summarizes the quoted history of an email thread. Return a brief summary of the earlier messages in one or two sentences of plain text."
+
+// summarizeText runs one summarization call and post-processes the result
+func (c *DeepseekClient) summarizeText(ctx context.Context, content, systemPrompt string, maxWords int) (string, chatChoice, error) {
+	// Build prompt
+	if maxWords > 0 {
+		systemPrompt += fmt.Sprintf(" The summary must be at most %d words.", maxWords)
+	}
 	reqBody := chatRequest{
-		Model: c.Model,
+		Model: c.Model(),
 		Messages: []chatMessage{
-			{Role: "system", Content: "Classify the email into the most appropriate category. Return ONLY ONE label with the highest confidence score. Output strict JSON: {\"labels\":[{\"label\":string,\"score\":number}]} with no extra text. Common labels: urgent, action_required, follow_up, spam, phishing, personal, meeting_reminder, business_communication, request_feedback, etc."},
-			{Role: "user", Content: fmt.Sprintf("Classify this email (HTML allowed):\n\n%s", content)},
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: fmt.Sprintf("Summarize this email (HTML allowed):\n\n%s", content)},
 		},
+		Temperature: temperature(c.Settings().SummarizeTemperature),
 	}
-	raw, _ := json.Marshal(reqBody)
-	resp, err := c.makeRequest("POST", "/v1/chat/completions", bytes.NewReader(raw), 3)
+	cr, err := c.chatExpanding(ctx, reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return "", chatChoice{}, err
 	}
-	defer resp.Body.Close()
+	summary := c.cleanSummary(cr.Choices[0].Message.Content)
+	if c.tooShort(summary) {
+		c.logf(ctx, "Summary too short (%d characters), retrying once", len([]rune(summary)))
+		retry, err := c.chatExpanding(retryContext(ctx, c.Settings().SummarizeTemperature), reqBody)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", chatChoice{}, err
+			}
+			c.logf(ctx, "Retry after a short summary failed, keeping the first result: %v", err)
+		} else {
+			cr, summary = retry, c.cleanSummary(retry.Choices[0].Message.Content)
+		}
+	}
+	if maxWords > 0 {
+		summary = truncateWords(summary, maxWords)
+	}
+	return summary, cr.Choices[0], nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		// Read response body for error details
-		bodyBytes, readErr := io.ReadAll(resp.Body)
-		errorMsg := fmt.Sprintf("unexpected status code: %d", resp.StatusCode)
-		if readErr == nil && len(bodyBytes) > 0 {
-			errorMsg = fmt.Sprintf("unexpected status code: %d, response: %s", resp.StatusCode, string(bodyBytes))
+// cleanSummary post-processes raw summary text from the model
+func (c *DeepseekClient) cleanSummary(text string) string {
+	return c.summaryPostProcessors().Apply(text)
+}
+
+// retryTemperatureStep is added to the sampling temperature when retrying
+// after an empty result
+const retryTemperatureStep = 0.2
+
+// tooShort reports whether a result is short enough to be retried
+func (c *DeepseekClient) tooShort(text string) bool {
+	return c.RetryEmptyResults && len([]rune(strings.TrimSpace(text))) < c.MinResultLength
+}
+
+// retryContext returns ctx with the sampling temperature raised slightly
+// above the caller's override, or base when there is none
+func retryContext(ctx context.Context, base float64) context.Context {
+	if t, ok := temperatureFromContext(ctx); ok {
+		base = t
+	}
+	return context.WithValue(ctx, temperatureKey{}, math.Min(base+retryTemperatureStep, maxTemperature))
+}
+
+// summarizeSplitHistory summarizes the latest message fully and any quoted
+// history briefly, in separate calls
+func (c *DeepseekClient) summarizeSplitHistory(ctx context.Context, content string, opts SummarizeOptions) (*SummaryResponse, error) {
+	latest, history := splitQuotedHistory(content)
+	if strings.TrimSpace(latest) == "" {
+		// The whole email is quoted; treat it as the latest message
+		latest, history = content, ""
+	}
+
+	latestSummary, choice, err := c.summarizeText(ctx, latest, summarizeSystemPrompt, opts.MaxWords)
+	if err != nil {
+		return nil, err
+	}
+	out := &SummaryResponse{
+		LatestSummary: latestSummary,
+		Metadata:      c.responseMetadata(ctx, choice, content),
+	}
+
+	if strings.TrimSpace(history) != "" {
+		historySummary, _, err := c.summarizeText(ctx, history, historySystemPrompt, opts.MaxWords)
+		if err != nil {
+			return nil, err
+		}
+		out.HistorySummary = historySummary
+	}
+	return out, nil
+}
+
+// highlightsSystemPrompt asks for a summary plus the verbatim sentences it draws on
+const highlightsSystemPrompt = "You are an assistant that summarizes emails. Return a concise plain-text summary and the most important sentences of the email copied verbatim, exactly as they appear. Output strict JSON: {\"summary\":string,\"highlights\":[string]} with no extra text."
+
+// summarizeWithHighlights summarizes content and returns the supporting
+// sentences, dropping any highlight that does not appear in the input
+func (c *DeepseekClient) summarizeWithHighlights(ctx context.Context, content string, opts SummarizeOptions) (*SummaryResponse, error) {
+	systemPrompt := highlightsSystemPrompt
+	if opts.MaxWords > 0 {
+		systemPrompt += fmt.Sprintf(" The summary must be at most %d words.", opts.MaxWords)
+	}
+	reqBody := chatRequest{
+		Model: c.Model(),
+		Messages: []chatMessage{
+			{Role: "system", Content: systemPrompt},
+			{Role: "user", Content: fmt.Sprintf("Summarize this email (HTML allowed):\n\n%s", content)},
+		},
+		Temperature: temperature(c.Settings().SummarizeTemperature),
+	}
+	cr, err := c.chat(ctx, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	responseContent := stripCodeFence(stripScratchpad(cr.Choices[0].Message.Content, c.ScratchpadDelimiter))
+	var parsed struct {
+		Summary    string   `json:"summary"`
+		Highlights []string `json:"highlights"`
+	}
+	if err := json.Unmarshal([]byte(responseContent), &parsed); err != nil {
+		return nil, fmt.Errorf("model did not return valid JSON for summary highlights: %w, content: %s", err, responseContent)
+	}
+
+	summary := c.cleanSummary(parsed.Summary)
+	if opts.MaxWords > 0 {
+		summary = truncateWords(summary, opts.MaxWords)
+	}
+	out := &SummaryResponse{
+		Summary:    summary,
+		Highlights: verifiedHighlights(content, parsed.Highlights),
+		Metadata:   c.responseMetadata(ctx, cr.Choices[0], content),
+	}
+	if dropped := len(parsed.Highlights) - len(out.Highlights); dropped > 0 {
+		c.logf(ctx, "Dropped %d highlight(s) not found in the input", dropped)
+		out.Metadata.Warnings = append(out.Metadata.Warnings, fmt.Sprintf("%d highlight(s) not found in the email were removed", dropped))
+	}
+	return out, nil
+}
+
+// verifiedHighlights keeps the highlights that occur in content, comparing
+// with whitespace collapsed so line wrapping in the email does not matter.
+// Duplicates and empty entries are dropped.
+func verifiedHighlights(content string, highlights []string) []string {
+	normalized := strings.Join(strings.Fields(content), " ")
+	out := []string{}
+	seen := make(map[string]bool)
+	for _, highlight := range highlights {
+		h := strings.Join(strings.Fields(highlight), " ")
+		if h == "" || seen[h] || !strings.Contains(normalized, h) {
+			continue
 		}
+		seen[h] = true
+		out = append(out, h)
+	}
+	return out
+}
 
-		// Try to decode as APIError
-		var apiErr APIError
-		if json.Unmarshal(bodyBytes, &apiErr) == nil {
-			return nil, &apiErr
+// truncateWords cuts text to at most maxWords words, appending an ellipsis when
+// anything was removed. Line breaks within the kept words are preserved.
+func truncateWords(text string, maxWords int) string {
+	words := 0
+	inWord := false
+	for i, r := range text {
+		if unicode.IsSpace(r) {
+			inWord = false
+			continue
 		}
+		if !inWord {
+			inWord = true
+			words++
+			if words > maxWords {
+				return strings.TrimRightFunc(text[:i], unicode.IsSpace) + "…"
+			}
+		}
+	}
+	return text
+}
+
+// JSON strictness levels for the classify prompt
+const (
+	JSONStrictnessNormal = "normal"
+	JSONStrictnessStrict = "strict"
+)
+
+// classifySingleLabelInstruction limits the classify prompts to one label; it
+// is replaced when ClassifyMaxLabels allows more
+const classifySingleLabelInstruction = "Return ONLY ONE label with the highest confidence score"
+
+// classifyJSONInstruction is the output format of the classify prompt; it is
+// kept when a caller overrides the system prompt
+const classifyJSONInstruction = "Output strict JSON: {\"labels\":[{\"label\":string,\"score\":number}]} with no extra text."
+
+// classifyRationaleJSONInstruction is classifyJSONInstruction with a rationale per label
+const classifyRationaleJSONInstruction = "Output strict JSON: {\"labels\":[{\"label\":string,\"score\":number,\"rationale\":string}]} with no extra text."
+
+// classifySystemPrompt instructs the model to output strict JSON with single best label
+const classifySystemPrompt = "Classify the email into the most appropriate category. " + classifySingleLabelInstruction + ". " + classifyJSONInstruction + " Common labels: urgent, action_required, follow_up, spam, phishing, personal, meeting_reminder, business_communication, request_feedback, etc."
+
+// classifyRationaleSystemPrompt is classifySystemPrompt with a per-label rationale
+const classifyRationaleSystemPrompt = "Classify the email into the most appropriate category. " + classifySingleLabelInstruction + " and a one-sentence rationale explaining why it applies. " + classifyRationaleJSONInstruction + " Common labels: urgent, action_required, follow_up, spam, phishing, personal, meeting_reminder, business_communication, request_feedback, etc."
 
-		return nil, fmt.Errorf(errorMsg)
+// strictJSONSuffix is appended to the classify prompt in strict mode
+const strictJSONSuffix = " IMPORTANT: Respond with only a JSON object. No prose, no explanations, no markdown, no code fences. The first character of your reply must be { and the last must be }."
+
+// strictJSONTemperature is the sampling temperature used in strict mode; a
+// per-request temperature does not replace it
+const strictJSONTemperature = 0.0
+
+// buildClassifyRequest builds the chat request for classifying content
+func (c *DeepseekClient) buildClassifyRequest(ctx context.Context, content string, opts ClassifyOptions) chatRequest {
+	builtin, formatInstruction := classifySystemPrompt, classifyJSONInstruction
+	if opts.IncludeRationale {
+		builtin, formatInstruction = classifyRationaleSystemPrompt, classifyRationaleJSONInstruction
+	}
+	if c.ClassifyMaxLabels > 1 {
+		builtin = strings.Replace(builtin, classifySingleLabelInstruction,
+			fmt.Sprintf("Return up to %d labels that apply, each with its confidence score", c.ClassifyMaxLabels), 1)
+	}
+	prompt := systemPrompt(ctx, builtin, formatInstruction)
+	t := c.Settings().ClassifyTemperature
+	if c.JSONStrictness == JSONStrictnessStrict {
+		prompt += strictJSONSuffix
+		t = strictJSONTemperature
+	}
+	req := chatRequest{
+		Model: c.Model(),
+		Messages: []chatMessage{
+			{Role: "system", Content: prompt},
+			{Role: "user", Content: fmt.Sprintf("Classify this email (HTML allowed):\n\n%s", content)},
+		},
+		Temperature:      temperature(t),
+		FixedTemperature: c.JSONStrictness == JSONStrictnessStrict,
+	}
+	if c.ClassifyChoices > 1 {
+		req.N = c.ClassifyChoices
 	}
+	return req
+}
 
-	var cr chatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&cr); err != nil {
-		return nil, fmt.Errorf("failed to decode chat response: %w", err)
+// ClassifyEmail sends email content to the classify endpoint
+func (c *DeepseekClient) ClassifyEmail(ctx context.Context, content string, opts ClassifyOptions) (*ClassifyResponse, error) {
+	if contentTooShort(content, c.ClassifyMinContentLength) {
+		return &ClassifyResponse{Labels: []ClassificationLabel{{Label: shortMessageLabel, Score: 1}}, ShortMessage: true}, nil
 	}
-	if len(cr.Choices) == 0 {
-		return nil, fmt.Errorf("no choices returned from model")
+	if c.degraded(ctx) {
+		return &ClassifyResponse{
+			Labels:   []ClassificationLabel{{Label: c.ClassifyFallbackLabel, Score: 0}},
+			Degraded: true,
+		}, nil
 	}
+	ctx, cancel := withTimeout(ctx, c.Settings().ClassifyTimeout)
+	defer cancel()
+	content = c.fitContent(ctx, content)
+	reqBody := c.buildClassifyRequest(ctx, content, opts)
+	cr, err := c.chat(ctx, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if len(cr.Choices) > 1 {
+		if c.AggregateClassifyChoices {
+			c.logf(ctx, "Classification returned %d choices; aggregating labels", len(cr.Choices))
+			return c.aggregateClassifyChoices(ctx, cr.Choices)
+		}
+		c.logf(ctx, "Classification returned %d choices; using only the first", len(cr.Choices))
+	}
+	return c.parseClassifyChoice(ctx, cr.Choices[0])
+}
+
+// errInvalidClassifyJSON is wrapped by errors from unparseable classify output
+var errInvalidClassifyJSON = errors.New("model did not return valid JSON for classification")
+
+// parseClassifyChoice parses the JSON labels of a single classify choice
+func (c *DeepseekClient) parseClassifyChoice(ctx context.Context, choice chatChoice) (*ClassifyResponse, error) {
 	var out ClassifyResponse
 	// Try to parse strict JSON from model content
-	responseContent := strings.TrimSpace(cr.Choices[0].Message.Content)
-	
+	responseContent := strings.TrimSpace(choice.Message.Content)
+
 	// Log raw content for debugging
-	log.Printf("DeepSeek API response content: %s", responseContent)
-	
+	c.logf(ctx, "DeepSeek API response content: %s", responseContent)
+
 	// Try to extract JSON if wrapped in markdown code blocks
-	if strings.HasPrefix(responseContent, "```json") {
-		responseContent = strings.TrimPrefix(responseContent, "```json")
-		responseContent = strings.TrimSuffix(responseContent, "```")
-		responseContent = strings.TrimSpace(responseContent)
-	} else if strings.HasPrefix(responseContent, "```") {
-		responseContent = strings.TrimPrefix(responseContent, "```")
-		responseContent = strings.TrimSuffix(responseContent, "```")
-		responseContent = strings.TrimSpace(responseContent)
-	}
-	
+	responseContent = stripCodeFence(responseContent)
+
 	if err := json.Unmarshal([]byte(responseContent), &out); err != nil {
-		log.Printf("Failed to parse JSON from model response: %v, content: %s", err, responseContent)
-		return nil, fmt.Errorf("model did not return valid JSON for classification: %w, content: %s", err, responseContent)
+		repaired := repairJSON(responseContent)
+		if !c.RepairJSON || repaired == responseContent || json.Unmarshal([]byte(repaired), &out) != nil {
+			c.logf(ctx, "Failed to parse JSON from model response: %v, content: %s", err, responseContent)
+			return nil, fmt.Errorf("%w: %w, content: %s", errInvalidClassifyJSON, err, responseContent)
+		}
+		c.logf(ctx, "Repaired malformed classification JSON (%v)", err)
 	}
-	
+
 	// Validate that labels are not empty
 	if len(out.Labels) == 0 {
-		log.Printf("Warning: Model returned empty labels, content: %s", responseContent)
+		c.logf(ctx, "Warning: Model returned empty labels, content: %s", responseContent)
 	}
-	
+	out.Labels = c.normalizeLabels(out.Labels)
+
 	return &out, nil
 }
 
+// aggregateClassifyChoices merges the labels of every parseable choice,
+// keeping the highest score seen for each label. Choices that fail to parse
+// are skipped; it fails only when none parse.
+func (c *DeepseekClient) aggregateClassifyChoices(ctx context.Context, choices []chatChoice) (*ClassifyResponse, error) {
+	out := &ClassifyResponse{Labels: []ClassificationLabel{}}
+	var firstErr error
+	parsed := 0
+	for _, choice := range choices {
+		resp, err := c.parseClassifyChoice(ctx, choice)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		parsed++
+		out.Labels = append(out.Labels, resp.Labels...)
+	}
+	if parsed == 0 {
+		return nil, firstErr
+	}
+	out.Labels = dedupeLabels(out.Labels)
+	return out, nil
+}
+
+// Draft output formats
+const (
+	DraftFormatText = "text"
+	DraftFormatHTML = "html"
+	DraftFormatBoth = "both"
+)
+
+// DraftOptions holds per-request drafting settings
+type DraftOptions struct {
+	// N is the number of candidate drafts to generate
+	N int
+	// Format selects plain text, sanitized HTML or both; "" means text
+	Format string
+	// IncludeConfidence asks the model how appropriate its draft is, returned
+	// as Confidence and NeedsHumanReview
+	IncludeConfidence bool
+}
+
+// draftSystemPrompt asks for a plain text reply
+const draftSystemPrompt = "Write a polite, concise reply to the user's email. Output only the reply text."
+
+// draftHTMLInstruction is the output format of the HTML draft prompt; it is
+// kept when a caller overrides the system prompt
+const draftHTMLInstruction = "Write the reply as valid HTML using only simple tags (p, br, strong, em, ul, ol, li, a). Output only the HTML fragment, without html, head or body tags."
+
+// draftHTMLSystemPrompt asks for a reply as a simple HTML fragment
+const draftHTMLSystemPrompt = "Write a polite, concise reply to the user's email. " + draftHTMLInstruction
+
+// draftSalutationSuffix is appended to the draft prompt when salutations are enabled
+const draftSalutationSuffix = " Begin with a greeting appropriate to the email's language and tone, addressing the sender by name if the email shows it, and end with a matching sign-off."
+
+// draftPrompt returns the draft system prompt for format, in the voice of
+// the request's persona if one was selected
+func (c *DeepseekClient) draftPrompt(ctx context.Context, format string) string {
+	wantHTML := format == DraftFormatHTML || format == DraftFormatBoth
+	_, override := systemPromptFromContext(ctx)
+	var prompt string
+	switch {
+	case override && wantHTML:
+		prompt = systemPrompt(ctx, "", draftHTMLInstruction)
+	case override:
+		prompt = systemPrompt(ctx, "", "")
+	default:
+		prompt = draftSystemPrompt
+		if wantHTML {
+			prompt = draftHTMLSystemPrompt
+		}
+		if c.DraftIncludeSalutation {
+			prompt += draftSalutationSuffix
+		}
+	}
+	// A persona sets the voice ahead of the task instructions
+	if persona, ok := personaFromContext(ctx); ok {
+		prompt = persona + " " + prompt
+	}
+	return prompt
+}
+
 // DraftReply sends email content to the draft endpoint
-func (c *DeepseekClient) DraftReply(content string) (*DraftResponse, error) {
+// opts.N is the number of candidate drafts to generate; when N > 1 all
+// candidates are returned in Drafts (and DraftsHTML) and Draft holds the first.
+// In html and both formats the model writes HTML, which is sanitized; the
+// plain text draft in both format is derived from it.
+func (c *DeepseekClient) DraftReply(ctx context.Context, content string, opts DraftOptions) (*DraftResponse, error) {
+	if contentTooShort(content, c.DraftMinContentLength) {
+		out := c.cannedDraft(opts.Format)
+		out.Metadata = &ResponseMetadata{ProcessedAt: processedAt(), Warnings: []string{shortContentWarning(c.DraftMinContentLength)}}
+		if opts.IncludeConfidence {
+			// The canned reply was not written for this email
+			c.assessDraft(out, nil, content)
+		}
+		return out, nil
+	}
+	if c.degraded(ctx) {
+		out := c.cannedDraft(opts.Format)
+		out.Metadata = &ResponseMetadata{Degraded: true, ProcessedAt: processedAt()}
+		if opts.IncludeConfidence {
+			c.assessDraft(out, nil, content)
+		}
+		return out, nil
+	}
+	ctx, cancel := withTimeout(ctx, c.Settings().DraftTimeout)
+	defer cancel()
+	content = c.fitContent(ctx, content)
+	prompt := c.draftPrompt(ctx, opts.Format)
+	if opts.IncludeConfidence {
+		prompt += draftConfidenceInstruction
+	}
 	reqBody := chatRequest{
-		Model: c.Model,
+		Model: c.Model(),
 		Messages: []chatMessage{
-			{Role: "system", Content: "Write a polite, concise reply to the user's email. Output only the reply text."},
+			{Role: "system", Content: prompt},
 			{Role: "user", Content: fmt.Sprintf("Write a reply to this email (HTML allowed):\n\n%s", content)},
 		},
+		Temperature: temperature(c.Settings().DraftTemperature),
 	}
-	raw, _ := json.Marshal(reqBody)
-	resp, err := c.makeRequest("POST", "/v1/chat/completions", bytes.NewReader(raw), 3)
+	if opts.N > 1 {
+		reqBody.N = opts.N
+	}
+	cr, err := c.chatExpanding(ctx, reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
+	var env *draftEnvelope
+	if opts.IncludeConfidence {
+		cr.Choices, env = c.unwrapDraftEnvelopes(cr.Choices)
+	}
+	drafts := c.cleanDrafts(cr.Choices, opts.N)
+	if c.tooShort(drafts[0]) {
+		c.logf(ctx, "Draft too short (%d characters), retrying once", len([]rune(drafts[0])))
+		retry, err := c.chatExpanding(retryContext(ctx, c.Settings().DraftTemperature), reqBody)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			c.logf(ctx, "Retry after a short draft failed, keeping the first result: %v", err)
+		} else {
+			if opts.IncludeConfidence {
+				retry.Choices, env = c.unwrapDraftEnvelopes(retry.Choices)
+			}
+			cr, drafts = retry, c.cleanDrafts(retry.Choices, opts.N)
+		}
+	}
+	out := newDraftResponse(drafts, opts)
+	out.Metadata = c.responseMetadata(ctx, cr.Choices[0], content)
+	if opts.IncludeConfidence {
+		c.assessDraft(out, env, content)
+	}
+	return out, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		// Read response body for error details
-		bodyBytes, readErr := io.ReadAll(resp.Body)
-		errorMsg := fmt.Sprintf("unexpected status code: %d", resp.StatusCode)
-		if readErr == nil && len(bodyBytes) > 0 {
-			errorMsg = fmt.Sprintf("unexpected status code: %d, response: %s", resp.StatusCode, string(bodyBytes))
+// cleanDrafts post-processes up to n drafts from the model's choices
+func (c *DeepseekClient) cleanDrafts(choices []chatChoice, n int) []string {
+	var drafts []string
+	for _, choice := range choices {
+		if len(drafts) == n && n > 0 {
+			break
 		}
+		drafts = append(drafts, c.draftPostProcessors().Apply(choice.Message.Content))
+	}
+	return drafts
+}
 
-		// Try to decode as APIError
-		var apiErr APIError
-		if json.Unmarshal(bodyBytes, &apiErr) == nil {
-			return nil, &apiErr
+// newDraftResponse fills the text and HTML fields requested by opts.Format
+// from the model's drafts
+func newDraftResponse(drafts []string, opts DraftOptions) *DraftResponse {
+	out := &DraftResponse{}
+	for _, draft := range drafts {
+		switch opts.Format {
+		case DraftFormatHTML:
+			out.DraftsHTML = append(out.DraftsHTML, sanitizeHTML(draft))
+		case DraftFormatBoth:
+			safe := sanitizeHTML(draft)
+			out.DraftsHTML = append(out.DraftsHTML, safe)
+			out.Drafts = append(out.Drafts, toPlainText(safe))
+		default:
+			out.Drafts = append(out.Drafts, draft)
 		}
+	}
+	if len(out.Drafts) > 0 {
+		out.Draft = out.Drafts[0]
+	}
+	if len(out.DraftsHTML) > 0 {
+		out.DraftHTML = out.DraftsHTML[0]
+	}
+	if opts.N <= 1 {
+		out.Drafts, out.DraftsHTML = nil, nil
+	}
+	return out
+}
 
-		return nil, fmt.Errorf(errorMsg)
+// AnalyzeResponse represents the response from the analyze endpoint
+type AnalyzeResponse struct {
+	Summary  string                `json:"summary"`
+	Labels   []ClassificationLabel `json:"labels"`
+	Metadata *ResponseMetadata     `json:"metadata,omitempty"`
+}
+
+// analyzeSystemPrompt asks for a summary and classification in one JSON envelope
+const analyzeSystemPrompt = "Summarize the email concisely in plain text and classify it into the most appropriate category. Output strict JSON: {\"summary\":string,\"labels\":[{\"label\":string,\"score\":number}]} with ONLY ONE label and no extra text. Common labels: urgent, action_required, follow_up, spam, phishing, personal, meeting_reminder, business_communication, request_feedback, etc."
+
+// AnalyzeEmail summarizes and classifies an email with a single model call.
+// If the combined response cannot be parsed it falls back to separate
+// summarize and classify calls.
+func (c *DeepseekClient) AnalyzeEmail(ctx context.Context, content string) (*AnalyzeResponse, error) {
+	ctx, cancel := withTimeout(ctx, c.Settings().SummarizeTimeout)
+	defer cancel()
+	fitted := c.fitContent(ctx, content)
+
+	reqBody := chatRequest{
+		Model: c.Model(),
+		Messages: []chatMessage{
+			{Role: "system", Content: analyzeSystemPrompt},
+			{Role: "user", Content: fmt.Sprintf("Analyze this email (HTML allowed):\n\n%s", fitted)},
+		},
+		Temperature: temperature(c.Settings().SummarizeTemperature),
+	}
+	cr, err := c.chat(ctx, reqBody)
+	if err != nil {
+		return nil, err
 	}
 
-	var cr chatResponse
-	if err := json.NewDecoder(resp.Body).Decode(&cr); err != nil {
-		return nil, fmt.Errorf("failed to decode chat response: %w", err)
+	responseContent := stripCodeFence(stripScratchpad(cr.Choices[0].Message.Content, c.ScratchpadDelimiter))
+	var out AnalyzeResponse
+	if err := json.Unmarshal([]byte(responseContent), &out); err == nil && strings.TrimSpace(out.Summary) != "" && len(out.Labels) > 0 {
+		out.Summary = c.cleanSummary(out.Summary)
+		out.Labels = c.postProcessLabels(getTopLabel(c.normalizeLabels(out.Labels)))
+		out.Metadata = c.responseMetadata(ctx, cr.Choices[0], fitted)
+		return &out, nil
 	}
-	if len(cr.Choices) == 0 {
-		return nil, fmt.Errorf("no choices returned from model")
+
+	c.logf(ctx, "Combined analyze response could not be parsed, falling back to separate calls: %s", responseContent)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.analyzeSeparately(ctx, content)
+}
+
+// analyzeSeparately produces an AnalyzeResponse from separate summarize and classify calls
+func (c *DeepseekClient) analyzeSeparately(ctx context.Context, content string) (*AnalyzeResponse, error) {
+	summary, err := c.SummarizeEmail(ctx, content, SummarizeOptions{})
+	if err != nil {
+		return nil, err
+	}
+	classification, err := c.ClassifyEmail(ctx, content, ClassifyOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	meta := summary.Metadata
+	if meta == nil {
+		meta = &ResponseMetadata{ProcessedAt: processedAt()}
+	}
+	meta.Warnings = append(meta.Warnings, "combined analysis could not be parsed; used separate summarize and classify calls")
+	return &AnalyzeResponse{
+		Summary:  summary.Summary,
+		Labels:   c.postProcessLabels(getTopLabel(classification.Labels)),
+		Metadata: meta,
+	}, nil
+}
+
+// stripCodeFence removes a surrounding markdown code block from model output
+func stripCodeFence(content string) string {
+	if strings.HasPrefix(content, "```json") {
+		content = strings.TrimPrefix(content, "```json")
+		content = strings.TrimSuffix(content, "```")
+		content = strings.TrimSpace(content)
+	} else if strings.HasPrefix(content, "```") {
+		content = strings.TrimPrefix(content, "```")
+		content = strings.TrimSuffix(content, "```")
+		content = strings.TrimSpace(content)
+	}
+	return content
+}
+
+// maxSuggestions is the number of quick replies returned by SuggestReplies
+const maxSuggestions = 3
+
+// maxSuggestionLength is the maximum length of a quick reply, in characters
+const maxSuggestionLength = 80
+
+// SuggestionsResponse represents the response from the suggest-replies endpoint
+type SuggestionsResponse struct {
+	Suggestions []string `json:"suggestions"`
+}
+
+// SuggestReplies generates up to three short quick-reply suggestions for an email
+func (c *DeepseekClient) SuggestReplies(ctx context.Context, content string) (*SuggestionsResponse, error) {
+	ctx, cancel := withTimeout(ctx, c.Settings().DraftTimeout)
+	defer cancel()
+	content = c.fitContent(ctx, content)
+	reqBody := chatRequest{
+		Model: c.Model(),
+		Messages: []chatMessage{
+			{Role: "system", Content: fmt.Sprintf("Suggest %d short, distinct quick replies (a few words each) the recipient could send in response to the email. Output strict JSON: {\"suggestions\":[string]} with no extra text.", maxSuggestions)},
+			{Role: "user", Content: fmt.Sprintf("Suggest quick replies to this email (HTML allowed):\n\n%s", content)},
+		},
+		Temperature: temperature(c.Settings().DraftTemperature),
+	}
+	cr, err := c.chat(ctx, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	responseContent := stripCodeFence(stripScratchpad(cr.Choices[0].Message.Content, c.ScratchpadDelimiter))
+	var out SuggestionsResponse
+	if err := json.Unmarshal([]byte(responseContent), &out); err != nil {
+		return nil, fmt.Errorf("model did not return valid JSON for suggestions: %w, content: %s", err, responseContent)
+	}
+	out.Suggestions = normalizeSuggestions(out.Suggestions)
+	return &out, nil
+}
+
+// normalizeSuggestions trims, shortens and de-duplicates suggestions, keeping at most maxSuggestions
+func normalizeSuggestions(suggestions []string) []string {
+	out := []string{}
+	seen := make(map[string]bool)
+	for _, suggestion := range suggestions {
+		suggestion = strings.TrimSpace(suggestion)
+		if runes := []rune(suggestion); len(runes) > maxSuggestionLength {
+			suggestion = strings.TrimSpace(string(runes[:maxSuggestionLength]))
+		}
+		key := strings.ToLower(suggestion)
+		if suggestion == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, suggestion)
+		if len(out) == maxSuggestions {
+			break
+		}
+	}
+	return out
+}
+
+// classifyErrorMessage is the per-email error reported to clients. Upstream
+// details stay in the logs; only the kind of failure is exposed.
+func classifyErrorMessage(err error) string {
+	var rateLimitErr *RateLimitError
+	switch {
+	case errors.As(err, &rateLimitErr):
+		return "upstream rate limit exceeded"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "classification timed out"
+	case errors.Is(err, errInvalidClassifyJSON):
+		return "model returned an unparseable classification"
+	default:
+		return "classification failed"
 	}
-	return &DraftResponse{Draft: strings.TrimSpace(cr.Choices[0].Message.Content)}, nil
 }
 
-// ClassifyEmailsBatch processes multiple emails for classification
-func (c *DeepseekClient) ClassifyEmailsBatch(emails []EmailRequest) ([]BatchClassificationResult, error) {
+// ClassifyEmailsBatch processes multiple emails for classification, up to
+// BatchWorkers at once, returning results in the order of emails. Each
+// provider client shares this implementation; an email that fails gets empty
+// labels and an Error instead of failing the batch.
+func (c *DeepseekClient) ClassifyEmailsBatch(ctx context.Context, emails []EmailRequest, opts ClassifyOptions) ([]BatchClassificationResult, error) {
 	results := make([]BatchClassificationResult, len(emails))
-	
-	// Process emails sequentially (can be parallelized if needed)
-	for i, email := range emails {
-		classification, err := c.ClassifyEmail(email.Content)
-		if err != nil {
-			// Log error but continue processing other emails
-			log.Printf("Error classifying email %s: %v", email.ID, err)
-			// Return error result for this email
-			results[i] = BatchClassificationResult{
-				ID:     email.ID,
-				Labels: []ClassificationLabel{},
+	workers := c.BatchWorkers
+	if workers > len(emails) {
+		workers = len(emails)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var batchErr error
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				result, err := c.classifyBatchEmail(ctx, emails[i], opts)
+				if err != nil {
+					errOnce.Do(func() { batchErr = err })
+					continue
+				}
+				results[i] = result
 			}
-			continue
+		}()
+	}
+	// Stop handing out emails once the request deadline has passed instead
+	// of serving fallbacks or empty results for the remaining emails
+	dispatched := 0
+feed:
+	for ; dispatched < len(emails); dispatched++ {
+		select {
+		case indexes <- dispatched:
+		case <-ctx.Done():
+			break feed
 		}
-		
-		// Keep only the label with the highest score
-		topLabel := getTopLabel(classification.Labels)
-		
-		results[i] = BatchClassificationResult{
+	}
+	close(indexes)
+	wg.Wait()
+
+	if batchErr == nil && dispatched < len(emails) {
+		batchErr = ctx.Err()
+	}
+	if batchErr != nil {
+		return nil, batchErr
+	}
+	return results, nil
+}
+
+// classifyBatchEmail classifies one email of a batch. Failures are reported
+// in the result; an error is returned only when ctx ends before the email is
+// classified, which fails the whole batch.
+func (c *DeepseekClient) classifyBatchEmail(ctx context.Context, email EmailRequest, opts ClassifyOptions) (BatchClassificationResult, error) {
+	if err := ctx.Err(); err != nil {
+		return BatchClassificationResult{}, err
+	}
+	// Serve previously classified content from the cache
+	cacheKey := c.classifyCacheKey(ctx, email.Content, opts)
+	if labels, ok := c.cachedLabels(ctx, cacheKey); ok {
+		return BatchClassificationResult{
 			ID:     email.ID,
-			Labels: topLabel,
+			Labels: c.postProcessLabels(labels),
+			Cached: true,
+		}, nil
+	}
+	// A lookup abandoned at the deadline is a miss; do not go upstream
+	if err := ctx.Err(); err != nil {
+		return BatchClassificationResult{}, err
+	}
+
+	// Record this email's prompt when the caller asked for prompts
+	emailCtx, capture := ctx, (*promptCapture)(nil)
+	if promptCaptureFromContext(ctx) != nil {
+		emailCtx, capture = withPromptCapture(ctx)
+	}
+	// A slow email is abandoned on its own so the rest of the batch proceeds
+	emailCtx, cancel := withTimeout(emailCtx, c.BatchEmailTimeout)
+	classification, err := c.ClassifyEmail(emailCtx, email.Content, opts)
+	cancel()
+	if err != nil {
+		// Log error but continue processing other emails
+		c.logf(ctx, "Error classifying email %s: %v", email.ID, err)
+		// Return error result for this email
+		return BatchClassificationResult{
+			ID:     email.ID,
+			Labels: []ClassificationLabel{},
+			Error:  classifyErrorMessage(err),
+		}, nil
+	}
+	// The short-message label is not a model result, so it is neither
+	// constrained, remapped nor cached
+	if classification.ShortMessage {
+		return BatchClassificationResult{
+			ID:           email.ID,
+			Labels:       classification.Labels,
+			ShortMessage: true,
+		}, nil
+	}
+
+	// Keep only the highest-scoring labels allowed by the label count limits
+	topLabel := c.constrainLabels(classification.Labels)
+	if classification.Degraded {
+		// An expired result for the same content beats the fallback label
+		if labels, ok := c.staleLabels(ctx, cacheKey); ok {
+			return BatchClassificationResult{
+				ID:     email.ID,
+				Labels: c.postProcessLabels(labels),
+				Cached: true,
+				Stale:  true,
+			}, nil
 		}
+		return BatchClassificationResult{
+			ID:       email.ID,
+			Labels:   topLabel,
+			Degraded: true,
+		}, nil
+	}
+	c.cacheLabels(ctx, cacheKey, topLabel)
+
+	result := BatchClassificationResult{
+		ID:     email.ID,
+		Labels: c.postProcessLabels(topLabel),
+	}
+	if capture != nil {
+		result.Prompt = capture.messages
+	}
+	return result, nil
+}
+
+// needsReviewLabel is injected when the top label's confidence is too low
+const needsReviewLabel = "needs_review"
+
+// postProcessLabels applies the configured adjustments to a model (or cached)
+// classification before it is returned. Cached entries hold the unadjusted
+// labels so configuration changes apply to them too.
+func (c *DeepseekClient) postProcessLabels(labels []ClassificationLabel) []ClassificationLabel {
+	labels = c.remapLabels(labels)
+	threshold := c.Settings().ReviewThreshold
+	if threshold > 0 && len(labels) > 0 {
+		top := getTopLabel(labels)[0]
+		if top.Score < threshold {
+			review := ClassificationLabel{Label: needsReviewLabel, Score: 1 - top.Score}
+			labels = append([]ClassificationLabel{review}, labels...)
+		}
+	}
+	return labels
+}
+
+// classifyCacheKey returns the cache key for classifying content with the model used for ctx
+func (c *DeepseekClient) classifyCacheKey(ctx context.Context, content string, opts ClassifyOptions) string {
+	key := "classify:" + c.modelFor(ctx) + ":"
+	if prompt, ok := systemPromptFromContext(ctx); ok {
+		key += "prompt:" + contentHash(prompt)[:16] + ":"
+	}
+	if opts.IncludeRationale {
+		key += "rationale:"
+	}
+	return key + contentHash(content)
+}
+
+// cachedLabels looks up cached classification labels; cache errors count as misses
+func (c *DeepseekClient) cachedLabels(ctx context.Context, key string) ([]ClassificationLabel, bool) {
+	if c.Cache == nil {
+		return nil, false
+	}
+	raw, ok, err := c.Cache.Get(ctx, key)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.logf(ctx, "Cache get failed for %s: %v", key, err)
+		}
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	var labels []ClassificationLabel
+	if err := json.Unmarshal(raw, &labels); err != nil {
+		return nil, false
+	}
+	return labels, true
+}
+
+// staleLabels looks up cached classification labels that may have expired,
+// when the cache keeps expired entries
+func (c *DeepseekClient) staleLabels(ctx context.Context, key string) ([]ClassificationLabel, bool) {
+	stale, ok := c.Cache.(StaleCache)
+	if !ok {
+		return nil, false
+	}
+	raw, ok, err := stale.GetStale(ctx, key)
+	if err != nil || !ok {
+		return nil, false
+	}
+	var labels []ClassificationLabel
+	if err := json.Unmarshal(raw, &labels); err != nil {
+		return nil, false
+	}
+	c.logf(ctx, "Serving stale cached classification for %s", key)
+	return labels, true
+}
+
+// cacheLabels stores classification labels; empty results are not cached
+func (c *DeepseekClient) cacheLabels(ctx context.Context, key string, labels []ClassificationLabel) {
+	if c.Cache == nil || len(labels) == 0 {
+		return
+	}
+	raw, _ := json.Marshal(labels)
+	if err := c.Cache.Set(ctx, key, raw); err != nil {
+		c.logf(ctx, "Cache set failed for %s: %v", key, err)
 	}
-	
-	return results, nil
+}
+
+// dedupeLabels merges labels with the same name, ignoring case and
+// surrounding space, keeping the highest-scoring entry in the position of
+// the first
+func dedupeLabels(labels []ClassificationLabel) []ClassificationLabel {
+	index := make(map[string]int, len(labels))
+	out := make([]ClassificationLabel, 0, len(labels))
+	for _, label := range labels {
+		key := strings.ToLower(strings.TrimSpace(label.Label))
+		if i, ok := index[key]; ok {
+			if label.Score > out[i].Score {
+				out[i] = label
+			}
+			continue
+		}
+		index[key] = len(out)
+		out = append(out, label)
+	}
+	return out
+}
+
+// constrainLabels merges duplicate labels, sorts them by descending score,
+// keeps at most ClassifyMaxLabels and pads with the fallback label, at score
+// 0, until ClassifyMinLabels remain
+func (c *DeepseekClient) constrainLabels(labels []ClassificationLabel) []ClassificationLabel {
+	out := dedupeLabels(labels)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	if c.ClassifyMaxLabels > 0 && len(out) > c.ClassifyMaxLabels {
+		out = out[:c.ClassifyMaxLabels]
+	}
+	for len(out) < c.ClassifyMinLabels {
+		out = append(out, ClassificationLabel{Label: c.ClassifyFallbackLabel, Score: 0})
+	}
+	return out
+}
+
+// bestLabel returns the highest-scoring label, or fallback with score 0 when
+// there are none
+func bestLabel(labels []ClassificationLabel, fallback string) ClassificationLabel {
+	top := getTopLabel(labels)
+	if len(top) == 0 {
+		return ClassificationLabel{Label: fallback, Score: 0}
+	}
+	return top[0]
 }
 
 // getTopLabel returns only the label with the highest score
@@ -356,7 +1935,7 @@ func getTopLabel(labels []ClassificationLabel) []ClassificationLabel {
 	if len(labels) == 0 {
 		return []ClassificationLabel{}
 	}
-	
+
 	// Find the label with the highest score
 	topLabel := labels[0]
 	for _, label := range labels[1:] {
@@ -364,6 +1943,6 @@ func getTopLabel(labels []ClassificationLabel) []ClassificationLabel {
 			topLabel = label
 		}
 	}
-	
+
 	return []ClassificationLabel{topLabel}
 }
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// scripted returns a fakeUpstream answering the n-th call with replies[n-1],
+// repeating the last reply once they run out
+func scripted(replies ...func() (*http.Response, error)) *fakeUpstream {
+	return &fakeUpstream{reply: func(n int, _ *http.Request, _ map[string]interface{}) (*http.Response, error) {
+		return replies[min(n, len(replies))-1]()
+	}}
+}
+
+// status returns a reply with status and body
+func status(code int, body string) func() (*http.Response, error) {
+	return func() (*http.Response, error) { return newResponse(code, body), nil }
+}
+
+// withHeader returns reply with header set on its response
+func withHeader(reply func() (*http.Response, error), key, value string) func() (*http.Response, error) {
+	return func() (*http.Response, error) {
+		resp, err := reply()
+		resp.Header.Set(key, value)
+		return resp, err
+	}
+}
+
+// labelsReply is a successful classification
+func labelsReply() (*http.Response, error) {
+	return chatReply(`{"labels":[{"label":"urgent","score":0.9}]}`), nil
+}
+
+func TestClassifyUpstreamFailures(t *testing.T) {
+	connReset := func() (*http.Response, error) { return nil, errors.New("connection reset by peer") }
+	truncated := func() (*http.Response, error) {
+		resp := newResponse(http.StatusOK, "")
+		resp.Body = io.NopCloser(strings.NewReader(`{"choices":[{"index":0,`))
+		return resp, nil
+	}
+	tests := []struct {
+		name    string
+		replies []func() (*http.Response, error)
+		calls   int
+		wantErr func(error) bool
+	}{
+		{"success", []func() (*http.Response, error){labelsReply}, 1, nil},
+		{"5xx retried then success", []func() (*http.Response, error){status(503, "busy"), status(502, "bad gateway"), labelsReply}, 3, nil},
+		{"5xx exhausts retries", []func() (*http.Response, error){status(500, `{"message":"boom","code":500}`)}, 4, func(err error) bool {
+			var apiErr *APIError
+			return errors.As(err, &apiErr) && apiErr.Code == 500
+		}},
+		{"429 with Retry-After retried", []func() (*http.Response, error){withHeader(status(429, "slow down"), "Retry-After", "0"), labelsReply}, 2, nil},
+		{"429 without Retry-After not retried", []func() (*http.Response, error){status(429, "slow down")}, 1, func(err error) bool {
+			var rateErr *RateLimitError
+			return errors.As(err, &rateErr)
+		}},
+		{"429 exhausts retries", []func() (*http.Response, error){withHeader(status(429, "slow down"), "Retry-After", "0")}, 3, func(err error) bool {
+			var rateErr *RateLimitError
+			return errors.As(err, &rateErr) && rateErr.RetryAfter == "0"
+		}},
+		{"transport error retried", []func() (*http.Response, error){connReset, labelsReply}, 2, nil},
+		{"truncated body retried", []func() (*http.Response, error){truncated, labelsReply}, 2, nil},
+		{"undecodable response", []func() (*http.Response, error){status(200, "<html>oops</html>")}, 1, func(err error) bool {
+			return strings.Contains(err.Error(), "failed to decode chat response")
+		}},
+		{"unparseable labels", []func() (*http.Response, error){func() (*http.Response, error) { return chatReply("I think it is urgent"), nil }}, 1, func(err error) bool {
+			return errors.Is(err, errInvalidClassifyJSON)
+		}},
+		{"no choices", []func() (*http.Response, error){status(200, `{"choices":[]}`)}, 1, func(err error) bool {
+			return strings.Contains(err.Error(), "no choices")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLASSIFY_JSON_REPAIR", "false")
+			upstream := scripted(tt.replies...)
+			c := newTestClient(t, upstream)
+			c.backoffBase = time.Millisecond
+
+			out, err := c.ClassifyEmail(context.Background(), "The production database is down and customers cannot log in.", ClassifyOptions{})
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("ClassifyEmail: %v", err)
+				}
+				if len(out.Labels) == 0 || out.Labels[0].Label != "urgent" {
+					t.Errorf("labels = %+v, want urgent", out.Labels)
+				}
+			} else if err == nil || !tt.wantErr(err) {
+				t.Errorf("ClassifyEmail error = %v, not the expected kind", err)
+			}
+			if got := upstream.calls(); got != tt.calls {
+				t.Errorf("upstream calls = %d, want %d", got, tt.calls)
+			}
+		})
+	}
+}
+
+func TestDoWithRetryStopsOnCancel(t *testing.T) {
+	upstream := scripted(status(503, "busy"))
+	c := newTestClient(t, upstream)
+	c.backoffBase = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := c.ClassifyEmail(ctx, "The production database is down and customers cannot log in.", ClassifyOptions{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want the context deadline", err)
+	}
+	if upstream.calls() != 1 {
+		t.Errorf("upstream calls = %d, want 1", upstream.calls())
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		name   string
+		jitter string
+	}{
+		{"exponential", "false"},
+		{"full jitter", "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BACKOFF_JITTER", tt.jitter)
+			t.Setenv("BACKOFF_JITTER_SEED", "42")
+			a := newTestClient(t, replying(""))
+			b := newTestClient(t, replying(""))
+			for attempt := 1; attempt <= 5; attempt++ {
+				ceiling := time.Duration(1<<uint(attempt-1)) * time.Second
+				got := a.backoffDelay(attempt)
+				if tt.jitter != "true" && got != ceiling {
+					t.Errorf("attempt %d: delay = %v, want %v", attempt, got, ceiling)
+				}
+				if got < 0 || got > ceiling {
+					t.Errorf("attempt %d: delay = %v, want within [0, %v]", attempt, got, ceiling)
+				}
+				if same := b.backoffDelay(attempt); same != got {
+					t.Errorf("attempt %d: delays with the same seed differ: %v and %v", attempt, got, same)
+				}
+			}
+		})
+	}
+}
+
+func TestClassifyJSONStrictness(t *testing.T) {
+	tests := []struct {
+		name        string
+		strictness  string
+		strict      bool
+		temperature float64
+	}{
+		{"default", "", false, 0.4},
+		{"normal", "normal", false, 0.4},
+		{"strict", "STRICT", true, strictJSONTemperature},
+		{"unknown falls back to normal", "loose", false, 0.4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLASSIFY_JSON_STRICTNESS", tt.strictness)
+			t.Setenv("CLASSIFY_TEMPERATURE", "0.4")
+			upstream := replying(`{"labels":[{"label":"urgent","score":0.9}]}`)
+			c := newTestClient(t, upstream)
+			if _, err := c.ClassifyEmail(context.Background(), "The server is down again", ClassifyOptions{}); err != nil {
+				t.Fatalf("ClassifyEmail: %v", err)
+			}
+			if got := strings.Contains(upstream.messages(0), strictJSONSuffix); got != tt.strict {
+				t.Errorf("strict wording in prompt = %v, want %v", got, tt.strict)
+			}
+			if got := upstream.body(0)["temperature"]; got != tt.temperature {
+				t.Errorf("temperature = %v, want %v", got, tt.temperature)
+			}
+		})
+	}
+}
+
+func TestClassifyMultipleChoices(t *testing.T) {
+	first := `{"labels":[{"label":"urgent","score":0.6},{"label":"spam","score":0.1}]}`
+	second := `{"labels":[{"label":"Urgent","score":0.9},{"label":"follow_up","score":0.4}]}`
+	tests := []struct {
+		name      string
+		aggregate bool
+		choices   []string
+		want      []ClassificationLabel
+		wantErr   bool
+	}{
+		{"first choice only", false, []string{first, second}, []ClassificationLabel{{Label: "urgent", Score: 0.6}, {Label: "spam", Score: 0.1}}, false},
+		{"union with max score", true, []string{first, second}, []ClassificationLabel{{Label: "Urgent", Score: 0.9}, {Label: "spam", Score: 0.1}, {Label: "follow_up", Score: 0.4}}, false},
+		{"unparseable choice skipped", true, []string{"not json", second}, []ClassificationLabel{{Label: "Urgent", Score: 0.9}, {Label: "follow_up", Score: 0.4}}, false},
+		{"all unparseable", true, []string{"not json", "nor this"}, nil, true},
+		{"single choice", true, []string{first}, []ClassificationLabel{{Label: "urgent", Score: 0.6}, {Label: "spam", Score: 0.1}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLASSIFY_JSON_REPAIR", "false")
+			if tt.aggregate {
+				t.Setenv("CLASSIFY_AGGREGATE_CHOICES", "true")
+			}
+			logs := captureLog(t)
+			c := newTestClient(t, replying(tt.choices...))
+			out, err := c.ClassifyEmail(context.Background(), "The server is down again and customers are waiting", ClassifyOptions{})
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidClassifyJSON) {
+					t.Errorf("ClassifyEmail error = %v, want invalid JSON", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ClassifyEmail: %v", err)
+			}
+			if !reflect.DeepEqual(out.Labels, tt.want) {
+				t.Errorf("labels = %+v, want %+v", out.Labels, tt.want)
+			}
+			if logged := strings.Contains(logs.String(), "Classification returned 2 choices"); logged != (len(tt.choices) > 1) {
+				t.Errorf("multiple choices logged = %v, want %v (logs %q)", logged, len(tt.choices) > 1, logs.String())
+			}
+		})
+	}
+}
+
+func TestClassifyRationale(t *testing.T) {
+	tests := []struct {
+		name      string
+		rationale bool
+		reply     string
+		want      []ClassificationLabel
+		wantJSON  string
+	}{
+		{"with rationale", true,
+			`{"labels":[{"label":"urgent","score":0.9,"rationale":"The server outage is blocking customers."}]}`,
+			[]ClassificationLabel{{Label: "urgent", Score: 0.9, Rationale: "The server outage is blocking customers."}},
+			`[{"label":"urgent","score":0.9,"rationale":"The server outage is blocking customers."}]`},
+		{"without rationale", false,
+			`{"labels":[{"label":"urgent","score":0.9}]}`,
+			[]ClassificationLabel{{Label: "urgent", Score: 0.9}},
+			`[{"label":"urgent","score":0.9}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying(tt.reply)
+			c := newTestClient(t, upstream)
+			out, err := c.ClassifyEmail(context.Background(), "The server is down again", ClassifyOptions{IncludeRationale: tt.rationale})
+			if err != nil {
+				t.Fatalf("ClassifyEmail: %v", err)
+			}
+			if !reflect.DeepEqual(out.Labels, tt.want) {
+				t.Errorf("labels = %+v, want %+v", out.Labels, tt.want)
+			}
+			encoded, _ := json.Marshal(out.Labels)
+			if string(encoded) != tt.wantJSON {
+				t.Errorf("encoded labels = %s, want %s", encoded, tt.wantJSON)
+			}
+			if got := strings.Contains(upstream.messages(0), classifyRationaleJSONInstruction); got != tt.rationale {
+				t.Errorf("rationale instruction in prompt = %v, want %v", got, tt.rationale)
+			}
+		})
+	}
+}
+
+func TestRetryEmptyResults(t *testing.T) {
+	ok := func(content string) func() (*http.Response, error) {
+		return func() (*http.Response, error) { return chatReply(content), nil }
+	}
+	operations := []struct {
+		name        string
+		temperature float64
+		call        func(c *DeepseekClient) (string, error)
+	}{
+		{"summarize", defaultSummarizeTemperature, func(c *DeepseekClient) (string, error) {
+			out, err := c.SummarizeEmail(context.Background(), "The launch moves to Friday because QA found a bug.", SummarizeOptions{})
+			if err != nil {
+				return "", err
+			}
+			return out.Summary, nil
+		}},
+		{"draft", defaultDraftTemperature, func(c *DeepseekClient) (string, error) {
+			out, err := c.DraftReply(context.Background(), "Can we meet on Friday?", DraftOptions{N: 1})
+			if err != nil {
+				return "", err
+			}
+			return out.Draft, nil
+		}},
+	}
+	tests := []struct {
+		name      string
+		enabled   bool
+		minLength string
+		replies   []func() (*http.Response, error)
+		want      string
+		calls     int
+	}{
+		{"disabled keeps empty result", false, "", []func() (*http.Response, error){ok(""), ok("Friday works.")}, "", 1},
+		{"empty then good", true, "", []func() (*http.Response, error){ok("   "), ok("Friday works.")}, "Friday works.", 2},
+		{"below minimum length", true, "10", []func() (*http.Response, error){ok("Ok."), ok("Friday works.")}, "Friday works.", 2},
+		{"long enough not retried", true, "10", []func() (*http.Response, error){ok("Friday works."), ok("Other.")}, "Friday works.", 1},
+		{"failed retry keeps first result", true, "10", []func() (*http.Response, error){ok("Ok."), status(500, "boom")}, "Ok.", 2},
+	}
+	for _, op := range operations {
+		for _, tt := range tests {
+			op, tt := op, tt
+			t.Run(op.name+"/"+tt.name, func(t *testing.T) {
+				t.Setenv("RETRY_EMPTY_RESULTS", strconv.FormatBool(tt.enabled))
+				t.Setenv("MIN_RESULT_LENGTH", tt.minLength)
+				t.Setenv("SERVER_MAX_RETRIES", "0")
+				upstream := scripted(tt.replies...)
+				c := newTestClient(t, upstream)
+				got, err := op.call(c)
+				if err != nil {
+					t.Fatalf("call: %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("result = %q, want %q", got, tt.want)
+				}
+				if upstream.calls() != tt.calls {
+					t.Fatalf("upstream calls = %d, want %d", upstream.calls(), tt.calls)
+				}
+				if tt.calls == 2 {
+					want := op.temperature + retryTemperatureStep
+					if got := upstream.body(1)["temperature"].(float64); math.Abs(got-want) > 1e-9 {
+						t.Errorf("retry temperature = %v, want %v", got, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestContextLengthFallback(t *testing.T) {
+	tooLong := status(400, `{"error":{"message":"This model's maximum context length is 65536 tokens","code":"context_length_exceeded"}}`)
+	ok := func() (*http.Response, error) { return chatReply("Launch moves to Friday."), nil }
+	tests := []struct {
+		name     string
+		fallback string
+		replies  []func() (*http.Response, error)
+		models   []string
+		wantErr  bool
+	}{
+		{"switches to the fallback model", "deepseek-chat-128k", []func() (*http.Response, error){tooLong, ok}, []string{"deepseek-chat", "deepseek-chat-128k"}, false},
+		{"no fallback configured", "", []func() (*http.Response, error){tooLong, ok}, []string{"deepseek-chat"}, true},
+		{"fallback also too long", "deepseek-chat-128k", []func() (*http.Response, error){tooLong}, []string{"deepseek-chat", "deepseek-chat-128k"}, true},
+		{"other 400 not retried", "deepseek-chat-128k", []func() (*http.Response, error){status(400, `{"error":{"message":"invalid temperature"}}`), ok}, []string{"deepseek-chat"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEEPSEEK_MODEL", "deepseek-chat")
+			t.Setenv("FALLBACK_LARGE_MODEL", tt.fallback)
+			upstream := scripted(tt.replies...)
+			c := newTestClient(t, upstream)
+			out, err := c.SummarizeEmail(context.Background(), "The launch moves to Friday because QA found a bug.", SummarizeOptions{})
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("SummarizeEmail = %+v, want an error", out)
+				}
+			} else if err != nil {
+				t.Fatalf("SummarizeEmail: %v", err)
+			} else if out.Summary != "Launch moves to Friday." {
+				t.Errorf("summary = %q", out.Summary)
+			}
+			var models []string
+			for i := 0; i < upstream.calls(); i++ {
+				models = append(models, upstream.body(i)["model"].(string))
+			}
+			if !reflect.DeepEqual(models, tt.models) {
+				t.Errorf("models called = %q, want %q", models, tt.models)
+			}
+		})
+	}
+}
+
+func TestBatchEmailTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout string
+		want    []string // per-email error, "" for success
+	}{
+		{"slow email abandoned", "50ms", []string{"", "classification timed out", ""}},
+		{"no timeout waits for the request deadline", "", []string{"", "", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BATCH_EMAIL_TIMEOUT", tt.timeout)
+			t.Setenv("NET_MAX_RETRIES", "0")
+			t.Setenv("BATCH_DEDUP_ENABLED", "false")
+			captureLog(t)
+			hang := 200 * time.Millisecond
+			upstream := &fakeUpstream{reply: func(_ int, req *http.Request, body map[string]interface{}) (*http.Response, error) {
+				msgs := body["messages"].([]interface{})
+				if strings.Contains(msgs[len(msgs)-1].(map[string]interface{})["content"].(string), "pathological") {
+					select {
+					case <-time.After(hang):
+					case <-req.Context().Done():
+						return nil, req.Context().Err()
+					}
+				}
+				return chatReply(`{"labels":[{"label":"urgent","score":0.9}]}`), nil
+			}}
+			c := newTestClient(t, upstream)
+			start := time.Now()
+			results, err := c.ClassifyEmailsBatch(context.Background(), []EmailRequest{
+				{ID: "a", Content: "The server is down again"},
+				{ID: "b", Content: "A pathological email the model chews on forever"},
+				{ID: "c", Content: "Lunch on Thursday?"},
+			}, ClassifyOptions{})
+			if err != nil {
+				t.Fatalf("ClassifyEmailsBatch: %v", err)
+			}
+			for i, want := range tt.want {
+				if results[i].Error != want {
+					t.Errorf("result %s error = %q, want %q", results[i].ID, results[i].Error, want)
+				}
+				if want == "" && (len(results[i].Labels) == 0 || results[i].Labels[0].Label != "urgent") {
+					t.Errorf("result %s labels = %+v, want urgent", results[i].ID, results[i].Labels)
+				}
+			}
+			if tt.timeout != "" && time.Since(start) >= hang {
+				t.Errorf("batch took %v, want the slow email abandoned before %v", time.Since(start), hang)
+			}
+		})
+	}
+}
+
+func TestBatchWorkerPool(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	tests := []struct {
+		name        string
+		workers     string
+		timeout     string
+		emails      int
+		wantWorkers int32
+		maxElapsed  time.Duration
+		wantErr     string
+	}{
+		{"sequential", "1", "", 4, 1, time.Hour, ""},
+		{"pool", "3", "", 6, 3, 4 * delay, ""},
+		{"pool larger than the batch", "8", "", 2, 2, 2 * delay, ""},
+		{"default size", "", "", 6, defaultBatchWorkers, 3 * delay, ""},
+		{"slow emails time out together", "4", "20ms", 4, 4, 2 * delay, "classification timed out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BATCH_WORKERS", tt.workers)
+			t.Setenv("BATCH_EMAIL_TIMEOUT", tt.timeout)
+			t.Setenv("NET_MAX_RETRIES", "0")
+			t.Setenv("BATCH_DEDUP_ENABLED", "false")
+			captureLog(t)
+			var active, peak int32
+			upstream := &fakeUpstream{reply: func(_ int, req *http.Request, body map[string]interface{}) (*http.Response, error) {
+				n := atomic.AddInt32(&active, 1)
+				defer atomic.AddInt32(&active, -1)
+				for p := atomic.LoadInt32(&peak); n > p && !atomic.CompareAndSwapInt32(&peak, p, n); p = atomic.LoadInt32(&peak) {
+				}
+				select {
+				case <-time.After(delay):
+				case <-req.Context().Done():
+					return nil, req.Context().Err()
+				}
+				// Label each email with its topic so results can be matched to emails
+				msgs := body["messages"].([]interface{})
+				content := msgs[len(msgs)-1].(map[string]interface{})["content"].(string)
+				topic := content[strings.Index(content, "topic"):]
+				topic = topic[:strings.IndexByte(topic, ' ')]
+				return chatReply(`{"labels":[{"label":"` + topic + `","score":0.9}]}`), nil
+			}}
+			c := newTestClient(t, upstream)
+			emails := make([]EmailRequest, tt.emails)
+			for i := range emails {
+				emails[i] = EmailRequest{ID: strconv.Itoa(i), Content: "Please look at topic" + strconv.Itoa(i) + " before the review"}
+			}
+
+			start := time.Now()
+			results, err := c.ClassifyEmailsBatch(context.Background(), emails, ClassifyOptions{})
+			elapsed := time.Since(start)
+			if err != nil {
+				t.Fatalf("ClassifyEmailsBatch: %v", err)
+			}
+			if peak != tt.wantWorkers {
+				t.Errorf("concurrent upstream calls = %d, want %d", peak, tt.wantWorkers)
+			}
+			if elapsed >= tt.maxElapsed {
+				t.Errorf("batch took %v, want under %v", elapsed, tt.maxElapsed)
+			}
+			for i, r := range results {
+				if r.ID != emails[i].ID {
+					t.Errorf("result %d is for email %s, want results in request order", i, r.ID)
+				}
+				if r.Error != tt.wantErr {
+					t.Errorf("result %s error = %q, want %q", r.ID, r.Error, tt.wantErr)
+				}
+				if want := "topic" + r.ID; tt.wantErr == "" && (len(r.Labels) == 0 || r.Labels[0].Label != want) {
+					t.Errorf("result %s labels = %+v, want %s", r.ID, r.Labels, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Defaults for error-rate based degradation
+const (
+	defaultDegradeErrorRate   = 0.5
+	defaultDegradeWindow      = time.Minute
+	defaultDegradeMinRequests = 10
+	defaultClassifyFallback   = "uncategorized"
+	defaultDegradedDraftText  = "Thank you for your email. We have received it and will get back to you shortly."
+)
+
+// maxTrackedOutcomes bounds the memory used by the rolling window
+const maxTrackedOutcomes = 1000
+
+// outcome records whether a single upstream call failed
+type outcome struct {
+	at     time.Time
+	failed bool
+}
+
+// ErrorRateTracker tracks the upstream error rate over a rolling time window
+type ErrorRateTracker struct {
+	mu          sync.Mutex
+	window      time.Duration
+	threshold   float64
+	minRequests int
+	outcomes    []outcome
+	now         func() time.Time
+}
+
+// NewErrorRateTracker creates a tracker that reports degraded once at least
+// minRequests calls in window have an error rate above threshold
+func NewErrorRateTracker(window time.Duration, threshold float64, minRequests int) *ErrorRateTracker {
+	return &ErrorRateTracker{
+		window:      window,
+		threshold:   threshold,
+		minRequests: minRequests,
+		now:         time.Now,
+	}
+}
+
+// Record adds the outcome of an upstream call
+func (t *ErrorRateTracker) Record(failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.outcomes = append(t.outcomes, outcome{at: t.now(), failed: failed})
+	if len(t.outcomes) > maxTrackedOutcomes {
+		t.outcomes = t.outcomes[len(t.outcomes)-maxTrackedOutcomes:]
+	}
+}
+
+// ErrorRate returns the error rate and number of calls within the window
+func (t *ErrorRateTracker) ErrorRate() (float64, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := t.now().Add(-t.window)
+	i := 0
+	for i < len(t.outcomes) && t.outcomes[i].at.Before(cutoff) {
+		i++
+	}
+	t.outcomes = t.outcomes[i:]
+
+	if len(t.outcomes) == 0 {
+		return 0, 0
+	}
+	failures := 0
+	for _, o := range t.outcomes {
+		if o.failed {
+			failures++
+		}
+	}
+	return float64(failures) / float64(len(t.outcomes)), len(t.outcomes)
+}
+
+// Degraded reports whether the rolling error rate is above the threshold.
+// Failures age out of the window, so the tracker recovers on its own.
+func (t *ErrorRateTracker) Degraded() bool {
+	rate, n := t.ErrorRate()
+	return n >= t.minRequests && rate > t.threshold
+}
+
+// newErrorRateTrackerFromEnv builds the tracker from DEGRADE_* settings.
+// It returns nil when degradation is disabled.
+func newErrorRateTrackerFromEnv() *ErrorRateTracker {
+	if !envBool("DEGRADE_ENABLED", false) {
+		return nil
+	}
+	threshold := defaultDegradeErrorRate
+	if v := envInt("DEGRADE_ERROR_RATE_PERCENT", 0); v > 0 && v <= 100 {
+		threshold = float64(v) / 100
+	}
+	return NewErrorRateTracker(
+		envDuration("DEGRADE_WINDOW", defaultDegradeWindow),
+		threshold,
+		envInt("DEGRADE_MIN_REQUESTS", defaultDegradeMinRequests),
+	)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source for the error rate tracker
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func TestErrorRateTracker(t *testing.T) {
+	tests := []struct {
+		name     string
+		outcomes []bool
+		advance  time.Duration
+		rate     float64
+		n        int
+		degraded bool
+	}{
+		{"no calls", nil, 0, 0, 0, false},
+		{"below min requests", []bool{true, true}, 0, 1, 2, false},
+		{"above threshold", []bool{true, true, false}, 0, 2.0 / 3, 3, true},
+		{"at threshold", []bool{true, false, true, false}, 0, 0.5, 4, false},
+		{"below threshold", []bool{true, false, false}, 0, 1.0 / 3, 3, false},
+		{"aged out", []bool{true, true, true}, 2 * time.Minute, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{t: time.Unix(1700000000, 0)}
+			tracker := NewErrorRateTracker(time.Minute, 0.5, 3)
+			tracker.now = clock.now
+			for _, failed := range tt.outcomes {
+				tracker.Record(failed)
+			}
+			clock.t = clock.t.Add(tt.advance)
+			rate, n := tracker.ErrorRate()
+			if rate != tt.rate || n != tt.n {
+				t.Errorf("ErrorRate() = %v, %d, want %v, %d", rate, n, tt.rate, tt.n)
+			}
+			if got := tracker.Degraded(); got != tt.degraded {
+				t.Errorf("Degraded() = %v, want %v", got, tt.degraded)
+			}
+		})
+	}
+}
+
+func TestDegradedResponses(t *testing.T) {
+	const email = "The server is down again and customers are waiting"
+	upstream := replying(`{"labels":[{"label":"urgent","score":0.9}]}`)
+	s := newTestServer(t, upstream)
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	tracker := NewErrorRateTracker(time.Minute, 0.5, 3)
+	tracker.now = clock.now
+	s.client.ErrorRate = tracker
+	s.client.ClassifyFallbackLabel = "uncategorized"
+	s.client.DegradedDraftText = "We will get back to you."
+
+	classify := func() BatchTopLabelResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.ClassifyHandler(rec, postJSON("/classify?single_label=true", `{"emails":[{"id":"1","content":"`+email+`"}]}`))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("classify status = %d, body %q", rec.Code, rec.Body.String())
+		}
+		var resp BatchTopLabelResponse
+		decodeResponse(t, rec, &resp)
+		return resp
+	}
+	draft := func() DraftResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.DraftHandler(rec, postJSON("/draft", `{"body":"`+email+`"}`))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("draft status = %d, body %q", rec.Code, rec.Body.String())
+		}
+		var resp DraftResponse
+		decodeResponse(t, rec, &resp)
+		return resp
+	}
+
+	// Force a high error rate
+	for i := 0; i < 3; i++ {
+		tracker.Record(true)
+	}
+	resp := classify()
+	if len(resp.Results) != 1 || resp.Results[0].Label != "uncategorized" {
+		t.Errorf("degraded classify results = %+v, want the fallback label", resp.Results)
+	}
+	if resp.Metadata == nil || resp.Metadata.Degraded != 1 {
+		t.Errorf("degraded classify metadata = %+v, want degraded 1", resp.Metadata)
+	}
+	d := draft()
+	if d.Draft != "We will get back to you." {
+		t.Errorf("degraded draft = %q, want the canned text", d.Draft)
+	}
+	if d.Metadata == nil || !d.Metadata.Degraded {
+		t.Errorf("degraded draft metadata = %+v, want degraded", d.Metadata)
+	}
+	if upstream.calls() != 0 {
+		t.Fatalf("upstream calls while degraded = %d, want 0", upstream.calls())
+	}
+
+	// Failures age out of the window and the model is called again
+	clock.t = clock.t.Add(2 * time.Minute)
+	resp = classify()
+	if len(resp.Results) != 1 || resp.Results[0].Label != "urgent" {
+		t.Errorf("recovered classify results = %+v, want the model label", resp.Results)
+	}
+	if resp.Metadata != nil && resp.Metadata.Degraded != 0 {
+		t.Errorf("recovered classify metadata = %+v, want not degraded", resp.Metadata)
+	}
+	if upstream.calls() != 1 {
+		t.Errorf("upstream calls after recovery = %d, want 1", upstream.calls())
+	}
+}
+
+func TestDegradedStaleCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		staleTTL string
+		content  string
+		want     string
+		stale    bool
+	}{
+		{"stale hit served", "1h", "The server is down again", "urgent", true},
+		{"nothing cached for the content", "1h", "Lunch on Thursday?", "uncategorized", false},
+		{"stale entries not kept", "", "The server is down again", "uncategorized", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CACHE_ENABLED", "true")
+			t.Setenv("CACHE_TTL", "10ms")
+			t.Setenv("CACHE_STALE_TTL", tt.staleTTL)
+			t.Setenv("BATCH_DEDUP_ENABLED", "false")
+			upstream := replying(`{"labels":[{"label":"urgent","score":0.9}]}`)
+			s := newTestServer(t, upstream)
+			tracker := NewErrorRateTracker(time.Minute, 0.5, 3)
+			s.client.ErrorRate = tracker
+			s.client.ClassifyFallbackLabel = "uncategorized"
+
+			classify := func(content string) (*httptest.ResponseRecorder, BatchClassifyResponse) {
+				t.Helper()
+				rec := httptest.NewRecorder()
+				s.ClassifyHandler(rec, postJSON("/classify", `{"emails":[{"id":"1","content":"`+content+`"}]}`))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("classify status = %d, body %q", rec.Code, rec.Body.String())
+				}
+				var resp BatchClassifyResponse
+				decodeResponse(t, rec, &resp)
+				return rec, resp
+			}
+
+			classify("The server is down again")
+			time.Sleep(20 * time.Millisecond) // let the cached entry expire
+			for i := 0; i < 3; i++ {
+				tracker.Record(true)
+			}
+			rec, resp := classify(tt.content)
+
+			if upstream.calls() != 1 {
+				t.Errorf("upstream calls = %d, want only the first request", upstream.calls())
+			}
+			if len(resp.Results) != 1 || len(resp.Results[0].Labels) == 0 || resp.Results[0].Labels[0].Label != tt.want {
+				t.Fatalf("results = %+v, want %s", resp.Results, tt.want)
+			}
+			if got := rec.Header().Get("X-Cache") == "STALE"; got != tt.stale {
+				t.Errorf("X-Cache = %q, want STALE %v", rec.Header().Get("X-Cache"), tt.stale)
+			}
+			wantStale, wantDegraded := 0, 1
+			if tt.stale {
+				wantStale, wantDegraded = 1, 0
+			}
+			if resp.Metadata == nil || resp.Metadata.Stale != wantStale || resp.Metadata.Degraded != wantDegraded {
+				t.Errorf("metadata = %+v, want stale %d and degraded %d", resp.Metadata, wantStale, wantDegraded)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// defaultDraftReviewThreshold is the confidence below which a draft needs human review
+const defaultDraftReviewThreshold = 0.7
+
+// highRiskDraftConfidence caps the confidence of replies to complaints and
+// legal matters, whatever the model reports
+const highRiskDraftConfidence = 0.5
+
+// draftConfidenceInstruction asks for the draft inside a JSON envelope that
+// carries the model's own assessment of it
+const draftConfidenceInstruction = ` Output strict JSON: {"draft":string,"confidence":number,"needs_human_review":boolean} with no extra text. draft is the reply; confidence, from 0 to 1, is how sure you are that the reply is appropriate to send as is; needs_human_review is true if a person should check it before it is sent. Give low confidence to replies to complaints, legal matters and anything the email does not give you enough to answer.`
+
+// highRiskPattern matches content about complaints, disputes and legal matters
+var highRiskPattern = regexp.MustCompile(`(?i)\b(complain\w*|refund\w*|chargeback\w*|disput\w*|unacceptable|legal\w*|lawyer\w*|attorney\w*|solicitor\w*|lawsuit\w*|litigation|suing|court)\b`)
+
+// draftEnvelope is the JSON the model returns when asked for draft confidence
+type draftEnvelope struct {
+	Draft            string   `json:"draft"`
+	Confidence       *float64 `json:"confidence"`
+	NeedsHumanReview bool     `json:"needs_human_review"`
+}
+
+// unwrapDraftEnvelopes replaces each choice's content with the draft from
+// its JSON envelope and returns the envelope of the first choice. A choice
+// that is not a valid envelope is kept as a plain-text draft; nil is
+// returned when the first one is not.
+func (c *DeepseekClient) unwrapDraftEnvelopes(choices []chatChoice) ([]chatChoice, *draftEnvelope) {
+	unwrapped := make([]chatChoice, len(choices))
+	var first *draftEnvelope
+	for i, choice := range choices {
+		unwrapped[i] = choice
+		raw := stripCodeFence(stripScratchpad(choice.Message.Content, c.ScratchpadDelimiter))
+		var env draftEnvelope
+		if err := json.Unmarshal([]byte(raw), &env); err != nil || strings.TrimSpace(env.Draft) == "" {
+			continue
+		}
+		unwrapped[i].Message.Content = env.Draft
+		if i == 0 {
+			first = &env
+		}
+	}
+	return unwrapped, first
+}
+
+// assessDraft sets the confidence and review flag of a draft replying to
+// content. The model's confidence is capped for high-risk content, and a
+// draft below DraftReviewThreshold needs review. Without an envelope there
+// is no confidence and the draft always needs review.
+func (c *DeepseekClient) assessDraft(out *DraftResponse, env *draftEnvelope, content string) {
+	needsReview := true
+	if env != nil && env.Confidence != nil {
+		confidence := min(max(*env.Confidence, 0), 1)
+		if highRiskPattern.MatchString(content) {
+			confidence = min(confidence, highRiskDraftConfidence)
+		}
+		out.Confidence = &confidence
+		needsReview = env.NeedsHumanReview || confidence < c.DraftReviewThreshold
+	} else if out.Metadata != nil {
+		out.Metadata.Warnings = append(out.Metadata.Warnings, "the model did not report a confidence; the draft needs human review")
+	}
+	out.NeedsHumanReview = &needsReview
+}
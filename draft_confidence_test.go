@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHighRiskPattern(t *testing.T) {
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"I want a refund for this order", true},
+		{"This is unacceptable.", true},
+		{"Our lawyer will be in touch", true},
+		{"We are suing the supplier", true},
+		{"The case goes to court next week", true},
+		{"Sue from accounting sent the invoice", false},
+		{"Please ensure the pursuit of the issue", false},
+		{"Lunch on Friday?", false},
+	}
+	for _, tt := range tests {
+		if got := highRiskPattern.MatchString(tt.content); got != tt.want {
+			t.Errorf("highRiskPattern.MatchString(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestDraftIncludeConfidence(t *testing.T) {
+	const envelope = `{"draft":"Thanks, Friday works.","confidence":0.9,"needs_human_review":false}`
+	tests := []struct {
+		name  string
+		query string
+		body  string
+		want  bool
+	}{
+		{"off", "", `{"body":"Can we meet on Friday?"}`, false},
+		{"query", "?include_confidence=true", `{"body":"Can we meet on Friday?"}`, true},
+		{"json", "", `{"body":"Can we meet on Friday?","include_confidence":true}`, true},
+		{"query with json false", "?include_confidence=true", `{"body":"Can we meet on Friday?","include_confidence":false}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := "Thanks, Friday works."
+			if tt.want {
+				reply = envelope
+			}
+			s := newTestServer(t, replying(reply))
+			rec := httptest.NewRecorder()
+			s.DraftHandler(rec, postJSON("/draft"+tt.query, tt.body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+			}
+			var resp DraftResponse
+			decodeResponse(t, rec, &resp)
+			if got := resp.Confidence != nil; got != tt.want {
+				t.Errorf("confidence present = %v, want %v (response %+v)", got, tt.want, resp)
+			}
+			if resp.Draft != "Thanks, Friday works." {
+				t.Errorf("draft = %q", resp.Draft)
+			}
+		})
+	}
+}
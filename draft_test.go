@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDraftCandidates(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		replies []string
+		status  int
+		n       interface{} // n sent upstream; nil when omitted
+		draft   string
+		drafts  []string
+	}{
+		{"single", "", []string{"Friday works."}, http.StatusOK, nil, "Friday works.", nil},
+		{"three", "?n=3", []string{"Friday works.", "Yes, Friday.", "See you Friday."}, http.StatusOK, 3.0, "Friday works.", []string{"Friday works.", "Yes, Friday.", "See you Friday."}},
+		{"fewer choices than asked", "?n=3", []string{"Friday works.", "Yes, Friday."}, http.StatusOK, 3.0, "Friday works.", []string{"Friday works.", "Yes, Friday."}},
+		{"over the cap", "?n=6", nil, http.StatusBadRequest, nil, "", nil},
+		{"not a number", "?n=two", nil, http.StatusBadRequest, nil, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying(tt.replies...)
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			s.DraftHandler(rec, httptest.NewRequest(http.MethodPost, "/draft"+tt.query, strings.NewReader("Can we meet on Friday?")))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				if upstream.calls() != 0 {
+					t.Errorf("rejected request called upstream")
+				}
+				return
+			}
+			if got := upstream.body(0)["n"]; got != tt.n {
+				t.Errorf("upstream n = %v, want %v", got, tt.n)
+			}
+			var resp DraftResponse
+			decodeResponse(t, rec, &resp)
+			if resp.Draft != tt.draft {
+				t.Errorf("draft = %q, want %q", resp.Draft, tt.draft)
+			}
+			if !reflect.DeepEqual(resp.Drafts, tt.drafts) {
+				t.Errorf("drafts = %q, want %q", resp.Drafts, tt.drafts)
+			}
+		})
+	}
+}
+
+func TestDraftSalutation(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled string
+		format  DraftOptions
+		want    bool
+	}{
+		{"disabled", "false", DraftOptions{}, false},
+		{"enabled", "true", DraftOptions{}, true},
+		{"enabled html", "true", DraftOptions{Format: DraftFormatHTML}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DRAFT_INCLUDE_SALUTATION", tt.enabled)
+			upstream := replying("Hi Anna, Friday works. Best, Sam")
+			c := newTestClient(t, upstream)
+			if _, err := c.DraftReply(context.Background(), "Hi Sam, can we meet on Friday? Anna", tt.format); err != nil {
+				t.Fatalf("DraftReply: %v", err)
+			}
+			prompt := upstream.messages(0)
+			if got := strings.Contains(prompt, draftSalutationSuffix); got != tt.want {
+				t.Errorf("salutation instruction present = %v, want %v (prompt %q)", got, tt.want, prompt)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Response encodings the server can produce
+const (
+	encodingGzip     = "gzip"
+	encodingDeflate  = "deflate"
+	encodingIdentity = "identity"
+)
+
+// supportedEncodings lists the response encodings in order of preference
+// between equally weighted choices
+var supportedEncodings = []string{encodingGzip, encodingDeflate, encodingIdentity}
+
+// implicitIdentityWeight ranks identity below any encoding the client listed
+// when the header does not mention it; identity stays acceptable unless excluded
+const implicitIdentityWeight = 0.001
+
+// negotiateEncoding picks the response encoding for an Accept-Encoding header
+// by q-value. A missing header keeps the default gzip; ok is false when the
+// header rules out every supported encoding.
+func negotiateEncoding(header string) (encoding string, ok bool) {
+	if strings.TrimSpace(header) == "" {
+		return encodingGzip, true
+	}
+	weights := make(map[string]float64)
+	wildcard := -1.0
+	for _, entry := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(entry, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "x-gzip" {
+			name = encodingGzip
+		}
+		weight, valid := 1.0, true
+		for _, param := range strings.Split(params, ";") {
+			key, value, found := strings.Cut(param, "=")
+			if !found || !strings.EqualFold(strings.TrimSpace(key), "q") {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 || q > 1 {
+				valid = false
+				break
+			}
+			weight = q
+		}
+		if !valid {
+			continue
+		}
+		if name == "*" {
+			wildcard = weight
+		} else {
+			weights[name] = weight
+		}
+	}
+
+	best, bestWeight := "", 0.0
+	for _, candidate := range supportedEncodings {
+		weight, listed := weights[candidate]
+		if !listed {
+			switch {
+			case wildcard >= 0:
+				weight = wildcard
+			case candidate == encodingIdentity:
+				weight = implicitIdentityWeight
+			default:
+				continue
+			}
+		}
+		if weight > bestWeight {
+			best, bestWeight = candidate, weight
+		}
+	}
+	return best, best != ""
+}
+
+// encodingWriter carries the encoding negotiated for a response
+type encodingWriter struct {
+	http.ResponseWriter
+	encoding string
+}
+
+func (ew *encodingWriter) recordUncompressed(n int64) {
+	if rec, ok := ew.ResponseWriter.(uncompressedSizeRecorder); ok {
+		rec.recordUncompressed(n)
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (ew *encodingWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
+
+// responseEncoding returns the encoding negotiated for w, or gzip when the
+// request did not pass through NegotiateEncoding
+func responseEncoding(w http.ResponseWriter) string {
+	for {
+		switch rw := w.(type) {
+		case *encodingWriter:
+			return rw.encoding
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return encodingGzip
+		}
+	}
+}
+
+// NegotiateEncoding middleware chooses the encoding of JSON responses from
+// Accept-Encoding. Requests that accept none of gzip, deflate and identity
+// get 406.
+func NegotiateEncoding(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding, ok := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if !ok {
+			JSONError(&encodingWriter{ResponseWriter: w, encoding: encodingIdentity},
+				"None of the supported response encodings (gzip, deflate, identity) is acceptable", http.StatusNotAcceptable)
+			return
+		}
+		next.ServeHTTP(&encodingWriter{ResponseWriter: w, encoding: encoding}, r)
+	})
+}
+
+// writeEncodedJSON writes data as JSON in the encoding negotiated for the response
+func writeEncodedJSON(w http.ResponseWriter, data interface{}) error {
+	return writeEncodedJSONStatus(w, 0, data)
+}
+
+// writeEncodedJSONStatus is writeEncodedJSON with an explicit status code;
+// 0 leaves the status to the first write
+func writeEncodedJSONStatus(w http.ResponseWriter, statusCode int, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	raw = append(raw, '\n')
+
+	encoding := responseEncoding(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Encoding")
+	if encoding != encodingIdentity {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	if statusCode != 0 {
+		w.WriteHeader(statusCode)
+	}
+
+	var out io.WriteCloser
+	switch encoding {
+	case encodingIdentity:
+		_, err = w.Write(raw)
+		return err
+	case encodingDeflate:
+		out = zlib.NewWriter(w)
+	default:
+		out = gzip.NewWriter(w)
+	}
+	if rec, ok := w.(uncompressedSizeRecorder); ok {
+		rec.recordUncompressed(int64(len(raw)))
+	}
+	if _, err := out.Write(raw); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+		ok     bool
+	}{
+		{"", encodingGzip, true},
+		{"gzip", encodingGzip, true},
+		{"deflate", encodingDeflate, true},
+		{"identity", encodingIdentity, true},
+		{"br", encodingIdentity, true},
+		{"gzip;q=0.5, deflate;q=0.8, identity;q=0.1", encodingDeflate, true},
+		{"deflate;q=0.4, GZIP;q=0.9", encodingGzip, true},
+		{"gzip, deflate", encodingGzip, true},
+		{"identity;q=1, gzip;q=0.5", encodingIdentity, true},
+		{"x-gzip", encodingGzip, true},
+		{"gzip;q=0, deflate;q=0", encodingIdentity, true},
+		{"gzip;q=0, identity;q=0", "", false},
+		{"*;q=0", "", false},
+		{"*;q=0, deflate", encodingDeflate, true},
+		{"*", encodingGzip, true},
+		{"gzip;q=2, deflate", encodingDeflate, true},
+	}
+	for _, tt := range tests {
+		got, ok := negotiateEncoding(tt.header)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("negotiateEncoding(%q) = %q, %v, want %q, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestEncodedResponses(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		status   int
+		encoding string
+	}{
+		{"gzip", "gzip", http.StatusOK, "gzip"},
+		{"deflate", "deflate", http.StatusOK, "deflate"},
+		{"identity", "identity", http.StatusOK, ""},
+		{"q-values", "gzip;q=0.2, deflate;q=0.9", http.StatusOK, "deflate"},
+		{"nothing acceptable", "identity;q=0, gzip;q=0", http.StatusNotAcceptable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying("Launch moves to Friday.")
+			s := newTestServer(t, upstream)
+			req := postJSON("/summarize", `{"body":"The launch moves to Friday because QA found a bug."}`)
+			req.Header.Set("Accept-Encoding", tt.accept)
+			rec := httptest.NewRecorder()
+			NegotiateEncoding(http.HandlerFunc(s.SummarizeHandler)).ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+			if tt.status != http.StatusOK {
+				if upstream.calls() != 0 {
+					t.Errorf("upstream calls = %d, want 0", upstream.calls())
+				}
+				return
+			}
+			var resp SummaryResponse
+			decodeResponse(t, rec, &resp)
+			if resp.Summary != "Launch moves to Friday." {
+				t.Errorf("summary = %q", resp.Summary)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Operations /estimate can project
+const (
+	EstimateSummarize = "summarize"
+	EstimateClassify  = "classify"
+	EstimateDraft     = "draft"
+)
+
+// estimatedCompletionTokens is the typical reply length of each operation
+var estimatedCompletionTokens = map[string]int{
+	EstimateSummarize: 150,
+	EstimateClassify:  20,
+	EstimateDraft:     250,
+}
+
+// ModelPrice is the USD price of a model per million tokens
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// defaultModelPricing holds list prices of the default models; MODEL_PRICING
+// adds to or replaces them
+var defaultModelPricing = map[string]ModelPrice{
+	"deepseek-chat":     {Input: 0.27, Output: 1.10},
+	"deepseek-reasoner": {Input: 0.55, Output: 2.19},
+	"gpt-4o-mini":       {Input: 0.15, Output: 0.60},
+}
+
+// parseModelPricing parses comma-separated model=input:output entries in USD
+// per million tokens over the default prices
+func parseModelPricing(spec string) map[string]ModelPrice {
+	pricing := make(map[string]ModelPrice, len(defaultModelPricing))
+	for model, price := range defaultModelPricing {
+		pricing[model] = price
+	}
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		model, prices, ok := strings.Cut(pair, "=")
+		input, output, ok2 := strings.Cut(prices, ":")
+		in, err := strconv.ParseFloat(strings.TrimSpace(input), 64)
+		out, err2 := strconv.ParseFloat(strings.TrimSpace(output), 64)
+		if !ok || !ok2 || err != nil || err2 != nil || in < 0 || out < 0 {
+			log.Printf("Ignoring malformed MODEL_PRICING entry %q", pair)
+			continue
+		}
+		pricing[strings.TrimSpace(model)] = ModelPrice{Input: in, Output: out}
+	}
+	return pricing
+}
+
+// UsageEstimate is the projected token usage of running an operation once
+type UsageEstimate struct {
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// EstimateUsage projects the tokens of running operation on content by
+// building the prompt the operation would send, without calling the model
+func (c *DeepseekClient) EstimateUsage(ctx context.Context, operation, content string) UsageEstimate {
+	content = c.fitContent(ctx, content)
+	var messages []chatMessage
+	choices := 1
+	switch operation {
+	case EstimateClassify:
+		req := c.buildClassifyRequest(ctx, content, ClassifyOptions{})
+		messages = req.Messages
+		if req.N > 1 {
+			choices = req.N
+		}
+	case EstimateDraft:
+		messages = []chatMessage{
+			{Role: "system", Content: c.draftPrompt(ctx, DraftFormatText)},
+			{Role: "user", Content: fmt.Sprintf("Write a reply to this email (HTML allowed):\n\n%s", content)},
+		}
+	default:
+		messages = []chatMessage{
+			{Role: "system", Content: systemPrompt(ctx, summarizeSystemPrompt, "")},
+			{Role: "user", Content: fmt.Sprintf("Summarize this email (HTML allowed):\n\n%s", content)},
+		}
+	}
+	out := UsageEstimate{Model: c.modelFor(ctx)}
+	for _, m := range messages {
+		out.PromptTokens += countTokens(m.Content)
+	}
+	completion := estimatedCompletionTokens[operation]
+	if c.CompletionTokens > 0 && completion > c.CompletionTokens {
+		completion = c.CompletionTokens
+	}
+	out.CompletionTokens = completion * choices
+	return out
+}
+
+// EstimateRequest asks for the projected usage of content or a batch of emails
+type EstimateRequest struct {
+	Content   string         `json:"content"`
+	Emails    []EmailRequest `json:"emails"`
+	Operation string         `json:"operation"`
+	// Model estimates for a model other than the active one
+	Model string `json:"model"`
+}
+
+// Validate checks the request, defaulting the operation to summarize
+func (req *EstimateRequest) Validate(client LLMClient) error {
+	hasContent := strings.TrimSpace(req.Content) != ""
+	switch {
+	case hasContent && len(req.Emails) > 0:
+		return errors.New("content and emails cannot be combined")
+	case !hasContent && len(req.Emails) == 0:
+		return errors.New("content or emails is required")
+	case len(req.Emails) > 100:
+		return errors.New("maximum 100 emails allowed per request")
+	}
+	for i, email := range req.Emails {
+		if strings.TrimSpace(email.Content) == "" {
+			return fmt.Errorf("emails[%d].content is required", i)
+		}
+	}
+	if req.Model != "" && !client.AllowsModel(req.Model) {
+		return fmt.Errorf("model %q is not allowed", req.Model)
+	}
+	switch req.Operation {
+	case "":
+		req.Operation = EstimateSummarize
+	case EstimateSummarize, EstimateClassify, EstimateDraft:
+	default:
+		return fmt.Errorf("operation must be %s, %s or %s", EstimateSummarize, EstimateClassify, EstimateDraft)
+	}
+	return nil
+}
+
+// EstimateResponse is the projected usage and cost of a request
+type EstimateResponse struct {
+	Model            string `json:"model"`
+	Operation        string `json:"operation"`
+	Emails           int    `json:"emails"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+	// EstimatedCostUSD is omitted when the model has no price configured
+	EstimatedCostUSD *float64 `json:"estimated_cost_usd,omitempty"`
+}
+
+// EstimateHandler handles POST /estimate. It projects tokens and cost from
+// the prompts the operation would send and makes no upstream call.
+func (s *Server) EstimateHandler(w http.ResponseWriter, r *http.Request) {
+	bodyBytes, err := readRequestBody(w, r, s.bodyReadTimeout)
+	if err != nil {
+		writeBodyReadError(w, err)
+		return
+	}
+
+	var req EstimateRequest
+	if err := s.decodeJSON(bodyBytes, &req); err != nil {
+		JSONDecodeError(w, bodyBytes, err)
+		return
+	}
+
+	client, err := s.clientFor(r)
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := req.Validate(client); err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if req.Model != "" {
+		ctx = withModel(ctx, req.Model)
+	}
+	contents := []string{req.Content}
+	if len(req.Emails) > 0 {
+		contents = contents[:0]
+		for _, email := range req.Emails {
+			contents = append(contents, email.Content)
+		}
+	}
+
+	resp := EstimateResponse{Operation: req.Operation, Emails: len(contents)}
+	for _, content := range contents {
+		usage := client.EstimateUsage(ctx, req.Operation, content)
+		resp.Model = usage.Model
+		resp.PromptTokens += usage.PromptTokens
+		resp.CompletionTokens += usage.CompletionTokens
+	}
+	resp.TotalTokens = resp.PromptTokens + resp.CompletionTokens
+	if price, ok := s.pricing[resp.Model]; ok {
+		cost := (float64(resp.PromptTokens)*price.Input + float64(resp.CompletionTokens)*price.Output) / 1e6
+		cost = math.Round(cost*1e6) / 1e6
+		resp.EstimatedCostUSD = &cost
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseModelPricing(t *testing.T) {
+	tests := []struct {
+		spec  string
+		model string
+		want  ModelPrice
+		ok    bool
+	}{
+		{"", "deepseek-chat", defaultModelPricing["deepseek-chat"], true},
+		{"custom-model=1.5:3", "custom-model", ModelPrice{Input: 1.5, Output: 3}, true},
+		{" deepseek-chat = 0.1 : 0.2 ", "deepseek-chat", ModelPrice{Input: 0.1, Output: 0.2}, true},
+		{"broken=abc:1,custom-model=-1:2", "custom-model", ModelPrice{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseModelPricing(tt.spec)[tt.model]
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseModelPricing(%q)[%q] = %+v, %v, want %+v, %v", tt.spec, tt.model, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestEstimateScalesWithInput(t *testing.T) {
+	estimate := func(t *testing.T, s *Server, body string) EstimateResponse {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		s.EstimateHandler(rec, postJSON("/estimate", body))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+		}
+		var resp EstimateResponse
+		decodeResponse(t, rec, &resp)
+		return resp
+	}
+	short := "The launch moves to Friday."
+	long := strings.Repeat("The launch moves to Friday because QA found a bug in the payment flow. ", 40)
+	for _, operation := range []string{EstimateSummarize, EstimateClassify, EstimateDraft} {
+		t.Run(operation, func(t *testing.T) {
+			upstream := replying("unused")
+			s := newTestServer(t, upstream)
+			small := estimate(t, s, fmt.Sprintf(`{"operation":%q,"content":%q}`, operation, short))
+			large := estimate(t, s, fmt.Sprintf(`{"operation":%q,"content":%q}`, operation, long))
+			batch := estimate(t, s, fmt.Sprintf(`{"operation":%q,"emails":[{"id":"1","content":%q},{"id":"2","content":%q},{"id":"3","content":%q}]}`, operation, short, short, short))
+
+			if large.PromptTokens <= small.PromptTokens {
+				t.Errorf("prompt tokens: long %d, short %d, want more for longer content", large.PromptTokens, small.PromptTokens)
+			}
+			if batch.PromptTokens != 3*small.PromptTokens || batch.CompletionTokens != 3*small.CompletionTokens || batch.Emails != 3 {
+				t.Errorf("batch of 3 = %+v, want three times %+v", batch, small)
+			}
+			if small.TotalTokens != small.PromptTokens+small.CompletionTokens || small.CompletionTokens != estimatedCompletionTokens[operation] {
+				t.Errorf("estimate = %+v", small)
+			}
+			if small.EstimatedCostUSD == nil || large.EstimatedCostUSD == nil || *large.EstimatedCostUSD <= *small.EstimatedCostUSD {
+				t.Errorf("cost: short %v, long %v, want a higher cost for longer content", small.EstimatedCostUSD, large.EstimatedCostUSD)
+			}
+			if upstream.calls() != 0 {
+				t.Errorf("upstream calls = %d, want 0", upstream.calls())
+			}
+		})
+	}
+}
+
+func TestEstimateValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		message string
+	}{
+		{"empty", `{}`, "content or emails is required"},
+		{"both", `{"content":"Hi","emails":[{"id":"1","content":"Hi"}]}`, "content and emails cannot be combined"},
+		{"blank email", `{"emails":[{"id":"1","content":" "}]}`, "emails[0].content is required"},
+		{"unknown operation", `{"content":"Hi","operation":"translate"}`, "operation must be summarize, classify or draft"},
+		{"model not allowed", `{"content":"Hi","model":"gpt-5"}`, `model "gpt-5" is not allowed`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, replying("unused"))
+			rec := httptest.NewRecorder()
+			s.EstimateHandler(rec, postJSON("/estimate", tt.body))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", rec.Code)
+			}
+			var resp ErrorResponse
+			decodeResponse(t, rec, &resp)
+			if resp.Message != tt.message {
+				t.Errorf("message = %q, want %q", resp.Message, tt.message)
+			}
+		})
+	}
+}
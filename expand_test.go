@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+// lengthThenStop answers with output cut off by the token limit for the
+// first truncated calls, then with the complete output
+func lengthThenStop(truncated int) *fakeUpstream {
+	return &fakeUpstream{reply: func(n int, _ *http.Request, _ map[string]interface{}) (*http.Response, error) {
+		if n <= truncated {
+			return chatReplyWithReason(finishReasonLength, "The launch moves to"), nil
+		}
+		return chatReply("The launch moves to Friday."), nil
+	}}
+}
+
+func TestLengthExpansions(t *testing.T) {
+	tests := []struct {
+		name       string
+		expansions string
+		cap        string
+		truncated  int
+		want       string
+		maxTokens  []float64
+	}{
+		{"disabled keeps truncated output", "", "", 1, "The launch moves to", []float64{0}},
+		{"one expansion completes", "2", "", 1, "The launch moves to Friday.", []float64{4096, 8192}},
+		{"expansions bounded", "1", "", 5, "The launch moves to", []float64{4096, 8192}},
+		{"cap stops doubling", "3", "6000", 5, "The launch moves to", []float64{4096, 6000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LENGTH_EXPANSIONS", tt.expansions)
+			t.Setenv("MAX_TOKENS_CAP", tt.cap)
+			upstream := lengthThenStop(tt.truncated)
+			c := newTestClient(t, upstream)
+
+			out, err := c.SummarizeEmail(context.Background(), "Hi team, the product launch is moving from Wednesday to Friday because QA needs two more days.", SummarizeOptions{})
+			if err != nil {
+				t.Fatalf("SummarizeEmail: %v", err)
+			}
+			if out.Summary != tt.want {
+				t.Errorf("summary = %q, want %q", out.Summary, tt.want)
+			}
+			if upstream.calls() != len(tt.maxTokens) {
+				t.Fatalf("upstream calls = %d, want %d", upstream.calls(), len(tt.maxTokens))
+			}
+			for i, want := range tt.maxTokens {
+				got, _ := upstream.body(i)["max_tokens"].(float64)
+				if got != want {
+					t.Errorf("call %d max_tokens = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
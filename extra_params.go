@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// extraParamsKey is the context key under which a caller's extra params are stored
+type extraParamsKey struct{}
+
+// withExtraParams returns a copy of ctx whose model calls carry params
+func withExtraParams(ctx context.Context, params map[string]interface{}) context.Context {
+	return context.WithValue(ctx, extraParamsKey{}, params)
+}
+
+// extraParamsFromContext returns the caller's extra params, if any
+func extraParamsFromContext(ctx context.Context) map[string]interface{} {
+	params, _ := ctx.Value(extraParamsKey{}).(map[string]interface{})
+	return params
+}
+
+// requestExtraParamsFromEnv returns the params callers may send in
+// extra_params, from REQUEST_EXTRA_PARAMS. Core request fields are never
+// allowed, even when listed.
+func requestExtraParamsFromEnv() []string {
+	var allowed []string
+	for _, name := range envList("REQUEST_EXTRA_PARAMS", nil) {
+		if reservedChatParams[name] {
+			log.Printf("Ignoring REQUEST_EXTRA_PARAMS entry %q: it is a core request field", name)
+			continue
+		}
+		allowed = append(allowed, name)
+	}
+	return allowed
+}
+
+// applyExtraParams checks a caller's extra_params against REQUEST_EXTRA_PARAMS
+// and stores them in ctx for every model call of the request
+func (s *Server) applyExtraParams(ctx context.Context, params map[string]interface{}) (context.Context, error) {
+	if len(params) == 0 {
+		return ctx, nil
+	}
+	var rejected []string
+	for name := range params {
+		if reservedChatParams[name] || !containsString(s.extraParamsAllowed, name) {
+			rejected = append(rejected, fmt.Sprintf("%q", name))
+		}
+	}
+	if len(rejected) > 0 {
+		sort.Strings(rejected)
+		return ctx, fmt.Errorf("extra_params may not set %s", strings.Join(rejected, ", "))
+	}
+	return withExtraParams(ctx, params), nil
+}
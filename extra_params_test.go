@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChatRequestMarshalExtraParams(t *testing.T) {
+	req := chatRequest{
+		Model:       "deepseek-chat",
+		Messages:    []chatMessage{{Role: "user", Content: "hi"}},
+		Temperature: temperature(0.3),
+		ExtraParams: map[string]interface{}{"logprobs": true, "model": "other", "temperature": 2.0},
+	}
+	raw, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var body map[string]interface{}
+	json.Unmarshal(raw, &body)
+	if body["logprobs"] != true {
+		t.Errorf("logprobs = %v, want true in %s", body["logprobs"], raw)
+	}
+	if body["model"] != "deepseek-chat" || body["temperature"] != 0.3 {
+		t.Errorf("core fields overridden: %s", raw)
+	}
+}
+
+func TestRequestExtraParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed string
+		global  string
+		params  string
+		status  int
+		want    map[string]interface{}
+	}{
+		{"disabled by default", "", "", `{"top_p":0.5}`, http.StatusBadRequest, nil},
+		{"allowed param passed through", "top_p,logprobs", "", `{"top_p":0.5}`, http.StatusOK, map[string]interface{}{"top_p": 0.5}},
+		{"unlisted param rejected", "top_p", "", `{"top_p":0.5,"user":"x"}`, http.StatusBadRequest, nil},
+		{"model never allowed", "model", "", `{"model":"gpt-4"}`, http.StatusBadRequest, nil},
+		{"messages never allowed", "messages,top_p", "", `{"messages":[]}`, http.StatusBadRequest, nil},
+		{"request wins over global", "top_p", `{"top_p":0.9,"logprobs":true}`, `{"top_p":0.5}`, http.StatusOK, map[string]interface{}{"top_p": 0.5, "logprobs": true}},
+		{"absent field", "", `{"logprobs":true}`, ``, http.StatusOK, map[string]interface{}{"logprobs": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("REQUEST_EXTRA_PARAMS", tt.allowed)
+			t.Setenv("DEEPSEEK_EXTRA_PARAMS", tt.global)
+			upstream := replying("A short summary.")
+			s := newTestServer(t, upstream)
+			body := `{"subject":"Launch","body":"The launch moves to Friday."`
+			if tt.params != "" {
+				body += `,"extra_params":` + tt.params
+			}
+			rec := httptest.NewRecorder()
+			s.SummarizeHandler(rec, postJSON("/summarize", body+"}"))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				if upstream.calls() != 0 {
+					t.Errorf("upstream called for a rejected request")
+				}
+				return
+			}
+			sent := upstream.body(0)
+			for key, value := range tt.want {
+				if sent[key] != value {
+					t.Errorf("upstream %s = %v, want %v", key, sent[key], value)
+				}
+			}
+			if sent["model"] != s.client.Model() {
+				t.Errorf("upstream model = %v", sent["model"])
+			}
+		})
+	}
+}
+
+func TestClassifyAndDraftExtraParams(t *testing.T) {
+	t.Setenv("REQUEST_EXTRA_PARAMS", "top_p")
+	tests := []struct {
+		name    string
+		handler func(*Server) http.HandlerFunc
+		body    string
+	}{
+		{"classify", func(s *Server) http.HandlerFunc { return s.ClassifyHandler }, `{"emails":[{"id":"1","content":"The server is down again"}],"extra_params":{"top_p":0.5}}`},
+		{"draft", func(s *Server) http.HandlerFunc { return s.DraftHandler }, `{"body":"Can we meet on Monday?","extra_params":{"top_p":0.5}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying(`{"labels":[{"label":"urgent","score":0.9}]}`)
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			tt.handler(s)(rec, postJSON("/", tt.body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			if got := upstream.body(0)["top_p"]; got != 0.5 {
+				t.Errorf("upstream top_p = %v, want 0.5", got)
+			}
+
+			rejected := httptest.NewRecorder()
+			tt.handler(s)(rejected, postJSON("/", tt.body[:len(tt.body)-len(`{"top_p":0.5}}`)]+`{"model":"x"}}`))
+			if rejected.Code != http.StatusBadRequest {
+				t.Errorf("model override status = %d, want 400", rejected.Code)
+			}
+		})
+	}
+}
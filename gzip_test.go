@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGzipUpstreamResponse(t *testing.T) {
+	var reply bytes.Buffer
+	json.NewEncoder(&reply).Encode(chatResponse{Choices: []chatChoice{{FinishReason: "stop", Message: chatMessage{Role: "assistant", Content: "Launch moves to Friday."}}}})
+
+	tests := []struct {
+		name     string
+		encoding string
+		gzip     bool
+	}{
+		{"plain", "", false},
+		{"gzip", "gzip", true},
+		{"gzip uppercase", "GZIP", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := reply.Bytes()
+			if tt.gzip {
+				body = gzipped(reply.String())
+			}
+			upstream := &fakeUpstream{reply: func(n int, req *http.Request, _ map[string]interface{}) (*http.Response, error) {
+				resp := newResponse(http.StatusOK, "")
+				resp.Body = io.NopCloser(bytes.NewReader(body))
+				if tt.encoding != "" {
+					resp.Header.Set("Content-Encoding", tt.encoding)
+				}
+				return resp, nil
+			}}
+			c := newTestClient(t, upstream)
+			out, err := c.SummarizeEmail(context.Background(), "The launch moves to Friday because QA found a bug.", SummarizeOptions{})
+			if err != nil {
+				t.Fatalf("SummarizeEmail: %v", err)
+			}
+			if out.Summary != "Launch moves to Friday." {
+				t.Errorf("summary = %q", out.Summary)
+			}
+			if got := upstream.requests[0].Header.Get("Accept-Encoding"); got != "gzip" {
+				t.Errorf("Accept-Encoding = %q, want gzip", got)
+			}
+		})
+	}
+}
+
+// TestGzipUpstreamOverHTTP goes through the standard transport, which leaves
+// decoding to the client because Accept-Encoding is set explicitly
+func TestGzipUpstreamOverHTTP(t *testing.T) {
+	reply, _ := json.Marshal(chatResponse{Choices: []chatChoice{{FinishReason: "stop", Message: chatMessage{Role: "assistant", Content: "Launch moves to Friday."}}}})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			t.Errorf("Accept-Encoding = %q, want gzip", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gzipped(string(reply)))
+	}))
+	defer upstream.Close()
+
+	c := NewDeepseekClient(upstream.URL, "test-key")
+	c.HTTPClient = upstream.Client()
+	out, err := c.SummarizeEmail(context.Background(), "The launch moves to Friday because QA found a bug.", SummarizeOptions{})
+	if err != nil {
+		t.Fatalf("SummarizeEmail: %v", err)
+	}
+	if out.Summary != "Launch moves to Friday." {
+		t.Errorf("summary = %q", out.Summary)
+	}
+}
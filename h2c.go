@@ -0,0 +1,21 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// newHTTPServer returns the server for handler. With ENABLE_H2C it also
+// speaks HTTP/2 without TLS (h2c) to clients that use it with prior
+// knowledge; HTTP/1.1 is served either way.
+func newHTTPServer(handler http.Handler) *http.Server {
+	srv := &http.Server{Handler: handler}
+	if envBool("ENABLE_H2C", false) {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		srv.Protocols = protocols
+		log.Printf("Serving HTTP/2 cleartext (h2c) alongside HTTP/1.1")
+	}
+	return srv
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestH2C(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   string
+		http2     bool
+		wantProto string // "" when the request must fail
+	}{
+		{"h2c enabled", "true", true, "HTTP/2.0"},
+		{"http/1.1 still served", "true", false, "HTTP/1.1"},
+		{"h2c disabled", "", true, ""},
+		{"disabled serves http/1.1", "", false, "HTTP/1.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ENABLE_H2C", tt.enabled)
+			s := newTestServer(t, replying("unused"))
+			mux := http.NewServeMux()
+			mux.HandleFunc("/health", s.HealthHandler)
+			srv := newHTTPServer(mux)
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go srv.Serve(listener)
+			defer srv.Close()
+
+			protocols := new(http.Protocols)
+			if tt.http2 {
+				protocols.SetUnencryptedHTTP2(true)
+			} else {
+				protocols.SetHTTP1(true)
+			}
+			client := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+			resp, err := client.Get("http://" + listener.Addr().String() + "/health")
+			if tt.wantProto == "" {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("request succeeded over %s, want h2c refused", resp.Proto)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GET /health: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || resp.Proto != tt.wantProto {
+				t.Errorf("status %d over %s, want 200 over %s", resp.StatusCode, resp.Proto, tt.wantProto)
+			}
+		})
+	}
+}
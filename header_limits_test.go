@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHeaderLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   int // extra headers sent
+		size    int // length of each header value
+		status  int
+		reached bool
+	}{
+		{"within limits", 5, 10, http.StatusOK, true},
+		{"at the count limit", 10, 1, http.StatusOK, true},
+		{"too many headers", 11, 1, http.StatusRequestHeaderFieldsTooLarge, false},
+		{"too many bytes", 2, 300, http.StatusRequestHeaderFieldsTooLarge, false},
+		{"excessive headers", 1000, 10, http.StatusRequestHeaderFieldsTooLarge, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			handler := HeaderLimits(10, 512)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			}))
+			req := httptest.NewRequest(http.MethodPost, "/summarize", nil)
+			for i := 0; i < tt.count; i++ {
+				req.Header.Add(fmt.Sprintf("X-Extra-%d", i), strings.Repeat("v", tt.size))
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if reached != tt.reached {
+				t.Errorf("handler reached = %v, want %v", reached, tt.reached)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// version is the build version, set with -ldflags "-X main.version=..."
+var version = "dev"
+
+// processStart is when the process started, used to report uptime
+var processStart = time.Now()
+
+// HealthConfig controls the /health response payload
+type HealthConfig struct {
+	Status         string
+	IncludeVersion bool
+	IncludeUptime  bool
+}
+
+// HealthResponse is the /health payload; optional fields are omitted unless enabled
+type HealthResponse struct {
+	Status        string `json:"status"`
+	Version       string `json:"version,omitempty"`
+	UptimeSeconds *int64 `json:"uptime_seconds,omitempty"`
+}
+
+// newHealthConfigFromEnv builds the health payload settings from HEALTH_* env vars
+func newHealthConfigFromEnv() HealthConfig {
+	return HealthConfig{
+		Status:         envString("HEALTH_STATUS", "ok"),
+		IncludeVersion: envBool("HEALTH_INCLUDE_VERSION", false),
+		IncludeUptime:  envBool("HEALTH_INCLUDE_UPTIME", false),
+	}
+}
+
+// HealthHandler handles GET /health
+func (s *Server) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	resp := HealthResponse{Status: s.health.Status}
+	if s.health.IncludeVersion {
+		resp.Version = version
+	}
+	if s.health.IncludeUptime {
+		uptime := int64(time.Since(processStart).Seconds())
+		resp.UptimeSeconds = &uptime
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := writeJSON(w, resp); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// Defaults for GET /health/providers
+const (
+	defaultProviderHealthTimeout  = 3 * time.Second
+	defaultProviderHealthCacheTTL = 10 * time.Second
+)
+
+// ProviderHealth is the probe result for one provider
+type ProviderHealth struct {
+	Healthy   bool   `json:"healthy"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// providerHealthCache holds the last probe results for a short time so
+// frequent polling does not hammer the providers
+type providerHealthCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	timeout   time.Duration
+	results   map[string]ProviderHealth
+	checkedAt time.Time
+}
+
+// newProviderHealthCacheFromEnv builds the cache from PROVIDER_HEALTH_* env vars
+func newProviderHealthCacheFromEnv() *providerHealthCache {
+	return &providerHealthCache{
+		ttl:     envDuration("PROVIDER_HEALTH_CACHE_TTL", defaultProviderHealthCacheTTL),
+		timeout: envDuration("PROVIDER_HEALTH_TIMEOUT", defaultProviderHealthTimeout),
+	}
+}
+
+// probeProviders pings every provider concurrently, each bounded by timeout
+func probeProviders(ctx context.Context, providers map[string]LLMClient, timeout time.Duration) map[string]ProviderHealth {
+	results := make(map[string]ProviderHealth, len(providers))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, client := range providers {
+		wg.Add(1)
+		go func(name string, client LLMClient) {
+			defer wg.Done()
+			probeCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			start := time.Now()
+			err := client.Ping(probeCtx)
+			health := ProviderHealth{Healthy: err == nil, LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				health.Error = err.Error()
+			}
+			mu.Lock()
+			results[name] = health
+			mu.Unlock()
+		}(name, client)
+	}
+	wg.Wait()
+	return results
+}
+
+// ProviderHealthHandler handles GET /health/providers. It responds 503 when
+// any provider is unhealthy.
+func (s *Server) ProviderHealthHandler(w http.ResponseWriter, r *http.Request) {
+	cache := s.providerHealth
+	cache.mu.Lock()
+	if cache.results == nil || time.Since(cache.checkedAt) > cache.ttl {
+		// Probes are detached from the request so a client disconnect does not
+		// cache spurious failures
+		cache.results = probeProviders(context.WithoutCancel(r.Context()), s.providers, cache.timeout)
+		cache.checkedAt = time.Now()
+	}
+	results := cache.results
+	cache.mu.Unlock()
+
+	status := http.StatusOK
+	for _, health := range results {
+		if !health.Healthy {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := writeJSON(w, results); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
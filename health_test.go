@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthPayload(t *testing.T) {
+	start := processStart
+	processStart = time.Now().Add(-90 * time.Second)
+	t.Cleanup(func() { processStart = start })
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"default", nil, `{"status":"ok"}`},
+		{"custom status", map[string]string{"HEALTH_STATUS": "UP"}, `{"status":"UP"}`},
+		{"version", map[string]string{"HEALTH_INCLUDE_VERSION": "true"}, `{"status":"ok","version":"dev"}`},
+		{"uptime", map[string]string{"HEALTH_INCLUDE_UPTIME": "true"}, `{"status":"ok","uptime_seconds":90}`},
+		{"everything", map[string]string{"HEALTH_STATUS": "pass", "HEALTH_INCLUDE_VERSION": "true", "HEALTH_INCLUDE_UPTIME": "true"}, `{"status":"pass","version":"dev","uptime_seconds":90}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			s := newTestServer(t, replying())
+			rec := httptest.NewRecorder()
+			s.HealthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderHealth(t *testing.T) {
+	ok := func(int, *http.Request, map[string]interface{}) (*http.Response, error) {
+		return newResponse(http.StatusOK, `{"data":[]}`), nil
+	}
+	failing := func(int, *http.Request, map[string]interface{}) (*http.Response, error) {
+		return newResponse(http.StatusInternalServerError, `{"message":"boom"}`), nil
+	}
+	hanging := func(_ int, req *http.Request, _ map[string]interface{}) (*http.Response, error) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+	type reply = func(int, *http.Request, map[string]interface{}) (*http.Response, error)
+	tests := []struct {
+		name    string
+		openai  reply
+		status  int
+		healthy bool   // openai health
+		err     string // substring of the openai error
+	}{
+		{"all healthy", ok, http.StatusOK, true, ""},
+		{"one failing", failing, http.StatusServiceUnavailable, false, "unexpected status code: 500"},
+		{"one timing out", hanging, http.StatusServiceUnavailable, false, "deadline exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OPENAI_API_KEY", "openai-key")
+			t.Setenv("SERVER_MAX_RETRIES", "0")
+			t.Setenv("NET_MAX_RETRIES", "0")
+			t.Setenv("PROVIDER_HEALTH_TIMEOUT", "50ms")
+			t.Setenv("PROVIDER_HEALTH_CACHE_TTL", "1m")
+			deepseek := &fakeUpstream{reply: ok}
+			openai := &fakeUpstream{reply: tt.openai}
+			s := newTestServer(t, deepseek)
+			s.providers[ProviderOpenAI].(*DeepseekClient).HTTPClient = openai
+
+			for i := 0; i < 2; i++ {
+				rec := httptest.NewRecorder()
+				s.ProviderHealthHandler(rec, httptest.NewRequest(http.MethodGet, "/health/providers", nil))
+				if rec.Code != tt.status {
+					t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+				}
+				var results map[string]ProviderHealth
+				if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+					t.Fatalf("decode: %v", err)
+				}
+				if len(results) != 2 {
+					t.Fatalf("results = %+v, want deepseek and openai", results)
+				}
+				if got := results[ProviderDeepseek]; !got.Healthy || got.Error != "" {
+					t.Errorf("deepseek = %+v, want healthy", got)
+				}
+				got := results[ProviderOpenAI]
+				if got.Healthy != tt.healthy || !strings.Contains(got.Error, tt.err) {
+					t.Errorf("openai = %+v, want healthy %v with error containing %q", got, tt.healthy, tt.err)
+				}
+			}
+			// The second request is served from the cache
+			if deepseek.calls() != 1 || openai.calls() != 1 {
+				t.Errorf("probes = %d deepseek, %d openai, want 1 each", deepseek.calls(), openai.calls())
+			}
+			if path := deepseek.requests[0].URL.Path; path != "/v1/models" {
+				t.Errorf("probe path = %q, want /v1/models", path)
+			}
+		})
+	}
+}
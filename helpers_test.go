@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// doerFunc adapts a function to the Doer interface
+type doerFunc func(*http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) { return f(req) }
+
+// newResponse returns a response with status, a JSON content type and body
+func newResponse(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+// chatReply returns a successful chat completion with one choice per content
+func chatReply(contents ...string) *http.Response {
+	return chatReplyWithReason("stop", contents...)
+}
+
+// chatReplyWithReason is chatReply with a finish reason on every choice
+func chatReplyWithReason(reason string, contents ...string) *http.Response {
+	var resp chatResponse
+	for i, content := range contents {
+		resp.Choices = append(resp.Choices, chatChoice{
+			Index:        i,
+			FinishReason: reason,
+			Message:      chatMessage{Role: "assistant", Content: content},
+		})
+	}
+	raw, _ := json.Marshal(resp)
+	return newResponse(http.StatusOK, string(raw))
+}
+
+// fakeUpstream is a Doer that records every request and answers with reply
+type fakeUpstream struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   []map[string]interface{}
+	reply    func(n int, req *http.Request, body map[string]interface{}) (*http.Response, error)
+}
+
+// Do records req and its decoded JSON body and returns the scripted reply
+func (f *fakeUpstream) Do(req *http.Request) (*http.Response, error) {
+	var body map[string]interface{}
+	if req.Body != nil {
+		raw, _ := io.ReadAll(req.Body)
+		json.Unmarshal(raw, &body)
+	}
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	f.bodies = append(f.bodies, body)
+	n := len(f.requests)
+	f.mu.Unlock()
+	return f.reply(n, req, body)
+}
+
+// calls returns the number of requests received
+func (f *fakeUpstream) calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.requests)
+}
+
+// body returns the decoded JSON body of the i-th request
+func (f *fakeUpstream) body(i int) map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.bodies[i]
+}
+
+// messages returns the chat messages of the i-th request joined by newlines
+func (f *fakeUpstream) messages(i int) string {
+	var parts []string
+	msgs, _ := f.body(i)["messages"].([]interface{})
+	for _, m := range msgs {
+		if m, ok := m.(map[string]interface{}); ok {
+			parts = append(parts, m["content"].(string))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// replying returns a fakeUpstream answering every call with the given contents
+func replying(contents ...string) *fakeUpstream {
+	return &fakeUpstream{reply: func(int, *http.Request, map[string]interface{}) (*http.Response, error) {
+		return chatReply(contents...), nil
+	}}
+}
+
+// newTestClient returns a client with the default configuration that sends
+// its upstream requests to doer
+func newTestClient(t *testing.T, doer Doer) *DeepseekClient {
+	t.Helper()
+	c := NewDeepseekClient("http://upstream.test", "test-key")
+	c.HTTPClient = doer
+	return c
+}
+
+// newTestServer returns a server built by NewServer from the environment,
+// with its DeepSeek client sending upstream requests to doer
+func newTestServer(t *testing.T, doer Doer) *Server {
+	t.Helper()
+	t.Setenv("DEEPSEEK_API_URL", "http://upstream.test")
+	t.Setenv("DEEPSEEK_API_KEY", "test-key")
+	s := NewServer()
+	s.client.HTTPClient = doer
+	return s
+}
+
+// decodeResponse decodes the JSON body of rec, decompressing gzip or deflate if needed
+func decodeResponse(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	var body io.Reader = rec.Body
+	switch rec.Header().Get("Content-Encoding") {
+	case "gzip":
+		gz, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("gzip body: %v", err)
+		}
+		body = gz
+	case "deflate":
+		zr, err := zlib.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("deflate body: %v", err)
+		}
+		body = zr
+	}
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+}
+
+// postJSON builds a POST request with a JSON body
+func postJSON(target, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// captureLog redirects the standard logger into a buffer for the rest of the test
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSummarizeIncludeHighlights(t *testing.T) {
+	const email = "The launch moves to Friday. Marketing needs the copy by Wednesday."
+	tests := []struct {
+		name  string
+		query string
+		body  string
+		want  bool
+	}{
+		{"off", "", `{"body":"` + email + `"}`, false},
+		{"query", "?include_highlights=true", `{"body":"` + email + `"}`, true},
+		{"json", "", `{"body":"` + email + `","include_highlights":true}`, true},
+		{"query with json false", "?include_highlights=true", `{"body":"` + email + `","include_highlights":false}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := "The launch moves to Friday."
+			if tt.want {
+				reply = `{"summary":"The launch moves to Friday.","highlights":["The launch moves to Friday."]}`
+			}
+			s := newTestServer(t, replying(reply))
+			rec := httptest.NewRecorder()
+			s.SummarizeHandler(rec, postJSON("/summarize"+tt.query, tt.body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+			}
+			var resp SummaryResponse
+			decodeResponse(t, rec, &resp)
+			if got := len(resp.Highlights) > 0; got != tt.want {
+				t.Errorf("highlights present = %v, want %v (response %+v)", got, tt.want, resp)
+			}
+			if resp.Summary != "The launch moves to Friday." {
+				t.Errorf("summary = %q", resp.Summary)
+			}
+		})
+	}
+}
+
+func TestVerifiedHighlights(t *testing.T) {
+	const content = "The launch moves\nto Friday.  Marketing needs the copy by Wednesday."
+	tests := []struct {
+		name       string
+		highlights []string
+		want       []string
+	}{
+		{"verbatim", []string{"Marketing needs the copy by Wednesday."}, []string{"Marketing needs the copy by Wednesday."}},
+		{"wrapped in the email", []string{"The launch moves to Friday."}, []string{"The launch moves to Friday."}},
+		{"invented", []string{"The launch is cancelled."}, []string{}},
+		{"duplicates and blanks", []string{"to Friday.", " ", "to  Friday."}, []string{"to Friday."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := verifiedHighlights(content, tt.highlights)
+			if len(got) != len(tt.want) {
+				t.Fatalf("verifiedHighlights = %q, want %q", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("verifiedHighlights = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
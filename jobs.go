@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Defaults for asynchronous classification jobs
+const (
+	defaultJobWorkers   = 4
+	defaultJobMaxQueued = 100
+	defaultJobTimeout   = 10 * time.Minute
+	defaultJobRetention = time.Hour
+)
+
+// errJobQueueFull is returned when JOB_MAX_QUEUED jobs are already running
+var errJobQueueFull = errors.New("too many jobs are queued; try again later")
+
+// Job states
+const (
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// JobProgress is the state of an asynchronous job as reported to clients
+type JobProgress struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	Processed int    `json:"processed"`
+	Total     int    `json:"total"`
+	Error     string `json:"error,omitempty"`
+	// Result is the /classify response body, set once the job ends; a
+	// failed job reports the emails it classified
+	Result interface{} `json:"result,omitempty"`
+}
+
+// Job is an asynchronous batch classification. Every change closes the
+// current updated channel and replaces it, waking all stream subscribers.
+type Job struct {
+	mu         sync.Mutex
+	progress   JobProgress
+	updated    chan struct{}
+	finishedAt time.Time
+}
+
+// newJob creates a running job over total emails
+func newJob(id string, total int) *Job {
+	return &Job{
+		progress: JobProgress{ID: id, Status: JobRunning, Total: total},
+		updated:  make(chan struct{}),
+	}
+}
+
+// Progress returns the job's state and a channel closed on its next change
+func (j *Job) Progress() (JobProgress, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.progress, j.updated
+}
+
+// update applies change to the job's state and notifies subscribers
+func (j *Job) update(change func(*JobProgress)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	change(&j.progress)
+	if j.progress.Status != JobRunning {
+		j.finishedAt = time.Now()
+	}
+	close(j.updated)
+	j.updated = make(chan struct{})
+}
+
+// expired reports whether the job finished more than retention ago
+func (j *Job) expired(retention time.Duration, now time.Time) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.progress.Status != JobRunning && now.Sub(j.finishedAt) > retention
+}
+
+// JobStore keeps asynchronous jobs in memory until retention after they
+// finish. The emails of every job are classified by one shared pool of
+// workers, so the number of concurrent model calls does not grow with the
+// number of jobs.
+type JobStore struct {
+	mu        sync.Mutex
+	jobs      map[string]*Job
+	workers   int
+	maxQueued int
+	timeout   time.Duration
+	retention time.Duration
+	tasks     chan func()
+	startPool sync.Once
+}
+
+// newJobStoreFromEnv builds the job store from JOB_* settings
+func newJobStoreFromEnv() *JobStore {
+	return &JobStore{
+		jobs:      make(map[string]*Job),
+		workers:   envInt("JOB_WORKERS", defaultJobWorkers),
+		maxQueued: envInt("JOB_MAX_QUEUED", defaultJobMaxQueued),
+		timeout:   envDuration("JOB_TIMEOUT", defaultJobTimeout),
+		retention: envDuration("JOB_RETENTION", defaultJobRetention),
+		tasks:     make(chan func()),
+	}
+}
+
+// add registers a new job, dropping finished jobs past their retention. It
+// returns errJobQueueFull when maxQueued jobs are still running.
+func (s *JobStore) add(total int) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	running := 0
+	for id, job := range s.jobs {
+		if job.expired(s.retention, now) {
+			delete(s.jobs, id)
+			continue
+		}
+		if progress, _ := job.Progress(); progress.Status == JobRunning {
+			running++
+		}
+	}
+	if running >= s.maxQueued {
+		return nil, errJobQueueFull
+	}
+	job := newJob(newRequestID(), total)
+	s.jobs[job.progress.ID] = job
+	return job, nil
+}
+
+// run hands task to the worker pool, starting the pool on first use. It
+// returns false without running task if ctx ends before a worker is free.
+func (s *JobStore) run(ctx context.Context, task func()) bool {
+	s.startPool.Do(func() {
+		for n := 0; n < s.workers; n++ {
+			go func() {
+				for task := range s.tasks {
+					task()
+				}
+			}()
+		}
+	})
+	select {
+	case s.tasks <- task:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// get returns the job with id, if it is still kept
+func (s *JobStore) get(id string) (*Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	return job, ok
+}
+
+// JobCreatedResponse is returned when an asynchronous job is accepted
+type JobCreatedResponse struct {
+	ID        string `json:"id"`
+	StatusURL string `json:"status_url"`
+	StreamURL string `json:"stream_url"`
+}
+
+// startClassifyJob accepts a validated batch as an asynchronous job and
+// answers 202, or 429 when too many jobs are queued. The shared worker pool
+// classifies the emails one at a time, reporting progress as each completes.
+// The job outlives the request but keeps its values, such as the request ID
+// and overrides, and it holds the request's per-key concurrency slot and
+// token meter until it ends. A job that fails or runs past JOB_TIMEOUT keeps
+// the results of the emails it classified; the others carry an error.
+func (s *Server) startClassifyJob(w http.ResponseWriter, r *http.Request, client LLMClient, emails []EmailRequest, opts ClassifyOptions, singleLabel bool) {
+	job, err := s.jobs.add(len(emails))
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	progress, _ := job.Progress()
+	id := progress.ID
+	finish := detachRequest(r.Context())
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), s.jobs.timeout)
+	go func() {
+		defer finish()
+		defer cancel()
+		results := make([]BatchClassificationResult, len(emails))
+		var wg sync.WaitGroup
+		var errOnce sync.Once
+		var jobErr error
+		for i := range emails {
+			results[i] = BatchClassificationResult{ID: emails[i].ID, Error: "not classified before the job ended"}
+			i := i
+			wg.Add(1)
+			task := func() {
+				defer wg.Done()
+				if ctx.Err() != nil {
+					return
+				}
+				batch, err := client.ClassifyEmailsBatch(ctx, emails[i:i+1], opts)
+				if err != nil {
+					errOnce.Do(func() { jobErr = err })
+					results[i].Error = classifyErrorMessage(err)
+					return
+				}
+				results[i] = batch[0]
+				job.update(func(p *JobProgress) { p.Processed++ })
+			}
+			if !s.jobs.run(ctx, task) {
+				wg.Done()
+				break
+			}
+		}
+		wg.Wait()
+
+		if jobErr == nil && ctx.Err() != nil {
+			jobErr = ctx.Err()
+		}
+		response, _ := s.classifyResponse(ctx, results, singleLabel)
+		if jobErr != nil {
+			log.Printf("[%s] Classification job %s failed: %v", requestIDFromContext(ctx), id, jobErr)
+			job.update(func(p *JobProgress) {
+				p.Status = JobFailed
+				p.Error = classifyErrorMessage(jobErr)
+				if errors.Is(jobErr, context.DeadlineExceeded) {
+					p.Error = fmt.Sprintf("job timed out after processing %d of %d emails", p.Processed, p.Total)
+				}
+				p.Result = response
+			})
+			return
+		}
+		job.update(func(p *JobProgress) {
+			p.Status = JobDone
+			p.Result = response
+		})
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+id)
+	w.WriteHeader(http.StatusAccepted)
+	resp := JobCreatedResponse{
+		ID:        id,
+		StatusURL: "/jobs/" + id,
+		StreamURL: "/jobs/" + id + "/stream",
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// JobHandler handles GET /jobs/{id}
+func (s *Server) JobHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.get(mux.Vars(r)["id"])
+	if !ok {
+		JSONError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	progress, _ := job.Progress()
+	if err := writeEncodedJSON(w, progress); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// writeSSE writes one server-sent event with data encoded as JSON
+func writeSSE(w http.ResponseWriter, event string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, raw)
+	return err
+}
+
+// JobStreamHandler handles GET /jobs/{id}/stream. It sends a progress event
+// with {processed, total} on subscribing and whenever an email completes,
+// then a done event carrying the final job state, or an error event if the
+// job failed.
+func (s *Server) JobStreamHandler(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.get(mux.Vars(r)["id"])
+	if !ok {
+		JSONError(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		progress, updated := job.Progress()
+		var err error
+		switch progress.Status {
+		case JobDone:
+			err = writeSSE(w, "done", progress)
+		case JobFailed:
+			err = writeSSE(w, "error", progress)
+		default:
+			err = writeSSE(w, "progress", struct {
+				Processed int `json:"processed"`
+				Total     int `json:"total"`
+			}{progress.Processed, progress.Total})
+		}
+		if err != nil {
+			return
+		}
+		rc.Flush()
+		if progress.Status != JobRunning {
+			return
+		}
+		select {
+		case <-updated:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// requestWork tracks background work a handler starts that outlives its
+// request, so middleware can defer per-request cleanup, such as releasing a
+// concurrency slot or charging metered tokens, until that work ends
+type requestWork struct {
+	mu         sync.Mutex
+	background bool
+	finished   bool
+	cleanups   []func()
+}
+
+// requestWorkKey is the context key under which the request's requestWork is stored
+type requestWorkKey struct{}
+
+// withRequestWork returns ctx carrying a requestWork, reusing one already there
+func withRequestWork(ctx context.Context) (context.Context, *requestWork) {
+	if work, ok := ctx.Value(requestWorkKey{}).(*requestWork); ok {
+		return ctx, work
+	}
+	work := &requestWork{}
+	return context.WithValue(ctx, requestWorkKey{}, work), work
+}
+
+// after runs cleanup now, or once the request's background work finishes
+func (w *requestWork) after(cleanup func()) {
+	w.mu.Lock()
+	if w.background && !w.finished {
+		w.cleanups = append(w.cleanups, cleanup)
+		w.mu.Unlock()
+		return
+	}
+	w.mu.Unlock()
+	cleanup()
+}
+
+// finish marks the background work done and runs the deferred cleanups
+func (w *requestWork) finish() {
+	w.mu.Lock()
+	w.finished = true
+	cleanups := w.cleanups
+	w.cleanups = nil
+	w.mu.Unlock()
+	for _, cleanup := range cleanups {
+		cleanup()
+	}
+}
+
+// detachRequest marks the request in ctx as continuing in the background and
+// returns the function to call when that work ends
+func detachRequest(ctx context.Context) func() {
+	work, ok := ctx.Value(requestWorkKey{}).(*requestWork)
+	if !ok {
+		return func() {}
+	}
+	work.mu.Lock()
+	work.background = true
+	work.mu.Unlock()
+	return work.finish
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// classifyReply is a single-label classification with 10 tokens of usage
+const classifyReply = `{"choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"{\"labels\":[{\"label\":\"urgent\",\"score\":0.9}]}"}}],"usage":{"total_tokens":10}}`
+
+// gatedUpstream answers classification calls once gate is closed, tracking
+// how many calls are in flight at once
+type gatedUpstream struct {
+	gate     chan struct{}
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+	started  chan struct{}
+}
+
+func newGatedUpstream() *gatedUpstream {
+	return &gatedUpstream{gate: make(chan struct{}), started: make(chan struct{}, 100)}
+}
+
+func (g *gatedUpstream) Do(req *http.Request) (*http.Response, error) {
+	g.mu.Lock()
+	g.inFlight++
+	g.peak = max(g.peak, g.inFlight)
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		g.inFlight--
+		g.mu.Unlock()
+	}()
+	g.started <- struct{}{}
+	select {
+	case <-g.gate:
+		return newResponse(http.StatusOK, classifyReply), nil
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+// batchBody returns a /classify body with n emails
+func batchBody(n int) string {
+	var emails []string
+	for i := 0; i < n; i++ {
+		emails = append(emails, `{"id":"`+string(rune('a'+i))+`","content":"Server `+string(rune('a'+i))+` is down"}`)
+	}
+	return `{"emails":[` + strings.Join(emails, ",") + `]}`
+}
+
+// startJob posts an async batch through h and returns the job ID
+func startJob(t *testing.T, h http.Handler, key string, n int) string {
+	t.Helper()
+	req := postJSON("/classify?async=true", batchBody(n))
+	req.Header.Set(apiKeyHeader, key)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", rec.Code)
+	}
+	return strings.TrimPrefix(rec.Header().Get("Location"), "/jobs/")
+}
+
+// waitJob waits for job id to end and returns its final state
+func waitJob(t *testing.T, s *Server, id string) JobProgress {
+	t.Helper()
+	job, ok := s.jobs.get(id)
+	if !ok {
+		t.Fatalf("job %s not found", id)
+	}
+	deadline := time.After(5 * time.Second)
+	for {
+		progress, updated := job.Progress()
+		if progress.Status != JobRunning {
+			return progress
+		}
+		select {
+		case <-updated:
+		case <-deadline:
+			t.Fatalf("job %s still running", id)
+		}
+	}
+}
+
+func TestJobsShareWorkerPool(t *testing.T) {
+	t.Setenv("JOB_WORKERS", "2")
+	upstream := newGatedUpstream()
+	s := newTestServer(t, upstream)
+	h := http.HandlerFunc(s.ClassifyHandler)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, startJob(t, h, "", 3))
+	}
+	<-upstream.started
+	<-upstream.started
+	time.Sleep(20 * time.Millisecond)
+	close(upstream.gate)
+	for _, id := range ids {
+		if progress := waitJob(t, s, id); progress.Status != JobDone || progress.Processed != 3 {
+			t.Errorf("job %s = %s with %d processed, want done with 3", id, progress.Status, progress.Processed)
+		}
+	}
+	if upstream.peak > 2 {
+		t.Errorf("peak concurrent upstream calls = %d, want at most JOB_WORKERS (2)", upstream.peak)
+	}
+}
+
+func TestJobQueueFull(t *testing.T) {
+	t.Setenv("JOB_MAX_QUEUED", "1")
+	upstream := newGatedUpstream()
+	s := newTestServer(t, upstream)
+	h := http.HandlerFunc(s.ClassifyHandler)
+
+	first := startJob(t, h, "", 1)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, postJSON("/classify?async=true", batchBody(1)))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second job status = %d, want 429", rec.Code)
+	}
+
+	close(upstream.gate)
+	waitJob(t, s, first)
+	startJob(t, h, "", 1)
+}
+
+func TestJobTimeoutKeepsPartialResults(t *testing.T) {
+	t.Setenv("JOB_WORKERS", "1")
+	t.Setenv("JOB_TIMEOUT", "200ms")
+	upstream := &fakeUpstream{reply: func(n int, req *http.Request, _ map[string]interface{}) (*http.Response, error) {
+		if n == 1 {
+			return newResponse(http.StatusOK, classifyReply), nil
+		}
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}}
+	s := newTestServer(t, upstream)
+
+	progress := waitJob(t, s, startJob(t, http.HandlerFunc(s.ClassifyHandler), "", 3))
+	if progress.Status != JobFailed || !strings.Contains(progress.Error, "timed out after processing 2 of 3") {
+		t.Errorf("job = %s %q, want failed after a timeout with 2 of 3 processed", progress.Status, progress.Error)
+	}
+	result, ok := progress.Result.(BatchClassifyResponse)
+	if !ok || len(result.Results) != 3 {
+		t.Fatalf("result = %#v, want 3 results", progress.Result)
+	}
+	if len(result.Results[0].Labels) == 0 || result.Results[0].Error != "" {
+		t.Errorf("first result = %+v, want its labels", result.Results[0])
+	}
+	for _, r := range result.Results[1:] {
+		if r.Error == "" {
+			t.Errorf("result %s has no error", r.ID)
+		}
+	}
+}
+
+func TestJobHoldsKeyLimits(t *testing.T) {
+	t.Setenv("API_KEY_CONCURRENCY", "alpha=1")
+	t.Setenv("API_KEY_QUOTAS", "alpha=0:1000")
+	upstream := newGatedUpstream()
+	s := newTestServer(t, upstream)
+	store := NewMemoryQuotaStore()
+	s.keyQuotas.store = store
+	h := RequireAPIKey(s.apiKeys())(s.keyConcurrency.Middleware(s.keyQuotas.Middleware(http.HandlerFunc(s.ClassifyHandler))))
+
+	id := startJob(t, h, "alpha", 2)
+	<-upstream.started
+	req := postJSON("/classify", batchBody(1))
+	req.Header.Set(apiKeyHeader, "alpha")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("request during job: status = %d, want 429", rec.Code)
+	}
+
+	close(upstream.gate)
+	waitJob(t, s, id)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		usage, _ := store.Usage(context.Background(), "alpha", s.keyQuotas.period())
+		if usage.Tokens == 20 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metered tokens = %d, want 20 from the job's two calls", usage.Tokens)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req.Clone(context.Background()))
+	if rec.Code == http.StatusTooManyRequests {
+		t.Error("concurrency slot still held after the job ended")
+	}
+}
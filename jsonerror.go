@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Machine-readable codes for request bodies that fail to decode
+const (
+	ErrCodeInvalidJSON      = "invalid_json"
+	ErrCodeTruncatedJSON    = "truncated_json"
+	ErrCodeInvalidFieldType = "invalid_field_type"
+	ErrCodeUnknownField     = "unknown_field"
+)
+
+// unknownFieldError reports a body field the request type does not define
+type unknownFieldError struct {
+	Field  string
+	Offset int64
+}
+
+func (e *unknownFieldError) Error() string {
+	return fmt.Sprintf("unknown field %q", e.Field)
+}
+
+// trailingDataError reports data after the first JSON value in a body
+type trailingDataError struct {
+	Offset int64
+}
+
+func (e *trailingDataError) Error() string {
+	return "unexpected data after the JSON body"
+}
+
+// decodeStrictJSON decodes data into v, rejecting fields v does not define
+// and anything after the JSON value
+func decodeStrictJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		// The decoder reports unknown fields only as a formatted error
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			if unquoted, qerr := strconv.Unquote(field); qerr == nil {
+				field = unquoted
+			}
+			return &unknownFieldError{Field: field, Offset: dec.InputOffset()}
+		}
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return &trailingDataError{Offset: dec.InputOffset()}
+	}
+	return nil
+}
+
+// jsonPosition converts a byte offset in data to a 1-based line and column
+func jsonPosition(data []byte, offset int64) (line, column int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line, column = 1, 1
+	for _, b := range data[:offset] {
+		if b == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return line, column
+}
+
+// offendingByte converts a decoder error offset, which counts the byte that
+// failed to decode, into the offset of that byte
+func offendingByte(offset int64) int64 {
+	if offset > 0 {
+		return offset - 1
+	}
+	return 0
+}
+
+// jsonTypeName describes a Go type in JSON terms
+func jsonTypeName(t string) string {
+	switch {
+	case t == "string":
+		return "a string"
+	case t == "bool":
+		return "a boolean"
+	case strings.HasPrefix(t, "int"), strings.HasPrefix(t, "uint"), strings.HasPrefix(t, "float"):
+		return "a number"
+	case strings.HasPrefix(t, "[]"):
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
+// describeJSONError turns a json.Unmarshal error for data into an error code
+// and a message that points at the problem without echoing the body
+func describeJSONError(data []byte, err error) (code, message string) {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var fieldErr *unknownFieldError
+	var trailingErr *trailingDataError
+	switch {
+	case errors.As(err, &fieldErr):
+		line, column := jsonPosition(data, fieldErr.Offset)
+		return ErrCodeUnknownField, fmt.Sprintf("Unknown field %q (line %d, column %d)", fieldErr.Field, line, column)
+	case errors.As(err, &trailingErr):
+		line, column := jsonPosition(data, trailingErr.Offset)
+		return ErrCodeInvalidJSON, fmt.Sprintf("Unexpected data after the JSON body at line %d, column %d", line, column)
+	case errors.As(err, &typeErr):
+		line, column := jsonPosition(data, offendingByte(typeErr.Offset))
+		field := typeErr.Field
+		if field == "" {
+			field = "request body"
+		}
+		return ErrCodeInvalidFieldType, fmt.Sprintf("Field %q must be %s, got %s (line %d, column %d)", field, jsonTypeName(typeErr.Type.String()), typeErr.Value, line, column)
+	case errors.As(err, &syntaxErr):
+		if syntaxErr.Offset >= int64(len(data)) {
+			line, column := jsonPosition(data, int64(len(data)))
+			return ErrCodeTruncatedJSON, fmt.Sprintf("JSON body ends unexpectedly at line %d, column %d", line, column)
+		}
+		line, column := jsonPosition(data, offendingByte(syntaxErr.Offset))
+		return ErrCodeInvalidJSON, fmt.Sprintf("Malformed JSON at line %d, column %d", line, column)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		line, column := jsonPosition(data, int64(len(data)))
+		return ErrCodeTruncatedJSON, fmt.Sprintf("JSON body ends unexpectedly at line %d, column %d", line, column)
+	}
+	return ErrCodeInvalidJSON, "Request body is not valid JSON"
+}
+
+// JSONDecodeError writes a 400 describing why data failed to decode
+func JSONDecodeError(w http.ResponseWriter, data []byte, err error) {
+	code, message := describeJSONError(data, err)
+	writeErrorResponse(w, ErrorResponse{
+		Error:   http.StatusText(http.StatusBadRequest),
+		Code:    code,
+		Message: message,
+	}, http.StatusBadRequest)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStrictJSONBodies(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    string
+		body   string
+		status int
+		code   string
+	}{
+		{"default accepts unknown fields", "", `{"body":"Can we meet on Monday?","colour":"red"}`, http.StatusOK, ""},
+		{"strict rejects unknown fields", "true", `{"body":"Can we meet on Monday?","colour":"red"}`, http.StatusBadRequest, ErrCodeUnknownField},
+		{"strict accepts known fields", "true", `{"body":"Can we meet on Monday?","subject":"Meeting"}`, http.StatusOK, ""},
+		{"disabled accepts unknown fields", "false", `{"body":"Can we meet on Monday?","colour":"red"}`, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("STRICT_JSON_BODIES", tt.env)
+			}
+			s := newTestServer(t, replying("A summary."))
+			rec := httptest.NewRecorder()
+			s.SummarizeHandler(rec, postJSON("/summarize", tt.body))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.code != "" {
+				var resp ErrorResponse
+				decodeResponse(t, rec, &resp)
+				if resp.Code != tt.code {
+					t.Errorf("code = %q, want %q", resp.Code, tt.code)
+				}
+			}
+		})
+	}
+}
+
+func TestClassifyJSONDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		code    string
+		message string
+	}{
+		{"truncated body", `{"emails":[{"id":"1","content":"Quarterly numbers attached"}`, ErrCodeTruncatedJSON, "JSON body ends unexpectedly at line 1, column 61"},
+		{"wrong field type", `{"emails":[{"id":1,"content":"Quarterly numbers attached"}]}`, ErrCodeInvalidFieldType, `Field "emails.0.id" must be a string, got number (line 1, column 18)`},
+		{"malformed on a later line", "{\n  \"emails\": [,]\n}", ErrCodeInvalidJSON, "Malformed JSON at line 2, column 14"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying(`{"labels":[{"label":"urgent","score":0.9}]}`)
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			s.ClassifyHandler(rec, postJSON("/classify", tt.body))
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", rec.Code)
+			}
+			var resp ErrorResponse
+			decodeResponse(t, rec, &resp)
+			if resp.Code != tt.code || resp.Message != tt.message {
+				t.Errorf("error = %q %q, want %q %q", resp.Code, resp.Message, tt.code, tt.message)
+			}
+			if strings.Contains(resp.Message, "Quarterly") {
+				t.Errorf("message %q echoes the body", resp.Message)
+			}
+			if upstream.calls() != 0 {
+				t.Errorf("upstream calls = %d, want 0", upstream.calls())
+			}
+		})
+	}
+}
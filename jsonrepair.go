@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+)
+
+// repairJSON fixes the near-JSON models commonly produce so it can be parsed
+// strictly: prose around the outermost object is dropped, single-quoted
+// strings are double-quoted, bare object keys are quoted, trailing commas
+// are removed and the Python literals True, False and None are lowercased
+// to their JSON equivalents. Valid JSON comes back unchanged.
+func repairJSON(s string) string {
+	if start, end := strings.IndexByte(s, '{'), strings.LastIndexByte(s, '}'); start >= 0 && end > start {
+		s = s[start : end+1]
+	}
+	out := make([]byte, 0, len(s)+16)
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == '"' || ch == '\'':
+			str, n := repairString(s[i:])
+			out = append(out, str...)
+			i += n - 1
+		case ch == '}' || ch == ']':
+			out = trimTrailingComma(out)
+			out = append(out, ch)
+		case isIdentByte(ch) && !isDigit(ch):
+			j := i
+			for j < len(s) && isIdentByte(s[j]) {
+				j++
+			}
+			word := s[i:j]
+			k := j
+			for k < len(s) && isSpaceByte(s[k]) {
+				k++
+			}
+			switch {
+			case k < len(s) && s[k] == ':':
+				out = append(out, '"')
+				out = append(out, word...)
+				out = append(out, '"')
+			case word == "True":
+				out = append(out, "true"...)
+			case word == "False":
+				out = append(out, "false"...)
+			case word == "None":
+				out = append(out, "null"...)
+			default:
+				out = append(out, word...)
+			}
+			i = j - 1
+		default:
+			out = append(out, ch)
+		}
+	}
+	return string(out)
+}
+
+// repairString re-encodes the quoted string at the start of s as a JSON
+// string and returns it with the number of bytes consumed. A single-quoted
+// string has its inner double quotes escaped and \' unescaped; an
+// unterminated string runs to the end of s.
+func repairString(s string) (string, int) {
+	quote := s[0]
+	var b strings.Builder
+	b.WriteByte('"')
+	i := 1
+	for ; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case ch == '\\' && i+1 < len(s):
+			if quote == '\'' && s[i+1] == '\'' {
+				b.WriteByte('\'')
+			} else {
+				b.WriteByte(ch)
+				b.WriteByte(s[i+1])
+			}
+			i++
+		case ch == quote:
+			b.WriteByte('"')
+			return b.String(), i + 1
+		case ch == '"':
+			b.WriteString(`\"`)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	b.WriteByte('"')
+	return b.String(), i
+}
+
+// trimTrailingComma removes a comma, and the space after it, from the end of out
+func trimTrailingComma(out []byte) []byte {
+	end := len(out)
+	for end > 0 && isSpaceByte(out[end-1]) {
+		end--
+	}
+	if end > 0 && out[end-1] == ',' {
+		return out[:end-1]
+	}
+	return out
+}
+
+func isIdentByte(ch byte) bool {
+	return ch == '_' || ch == '$' || ch == '-' || isDigit(ch) || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z'
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isSpaceByte(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r'
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRepairJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"valid unchanged", `{"labels":[{"label":"urgent","score":0.9}]}`, `{"labels":[{"label":"urgent","score":0.9}]}`},
+		{"trailing commas", `{"labels":[{"label":"urgent","score":0.9,},],}`, `{"labels":[{"label":"urgent","score":0.9}]}`},
+		{"single quotes", `{'labels':[{'label':'urgent','score':0.9}]}`, `{"labels":[{"label":"urgent","score":0.9}]}`},
+		{"single quotes with inner quotes", `{'label':'say "hi"','note':'it\'s'}`, `{"label":"say \"hi\"","note":"it's"}`},
+		{"bare keys", `{labels: [{label: "urgent", score: 0.9}]}`, `{"labels": [{"label": "urgent", "score": 0.9}]}`},
+		{"python literals", `{"ok": True, "spam": False, "note": None}`, `{"ok": true, "spam": false, "note": null}`},
+		{"prose around the object", `Here you go: {"labels":[]} Hope this helps!`, `{"labels":[]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := repairJSON(tt.in)
+			if got != tt.want {
+				t.Errorf("repairJSON(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if !json.Valid([]byte(got)) {
+				t.Errorf("repairJSON(%q) = %q is not valid JSON", tt.in, got)
+			}
+		})
+	}
+}
+
+func TestClassifyRepairsJSON(t *testing.T) {
+	want := []ClassificationLabel{{Label: "urgent", Score: 0.9}}
+	tests := []struct {
+		name     string
+		repair   string
+		reply    string
+		repaired bool
+		wantErr  bool
+	}{
+		{"trailing comma", "true", `{"labels":[{"label":"urgent","score":0.9},]}`, true, false},
+		{"single quotes", "true", `{'labels':[{'label':'urgent','score':0.9}]}`, true, false},
+		{"valid needs no repair", "true", `{"labels":[{"label":"urgent","score":0.9}]}`, false, false},
+		{"repair disabled", "false", `{"labels":[{"label":"urgent","score":0.9},]}`, false, true},
+		{"beyond repair", "true", `{"labels":[{"label":"urgent","score":`, false, true},
+		{"strict when unset", "", `{"labels":[{"label":"urgent","score":0.9},]}`, false, true},
+		{"strict when unset, single quotes", "", `{'labels':[{'label':'urgent','score':0.9}]}`, false, true},
+		{"strict when unset, valid", "", `{"labels":[{"label":"urgent","score":0.9}]}`, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLASSIFY_JSON_REPAIR", tt.repair)
+			logs := captureLog(t)
+			upstream := replying(tt.reply)
+			c := newTestClient(t, upstream)
+			out, err := c.ClassifyEmail(context.Background(), "The server is down again", ClassifyOptions{})
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidClassifyJSON) {
+					t.Errorf("ClassifyEmail error = %v, want invalid JSON", err)
+				}
+			} else if err != nil {
+				t.Fatalf("ClassifyEmail: %v", err)
+			} else if !reflect.DeepEqual(out.Labels, want) {
+				t.Errorf("labels = %+v, want %+v", out.Labels, want)
+			}
+			if got := strings.Contains(logs.String(), "Repaired malformed classification JSON"); got != tt.repaired {
+				t.Errorf("repair logged = %v, want %v", got, tt.repaired)
+			}
+			if upstream.calls() != 1 {
+				t.Errorf("upstream calls = %d, want 1", upstream.calls())
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// apiKeyHeader identifies the calling tenant for per-key limits
+const apiKeyHeader = "X-API-Key"
+
+// KeyConcurrency limits the requests each API key may have in flight at once,
+// using one semaphore per configured key
+type KeyConcurrency struct {
+	sems map[string]chan struct{}
+}
+
+// NewKeyConcurrency creates limits from a key -> max concurrent requests map
+func NewKeyConcurrency(limits map[string]int) *KeyConcurrency {
+	k := &KeyConcurrency{sems: make(map[string]chan struct{}, len(limits))}
+	for key, n := range limits {
+		k.sems[key] = make(chan struct{}, n)
+	}
+	return k
+}
+
+// parseKeyConcurrency parses comma-separated key=max_concurrent pairs
+func parseKeyConcurrency(spec string) map[string]int {
+	limits := make(map[string]int)
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, limit, ok := strings.Cut(pair, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(limit))
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || err != nil || n <= 0 {
+			// Do not log the pair itself: it holds an API key
+			log.Printf("Ignoring malformed API_KEY_CONCURRENCY entry")
+			continue
+		}
+		limits[key] = n
+	}
+	return limits
+}
+
+// newKeyConcurrencyFromEnv builds per-key limits from API_KEY_CONCURRENCY (or
+// API_KEY_CONCURRENCY_FILE). It returns nil when none are configured.
+func newKeyConcurrencyFromEnv() *KeyConcurrency {
+	spec, err := loadSecret("API_KEY_CONCURRENCY")
+	if err != nil {
+		log.Fatal(err)
+	}
+	limits := parseKeyConcurrency(spec)
+	if len(limits) == 0 {
+		return nil
+	}
+	log.Printf("Per-key concurrency limits configured for %d API keys", len(limits))
+	return NewKeyConcurrency(limits)
+}
+
+// Keys returns the API keys that have a concurrency limit
+func (k *KeyConcurrency) Keys() []string {
+	keys := make([]string, 0, len(k.sems))
+	for key := range k.sems {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Middleware rejects a request with 429 when its API key already has as many
+// requests in flight as its limit allows. An async job started by the
+// request holds its slot until the job ends. It relies on RequireAPIKey having
+// authenticated the key; a key without a configured limit is not limited.
+func (k *KeyConcurrency) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sem, ok := k.sems[r.Header.Get(apiKeyHeader)]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		select {
+		case sem <- struct{}{}:
+			ctx, work := withRequestWork(r.Context())
+			defer work.after(func() { <-sem })
+			next.ServeHTTP(w, r.WithContext(ctx))
+		default:
+			JSONError(w, "Too many concurrent requests for this API key", http.StatusTooManyRequests)
+		}
+	})
+}
+
+// apiKeyExempt reports whether path is served without an API key: health
+// checks, the admin endpoints, which use ADMIN_TOKEN, and any of public
+func apiKeyExempt(path string, public []string) bool {
+	switch path {
+	case "/health", "/health/providers":
+		return true
+	}
+	for _, p := range public {
+		if path == p {
+			return true
+		}
+	}
+	return path == "/admin" || strings.HasPrefix(path, "/admin/")
+}
+
+// validAPIKey reports whether key is one of keys, comparing in constant time
+// so the response time does not reveal how much of a key matched
+func validAPIKey(key string, keys []string) bool {
+	valid := 0
+	for _, k := range keys {
+		valid |= subtle.ConstantTimeCompare([]byte(key), []byte(k))
+	}
+	return valid == 1
+}
+
+// RequireAPIKey rejects requests whose X-API-Key is missing (401) or not one
+// of keys (403), so per-key limits cannot be dodged by omitting the header or
+// sending a made-up key. Exempt paths, and the public paths given, are
+// passed through.
+func RequireAPIKey(keys []string, public ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if apiKeyExempt(r.URL.Path, public) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key := r.Header.Get(apiKeyHeader)
+			switch {
+			case key == "":
+				JSONError(w, "API key required", http.StatusUnauthorized)
+			case !validAPIKey(key, keys):
+				JSONError(w, "Invalid API key", http.StatusForbidden)
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// apiKeys returns the API keys configured for per-key limits, sorted; nil
+// when no per-key limits are enabled
+func (s *Server) apiKeys() []string {
+	var keys []string
+	if s.keyConcurrency != nil {
+		keys = append(keys, s.keyConcurrency.Keys()...)
+	}
+	if s.keyQuotas != nil {
+		for _, key := range s.keyQuotas.Keys() {
+			if !containsString(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAPIKey(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := RequireAPIKey([]string{"alpha", "beta"})(ok)
+	public := RequireAPIKey([]string{"alpha", "beta"}, "/metrics")(ok)
+	tests := []struct {
+		name   string
+		public bool
+		path   string
+		key    string
+		status int
+	}{
+		{"configured key", false, "/classify", "alpha", http.StatusOK},
+		{"second configured key", false, "/summarize", "beta", http.StatusOK},
+		{"missing key", false, "/classify", "", http.StatusUnauthorized},
+		{"unknown key", false, "/classify", "gamma", http.StatusForbidden},
+		{"prefix of a key", false, "/classify", "alph", http.StatusForbidden},
+		{"health exempt", false, "/health", "", http.StatusOK},
+		{"metrics needs a key", false, "/metrics", "", http.StatusUnauthorized},
+		{"metrics with a key", false, "/metrics", "alpha", http.StatusOK},
+		{"public metrics", true, "/metrics", "", http.StatusOK},
+		{"public paths only", true, "/classify", "", http.StatusUnauthorized},
+		{"admin exempt", false, "/admin/config", "", http.StatusOK},
+		{"admin-like path not exempt", false, "/administer", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			if tt.key != "" {
+				req.Header.Set(apiKeyHeader, tt.key)
+			}
+			rec := httptest.NewRecorder()
+			if tt.public {
+				public.ServeHTTP(rec, req)
+			} else {
+				handler.ServeHTTP(rec, req)
+			}
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestKeyConcurrencyLimit(t *testing.T) {
+	k := NewKeyConcurrency(map[string]int{"alpha": 1, "beta": 2})
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	handler := RequireAPIKey(k.Keys())(k.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})))
+	serve := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/classify", nil)
+		req.Header.Set(apiKeyHeader, key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	done := make(chan int, 3)
+	for _, key := range []string{"alpha", "beta", "beta"} {
+		go func(key string) { done <- serve(key).Code }(key)
+	}
+	for i := 0; i < 3; i++ {
+		<-started
+	}
+
+	tests := []struct {
+		key    string
+		status int
+	}{
+		{"alpha", http.StatusTooManyRequests},
+		{"beta", http.StatusTooManyRequests},
+		{"", http.StatusUnauthorized},
+		{"gamma", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if got := serve(tt.key).Code; got != tt.status {
+			t.Errorf("key %q while busy: status = %d, want %d", tt.key, got, tt.status)
+		}
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("in-flight request status = %d, want 200", code)
+		}
+	}
+	if got := serve("alpha").Code; got != http.StatusOK {
+		t.Errorf("after release: status = %d, want 200", got)
+	}
+}
+
+func TestServerAPIKeys(t *testing.T) {
+	t.Setenv("API_KEY_CONCURRENCY", "beta=2, alpha=1, bad")
+	s := newTestServer(t, replying())
+	keys := s.apiKeys()
+	if len(keys) != 2 || keys[0] != "alpha" || keys[1] != "beta" {
+		t.Errorf("apiKeys() = %v, want [alpha beta]", keys)
+	}
+}
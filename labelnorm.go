@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// labelSeparatorPattern matches the spaces and hyphens that separate words
+// of a label, so "Action requise" and "action-requise" compare equal
+var labelSeparatorPattern = regexp.MustCompile(`[\s\-]+`)
+
+// labelKey returns the form labels are compared in when normalizing
+func labelKey(label string) string {
+	return labelSeparatorPattern.ReplaceAllString(strings.ToLower(strings.TrimSpace(label)), "_")
+}
+
+// parseLabelSynonyms parses a JSON object mapping each canonical label to
+// the synonyms and translations the model may return for it
+func parseLabelSynonyms(data []byte) (map[string]string, error) {
+	var raw map[string][]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	synonyms := make(map[string]string)
+	for canonical, aliases := range raw {
+		canonical = strings.TrimSpace(canonical)
+		if canonical == "" {
+			continue
+		}
+		synonyms[labelKey(canonical)] = canonical
+		for _, alias := range aliases {
+			if key := labelKey(alias); key != "" {
+				synonyms[key] = canonical
+			}
+		}
+	}
+	return synonyms, nil
+}
+
+// loadLabelSynonyms reads LABEL_SYNONYMS_FILE. A missing setting, or a file
+// that cannot be read or parsed, disables normalization.
+func loadLabelSynonyms() map[string]string {
+	path := strings.TrimSpace(os.Getenv("LABEL_SYNONYMS_FILE"))
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Ignoring LABEL_SYNONYMS_FILE: %v", err)
+		return nil
+	}
+	synonyms, err := parseLabelSynonyms(data)
+	if err != nil {
+		log.Printf("Ignoring invalid LABEL_SYNONYMS_FILE: %v", err)
+		return nil
+	}
+	log.Printf("Loaded %d label synonyms", len(synonyms))
+	return synonyms
+}
+
+// normalizeLabels maps labels the model returned, possibly in the email's
+// language, to their canonical names. Labels without a synonym are kept as
+// returned; duplicates this creates are merged later by dedupeLabels.
+func (c *DeepseekClient) normalizeLabels(labels []ClassificationLabel) []ClassificationLabel {
+	if len(c.LabelSynonyms) == 0 {
+		return labels
+	}
+	for i, label := range labels {
+		if canonical, ok := c.LabelSynonyms[labelKey(label.Label)]; ok {
+			labels[i].Label = canonical
+		}
+	}
+	return labels
+}
+
+// parseLabelRemap parses a JSON object mapping old label names to the labels
+// that replace them
+func parseLabelRemap(data []byte) (map[string]string, error) {
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	remap := make(map[string]string, len(raw))
+	for from, to := range raw {
+		from, to = labelKey(from), strings.TrimSpace(to)
+		if from == "" || to == "" {
+			continue
+		}
+		remap[from] = to
+	}
+	return remap, nil
+}
+
+// loadLabelRemap reads the CLASSIFY_LABEL_REMAP file. A missing setting, or
+// a file that cannot be read or parsed, disables remapping.
+func loadLabelRemap() map[string]string {
+	path := strings.TrimSpace(os.Getenv("CLASSIFY_LABEL_REMAP"))
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Ignoring CLASSIFY_LABEL_REMAP: %v", err)
+		return nil
+	}
+	remap, err := parseLabelRemap(data)
+	if err != nil {
+		log.Printf("Ignoring invalid CLASSIFY_LABEL_REMAP: %v", err)
+		return nil
+	}
+	log.Printf("Loaded %d label remappings", len(remap))
+	return remap
+}
+
+// remapLabels renames labels retired from the taxonomy to their
+// replacements. Labels that end up with the same name are merged, keeping
+// the highest score. Remappings are not chained.
+func (c *DeepseekClient) remapLabels(labels []ClassificationLabel) []ClassificationLabel {
+	if len(c.LabelRemap) == 0 {
+		return labels
+	}
+	out := make([]ClassificationLabel, len(labels))
+	for i, label := range labels {
+		out[i] = label
+		if to, ok := c.LabelRemap[labelKey(label.Label)]; ok {
+			out[i].Label = to
+		}
+	}
+	return dedupeLabels(out)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const frenchSynonyms = `{
+	"urgent": ["urgente", "Urgence"],
+	"action_required": ["action requise", "à traiter"],
+	"spam": ["pourriel", "indésirable"]
+}`
+
+func TestParseLabelSynonyms(t *testing.T) {
+	synonyms, err := parseLabelSynonyms([]byte(frenchSynonyms))
+	if err != nil {
+		t.Fatalf("parseLabelSynonyms: %v", err)
+	}
+	tests := []struct {
+		label string
+		want  string
+		ok    bool
+	}{
+		{"Urgente", "urgent", true},
+		{"ACTION-REQUISE", "action_required", true},
+		{"  action   requise ", "action_required", true},
+		{"à traiter", "action_required", true},
+		{"urgent", "urgent", true},
+		{"personnel", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := synonyms[labelKey(tt.label)]
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("synonym of %q = %q, %v, want %q, %v", tt.label, got, ok, tt.want, tt.ok)
+		}
+	}
+	if _, err := parseLabelSynonyms([]byte(`{"urgent":"urgente"}`)); err == nil {
+		t.Error("parseLabelSynonyms accepted a string instead of a list")
+	}
+}
+
+func TestClassifyFrenchLabels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "synonyms.json")
+	if err := os.WriteFile(path, []byte(frenchSynonyms), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		file  string
+		reply string
+		want  []ClassificationLabel
+	}{
+		{"mapped to english", path, `{"labels":[{"label":"Urgente","score":0.9},{"label":"action requise","score":0.6},{"label":"personnel","score":0.1}]}`,
+			[]ClassificationLabel{{Label: "urgent", Score: 0.9}, {Label: "action_required", Score: 0.6}, {Label: "personnel", Score: 0.1}}},
+		{"synonyms merged", path, `{"labels":[{"label":"pourriel","score":0.4},{"label":"indésirable","score":0.8}]}`,
+			[]ClassificationLabel{{Label: "spam", Score: 0.8}}},
+		{"no synonyms file", "", `{"labels":[{"label":"Urgente","score":0.9}]}`,
+			[]ClassificationLabel{{Label: "Urgente", Score: 0.9}}},
+		{"unreadable file ignored", filepath.Join(t.TempDir(), "missing.json"), `{"labels":[{"label":"Urgente","score":0.9}]}`,
+			[]ClassificationLabel{{Label: "Urgente", Score: 0.9}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LABEL_SYNONYMS_FILE", tt.file)
+			t.Setenv("CLASSIFY_MAX_LABELS", "3")
+			captureLog(t)
+			c := newTestClient(t, replying(tt.reply))
+			results, err := c.ClassifyEmailsBatch(context.Background(), []EmailRequest{{ID: "1", Content: "Le serveur est en panne, merci de redémarrer avant midi."}}, ClassifyOptions{})
+			if err != nil {
+				t.Fatalf("ClassifyEmailsBatch: %v", err)
+			}
+			if !reflect.DeepEqual(results[0].Labels, tt.want) {
+				t.Errorf("labels = %+v, want %+v", results[0].Labels, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyLabelRemap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "remap.json")
+	if err := os.WriteFile(path, []byte(`{"invoice":"billing","Payment-Issue":"billing","meeting":"calendar"," ":"ignored"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		remap string
+		reply string
+		want  []ClassificationLabel
+	}{
+		{"two sources merge keeping the max", path, `{"labels":[{"label":"invoice","score":0.4},{"label":"urgent","score":0.6},{"label":"payment issue","score":0.8}]}`,
+			[]ClassificationLabel{{Label: "billing", Score: 0.8}, {Label: "urgent", Score: 0.6}}},
+		{"source merges into an existing target", path, `{"labels":[{"label":"billing","score":0.7},{"label":"invoice","score":0.5}]}`,
+			[]ClassificationLabel{{Label: "billing", Score: 0.7}}},
+		{"unmapped labels kept", path, `{"labels":[{"label":"urgent","score":0.9}]}`,
+			[]ClassificationLabel{{Label: "urgent", Score: 0.9}}},
+		{"no remap file", "", `{"labels":[{"label":"invoice","score":0.4},{"label":"payment issue","score":0.8}]}`,
+			[]ClassificationLabel{{Label: "payment issue", Score: 0.8}, {Label: "invoice", Score: 0.4}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLASSIFY_LABEL_REMAP", tt.remap)
+			t.Setenv("CLASSIFY_MAX_LABELS", "3")
+			captureLog(t)
+			c := newTestClient(t, replying(tt.reply))
+			results, err := c.ClassifyEmailsBatch(context.Background(), []EmailRequest{{ID: "1", Content: "Invoice 42 was charged twice, please refund it today."}}, ClassifyOptions{})
+			if err != nil {
+				t.Fatalf("ClassifyEmailsBatch: %v", err)
+			}
+			if !reflect.DeepEqual(results[0].Labels, tt.want) {
+				t.Errorf("labels = %+v, want %+v", results[0].Labels, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabelRemapAppliesToCachedResults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "remap.json")
+	if err := os.WriteFile(path, []byte(`{"invoice":"billing"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CACHE_ENABLED", "true")
+	upstream := replying(`{"labels":[{"label":"invoice","score":0.9}]}`)
+	c := newTestClient(t, upstream)
+	c.Cache = newResponseCacheFromEnv(&CacheMetrics{})
+	emails := []EmailRequest{{ID: "1", Content: "Invoice 42 was charged twice."}}
+
+	// Classify before the taxonomy change, then remap the cached history
+	if _, err := c.ClassifyEmailsBatch(context.Background(), emails, ClassifyOptions{}); err != nil {
+		t.Fatalf("ClassifyEmailsBatch: %v", err)
+	}
+	c.LabelRemap = loadLabelRemapFrom(t, path)
+	results, err := c.ClassifyEmailsBatch(context.Background(), emails, ClassifyOptions{})
+	if err != nil {
+		t.Fatalf("ClassifyEmailsBatch: %v", err)
+	}
+	if upstream.calls() != 1 || !results[0].Cached {
+		t.Fatalf("upstream calls = %d, cached = %v, want the second result from the cache", upstream.calls(), results[0].Cached)
+	}
+	if want := []ClassificationLabel{{Label: "billing", Score: 0.9}}; !reflect.DeepEqual(results[0].Labels, want) {
+		t.Errorf("cached labels = %+v, want %+v", results[0].Labels, want)
+	}
+}
+
+// loadLabelRemapFrom loads the remap table in path through CLASSIFY_LABEL_REMAP
+func loadLabelRemapFrom(t *testing.T, path string) map[string]string {
+	t.Helper()
+	t.Setenv("CLASSIFY_LABEL_REMAP", path)
+	return loadLabelRemap()
+}
+
+func TestParseLabelRemap(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"keys normalized, values trimmed", `{"Payment-Issue":" billing ","INVOICE":"billing"}`, map[string]string{"payment_issue": "billing", "invoice": "billing"}, false},
+		{"empty keys and values dropped", `{" ":"billing","invoice":" "}`, map[string]string{}, false},
+		{"malformed", `{"invoice":`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLabelRemap([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLabelRemap = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
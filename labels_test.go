@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestConstrainLabels(t *testing.T) {
+	urgent := ClassificationLabel{Label: "urgent", Score: 0.9}
+	spam := ClassificationLabel{Label: "spam", Score: 0.2}
+	followUp := ClassificationLabel{Label: "follow_up", Score: 0.5}
+	other := ClassificationLabel{Label: "other", Score: 0}
+	tests := []struct {
+		name     string
+		min, max int
+		labels   []ClassificationLabel
+		want     []ClassificationLabel
+	}{
+		{"within bounds", 1, 3, []ClassificationLabel{spam, urgent}, []ClassificationLabel{urgent, spam}},
+		{"over max keeps top scores", 1, 2, []ClassificationLabel{spam, urgent, followUp}, []ClassificationLabel{urgent, followUp}},
+		{"under min adds fallback", 2, 3, []ClassificationLabel{urgent}, []ClassificationLabel{urgent, other}},
+		{"under min pads to min", 3, 3, []ClassificationLabel{urgent}, []ClassificationLabel{urgent, other, other}},
+		{"empty pads to min", 2, 3, nil, []ClassificationLabel{other, other}},
+		{"duplicates merged before counting", 2, 3, []ClassificationLabel{urgent, {Label: "Urgent", Score: 0.4}}, []ClassificationLabel{urgent, other}},
+		{"no min", 0, 1, nil, []ClassificationLabel{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &DeepseekClient{ClassifyMinLabels: tt.min, ClassifyMaxLabels: tt.max, ClassifyFallbackLabel: "other"}
+			if got := c.constrainLabels(tt.labels); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("constrainLabels = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDedupeLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []ClassificationLabel
+		want   []ClassificationLabel
+	}{
+		{"no duplicates", []ClassificationLabel{{Label: "billing", Score: 0.9}, {Label: "urgent", Score: 0.3}}, []ClassificationLabel{{Label: "billing", Score: 0.9}, {Label: "urgent", Score: 0.3}}},
+		{"higher score kept", []ClassificationLabel{{Label: "billing", Score: 0.4}, {Label: "urgent", Score: 0.3}, {Label: "billing", Score: 0.9}}, []ClassificationLabel{{Label: "billing", Score: 0.9}, {Label: "urgent", Score: 0.3}}},
+		{"first kept on a tie", []ClassificationLabel{{Label: "billing", Score: 0.5, Rationale: "first"}, {Label: "billing", Score: 0.5, Rationale: "second"}}, []ClassificationLabel{{Label: "billing", Score: 0.5, Rationale: "first"}}},
+		{"case and space ignored", []ClassificationLabel{{Label: "Billing ", Score: 0.2}, {Label: "billing", Score: 0.7}}, []ClassificationLabel{{Label: "billing", Score: 0.7}}},
+		{"empty", nil, []ClassificationLabel{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dedupeLabels(tt.labels); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dedupeLabels = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyDuplicateLabels(t *testing.T) {
+	t.Setenv("CLASSIFY_MAX_LABELS", "3")
+	c := newTestClient(t, replying(`{"labels":[{"label":"billing","score":0.4},{"label":"urgent","score":0.6},{"label":"billing","score":0.9}]}`))
+	results, err := c.ClassifyEmailsBatch(context.Background(), []EmailRequest{{ID: "1", Content: "Invoice 42 was charged twice, please refund it today."}}, ClassifyOptions{})
+	if err != nil {
+		t.Fatalf("ClassifyEmailsBatch: %v", err)
+	}
+	want := []ClassificationLabel{{Label: "billing", Score: 0.9}, {Label: "urgent", Score: 0.6}}
+	if !reflect.DeepEqual(results[0].Labels, want) {
+		t.Errorf("labels = %+v, want %+v", results[0].Labels, want)
+	}
+}
+
+func TestReviewThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold string
+		reply     string
+		want      []ClassificationLabel
+	}{
+		{"off", "0", `{"labels":[{"label":"spam","score":0.25}]}`, []ClassificationLabel{{Label: "spam", Score: 0.25}}},
+		{"high confidence", "0.6", `{"labels":[{"label":"urgent","score":0.75},{"label":"spam","score":0.25}]}`, []ClassificationLabel{{Label: "urgent", Score: 0.75}, {Label: "spam", Score: 0.25}}},
+		{"low confidence", "0.6", `{"labels":[{"label":"spam","score":0.25}]}`, []ClassificationLabel{{Label: needsReviewLabel, Score: 0.75}, {Label: "spam", Score: 0.25}}},
+		{"top label decides", "0.6", `{"labels":[{"label":"spam","score":0.25},{"label":"urgent","score":0.5}]}`, []ClassificationLabel{{Label: needsReviewLabel, Score: 0.5}, {Label: "urgent", Score: 0.5}, {Label: "spam", Score: 0.25}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLASSIFY_REVIEW_THRESHOLD", tt.threshold)
+			t.Setenv("CLASSIFY_MAX_LABELS", "3")
+			c := newTestClient(t, replying(tt.reply))
+			results, err := c.ClassifyEmailsBatch(context.Background(), []EmailRequest{{ID: "1", Content: "The production database is down and customers cannot log in."}}, ClassifyOptions{})
+			if err != nil {
+				t.Fatalf("ClassifyEmailsBatch: %v", err)
+			}
+			if !reflect.DeepEqual(results[0].Labels, tt.want) {
+				t.Errorf("labels = %+v, want %+v", results[0].Labels, tt.want)
+			}
+		})
+	}
+}
+
+func TestBestLabel(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []ClassificationLabel
+		want   ClassificationLabel
+	}{
+		{"highest score wins", []ClassificationLabel{{Label: "spam", Score: 0.2}, {Label: "urgent", Score: 0.9}, {Label: "follow_up", Score: 0.5}}, ClassificationLabel{Label: "urgent", Score: 0.9}},
+		{"single label", []ClassificationLabel{{Label: "spam", Score: 0.2}}, ClassificationLabel{Label: "spam", Score: 0.2}},
+		{"empty uses fallback", nil, ClassificationLabel{Label: "other", Score: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bestLabel(tt.labels, "other"); got != tt.want {
+				t.Errorf("bestLabel = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifySingleLabel(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		body  string
+		reply string
+		want  ClassificationLabel
+	}{
+		{"query", "?single_label=true", `{"emails":[{"id":"1","content":"The server is down again"}]}`, `{"labels":[{"label":"spam","score":0.2},{"label":"urgent","score":0.9}]}`, ClassificationLabel{Label: "urgent", Score: 0.9}},
+		{"json field", "", `{"emails":[{"id":"1","content":"The server is down again"}],"single_label":true}`, `{"labels":[{"label":"spam","score":0.2},{"label":"urgent","score":0.9}]}`, ClassificationLabel{Label: "urgent", Score: 0.9}},
+		{"no labels uses fallback", "?single_label=true", `{"emails":[{"id":"1","content":"The server is down again"}]}`, `{"labels":[]}`, ClassificationLabel{Label: "other", Score: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLASSIFY_FALLBACK_LABEL", "other")
+			t.Setenv("CLASSIFY_MAX_LABELS", "3")
+			s := newTestServer(t, replying(tt.reply))
+			rec := httptest.NewRecorder()
+			s.ClassifyHandler(rec, postJSON("/classify"+tt.query, tt.body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+			}
+			var resp BatchTopLabelResponse
+			decodeResponse(t, rec, &resp)
+			if len(resp.Results) != 1 {
+				t.Fatalf("results = %+v, want 1", resp.Results)
+			}
+			if got := resp.Results[0].ClassificationLabel; got != tt.want {
+				t.Errorf("label = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
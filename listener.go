@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net"
+	"sync"
+)
+
+// limitListener caps the number of simultaneously open accepted connections.
+// Accept blocks once the cap is reached until an existing connection closes,
+// so a connection flood waits in the kernel backlog instead of consuming
+// file descriptors. It mirrors golang.org/x/net/netutil.LimitListener.
+type limitListener struct {
+	net.Listener
+	sem       chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// LimitListener returns a listener that accepts at most n connections at once
+func LimitListener(l net.Listener, n int) net.Listener {
+	return &limitListener{
+		Listener: l,
+		sem:      make(chan struct{}, n),
+		done:     make(chan struct{}),
+	}
+}
+
+// acquire waits for a free slot, returning false once the listener is closed
+func (l *limitListener) acquire() bool {
+	select {
+	case <-l.done:
+		return false
+	case l.sem <- struct{}{}:
+		return true
+	}
+}
+
+func (l *limitListener) release() { <-l.sem }
+
+// Accept waits for a free slot and then for the next connection
+func (l *limitListener) Accept() (net.Conn, error) {
+	if !l.acquire() {
+		// The listener is closed; let the underlying listener report it
+		return l.Listener.Accept()
+	}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	return &limitListenerConn{Conn: c, release: l.release}, nil
+}
+
+// Close closes the listener and unblocks pending Accept calls
+func (l *limitListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	return err
+}
+
+// limitListenerConn frees its listener slot when closed
+type limitListenerConn struct {
+	net.Conn
+	releaseOnce sync.Once
+	release     func()
+}
+
+func (c *limitListenerConn) Close() error {
+	err := c.Conn.Close()
+	c.releaseOnce.Do(c.release)
+	return err
+}
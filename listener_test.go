@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimitListener(t *testing.T) {
+	const limit = 2
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := LimitListener(inner, limit)
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	// Open more connections than the limit; the kernel backlog holds the rest
+	for i := 0; i < limit+2; i++ {
+		c, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+
+	var open []net.Conn
+	for i := 0; i < limit; i++ {
+		select {
+		case c := <-accepted:
+			open = append(open, c)
+		case <-time.After(time.Second):
+			t.Fatalf("accepted %d connections, want %d", i, limit)
+		}
+	}
+	select {
+	case <-accepted:
+		t.Fatalf("accepted more than %d connections at once", limit)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Closing an accepted connection frees a slot for exactly one more
+	open[0].Close()
+	select {
+	case c := <-accepted:
+		defer c.Close()
+	case <-time.After(time.Second):
+		t.Fatal("no connection accepted after one closed")
+	}
+	select {
+	case <-accepted:
+		t.Fatalf("accepted more than %d connections at once", limit)
+	case <-time.After(100 * time.Millisecond):
+	}
+	open[1].Close()
+}
+
+func TestLimitListenerCloseUnblocksAccept(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := LimitListener(inner, 1)
+	c, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	first, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		done <- err
+	}()
+	l.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Accept after Close returned no error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Accept still blocked after Close")
+	}
+}
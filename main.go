@@ -2,21 +2,80 @@ package main
 
 import (
 	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// defaultBodyReadTimeout bounds how long reading a request body may take
+const defaultBodyReadTimeout = 30 * time.Second
+
+// defaultMaxDraftCandidates is the default cap on draft candidates per request
+const defaultMaxDraftCandidates = 5
+
 // Server holds the application dependencies
 type Server struct {
-	client *DeepseekClient
+	// client is the DeepSeek client, also reachable through providers
+	client          *DeepseekClient
+	providers       map[string]LLMClient
+	defaultProvider string
+	bodyReadTimeout time.Duration
+	adminToken      string
+	// maxDraftCandidates caps the n query parameter on /draft
+	maxDraftCandidates int
+	// maxThreadMessages caps the thread messages sent to the model verbatim
+	maxThreadMessages int
+	health            HealthConfig
+	cacheMetrics      *CacheMetrics
+	// labelMetrics counts returned classification labels; nil disables it
+	labelMetrics *LabelMetrics
+	// sizeMetrics records request and response body sizes
+	sizeMetrics    *SizeMetrics
+	providerHealth *providerHealthCache
+	// debug captures a sample of requests for admin inspection; nil disables it
+	debug *DebugRecorder
+	// batchDedup replays results of identical batches; nil disables it
+	batchDedup ResponseCache
+	// allowPromptOverride honors system_prompt in request bodies
+	allowPromptOverride bool
+	// extraParamsAllowed lists the params callers may pass in extra_params
+	extraParamsAllowed []string
+	// includePromptEnabled lets admins request classification prompts
+	includePromptEnabled bool
+	// keyConcurrency limits in-flight requests per API key; nil disables it
+	keyConcurrency *KeyConcurrency
+	// keyQuotas meters monthly usage per API key; nil disables it
+	keyQuotas *KeyQuotas
+	// sniffBody detects JSON and HTML in raw /summarize and /draft bodies
+	sniffBody bool
+	// strictJSON rejects unknown fields in JSON request bodies
+	strictJSON bool
+	// pricing holds USD prices per million tokens for /estimate
+	pricing map[string]ModelPrice
+	// disabledOperations are operations whose routes are not registered
+	disabledOperations map[string]bool
+	// jobs holds asynchronous /classify?async=true batches
+	jobs *JobStore
+	// personas maps draft persona names to their system-prompt fragments
+	personas map[string]string
+	// noReplyPatterns skip drafting for automated email; nil disables the check
+	noReplyPatterns []SensitivePattern
+	// sensitivePatterns refuse content with regulated data; nil disables the check
+	sensitivePatterns []SensitivePattern
 }
 
 // NewServer creates a new server instance
@@ -29,77 +88,423 @@ func NewServer() *Server {
 		log.Printf("Using DEEPSEEK_API_URL: %s", baseURL)
 	}
 
-	apiKey := strings.TrimSpace(os.Getenv("DEEPSEEK_API_KEY"))
+	apiKey, err := loadSecret("DEEPSEEK_API_KEY")
+	if err != nil {
+		log.Fatal(err)
+	}
 	if apiKey == "" {
-		log.Fatal("DEEPSEEK_API_KEY environment variable is required")
+		log.Fatal("DEEPSEEK_API_KEY or DEEPSEEK_API_KEY_FILE environment variable is required")
 	}
 	log.Printf("DEEPSEEK_API_KEY is configured (length: %d)", len(apiKey))
 
+	cacheMetrics := &CacheMetrics{}
+	client := NewDeepseekClient(baseURL, apiKey)
+	client.Cache = newResponseCacheFromEnv(cacheMetrics)
+	providers := newProvidersFromEnv(client)
+	defaultProvider := strings.ToLower(envString("LLM_PROVIDER", ProviderDeepseek))
+	if _, ok := providers[defaultProvider]; !ok {
+		log.Fatalf("LLM_PROVIDER %q is not configured", defaultProvider)
+	}
+
+	personas, err := loadPersonas(os.Getenv("PERSONAS_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(personas) > 0 {
+		log.Printf("Loaded %d draft persona(s)", len(personas))
+	}
+
 	return &Server{
-		client: NewDeepseekClient(baseURL, apiKey),
+		client:               client,
+		providers:            providers,
+		defaultProvider:      defaultProvider,
+		bodyReadTimeout:      envDuration("BODY_READ_TIMEOUT", defaultBodyReadTimeout),
+		adminToken:           strings.TrimSpace(os.Getenv("ADMIN_TOKEN")),
+		maxDraftCandidates:   envInt("MAX_DRAFT_CANDIDATES", defaultMaxDraftCandidates),
+		maxThreadMessages:    envInt("MAX_THREAD_MESSAGES", defaultMaxThreadMessages),
+		health:               newHealthConfigFromEnv(),
+		cacheMetrics:         cacheMetrics,
+		labelMetrics:         newLabelMetricsFromEnv(),
+		sizeMetrics:          NewSizeMetrics(),
+		batchDedup:           newBatchDedupFromEnv(),
+		sensitivePatterns:    compileSensitivePatterns(),
+		noReplyPatterns:      compileNoReplyPatterns(),
+		sniffBody:            envBool("SNIFF_REQUEST_BODY", false),
+		strictJSON:           envBool("STRICT_JSON_BODIES", false),
+		pricing:              parseModelPricing(os.Getenv("MODEL_PRICING")),
+		disabledOperations:   disabledOperationsFromEnv(),
+		personas:             personas,
+		jobs:                 newJobStoreFromEnv(),
+		keyConcurrency:       newKeyConcurrencyFromEnv(),
+		keyQuotas:            newKeyQuotasFromEnv(),
+		includePromptEnabled: envBool("INCLUDE_PROMPT_ENABLED", false),
+		allowPromptOverride:  envBool("ALLOW_PROMPT_OVERRIDE", false),
+		extraParamsAllowed:   requestExtraParamsFromEnv(),
+		providerHealth:       newProviderHealthCacheFromEnv(),
+		debug:                newDebugRecorderFromEnv(),
 	}
 }
 
+// operationFlags maps each operation to the flag that enables it
+var operationFlags = map[string]string{
+	CompareSummarize: "ENABLE_SUMMARIZE",
+	CompareClassify:  "ENABLE_CLASSIFY",
+	CompareDraft:     "ENABLE_DRAFT",
+}
+
+// disabledOperationsFromEnv returns the operations turned off by their ENABLE_* flag
+func disabledOperationsFromEnv() map[string]bool {
+	disabled := make(map[string]bool)
+	for operation, flag := range operationFlags {
+		if !envBool(flag, true) {
+			log.Printf("%s is disabled by %s", operation, flag)
+			disabled[operation] = true
+		}
+	}
+	return disabled
+}
+
+// operationEnabled reports whether this deployment exposes operation
+func (s *Server) operationEnabled(operation string) bool {
+	return !s.disabledOperations[operation]
+}
+
+// decodeJSON decodes a request body into v, strictly when strictJSON is enabled
+func (s *Server) decodeJSON(data []byte, v interface{}) error {
+	if s.strictJSON {
+		return decodeStrictJSON(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
 // ErrorResponse represents an error response
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message,omitempty"`
+	Error string `json:"error"`
+	// Code is a machine-readable error code, set for some errors
+	Code    string            `json:"code,omitempty"`
+	Message string            `json:"message,omitempty"`
+	Errors  []ValidationError `json:"errors,omitempty"`
+	// Quota reports the exhausted quota of a 402 response
+	Quota *QuotaStatus `json:"quota,omitempty"`
 }
 
-// JSONError writes an error response as JSON (with gzip compression)
+// ValidationError describes a single invalid field in a request
+type ValidationError struct {
+	Index   int    `json:"index"`
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// JSONError writes an error response as JSON in the negotiated encoding
 func JSONError(w http.ResponseWriter, message string, statusCode int) {
-	errorResp := ErrorResponse{
+	writeErrorResponse(w, ErrorResponse{
 		Error:   http.StatusText(statusCode),
 		Message: message,
-	}
-	// Set status code first
-	w.WriteHeader(statusCode)
-	// Use gzip compression for error responses too
-	if err := writeGzipJSON(w, errorResp); err != nil {
-		// Fallback to uncompressed JSON if gzip fails
+	}, statusCode)
+}
+
+// JSONValidationError writes a 400 response listing every validation failure
+func JSONValidationError(w http.ResponseWriter, message string, errs []ValidationError) {
+	writeErrorResponse(w, ErrorResponse{
+		Error:   http.StatusText(http.StatusBadRequest),
+		Message: message,
+		Errors:  errs,
+	}, http.StatusBadRequest)
+}
+
+// writeErrorResponse writes errorResp with the given status code
+func writeErrorResponse(w http.ResponseWriter, errorResp ErrorResponse, statusCode int) {
+	// Error responses use the negotiated encoding too
+	if err := writeEncodedJSONStatus(w, statusCode, errorResp); err != nil {
+		// Fall back to uncompressed JSON if encoding fails before anything is written
 		w.Header().Set("Content-Type", "application/json")
-		w.Header().Del("Content-Encoding") // Remove gzip header if set
+		w.Header().Del("Content-Encoding")
+		w.WriteHeader(statusCode)
 		json.NewEncoder(w).Encode(errorResp)
 	}
 }
 
-// CORS middleware
-func CORS(next http.Handler) http.Handler {
+// CORS middleware. Any Origin is allowed when allowedOrigins is empty or
+// contains "*". A disallowed origin gets no Access-Control-Allow-Origin header,
+// so browsers block the response; with strict, non-preflight requests from it
+// are also rejected with 403 before reaching the handler.
+func CORS(allowedOrigins []string, strict bool) func(http.Handler) http.Handler {
+	allowAll := len(allowedOrigins) == 0 || containsString(allowedOrigins, "*")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if !allowAll {
+				w.Header().Add("Vary", "Origin")
+			}
+			switch {
+			case origin == "":
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			case allowAll || containsString(allowedOrigins, origin):
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			case strict && r.Method != "OPTIONS":
+				JSONError(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Request-ID, X-LLM-Provider, X-API-Key, X-Include-Prompt")
+			w.Header().Set("Access-Control-Max-Age", "3600")
+
+			if r.Method == "OPTIONS" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestIDKey is the context key under which the request ID is stored
+type requestIDKey struct{}
+
+// requestIDHeader is the header used to read and echo the correlation ID
+const requestIDHeader = "X-Request-ID"
+
+// withRequestID returns a copy of ctx carrying the given request ID
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFromContext returns the request ID stored in ctx, or "" if none
+func requestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID generates a random 16-byte hex request ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestID middleware assigns a correlation ID to every request
+func RequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		origin := r.Header.Get("Origin")
-		if origin != "" {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-		} else {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
+		id := strings.TrimSpace(r.Header.Get(requestIDHeader))
+		if id == "" {
+			id = newRequestID()
 		}
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		w.Header().Set("Access-Control-Max-Age", "3600")
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+	})
+}
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
+// forwardedHeadersKey is the context key under which forwarded inbound headers are stored
+type forwardedHeadersKey struct{}
+
+// forwardedHeadersFromContext returns the inbound headers to forward upstream, if any
+func forwardedHeadersFromContext(ctx context.Context) http.Header {
+	if ctx == nil {
+		return nil
+	}
+	h, _ := ctx.Value(forwardedHeadersKey{}).(http.Header)
+	return h
+}
+
+// ForwardHeaders middleware captures the allowlisted inbound headers so the
+// client can forward them to the upstream. Authorization is never forwarded.
+func ForwardHeaders(names []string) func(http.Handler) http.Handler {
+	var allowed []string
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		if name != "Authorization" {
+			allowed = append(allowed, name)
+		}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwarded := make(http.Header)
+			for _, name := range allowed {
+				if values := r.Header.Values(name); len(values) > 0 {
+					forwarded[name] = values
+				}
+			}
+			if len(forwarded) > 0 {
+				r = r.WithContext(context.WithValue(r.Context(), forwardedHeadersKey{}, forwarded))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// maxTemperature is the highest sampling temperature a request may ask for
+const maxTemperature = 2.0
+
+// temperatureKey is the context key under which a per-request temperature is stored
+type temperatureKey struct{}
+
+// temperatureFromContext returns the temperature requested by the caller, if any
+func temperatureFromContext(ctx context.Context) (float64, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	t, ok := ctx.Value(temperatureKey{}).(float64)
+	return t, ok
+}
+
+// TemperatureOverride middleware reads the optional temperature query
+// parameter, which replaces the operation's default sampling temperature
+func TemperatureOverride(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query().Get("temperature")
+		if v == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t < 0 || t > maxTemperature {
+			JSONError(w, fmt.Sprintf("temperature must be a number between 0 and %g", maxTemperature), http.StatusBadRequest)
 			return
 		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), temperatureKey{}, t)))
+	})
+}
 
-		next.ServeHTTP(w, r)
+// seedKey is the context key under which a per-request sampling seed is stored
+type seedKey struct{}
+
+// seedFromContext returns the seed requested by the caller, if any
+func seedFromContext(ctx context.Context) (int, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	seed, ok := ctx.Value(seedKey{}).(int)
+	return seed, ok
+}
+
+// SeedOverride middleware reads the optional seed query parameter, which is
+// passed to the provider on every model call the request makes
+func SeedOverride(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.URL.Query().Get("seed")
+		if v == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		seed, err := strconv.Atoi(v)
+		if err != nil {
+			JSONError(w, "seed must be an integer", http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), seedKey{}, seed)))
 	})
 }
 
-// Logging middleware
-func Logging(next http.Handler) http.Handler {
+// globalQueryParams are accepted on every endpoint in strict query mode
+var globalQueryParams = []string{"temperature", "seed"}
+
+// endpointQueryParams lists the query parameters each endpoint understands,
+// besides globalQueryParams; paths not listed accept none
+var endpointQueryParams = map[string][]string{
+	"/summarize": {"max_words", "split_history", "include_highlights", "stream_input"},
+	"/classify":  {"single_label", "include_rationale", "async"},
+	"/draft":     {"n", "format", "persona", "include_confidence"},
+}
+
+// StrictQueryParams middleware rejects requests carrying query parameters the
+// endpoint does not understand with 400, so typos surface instead of being ignored
+func StrictQueryParams(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		ww := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
-		next.ServeHTTP(ww, r)
-		duration := time.Since(start)
-		log.Printf("%s %s %d %v", r.Method, r.URL.Path, ww.statusCode, duration)
+		var unexpected []string
+		for name := range r.URL.Query() {
+			if !containsString(globalQueryParams, name) && !containsString(endpointQueryParams[r.URL.Path], name) {
+				unexpected = append(unexpected, strconv.Quote(name))
+			}
+		}
+		if len(unexpected) > 0 {
+			sort.Strings(unexpected)
+			JSONError(w, fmt.Sprintf("Unexpected query parameter(s): %s", strings.Join(unexpected, ", ")), http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
 	})
 }
 
-// responseWriter wraps http.ResponseWriter to capture status code
+// Default limits enforced by the HeaderLimits middleware
+const (
+	defaultMaxHeaderCount = 100
+	defaultMaxHeaderBytes = 16 << 10
+)
+
+// HeaderLimits middleware rejects requests with more than maxCount header
+// values or more than maxBytes of header names and values with 431
+func HeaderLimits(maxCount, maxBytes int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			count, size := 0, 0
+			for name, values := range r.Header {
+				for _, value := range values {
+					count++
+					size += len(name) + len(value)
+				}
+			}
+			if count > maxCount || size > maxBytes {
+				JSONError(w, fmt.Sprintf("Request headers too large (%d headers, %d bytes; limits %d headers, %d bytes)", count, size, maxCount, maxBytes), http.StatusRequestHeaderFieldsTooLarge)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Logging middleware logs each request with its body sizes and, when sizes
+// is non-nil, records them in its histograms
+func Logging(sizes *SizeMetrics) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			body := &countingReadCloser{ReadCloser: r.Body}
+			r.Body = body
+			next.ServeHTTP(ww, r)
+			duration := time.Since(start)
+			log.Printf("[%s] %s %s %d %v req_bytes=%d resp_bytes=%d resp_uncompressed_bytes=%d",
+				requestIDFromContext(r.Context()), r.Method, r.URL.Path, ww.statusCode, duration,
+				body.n, ww.bytesWritten, ww.uncompressedBytes())
+			if sizes != nil {
+				sizes.Record(body.n, ww.bytesWritten, ww.uncompressedBytes())
+			}
+		})
+	}
+}
+
+// countingReadCloser counts the bytes read from a request body
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// uncompressedSizeRecorder is implemented by writers that track the size of a
+// response before compression
+type uncompressedSizeRecorder interface {
+	recordUncompressed(n int64)
+}
+
+// responseWriter wraps http.ResponseWriter to capture status code and body size
 type responseWriter struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode   int
+	bytesWritten int64
+	// uncompressed is the pre-gzip body size, valid when compressed is set
+	uncompressed int64
+	compressed   bool
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
@@ -107,6 +512,30 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(p)
+	rw.bytesWritten += int64(n)
+	return n, err
+}
+
+func (rw *responseWriter) recordUncompressed(n int64) {
+	rw.compressed = true
+	rw.uncompressed += n
+}
+
+// uncompressedBytes returns the response size before gzip compression
+func (rw *responseWriter) uncompressedBytes() int64 {
+	if !rw.compressed {
+		return rw.bytesWritten
+	}
+	return rw.uncompressed
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
 // JSONRecovery middleware for panic recovery
 func JSONRecovery(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -120,10 +549,50 @@ func JSONRecovery(next http.Handler) http.Handler {
 	})
 }
 
-// readRequestBody reads the request body, handling gzip decompression
-func readRequestBody(r *http.Request) ([]byte, error) {
+// errBodyReadTimeout is returned when the client does not send the body in time
+var errBodyReadTimeout = errors.New("request body read timed out")
+
+// readRequestBody reads the request body, handling gzip decompression.
+// The read is abandoned with errBodyReadTimeout if it takes longer than timeout.
+func readRequestBody(w http.ResponseWriter, r *http.Request, timeout time.Duration) ([]byte, error) {
+	// Prefer a connection read deadline so a trickling client is cut off at the socket
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Now().Add(timeout)); err == nil {
+		defer rc.SetReadDeadline(time.Time{})
+		body, err := readAllBody(r)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil, errBodyReadTimeout
+		}
+		return body, err
+	}
+
+	// Fall back to bounding the read in a goroutine when deadlines are unsupported
+	type result struct {
+		body []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		body, err := readAllBody(r)
+		done <- result{body, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return res.body, res.err
+	case <-timer.C:
+		return nil, errBodyReadTimeout
+	}
+}
+
+// readAllBody reads the whole request body, decompressing gzip if needed and
+// transcoding it to UTF-8 from the charset named in Content-Type
+func readAllBody(r *http.Request) ([]byte, error) {
 	var reader io.Reader = r.Body
-	
+
 	// Check if content is gzip compressed
 	if r.Header.Get("Content-Encoding") == "gzip" {
 		gzReader, err := gzip.NewReader(r.Body)
@@ -133,23 +602,107 @@ func readRequestBody(r *http.Request) ([]byte, error) {
 		defer gzReader.Close()
 		reader = gzReader
 	}
-	
+
 	body, err := io.ReadAll(reader)
 	if err != nil {
 		return nil, err
 	}
-	return body, nil
+	return decodeBody(body, r.Header.Get("Content-Type"))
 }
 
-// writeGzipJSON writes JSON response with gzip compression
-func writeGzipJSON(w http.ResponseWriter, data interface{}) error {
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Content-Encoding", "gzip")
+// writeBodyReadError reports a request body read failure with the right status
+func writeBodyReadError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errBodyReadTimeout) {
+		// The rest of the body may never arrive; closing the connection stops
+		// the server from waiting to drain it before sending the response
+		w.Header().Set("Connection", "close")
+		JSONError(w, "Timed out reading request body", http.StatusRequestTimeout)
+		return
+	}
+	if errors.Is(err, errUnsupportedCharset) {
+		JSONError(w, fmt.Sprintf("Unsupported request body: %v", err), http.StatusUnsupportedMediaType)
+		return
+	}
+	JSONError(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+}
+
+// setContentHashHeader echoes the processed content hash as X-Content-Hash
+func setContentHashHeader(w http.ResponseWriter, meta *ResponseMetadata) {
+	if meta != nil && meta.ContentHash != "" {
+		w.Header().Set("X-Content-Hash", meta.ContentHash)
+	}
+}
+
+// isJSONContentType reports whether contentType is application/json, with or without parameters
+func isJSONContentType(contentType string) bool {
+	return contentType == "application/json" || strings.HasPrefix(contentType, "application/json;")
+}
+
+// writeUpstreamError reports a failed model call. Upstream rate limits become a
+// 429 carrying the upstream's Retry-After, an exceeded request deadline a 504;
+// anything else is a 500 with message.
+func writeUpstreamError(w http.ResponseWriter, message string, err error) {
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		if rateLimitErr.RetryAfter != "" {
+			w.Header().Set("Retry-After", rateLimitErr.RetryAfter)
+		}
+		JSONError(w, "Upstream rate limit exceeded, retry later", http.StatusTooManyRequests)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		JSONError(w, message+": request deadline exceeded", http.StatusGatewayTimeout)
+		return
+	}
+	JSONError(w, message, http.StatusInternalServerError)
+}
 
-	gz := gzip.NewWriter(w)
-	defer gz.Close()
+// positiveIntQuery parses an optional positive integer query parameter.
+// It returns 0 when the parameter is absent.
+func positiveIntQuery(r *http.Request, name string) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+	return n, nil
+}
 
-	return json.NewEncoder(gz).Encode(data)
+// boolQuery parses an optional boolean query parameter, false when absent
+func boolQuery(r *http.Request, name string) (bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", name)
+	}
+	return b, nil
+}
+
+// SummarizeRequest is the structured JSON body accepted by /summarize
+type SummarizeRequest struct {
+	StructuredEmail
+	// Thread holds earlier messages of the conversation, oldest first
+	Thread            []StructuredEmail `json:"thread"`
+	SplitHistory      bool              `json:"split_history"`
+	IncludeHighlights bool              `json:"include_highlights"`
+	// SystemPrompt replaces the built-in system prompt when ALLOW_PROMPT_OVERRIDE is set
+	SystemPrompt string `json:"system_prompt"`
+	// ExtraParams are passed to the provider; only REQUEST_EXTRA_PARAMS are allowed
+	ExtraParams map[string]interface{} `json:"extra_params"`
+}
+
+// Validate checks the email or thread
+func (req SummarizeRequest) Validate() error {
+	if err := validateSystemPrompt(req.SystemPrompt); err != nil {
+		return err
+	}
+	return validateThread(req.StructuredEmail, req.Thread)
 }
 
 // SummarizeHandler handles POST /summarize
@@ -159,27 +712,117 @@ func (s *Server) SummarizeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	bodyBytes, err := readRequestBody(r)
+	// Large bodies can be summarized progressively instead of buffered whole
+	streamInput, err := boolQuery(r, "stream_input")
 	if err != nil {
-		JSONError(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if streamInput {
+		maxWords, err := positiveIntQuery(r, "max_words")
+		if err != nil {
+			JSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.streamSummarize(w, r, SummarizeOptions{MaxWords: maxWords})
+		return
+	}
+
+	bodyBytes, err := readRequestBody(w, r, s.bodyReadTimeout)
+	if err != nil {
+		writeBodyReadError(w, err)
+		return
+	}
+
+	splitHistory, err := boolQuery(r, "split_history")
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	includeHighlights, err := boolQuery(r, "include_highlights")
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	var thread []StructuredEmail
 	content := string(bodyBytes)
+	kind := bodyKindText
+	if isJSONContentType(r.Header.Get("Content-Type")) {
+		kind = bodyKindJSON
+	} else if s.sniffBody {
+		kind = sniffBody(bodyBytes)
+	}
+	switch kind {
+	case bodyKindHTML:
+		content = htmlToText(content)
+	case bodyKindJSON:
+		// Structured body: {subject, from, to, date, body, split_history, include_highlights}
+		var req SummarizeRequest
+		if err := s.decodeJSON(bodyBytes, &req); err != nil {
+			JSONDecodeError(w, bodyBytes, err)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			JSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		content = req.Format()
+		if len(req.Thread) > 0 {
+			thread = threadMessages(req.StructuredEmail, req.Thread)
+			content = formatThread(thread, 1, len(thread))
+		}
+		splitHistory = splitHistory || req.SplitHistory
+		includeHighlights = includeHighlights || req.IncludeHighlights
+		ctx, err := s.applyExtraParams(s.applySystemPrompt(r.Context(), req.SystemPrompt), req.ExtraParams)
+		if err != nil {
+			JSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r = r.WithContext(ctx)
+	}
+	if splitHistory && includeHighlights {
+		JSONError(w, "split_history and include_highlights cannot be combined", http.StatusBadRequest)
+		return
+	}
 	if strings.TrimSpace(content) == "" {
 		JSONError(w, "Email content is required", http.StatusBadRequest)
 		return
 	}
+	if s.refuseSensitive(w, r, content) {
+		return
+	}
+
+	maxWords, err := positiveIntQuery(r, "max_words")
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client, err := s.clientFor(r)
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if thread != nil {
+		if content, err = threadContent(r.Context(), client, thread, s.maxThreadMessages); err != nil {
+			log.Printf("Error preparing thread for summarize: %v", err)
+			writeThreadError(w, err)
+			return
+		}
+	}
 
-	summary, err := s.client.SummarizeEmail(content)
+	summary, err := client.SummarizeEmail(r.Context(), content, SummarizeOptions{MaxWords: maxWords, SplitHistory: splitHistory, IncludeHighlights: includeHighlights})
 	if err != nil {
 		log.Printf("Error calling Deepseek API for summarize: %v", err)
 		// Log detailed error for debugging, but return generic message to client
-		JSONError(w, "Failed to summarize email", http.StatusInternalServerError)
+		writeUpstreamError(w, "Failed to summarize email", err)
 		return
 	}
 
-	if err := writeGzipJSON(w, summary); err != nil {
+	setContentHashHeader(w, summary.Metadata)
+	if err := writeEncodedJSON(w, summary); err != nil {
 		log.Printf("Error writing response: %v", err)
 		JSONError(w, "Failed to encode response", http.StatusInternalServerError)
 		return
@@ -189,17 +832,97 @@ func (s *Server) SummarizeHandler(w http.ResponseWriter, r *http.Request) {
 // BatchClassifyRequest represents the batch classification request
 type BatchClassifyRequest struct {
 	Emails []EmailRequest `json:"emails"`
+	// SingleLabel returns one {label, score} per email instead of a labels array
+	SingleLabel bool `json:"single_label"`
+	// IncludeRationale adds a rationale to each label
+	IncludeRationale bool `json:"include_rationale"`
+	// SystemPrompt replaces the built-in system prompt when ALLOW_PROMPT_OVERRIDE
+	// is set; the JSON output instruction is still appended
+	SystemPrompt string `json:"system_prompt"`
+	// ExtraParams are passed to the provider; only REQUEST_EXTRA_PARAMS are allowed
+	ExtraParams map[string]interface{} `json:"extra_params"`
 }
 
 // ClassificationResult represents the classification result for a single email
 type ClassificationResult struct {
-	ID      string                 `json:"id"`
-	Labels  []ClassificationLabel `json:"labels"`
+	ID     string                `json:"id"`
+	Labels []ClassificationLabel `json:"labels"`
+	// Error is set when this email could not be classified
+	Error string `json:"error,omitempty"`
+	// Debug holds the prompt when requested with X-Include-Prompt
+	Debug *PromptDebug `json:"_debug,omitempty"`
+}
+
+// BatchMetadata carries details about how a batch was processed
+type BatchMetadata struct {
+	CacheHits int `json:"cache_hits"`
+	Degraded  int `json:"degraded,omitempty"`
+	// Stale counts cache hits served past their TTL while degraded
+	Stale int `json:"stale,omitempty"`
+	// Warnings explains results produced without calling the model
+	Warnings []string `json:"warnings,omitempty"`
+	// ProcessedAt and UpstreamLatencyMS mirror ResponseMetadata; the latency
+	// is summed across the batch's model calls
+	ProcessedAt       string `json:"processed_at,omitempty"`
+	UpstreamLatencyMS int64  `json:"upstream_latency_ms"`
 }
 
 // BatchClassifyResponse represents the batch classification response
 type BatchClassifyResponse struct {
-	Results []ClassificationResult `json:"results"`
+	Results  []ClassificationResult `json:"results"`
+	Metadata *BatchMetadata         `json:"metadata,omitempty"`
+}
+
+// TopLabelResult is the single best label for one email in single_label mode
+type TopLabelResult struct {
+	ID string `json:"id"`
+	ClassificationLabel
+	Error string       `json:"error,omitempty"`
+	Debug *PromptDebug `json:"_debug,omitempty"`
+}
+
+// BatchTopLabelResponse represents the response for single_label batch classification
+type BatchTopLabelResponse struct {
+	Results  []TopLabelResult `json:"results"`
+	Metadata *BatchMetadata   `json:"metadata,omitempty"`
+}
+
+// validateBatchEmails checks every email in a batch for a non-empty, unique ID
+// and non-empty content
+func validateBatchEmails(emails []EmailRequest) []ValidationError {
+	var errs []ValidationError
+	firstIndex := make(map[string]int)
+	for i, email := range emails {
+		id := strings.TrimSpace(email.ID)
+		if id == "" {
+			errs = append(errs, ValidationError{Index: i, Field: "id", Message: "Email ID is required"})
+		} else if first, ok := firstIndex[id]; ok {
+			errs = append(errs, ValidationError{Index: i, Field: "id", Message: fmt.Sprintf("Duplicate email ID %q (first used at index %d)", id, first)})
+		} else {
+			firstIndex[id] = i
+		}
+		if strings.TrimSpace(email.Content) == "" {
+			errs = append(errs, ValidationError{Index: i, Field: "content", Message: "Email content is required"})
+		}
+	}
+	return errs
+}
+
+// duplicateEmailIDs returns each email ID that appears more than once, in order of first duplicate
+func duplicateEmailIDs(emails []EmailRequest) []string {
+	var dupes []string
+	counts := make(map[string]int)
+	for _, email := range emails {
+		id := strings.TrimSpace(email.ID)
+		if id == "" {
+			continue
+		}
+		counts[id]++
+		if counts[id] == 2 {
+			dupes = append(dupes, strconv.Quote(id))
+		}
+	}
+	return dupes
 }
 
 // ClassifyHandler handles POST /classify
@@ -210,25 +933,46 @@ func (s *Server) ClassifyHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate Content-Type must be application/json
-	contentType := r.Header.Get("Content-Type")
-	if contentType != "application/json" && !strings.HasPrefix(contentType, "application/json;") {
+	if !isJSONContentType(r.Header.Get("Content-Type")) {
 		JSONError(w, "Content-Type must be application/json", http.StatusBadRequest)
 		return
 	}
 
 	// Read and decompress request body
-	bodyBytes, err := readRequestBody(r)
+	bodyBytes, err := readRequestBody(w, r, s.bodyReadTimeout)
 	if err != nil {
-		JSONError(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+		writeBodyReadError(w, err)
 		return
 	}
 
 	// Parse JSON request
 	var batchReq BatchClassifyRequest
-	if err := json.Unmarshal(bodyBytes, &batchReq); err != nil {
-		JSONError(w, fmt.Sprintf("Invalid JSON format: %v", err), http.StatusBadRequest)
+	if err := s.decodeJSON(bodyBytes, &batchReq); err != nil {
+		JSONDecodeError(w, bodyBytes, err)
+		return
+	}
+	singleLabel, err := boolQuery(r, "single_label")
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	singleLabel = singleLabel || batchReq.SingleLabel
+	includeRationale, err := boolQuery(r, "include_rationale")
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	includeRationale = includeRationale || batchReq.IncludeRationale
+	if err := validateSystemPrompt(batchReq.SystemPrompt); err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	extraCtx, err := s.applyExtraParams(s.applySystemPrompt(r.Context(), batchReq.SystemPrompt), batchReq.ExtraParams)
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r = r.WithContext(extraCtx)
 
 	// Validate request
 	if len(batchReq.Emails) == 0 {
@@ -241,39 +985,205 @@ func (s *Server) ClassifyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate each email
-	for i, email := range batchReq.Emails {
-		if strings.TrimSpace(email.ID) == "" {
-			JSONError(w, fmt.Sprintf("Email ID is required for email at index %d", i), http.StatusBadRequest)
-			return
+	// Validate each email, reporting every problem at once
+	if errs := validateBatchEmails(batchReq.Emails); len(errs) > 0 {
+		message := fmt.Sprintf("%d invalid email(s) in batch", len(errs))
+		if dupes := duplicateEmailIDs(batchReq.Emails); len(dupes) > 0 {
+			message = fmt.Sprintf("Duplicate email ID(s) in batch: %s", strings.Join(dupes, ", "))
 		}
-		if strings.TrimSpace(email.Content) == "" {
-			JSONError(w, fmt.Sprintf("Email content is required for email at index %d", i), http.StatusBadRequest)
+		JSONValidationError(w, message, errs)
+		return
+	}
+	for _, email := range batchReq.Emails {
+		if s.refuseSensitive(w, r, email.Content) {
 			return
 		}
 	}
 
+	client, err := s.clientFor(r)
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// ?async=true runs the batch as a job reported through /jobs/{id}
+	async, err := boolQuery(r, "async")
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if async {
+		s.startClassifyJob(w, r, client, batchReq.Emails, ClassifyOptions{IncludeRationale: includeRationale}, singleLabel)
+		return
+	}
+
+	// An identical batch answered moments ago is replayed, not reprocessed.
+	// Prompt requests always run so they see the prompts.
+	includePrompt := s.includePrompt(r)
+	dedupKey := batchDedupKey(r, bodyBytes)
+	if !includePrompt && s.replayBatch(w, r, dedupKey) {
+		return
+	}
+
 	// Process batch classification
-	results, err := s.client.ClassifyEmailsBatch(batchReq.Emails)
+	ctx := r.Context()
+	if includePrompt {
+		ctx, _ = withPromptCapture(ctx)
+	}
+	results, err := client.ClassifyEmailsBatch(ctx, batchReq.Emails, ClassifyOptions{IncludeRationale: includeRationale})
 	if err != nil {
 		log.Printf("Error calling Deepseek API for batch classify: %v", err)
-		JSONError(w, "Failed to classify emails", http.StatusInternalServerError)
+		writeUpstreamError(w, "Failed to classify emails", err)
 		return
 	}
 
-	// Build response with only ID and classification result
-	response := BatchClassifyResponse{
-		Results: make([]ClassificationResult, len(results)),
+	response, metadata := s.classifyResponse(r.Context(), results, singleLabel)
+	if metadata.Stale > 0 {
+		w.Header().Set("X-Cache", "STALE")
 	}
-	for i, result := range results {
-		response.Results[i] = ClassificationResult{
-			ID:     result.ID,
-			Labels: result.Labels,
-		}
+
+	// Only complete results are replayed; a resubmission retries failures
+	if !includePrompt && batchComplete(results) {
+		s.rememberBatch(r, dedupKey, response)
 	}
 
 	// Send compressed JSON response
-	if err := writeGzipJSON(w, response); err != nil {
+	if err := writeEncodedJSON(w, response); err != nil {
+		log.Printf("Error writing response: %v", err)
+		JSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// classifyResponse builds the /classify response body for results along
+// with its metadata, counting the returned labels. The body only carries the
+// metadata when caching is enabled or an email was degraded.
+func (s *Server) classifyResponse(ctx context.Context, results []BatchClassificationResult, singleLabel bool) (interface{}, *BatchMetadata) {
+	metadata := &BatchMetadata{
+		ProcessedAt:       processedAt(),
+		UpstreamLatencyMS: upstreamLatency(ctx).Milliseconds(),
+	}
+	shortMessages := 0
+	for _, result := range results {
+		if result.Cached {
+			metadata.CacheHits++
+		}
+		if result.Degraded {
+			metadata.Degraded++
+		}
+		if result.Stale {
+			metadata.Stale++
+		}
+		if result.ShortMessage {
+			shortMessages++
+		}
+	}
+	if shortMessages > 0 {
+		metadata.Warnings = append(metadata.Warnings, fmt.Sprintf("%d email(s) shorter than %d characters were labeled %s without calling the model",
+			shortMessages, s.client.ClassifyMinContentLength, shortMessageLabel))
+	}
+	reported := metadata
+	if s.client.Cache == nil && metadata.Degraded == 0 && len(metadata.Warnings) == 0 {
+		reported = nil
+	}
+
+	// Build response with only ID and classification result
+	var response interface{}
+	if singleLabel {
+		top := BatchTopLabelResponse{
+			Results:  make([]TopLabelResult, len(results)),
+			Metadata: reported,
+		}
+		for i, result := range results {
+			top.Results[i] = TopLabelResult{
+				ID:                  result.ID,
+				ClassificationLabel: bestLabel(result.Labels, s.client.ClassifyFallbackLabel),
+				Error:               result.Error,
+				Debug:               promptDebug(result.Prompt),
+			}
+			s.recordLabels(top.Results[i].ClassificationLabel)
+		}
+		response = top
+	} else {
+		batch := BatchClassifyResponse{
+			Results:  make([]ClassificationResult, len(results)),
+			Metadata: reported,
+		}
+		for i, result := range results {
+			batch.Results[i] = ClassificationResult{
+				ID:     result.ID,
+				Labels: result.Labels,
+				Error:  result.Error,
+				Debug:  promptDebug(result.Prompt),
+			}
+			s.recordLabels(result.Labels...)
+		}
+		response = batch
+	}
+	return response, metadata
+}
+
+// recordLabels counts labels returned to the client in the label metrics
+func (s *Server) recordLabels(labels ...ClassificationLabel) {
+	if s.labelMetrics == nil {
+		return
+	}
+	names := make([]string, len(labels))
+	for i, label := range labels {
+		names[i] = label.Label
+	}
+	s.labelMetrics.Record(names...)
+}
+
+// DraftRequest is the structured JSON body accepted by /draft
+type DraftRequest struct {
+	StructuredEmail
+	// Thread holds earlier messages of the conversation, oldest first
+	Thread []StructuredEmail `json:"thread"`
+	// Template is a canned reply whose {{placeholders}} are filled from the email
+	Template string `json:"template"`
+	// SystemPrompt replaces the built-in system prompt when ALLOW_PROMPT_OVERRIDE is set
+	SystemPrompt string `json:"system_prompt"`
+	// Persona names a profile from PERSONAS_FILE whose voice the draft uses
+	Persona string `json:"persona"`
+	// MessageID is the Message-ID of the email being replied to, echoed as in_reply_to
+	MessageID string `json:"message_id"`
+	// IncludeConfidence returns the model's confidence in the draft
+	IncludeConfidence bool `json:"include_confidence"`
+	// ExtraParams are passed to the provider; only REQUEST_EXTRA_PARAMS are allowed
+	ExtraParams map[string]interface{} `json:"extra_params"`
+}
+
+// Validate checks the email and bounds the template's placeholders
+func (req DraftRequest) Validate() error {
+	if err := validateSystemPrompt(req.SystemPrompt); err != nil {
+		return err
+	}
+	if err := validateThread(req.StructuredEmail, req.Thread); err != nil {
+		return err
+	}
+	if err := validateMessageID(req.MessageID); err != nil {
+		return err
+	}
+	if n := len(templatePlaceholders(req.Template)); n > maxTemplatePlaceholders {
+		return fmt.Errorf("template has %d placeholders, at most %d are allowed", n, maxTemplatePlaceholders)
+	}
+	return nil
+}
+
+// draftFromTemplate writes the template filled with values from content
+func (s *Server) draftFromTemplate(w http.ResponseWriter, r *http.Request, client LLMClient, content, template string, headers ReplyHeaders) {
+	draft, err := client.DraftFromTemplate(r.Context(), content, template)
+	if err != nil {
+		log.Printf("Error calling Deepseek API for template draft: %v", err)
+		writeUpstreamError(w, "Failed to fill reply template", err)
+		return
+	}
+	draft.ReplyHeaders = headers
+
+	setContentHashHeader(w, draft.Metadata)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(draft); err != nil {
 		log.Printf("Error writing response: %v", err)
 		JSONError(w, "Failed to encode response", http.StatusInternalServerError)
 		return
@@ -287,25 +1197,132 @@ func (s *Server) DraftHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	bodyBytes, err := readRequestBody(r)
+	bodyBytes, err := readRequestBody(w, r, s.bodyReadTimeout)
 	if err != nil {
-		JSONError(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+		writeBodyReadError(w, err)
 		return
 	}
 
 	content := string(bodyBytes)
+	kind := bodyKindText
+	if isJSONContentType(r.Header.Get("Content-Type")) {
+		kind = bodyKindJSON
+	} else if s.sniffBody {
+		kind = sniffBody(bodyBytes)
+	}
+	includeConfidence, err := boolQuery(r, "include_confidence")
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var template string
+	var thread []StructuredEmail
+	var headers ReplyHeaders
+	persona := r.URL.Query().Get("persona")
+	switch kind {
+	case bodyKindHTML:
+		content = htmlToText(content)
+	case bodyKindJSON:
+		// Structured body: {subject, from, to, date, body, template}
+		var req DraftRequest
+		if err := s.decodeJSON(bodyBytes, &req); err != nil {
+			JSONDecodeError(w, bodyBytes, err)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			JSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		content = req.Format()
+		if len(req.Thread) > 0 {
+			thread = threadMessages(req.StructuredEmail, req.Thread)
+			content = formatThread(thread, 1, len(thread))
+		}
+		template = req.Template
+		headers = req.replyHeaders()
+		if req.Persona != "" {
+			persona = req.Persona
+		}
+		includeConfidence = includeConfidence || req.IncludeConfidence
+		ctx, err := s.applyExtraParams(s.applySystemPrompt(r.Context(), req.SystemPrompt), req.ExtraParams)
+		if err != nil {
+			JSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r = r.WithContext(ctx)
+	}
+	ctx, err := s.applyPersona(r.Context(), persona)
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r = r.WithContext(ctx)
 	if strings.TrimSpace(content) == "" {
 		JSONError(w, "Email content is required", http.StatusBadRequest)
 		return
 	}
+	if s.refuseSensitive(w, r, content) {
+		return
+	}
+	if s.refuseNoReply(w, r, content) {
+		return
+	}
 
-	draft, err := s.client.DraftReply(content)
+	n, err := positiveIntQuery(r, "n")
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if n == 0 {
+		n = 1
+	}
+	if n > s.maxDraftCandidates {
+		JSONError(w, fmt.Sprintf("n must be at most %d", s.maxDraftCandidates), http.StatusBadRequest)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = DraftFormatText
+	case DraftFormatText, DraftFormatHTML, DraftFormatBoth:
+	default:
+		JSONError(w, "format must be text, html or both", http.StatusBadRequest)
+		return
+	}
+
+	client, err := s.clientFor(r)
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if thread != nil {
+		if content, err = threadContent(r.Context(), client, thread, s.maxThreadMessages); err != nil {
+			log.Printf("Error preparing thread for draft: %v", err)
+			writeThreadError(w, err)
+			return
+		}
+	}
+
+	if strings.TrimSpace(template) != "" {
+		if n > 1 || format != DraftFormatText || includeConfidence {
+			JSONError(w, "template cannot be combined with n, format or include_confidence", http.StatusBadRequest)
+			return
+		}
+		s.draftFromTemplate(w, r, client, content, template, headers)
+		return
+	}
+
+	draft, err := client.DraftReply(r.Context(), content, DraftOptions{N: n, Format: format, IncludeConfidence: includeConfidence})
 	if err != nil {
 		log.Printf("Error calling Deepseek API for draft: %v", err)
-		JSONError(w, "Failed to generate draft reply", http.StatusInternalServerError)
+		writeUpstreamError(w, "Failed to generate draft reply", err)
 		return
 	}
+	draft.ReplyHeaders = headers
 
+	setContentHashHeader(w, draft.Metadata)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(draft); err != nil {
 		log.Printf("Error writing response: %v", err)
@@ -314,34 +1331,188 @@ func (s *Server) DraftHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// SuggestRepliesHandler handles POST /suggest-replies
+func (s *Server) SuggestRepliesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bodyBytes, err := readRequestBody(w, r, s.bodyReadTimeout)
+	if err != nil {
+		writeBodyReadError(w, err)
+		return
+	}
+
+	content := string(bodyBytes)
+	if strings.TrimSpace(content) == "" {
+		JSONError(w, "Email content is required", http.StatusBadRequest)
+		return
+	}
+	if s.refuseSensitive(w, r, content) {
+		return
+	}
+
+	client, err := s.clientFor(r)
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	suggestions, err := client.SuggestReplies(r.Context(), content)
+	if err != nil {
+		log.Printf("Error calling Deepseek API for suggest-replies: %v", err)
+		writeUpstreamError(w, "Failed to suggest replies", err)
+		return
+	}
+
+	if err := writeEncodedJSON(w, suggestions); err != nil {
+		log.Printf("Error writing response: %v", err)
+		JSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// AnalyzeHandler handles POST /analyze
+func (s *Server) AnalyzeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		JSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bodyBytes, err := readRequestBody(w, r, s.bodyReadTimeout)
+	if err != nil {
+		writeBodyReadError(w, err)
+		return
+	}
+
+	content := string(bodyBytes)
+	if strings.TrimSpace(content) == "" {
+		JSONError(w, "Email content is required", http.StatusBadRequest)
+		return
+	}
+	if s.refuseSensitive(w, r, content) {
+		return
+	}
+
+	client, err := s.clientFor(r)
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	analysis, err := client.AnalyzeEmail(r.Context(), content)
+	if err != nil {
+		log.Printf("Error calling Deepseek API for analyze: %v", err)
+		writeUpstreamError(w, "Failed to analyze email", err)
+		return
+	}
+
+	s.recordLabels(analysis.Labels...)
+	setContentHashHeader(w, analysis.Metadata)
+	if err := writeEncodedJSON(w, analysis); err != nil {
+		log.Printf("Error writing response: %v", err)
+		JSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// registerAPIRoutes adds the API endpoints to router. Routes of disabled
+// operations are not registered, so they 404.
+func (s *Server) registerAPIRoutes(router *mux.Router) {
+	if s.operationEnabled(CompareSummarize) {
+		router.HandleFunc("/summarize", s.SummarizeHandler).Methods("POST")
+	}
+	if s.operationEnabled(CompareClassify) {
+		router.HandleFunc("/classify", s.ClassifyHandler).Methods("POST")
+		router.HandleFunc("/jobs/{id}", s.JobHandler).Methods("GET")
+		router.HandleFunc("/jobs/{id}/stream", s.JobStreamHandler).Methods("GET")
+		router.HandleFunc("/reclassify", s.ReclassifyHandler).Methods("POST")
+	}
+	if s.operationEnabled(CompareDraft) {
+		router.HandleFunc("/draft", s.DraftHandler).Methods("POST")
+		router.HandleFunc("/suggest-replies", s.SuggestRepliesHandler).Methods("POST")
+	}
+	if s.operationEnabled(CompareSummarize) && s.operationEnabled(CompareClassify) {
+		router.HandleFunc("/analyze", s.AnalyzeHandler).Methods("POST")
+	}
+	router.HandleFunc("/compare", s.CompareHandler).Methods("POST")
+	router.HandleFunc("/estimate", s.EstimateHandler).Methods("POST")
+}
+
 func main() {
+	configureRequestCharset()
 	server := NewServer()
 
 	router := mux.NewRouter()
 
 	// Apply middleware
+	router.Use(RequestID)
+	router.Use(UpstreamTiming)
 	router.Use(JSONRecovery)
-	router.Use(Logging)
-	router.Use(CORS)
+	if server.debug != nil {
+		router.Use(server.debug.Middleware)
+	}
+	router.Use(Logging(server.sizeMetrics))
+	router.Use(NegotiateEncoding)
+	router.Use(HeaderLimits(envInt("MAX_HEADER_COUNT", defaultMaxHeaderCount), envInt("MAX_HEADER_BYTES", defaultMaxHeaderBytes)))
+	router.Use(CORS(envList("CORS_ALLOWED_ORIGINS", nil), envBool("CORS_STRICT", false)))
+	if keys := server.apiKeys(); len(keys) > 0 {
+		var public []string
+		if envBool("METRICS_PUBLIC", false) {
+			public = append(public, "/metrics")
+		}
+		router.Use(RequireAPIKey(keys, public...))
+	}
+	if server.keyConcurrency != nil {
+		router.Use(server.keyConcurrency.Middleware)
+	}
+	if server.keyQuotas != nil {
+		router.Use(server.keyQuotas.Middleware)
+	}
+	if envBool("STRICT_QUERY_PARAMS", false) {
+		router.Use(StrictQueryParams)
+	}
+	router.Use(ForwardHeaders(envList("FORWARD_HEADERS", nil)))
+	router.Use(TemperatureOverride)
+	router.Use(SeedOverride)
+	router.Use(server.PinModel)
 
 	// Health check endpoint
-	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
-	}).Methods("GET")
+	router.HandleFunc("/health", server.HealthHandler).Methods("GET")
+	router.HandleFunc("/health/providers", server.ProviderHealthHandler).Methods("GET")
+	if envBool("METRICS_ENABLED", false) {
+		router.HandleFunc("/metrics", server.MetricsHandler).Methods("GET")
+	}
 
 	// API endpoints
-	router.HandleFunc("/summarize", server.SummarizeHandler).Methods("POST")
-	router.HandleFunc("/classify", server.ClassifyHandler).Methods("POST")
-	router.HandleFunc("/draft", server.DraftHandler).Methods("POST")
+	server.registerAPIRoutes(router)
+
+	// Admin endpoints
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.Use(server.AdminAuth)
+	admin.HandleFunc("/model", server.AdminModelHandler).Methods("GET", "POST")
+	admin.HandleFunc("/config", server.AdminConfigHandler).Methods("GET", "PATCH")
+	admin.HandleFunc("/cache/flush", server.AdminCacheFlushHandler).Methods("POST")
+	admin.HandleFunc("/taxonomy/validate", server.AdminTaxonomyValidateHandler).Methods("POST")
+	admin.HandleFunc("/debug/captures", server.AdminDebugCapturesHandler).Methods("GET")
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	log.Printf("Server starting on port %s", port)
-	if err := http.ListenAndServe(":"+port, router); err != nil {
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
 		log.Fatalf("Server failed to start: %v", err)
 	}
+	if maxConns := envNonNegativeInt("MAX_CONNECTIONS", 0); maxConns > 0 {
+		listener = LimitListener(listener, maxConns)
+		log.Printf("Limiting concurrent connections to %d", maxConns)
+	}
+
+	log.Printf("Server starting on port %s", port)
+	if err := newHTTPServer(router).Serve(listener); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
 }
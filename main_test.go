@@ -0,0 +1,252 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestClassifyMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		cache    string
+		query    string
+		metadata bool
+	}{
+		{"omitted without cache", "", "", false},
+		{"reported with cache", "true", "", true},
+		{"omitted without cache, single label", "", "?single_label=true", false},
+		{"reported with cache, single label", "true", "?single_label=true", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CACHE_ENABLED", tt.cache)
+			s := newTestServer(t, replying(`{"labels":[{"label":"urgent","score":0.9}]}`))
+			rec := httptest.NewRecorder()
+			s.ClassifyHandler(rec, postJSON("/classify"+tt.query, `{"emails":[{"id":"1","content":"The server is down again"}]}`))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			var resp struct {
+				Metadata *BatchMetadata `json:"metadata"`
+			}
+			decodeResponse(t, rec, &resp)
+			if got := resp.Metadata != nil; got != tt.metadata {
+				t.Errorf("metadata present = %v, want %v", got, tt.metadata)
+			}
+			if tt.metadata && resp.Metadata.ProcessedAt == "" {
+				t.Errorf("metadata = %+v, want processed_at", resp.Metadata)
+			}
+		})
+	}
+}
+
+func TestClassifyShortMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		emails   string
+		calls    int
+		labels   []string
+		warnings int
+	}{
+		{
+			name:   "long email",
+			emails: `[{"id":"1","content":"The server is down again and customers are waiting"}]`,
+			calls:  1,
+			labels: []string{"urgent"},
+		},
+		{
+			name:     "short email",
+			emails:   `[{"id":"1","content":"ok"}]`,
+			labels:   []string{shortMessageLabel},
+			warnings: 1,
+		},
+		{
+			name:     "mixed batch",
+			emails:   `[{"id":"1","content":"<p>thanks</p>"},{"id":"2","content":"The server is down again and customers are waiting"}]`,
+			calls:    1,
+			labels:   []string{shortMessageLabel, "urgent"},
+			warnings: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLASSIFY_MIN_CONTENT_LENGTH", "20")
+			t.Setenv("CLASSIFY_MIN_LABELS", "2")
+			t.Setenv("CLASSIFY_MAX_LABELS", "2")
+			upstream := replying(`{"labels":[{"label":"urgent","score":0.9}]}`)
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			s.ClassifyHandler(rec, postJSON("/classify?single_label=true", `{"emails":`+tt.emails+`}`))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			var resp BatchTopLabelResponse
+			decodeResponse(t, rec, &resp)
+			if got := upstream.calls(); got != tt.calls {
+				t.Errorf("upstream calls = %d, want %d", got, tt.calls)
+			}
+			if len(resp.Results) != len(tt.labels) {
+				t.Fatalf("results = %+v, want %d", resp.Results, len(tt.labels))
+			}
+			for i, want := range tt.labels {
+				if got := resp.Results[i].Label; got != want {
+					t.Errorf("result %d label = %q, want %q", i, got, want)
+				}
+			}
+			warnings := 0
+			if resp.Metadata != nil {
+				warnings = len(resp.Metadata.Warnings)
+			}
+			if warnings != tt.warnings {
+				t.Errorf("metadata = %+v, want %d warning(s)", resp.Metadata, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestUpstreamRateLimitResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		calls      int
+	}{
+		{"retry after beyond the wait limit", "7", 1},
+		{"retries exhausted", "0", 3},
+		{"no retry after", "", 1},
+	}
+	handlers := []struct {
+		name   string
+		path   string
+		handle func(*Server) http.HandlerFunc
+	}{
+		{"summarize", "/summarize", func(s *Server) http.HandlerFunc { return s.SummarizeHandler }},
+		{"draft", "/draft", func(s *Server) http.HandlerFunc { return s.DraftHandler }},
+	}
+	for _, tt := range tests {
+		for _, h := range handlers {
+			tt, h := tt, h
+			t.Run(tt.name+"/"+h.name, func(t *testing.T) {
+				t.Setenv("RETRY_AFTER_MAX", "1s")
+				upstream := &fakeUpstream{reply: func(int, *http.Request, map[string]interface{}) (*http.Response, error) {
+					resp := newResponse(http.StatusTooManyRequests, `{"error":{"message":"slow down"}}`)
+					if tt.retryAfter != "" {
+						resp.Header.Set("Retry-After", tt.retryAfter)
+					}
+					return resp, nil
+				}}
+				s := newTestServer(t, upstream)
+				rec := httptest.NewRecorder()
+				h.handle(s)(rec, postJSON(h.path, `{"body":"The launch moves to Friday because QA found a bug."}`))
+				if rec.Code != http.StatusTooManyRequests {
+					t.Fatalf("status = %d, want 429 (body %q)", rec.Code, rec.Body.String())
+				}
+				if got := rec.Header().Get("Retry-After"); got != tt.retryAfter {
+					t.Errorf("Retry-After = %q, want %q", got, tt.retryAfter)
+				}
+				if got := upstream.calls(); got != tt.calls {
+					t.Errorf("upstream calls = %d, want %d", got, tt.calls)
+				}
+			})
+		}
+	}
+}
+
+func TestCORSDisallowedOrigin(t *testing.T) {
+	allowed := []string{"https://app.example.com"}
+	tests := []struct {
+		name        string
+		strict      bool
+		method      string
+		origin      string
+		status      int
+		allowOrigin string
+		reached     bool
+	}{
+		{"allowed origin", false, http.MethodPost, "https://app.example.com", http.StatusOK, "https://app.example.com", true},
+		{"disallowed origin omits header", false, http.MethodPost, "https://evil.example.net", http.StatusOK, "", true},
+		{"strict rejects disallowed origin", true, http.MethodPost, "https://evil.example.net", http.StatusForbidden, "", false},
+		{"strict allows allowed origin", true, http.MethodPost, "https://app.example.com", http.StatusOK, "https://app.example.com", true},
+		{"strict preflight from disallowed origin", true, http.MethodOptions, "https://evil.example.net", http.StatusOK, "", false},
+		{"no origin", true, http.MethodPost, "", http.StatusOK, "*", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			h := CORS(allowed, tt.strict)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			}))
+			req := httptest.NewRequest(tt.method, "/classify", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.allowOrigin)
+			}
+			if got := rec.Header().Get("Vary"); got != "Origin" {
+				t.Errorf("Vary = %q, want Origin", got)
+			}
+			if reached != tt.reached {
+				t.Errorf("handler reached = %v, want %v", reached, tt.reached)
+			}
+		})
+	}
+}
+
+func TestOperationFlags(t *testing.T) {
+	requests := map[string]string{
+		"/summarize":  `{"body":"The launch moves to Friday."}`,
+		"/classify":   `{"emails":[{"id":"1","content":"The server is down again"}]}`,
+		"/reclassify": `{"content":"The server is down again","labels":["urgent"]}`,
+		"/draft":      `{"body":"Can we meet on Friday?"}`,
+		"/analyze":    `{"content":"The server is down again"}`,
+	}
+	tests := []struct {
+		name     string
+		disabled string
+		notFound []string
+	}{
+		{"all enabled by default", "", nil},
+		{"draft disabled", "ENABLE_DRAFT", []string{"/draft"}},
+		{"classify disabled", "ENABLE_CLASSIFY", []string{"/classify", "/reclassify", "/analyze"}},
+		{"summarize disabled", "ENABLE_SUMMARIZE", []string{"/summarize", "/analyze"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.disabled != "" {
+				t.Setenv(tt.disabled, "false")
+			}
+			s := newTestServer(t, replying(`{"labels":[{"label":"urgent","score":0.9}],"summary":"Outage."}`))
+			router := mux.NewRouter()
+			s.registerAPIRoutes(router)
+			for path, body := range requests {
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, postJSON(path, body))
+				want := http.StatusOK
+				if containsString(tt.notFound, path) {
+					want = http.StatusNotFound
+				}
+				if rec.Code != want {
+					t.Errorf("POST %s status = %d, want %d (body %q)", path, rec.Code, want, rec.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCompareDisabledOperation(t *testing.T) {
+	t.Setenv("ENABLE_DRAFT", "false")
+	s := newTestServer(t, replying("Friday works."))
+	rec := httptest.NewRecorder()
+	s.CompareHandler(rec, postJSON("/compare", `{"content":"Can we meet on Friday?","models":["deepseek-chat","deepseek-reasoner"],"operation":"draft"}`))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", rec.Code)
+	}
+}
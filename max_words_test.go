@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTruncateWords(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		maxWords int
+		want     string
+	}{
+		{"under the cap", "Launch moves to Friday.", 10, "Launch moves to Friday."},
+		{"exactly the cap", "Launch moves to Friday.", 4, "Launch moves to Friday."},
+		{"over the cap", "Launch moves to Friday because QA found a bug.", 4, "Launch moves to Friday…"},
+		{"trailing space trimmed", "one two   three", 2, "one two…"},
+		{"line breaks kept", "one\ntwo\nthree", 2, "one\ntwo…"},
+		{"multibyte", "café crème brûlée", 2, "café crème…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateWords(tt.text, tt.maxWords); got != tt.want {
+				t.Errorf("truncateWords(%q, %d) = %q, want %q", tt.text, tt.maxWords, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeMaxWords(t *testing.T) {
+	const long = "The launch moves to Friday because QA found a bug in checkout."
+	tests := []struct {
+		name   string
+		query  string
+		reply  string
+		status int
+		want   string
+		prompt string
+	}{
+		{"no cap", "", long, http.StatusOK, long, ""},
+		{"short passes through", "?max_words=20", "Launch moves to Friday.", http.StatusOK, "Launch moves to Friday.", "at most 20 words"},
+		{"long is trimmed", "?max_words=5", long, http.StatusOK, "The launch moves to Friday…", "at most 5 words"},
+		{"zero", "?max_words=0", long, http.StatusBadRequest, "", ""},
+		{"negative", "?max_words=-3", long, http.StatusBadRequest, "", ""},
+		{"not a number", "?max_words=ten", long, http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying(tt.reply)
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			s.SummarizeHandler(rec, postJSON("/summarize"+tt.query, `{"body":"`+long+`"}`))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				if upstream.calls() != 0 {
+					t.Errorf("rejected request called upstream")
+				}
+				return
+			}
+			var resp SummaryResponse
+			decodeResponse(t, rec, &resp)
+			if resp.Summary != tt.want {
+				t.Errorf("summary = %q, want %q", resp.Summary, tt.want)
+			}
+			messages := upstream.messages(0)
+			if tt.prompt != "" && !strings.Contains(messages, tt.prompt) {
+				t.Errorf("prompt %q does not contain %q", messages, tt.prompt)
+			}
+			if tt.prompt == "" && strings.Contains(messages, "words.") {
+				t.Errorf("prompt %q asks for a word cap without max_words", messages)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFinishReasonMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		reason  string
+		warning bool
+	}{
+		{"stop", "stop", false},
+		{"length", finishReasonLength, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := func() (*http.Response, error) {
+				return chatReplyWithReason(tt.reason, "The launch moves to Friday."), nil
+			}
+			c := newTestClient(t, scripted(reply))
+			summary, err := c.SummarizeEmail(context.Background(), "The launch moves to Friday because QA found a bug.", SummarizeOptions{})
+			if err != nil {
+				t.Fatalf("SummarizeEmail: %v", err)
+			}
+			draft, err := c.DraftReply(context.Background(), "Can we meet on Friday?", DraftOptions{N: 1})
+			if err != nil {
+				t.Fatalf("DraftReply: %v", err)
+			}
+			for op, meta := range map[string]*ResponseMetadata{"summary": summary.Metadata, "draft": draft.Metadata} {
+				if meta == nil {
+					t.Fatalf("%s metadata missing", op)
+				}
+				if meta.FinishReason != tt.reason {
+					t.Errorf("%s finish_reason = %q, want %q", op, meta.FinishReason, tt.reason)
+				}
+				got := false
+				for _, w := range meta.Warnings {
+					got = got || strings.Contains(w, "length limit")
+				}
+				if got != tt.warning {
+					t.Errorf("%s length warning = %v, want %v (warnings %q)", op, got, tt.warning, meta.Warnings)
+				}
+			}
+		})
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	const email = "The launch moves to Friday because QA found a bug."
+	// SHA-256 of email, computed independently with sha256sum
+	const want = "4e45563bdcb6aac1dcf7f193e0b41a88fb6ec83ce88541a3d42338e6acad8921"
+	tests := []struct {
+		name    string
+		enabled string
+		want    string
+	}{
+		{"disabled", "", ""},
+		{"enabled", "true", want},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("INCLUDE_CONTENT_HASH", tt.enabled)
+			s := newTestServer(t, replying("The launch moves to Friday."))
+			rec := httptest.NewRecorder()
+			s.SummarizeHandler(rec, httptest.NewRequest(http.MethodPost, "/summarize", strings.NewReader(email)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			var resp SummaryResponse
+			decodeResponse(t, rec, &resp)
+			if resp.Metadata.ContentHash != tt.want {
+				t.Errorf("content_hash = %q, want %q", resp.Metadata.ContentHash, tt.want)
+			}
+			if got := rec.Header().Get("X-Content-Hash"); got != tt.want {
+				t.Errorf("X-Content-Hash = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// CacheMetrics counts cache lookups and evictions; safe for concurrent use
+type CacheMetrics struct {
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+	errors    atomic.Int64
+}
+
+// CacheMetricsSnapshot is a point-in-time copy of CacheMetrics
+type CacheMetricsSnapshot struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Errors    int64
+}
+
+// HitRatio returns hits / (hits + misses), or 0 before any lookups
+func (s CacheMetricsSnapshot) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// Snapshot returns the current counter values
+func (m *CacheMetrics) Snapshot() CacheMetricsSnapshot {
+	return CacheMetricsSnapshot{
+		Hits:      m.hits.Load(),
+		Misses:    m.misses.Load(),
+		Evictions: m.evictions.Load(),
+		Errors:    m.errors.Load(),
+	}
+}
+
+// RecordEvictions adds n evicted entries
+func (m *CacheMetrics) RecordEvictions(n int) {
+	m.evictions.Add(int64(n))
+}
+
+// instrumentedCache wraps a ResponseCache and records hits and misses. Backend
+// errors on Get and Set are logged, counted and swallowed so an unavailable
+// cache degrades to live upstream calls instead of failing requests.
+type instrumentedCache struct {
+	ResponseCache
+	metrics *CacheMetrics
+}
+
+// Get records a hit or a miss; errors count as misses. The lookup is abandoned
+// when ctx ends first, so a blocking backend cannot outlive the request; the
+// context error is returned so callers stop rather than call upstream.
+func (c *instrumentedCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	type result struct {
+		value []byte
+		ok    bool
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, ok, err := c.ResponseCache.Get(ctx, key)
+		done <- result{value, ok, err}
+	}()
+
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		c.metrics.misses.Add(1)
+		return nil, false, ctx.Err()
+	}
+	if res.err != nil {
+		c.metrics.errors.Add(1)
+		c.metrics.misses.Add(1)
+		log.Printf("[%s] Warning: cache get failed for %s, treating as miss: %v", requestIDFromContext(ctx), key, res.err)
+		return nil, false, nil
+	}
+	if res.ok {
+		c.metrics.hits.Add(1)
+	} else {
+		c.metrics.misses.Add(1)
+	}
+	return res.value, res.ok, nil
+}
+
+// GetStale looks up a possibly expired value when the backend keeps them.
+// Errors are counted and logged and reported as a miss.
+func (c *instrumentedCache) GetStale(ctx context.Context, key string) ([]byte, bool, error) {
+	stale, ok := c.ResponseCache.(StaleCache)
+	if !ok {
+		return nil, false, nil
+	}
+	value, found, err := stale.GetStale(ctx, key)
+	if err != nil {
+		c.metrics.errors.Add(1)
+		log.Printf("[%s] Warning: stale cache get failed for %s: %v", requestIDFromContext(ctx), key, err)
+		return nil, false, nil
+	}
+	return value, found, nil
+}
+
+// Set stores value; errors are counted and logged but not returned. Nothing is
+// stored once ctx has ended.
+func (c *instrumentedCache) Set(ctx context.Context, key string, value []byte) error {
+	if err := ctx.Err(); err != nil {
+		return nil
+	}
+	if err := c.ResponseCache.Set(ctx, key, value); err != nil {
+		c.metrics.errors.Add(1)
+		log.Printf("[%s] Warning: cache set failed for %s, continuing without caching: %v", requestIDFromContext(ctx), key, err)
+	}
+	return nil
+}
+
+// defaultLabelMetricsMaxLabels caps the distinct label names counted
+const defaultLabelMetricsMaxLabels = 50
+
+// otherLabel collects labels seen after the cardinality cap is reached
+const otherLabel = "other"
+
+// LabelMetrics counts the classification labels returned to clients, keyed by
+// label name. Once maxLabels names are tracked, new names count as "other" so
+// a model inventing labels cannot grow the metrics without bound.
+type LabelMetrics struct {
+	mu        sync.Mutex
+	maxLabels int
+	counts    map[string]int64
+}
+
+// NewLabelMetrics creates a LabelMetrics tracking at most maxLabels names
+func NewLabelMetrics(maxLabels int) *LabelMetrics {
+	return &LabelMetrics{maxLabels: maxLabels, counts: make(map[string]int64)}
+}
+
+// Record counts one assignment of each label
+func (m *LabelMetrics) Record(labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, label := range labels {
+		if _, ok := m.counts[label]; !ok && len(m.counts) >= m.maxLabels {
+			label = otherLabel
+		}
+		m.counts[label]++
+	}
+}
+
+// Snapshot returns a copy of the per-label counts
+func (m *LabelMetrics) Snapshot() map[string]int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]int64, len(m.counts))
+	for label, n := range m.counts {
+		out[label] = n
+	}
+	return out
+}
+
+// newLabelMetricsFromEnv builds label metrics from CLASSIFY_LABEL_METRICS
+// settings. It returns nil when they are disabled.
+func newLabelMetricsFromEnv() *LabelMetrics {
+	if !envBool("CLASSIFY_LABEL_METRICS", true) {
+		return nil
+	}
+	return NewLabelMetrics(envInt("CLASSIFY_LABEL_METRICS_MAX_LABELS", defaultLabelMetricsMaxLabels))
+}
+
+// prometheusLabelEscaper escapes a Prometheus label value
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeLabelMetrics writes the per-label classification counters in label order
+func writeLabelMetrics(w io.Writer, m *LabelMetrics) error {
+	counts := m.Snapshot()
+	labels := make([]string, 0, len(counts))
+	for label := range counts {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	if _, err := fmt.Fprint(w, `# HELP classification_labels_total Classification labels returned, by label name; labels past the cardinality cap count as "other".
+# TYPE classification_labels_total counter
+`); err != nil {
+		return err
+	}
+	for _, label := range labels {
+		if _, err := fmt.Fprintf(w, "classification_labels_total{label=\"%s\"} %d\n", prometheusLabelEscaper.Replace(label), counts[label]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sizeBuckets are the upper bounds, in bytes, of the body size histogram buckets
+var sizeBuckets = []int64{256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20}
+
+// sizeHistogram is a Prometheus-style histogram of body sizes in bytes
+type sizeHistogram struct {
+	mu     sync.Mutex
+	counts []int64 // per bucket, not cumulative; the last is +Inf
+	sum    int64
+	count  int64
+}
+
+func newSizeHistogram() *sizeHistogram {
+	return &sizeHistogram{counts: make([]int64, len(sizeBuckets)+1)}
+}
+
+// observe records one body of n bytes
+func (h *sizeHistogram) observe(n int64) {
+	i := sort.Search(len(sizeBuckets), func(i int) bool { return n <= sizeBuckets[i] })
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += n
+	h.count++
+}
+
+// write writes the histogram as name with cumulative buckets
+func (h *sizeHistogram) write(w io.Writer, name, help string) error {
+	h.mu.Lock()
+	counts := append([]int64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %[1]s %[2]s\n# TYPE %[1]s histogram\n", name, help); err != nil {
+		return err
+	}
+	var cumulative int64
+	for i, bound := range sizeBuckets {
+		cumulative += counts[i]
+		if _, err := fmt.Fprintf(w, "%s_bucket{le=\"%d\"} %d\n", name, bound, cumulative); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%[1]s_bucket{le=\"+Inf\"} %[2]d\n%[1]s_sum %[3]d\n%[1]s_count %[2]d\n", name, count, sum)
+	return err
+}
+
+// SizeMetrics records request and response body sizes for capacity planning
+type SizeMetrics struct {
+	requests     *sizeHistogram
+	responses    *sizeHistogram
+	uncompressed *sizeHistogram
+}
+
+// NewSizeMetrics creates empty size histograms
+func NewSizeMetrics() *SizeMetrics {
+	return &SizeMetrics{
+		requests:     newSizeHistogram(),
+		responses:    newSizeHistogram(),
+		uncompressed: newSizeHistogram(),
+	}
+}
+
+// Record adds one request's body size and its response size on the wire
+// and before compression
+func (m *SizeMetrics) Record(requestBytes, responseBytes, uncompressedBytes int64) {
+	m.requests.observe(requestBytes)
+	m.responses.observe(responseBytes)
+	m.uncompressed.observe(uncompressedBytes)
+}
+
+// writeSizeMetrics writes the body size histograms
+func writeSizeMetrics(w io.Writer, m *SizeMetrics) error {
+	if err := m.requests.write(w, "http_request_size_bytes", "Request body sizes in bytes."); err != nil {
+		return err
+	}
+	if err := m.responses.write(w, "http_response_size_bytes", "Response body sizes in bytes, as sent (after gzip)."); err != nil {
+		return err
+	}
+	return m.uncompressed.write(w, "http_response_uncompressed_size_bytes", "Response body sizes in bytes before gzip.")
+}
+
+// MetricsHandler handles GET /metrics in the Prometheus text format
+func (s *Server) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	snap := s.cacheMetrics.Snapshot()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, err := fmt.Fprintf(w, `# HELP cache_hits_total Cache lookups that found a valid entry.
+# TYPE cache_hits_total counter
+cache_hits_total %d
+# HELP cache_misses_total Cache lookups that found nothing or failed.
+# TYPE cache_misses_total counter
+cache_misses_total %d
+# HELP cache_evictions_total Entries evicted for capacity or expiry.
+# TYPE cache_evictions_total counter
+cache_evictions_total %d
+# HELP cache_errors_total Cache backend errors, served as misses.
+# TYPE cache_errors_total counter
+cache_errors_total %d
+# HELP cache_hit_ratio Fraction of cache lookups that were hits.
+# TYPE cache_hit_ratio gauge
+cache_hit_ratio %g
+`, snap.Hits, snap.Misses, snap.Evictions, snap.Errors, snap.HitRatio())
+	if err == nil && s.labelMetrics != nil {
+		err = writeLabelMetrics(w, s.labelMetrics)
+	}
+	if err == nil && s.sizeMetrics != nil {
+		err = writeSizeMetrics(w, s.sizeMetrics)
+	}
+	if err == nil && s.client != nil && s.client.RateLimits != nil {
+		err = writeRateLimitMetrics(w, s.client.RateLimits)
+	}
+	if err != nil {
+		log.Printf("Error writing metrics: %v", err)
+	}
+}
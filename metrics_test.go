@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSizeHistogram(t *testing.T) {
+	h := newSizeHistogram()
+	for _, n := range []int64{0, 256, 257, 5000, 10 << 20} {
+		h.observe(n)
+	}
+	var out bytes.Buffer
+	if err := h.write(&out, "body_bytes", "Body sizes."); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# TYPE body_bytes histogram\n",
+		`body_bytes_bucket{le="256"} 2` + "\n",
+		`body_bytes_bucket{le="1024"} 3` + "\n",
+		`body_bytes_bucket{le="4096"} 3` + "\n",
+		`body_bytes_bucket{le="16384"} 4` + "\n",
+		`body_bytes_bucket{le="4194304"} 4` + "\n",
+		`body_bytes_bucket{le="+Inf"} 5` + "\n",
+		"body_bytes_sum 10491273\n",
+		"body_bytes_count 5\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("histogram output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestLoggingRecordsSizes(t *testing.T) {
+	tests := []struct {
+		name         string
+		encoding     string
+		wantLog      string
+		wantResponse string
+	}{
+		{"identity", "identity", "req_bytes=11 resp_bytes=13 resp_uncompressed_bytes=13", `http_response_size_bytes_sum 13`},
+		{"gzip", "gzip", "req_bytes=11 resp_bytes=", `http_response_uncompressed_size_bytes_sum 13`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged := captureLog(t)
+
+			sizes := NewSizeMetrics()
+			h := Logging(sizes)(NegotiateEncoding(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if _, err := io.ReadAll(r.Body); err != nil {
+					t.Errorf("read body: %v", err)
+				}
+				if err := writeEncodedJSON(w, map[string]string{"ok": "yes"}); err != nil {
+					t.Errorf("write: %v", err)
+				}
+			})))
+			req := postJSON("/summarize", `{"a":"bcd"}`)
+			req.Header.Set("Accept-Encoding", tt.encoding)
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if !strings.Contains(logged.String(), tt.wantLog) {
+				t.Errorf("log = %q, want %q", logged.String(), tt.wantLog)
+			}
+			var out bytes.Buffer
+			if err := writeSizeMetrics(&out, sizes); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range []string{"http_request_size_bytes_sum 11\n", "http_request_size_bytes_count 1\n", tt.wantResponse + "\n"} {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("metrics missing %q:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestLabelMetricsByEndpoint(t *testing.T) {
+	const labels = `{"labels":[{"label":"urgent","score":0.9}]}`
+	tests := []struct {
+		name    string
+		reply   string
+		handler func(s *Server) http.HandlerFunc
+		target  string
+		body    string
+		want    map[string]int64
+	}{
+		{
+			name:    "classify",
+			reply:   labels,
+			handler: func(s *Server) http.HandlerFunc { return s.ClassifyHandler },
+			target:  "/classify",
+			body:    `{"emails":[{"id":"1","content":"The server is down"},{"id":"2","content":"The server is down again"}]}`,
+			want:    map[string]int64{"urgent": 2},
+		},
+		{
+			name:    "reclassify",
+			reply:   labels,
+			handler: func(s *Server) http.HandlerFunc { return s.ReclassifyHandler },
+			target:  "/reclassify",
+			body:    `{"content":"The server is down","labels":["urgent"]}`,
+			want:    map[string]int64{"urgent": 1},
+		},
+		{
+			name:    "analyze",
+			reply:   `{"summary":"The server is down.","labels":[{"label":"urgent","score":0.9}]}`,
+			handler: func(s *Server) http.HandlerFunc { return s.AnalyzeHandler },
+			target:  "/analyze",
+			body:    "The server is down",
+			want:    map[string]int64{"urgent": 1},
+		},
+		{
+			name:    "compare",
+			reply:   labels,
+			handler: func(s *Server) http.HandlerFunc { return s.CompareHandler },
+			target:  "/compare",
+			body:    `{"content":"The server is down","models":["deepseek-chat","deepseek-reasoner"],"operation":"classify"}`,
+			want:    map[string]int64{"urgent": 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, replying(tt.reply))
+			rec := httptest.NewRecorder()
+			tt.handler(s)(rec, postJSON(tt.target, tt.body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+			}
+			got := s.labelMetrics.Snapshot()
+			if len(got) != len(tt.want) {
+				t.Errorf("label counts = %v, want %v", got, tt.want)
+			}
+			for label, n := range tt.want {
+				if got[label] != n {
+					t.Errorf("label counts = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLabelMetricsCardinalityCap(t *testing.T) {
+	m := NewLabelMetrics(2)
+	m.Record("urgent", "spam", "urgent", "invented", "made_up")
+	got := m.Snapshot()
+	want := map[string]int64{"urgent": 2, "spam": 1, otherLabel: 2}
+	if len(got) != len(want) {
+		t.Fatalf("label counts = %v, want %v", got, want)
+	}
+	for label, n := range want {
+		if got[label] != n {
+			t.Errorf("label counts = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestCacheHitRatio(t *testing.T) {
+	tests := []struct {
+		name      string
+		ops       []string // "set:key" or "get:key"
+		hits      int64
+		misses    int64
+		evictions int64
+		ratio     string
+	}{
+		{"no lookups", nil, 0, 0, 0, "0"},
+		{"all misses", []string{"get:a", "get:b"}, 0, 2, 0, "0"},
+		{"hit after set", []string{"get:a", "set:a", "get:a", "get:a"}, 2, 1, 0, "0.6666666666666666"},
+		{"evicted entry misses", []string{"set:a", "set:b", "set:c", "get:a", "get:b", "get:c"}, 2, 1, 1, "0.6666666666666666"},
+		{"all hits", []string{"set:a", "get:a", "get:a", "get:a"}, 3, 0, 0, "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := &CacheMetrics{}
+			cache := &instrumentedCache{ResponseCache: NewMemoryCache(time.Hour, 2, metrics), metrics: metrics}
+			ctx := context.Background()
+			for _, op := range tt.ops {
+				kind, key, _ := strings.Cut(op, ":")
+				if kind == "set" {
+					cache.Set(ctx, key, []byte("{}"))
+				} else {
+					cache.Get(ctx, key)
+				}
+			}
+
+			snap := metrics.Snapshot()
+			if snap.Hits != tt.hits || snap.Misses != tt.misses || snap.Evictions != tt.evictions {
+				t.Errorf("snapshot = %+v, want %d hits, %d misses, %d evictions", snap, tt.hits, tt.misses, tt.evictions)
+			}
+			s := &Server{cacheMetrics: metrics}
+			rec := httptest.NewRecorder()
+			s.MetricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			body := rec.Body.String()
+			for _, line := range []string{
+				fmt.Sprintf("cache_hits_total %d\n", tt.hits),
+				fmt.Sprintf("cache_misses_total %d\n", tt.misses),
+				fmt.Sprintf("cache_evictions_total %d\n", tt.evictions),
+				"cache_hit_ratio " + tt.ratio + "\n",
+			} {
+				if !strings.Contains(body, line) {
+					t.Errorf("metrics missing %q in:\n%s", line, body)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheMetricsConcurrent(t *testing.T) {
+	metrics := &CacheMetrics{}
+	cache := &instrumentedCache{ResponseCache: NewMemoryCache(time.Hour, 0, metrics), metrics: metrics}
+	ctx := context.Background()
+	cache.Set(ctx, "hit", []byte("{}"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				cache.Get(ctx, "hit")
+				cache.Get(ctx, "miss")
+			}
+		}()
+	}
+	wg.Wait()
+
+	snap := metrics.Snapshot()
+	if snap.Hits != 1000 || snap.Misses != 1000 || snap.HitRatio() != 0.5 {
+		t.Errorf("snapshot = %+v (ratio %v), want 1000 hits, 1000 misses, ratio 0.5", snap, snap.HitRatio())
+	}
+}
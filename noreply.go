@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// defaultNoReplyPatterns flag automated senders and bulk mail that should not
+// get a drafted reply, as name=regex
+var defaultNoReplyPatterns = []string{
+	`noreply_sender=(?im)^\s*(from|reply-to)\s*:.*\b(no[-_.]?reply|do[-_.]?not[-_.]?reply|mailer-daemon|postmaster|bounces?)\b`,
+	`automated_notice=(?i)\b(this is an automated (message|email|notification)|(please )?do not reply to this (e-?mail|message)|this (mailbox|address) is not monitored)\b`,
+	`unsubscribe=(?i)\b(unsubscribe|manage (your )?(email )?preferences|opt[- ]out of (these|future) emails)\b`,
+}
+
+// compileNoReplyPatterns compiles NOREPLY_PATTERNS ("||"-separated
+// name=regex entries), falling back to the defaults when unset. It returns nil
+// when DRAFT_SKIP_NOREPLY is false.
+func compileNoReplyPatterns() []SensitivePattern {
+	if !envBool("DRAFT_SKIP_NOREPLY", false) {
+		return nil
+	}
+	specs := defaultNoReplyPatterns
+	if spec := strings.TrimSpace(os.Getenv("NOREPLY_PATTERNS")); spec != "" {
+		specs = strings.Split(spec, boilerplatePatternSeparator)
+	}
+	return compileNamedPatterns("no-reply", specs)
+}
+
+// refuseNoReply writes a 422 and returns true when content looks like an
+// automated or no-reply email that should not be answered
+func (s *Server) refuseNoReply(w http.ResponseWriter, r *http.Request, content string) bool {
+	matched := detectSensitive(content, s.noReplyPatterns)
+	if len(matched) == 0 {
+		return false
+	}
+	log.Printf("[%s] Skipped drafting a reply to a no-reply email (%s)", requestIDFromContext(r.Context()), strings.Join(matched, ", "))
+	JSONError(w, fmt.Sprintf("Reply not appropriate: the email looks automated or does not accept replies (%s)", strings.Join(matched, ", ")), http.StatusUnprocessableEntity)
+	return true
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDraftSkipNoReply(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    string
+		body   string
+		status int
+		calls  int
+	}{
+		{"no-reply sender skipped", "true", `{"from":"noreply@shop.example","body":"Your order shipped."}`, http.StatusUnprocessableEntity, 0},
+		{"automated notice skipped", "true", `{"body":"This is an automated message. Please do not reply to this email."}`, http.StatusUnprocessableEntity, 0},
+		{"unsubscribe footer skipped", "true", `{"body":"Big sale this week! Click here to unsubscribe."}`, http.StatusUnprocessableEntity, 0},
+		{"person drafted", "true", `{"from":"ana@example.com","body":"Can we meet on Monday?"}`, http.StatusOK, 1},
+		{"disabled drafts anyway", "", `{"from":"noreply@shop.example","body":"Your order shipped."}`, http.StatusOK, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("DRAFT_SKIP_NOREPLY", tt.env)
+			}
+			upstream := replying("Thanks, Monday works.")
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			s.DraftHandler(rec, postJSON("/draft", tt.body))
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if upstream.calls() != tt.calls {
+				t.Errorf("upstream calls = %d, want %d", upstream.calls(), tt.calls)
+			}
+		})
+	}
+}
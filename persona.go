@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadPersonas reads draft persona profiles from a JSON file mapping each
+// persona name to the system-prompt fragment that gives drafts its voice.
+// An empty path disables personas.
+func loadPersonas(path string) (map[string]string, error) {
+	if strings.TrimSpace(path) == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PERSONAS_FILE: %w", err)
+	}
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse PERSONAS_FILE: %w", err)
+	}
+	personas := make(map[string]string, len(raw))
+	for name, fragment := range raw {
+		name, fragment = strings.TrimSpace(name), strings.TrimSpace(fragment)
+		if name == "" || fragment == "" {
+			return nil, fmt.Errorf("PERSONAS_FILE entry %q must have a name and a prompt fragment", name)
+		}
+		if len(fragment) > maxSystemPromptLength {
+			return nil, fmt.Errorf("PERSONAS_FILE entry %q is longer than %d characters", name, maxSystemPromptLength)
+		}
+		personas[name] = fragment
+	}
+	return personas, nil
+}
+
+// personaKey is the context key under which the selected persona fragment is stored
+type personaKey struct{}
+
+// withPersona returns a copy of ctx whose drafts are written with fragment
+func withPersona(ctx context.Context, fragment string) context.Context {
+	return context.WithValue(ctx, personaKey{}, fragment)
+}
+
+// personaFromContext returns the persona fragment selected for the request, if any
+func personaFromContext(ctx context.Context) (string, bool) {
+	fragment, ok := ctx.Value(personaKey{}).(string)
+	return fragment, ok && fragment != ""
+}
+
+// applyPersona stores the fragment of the named persona in ctx; an empty
+// name leaves ctx unchanged and an unknown one is an error
+func (s *Server) applyPersona(ctx context.Context, name string) (context.Context, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ctx, nil
+	}
+	fragment, ok := s.personas[name]
+	if !ok {
+		return ctx, fmt.Errorf("unknown persona %q", name)
+	}
+	return withPersona(ctx, fragment), nil
+}
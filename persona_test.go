@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writePersonas writes a PERSONAS_FILE with contents and returns its path
+func writePersonas(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "personas.json")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPersonas(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     map[string]string
+		wantErr  bool
+	}{
+		{"valid", `{" support ":" You are the Acme Support Agent. "}`, map[string]string{"support": "You are the Acme Support Agent."}, false},
+		{"malformed", `{"support":`, nil, true},
+		{"blank fragment", `{"support":" "}`, nil, true},
+		{"too long", `{"support":"` + strings.Repeat("a", maxSystemPromptLength+1) + `"}`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadPersonas(writePersonas(t, tt.contents))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadPersonas error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("personas = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got, err := loadPersonas(""); got != nil || err != nil {
+		t.Errorf("loadPersonas(\"\") = %v, %v, want nil", got, err)
+	}
+	if _, err := loadPersonas(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loadPersonas of a missing file succeeded")
+	}
+}
+
+func TestDraftPersona(t *testing.T) {
+	const fragment = "You are the Acme Support Agent: warm, brief, and always sign off as Acme Support."
+	tests := []struct {
+		name    string
+		query   string
+		body    string
+		status  int
+		persona bool
+	}{
+		{"json field", "", `{"body":"Where is my order?","persona":"support"}`, http.StatusOK, true},
+		{"query parameter", "?persona=support", `{"body":"Where is my order?"}`, http.StatusOK, true},
+		{"no persona", "", `{"body":"Where is my order?"}`, http.StatusOK, false},
+		{"unknown persona", "", `{"body":"Where is my order?","persona":"pirate"}`, http.StatusBadRequest, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PERSONAS_FILE", writePersonas(t, `{"support":"`+fragment+`"}`))
+			upstream := replying("Your order ships Friday.")
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			s.DraftHandler(rec, postJSON("/draft"+tt.query, tt.body))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				var resp ErrorResponse
+				decodeResponse(t, rec, &resp)
+				if resp.Message != `unknown persona "pirate"` {
+					t.Errorf("message = %q", resp.Message)
+				}
+				if upstream.calls() != 0 {
+					t.Errorf("upstream calls = %d, want 0", upstream.calls())
+				}
+				return
+			}
+			system := upstream.messages(0)
+			if got := strings.HasPrefix(system, fragment+" "); got != tt.persona {
+				t.Errorf("prompt starts with the persona = %v, want %v (prompt %q)", got, tt.persona, system)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// PostProcessor is one stage that transforms summary or draft text before
+// it is returned
+type PostProcessor func(string) string
+
+// PostProcessChain runs its stages in order
+type PostProcessChain []PostProcessor
+
+// Apply runs text through every stage, trimming surrounding space
+func (chain PostProcessChain) Apply(text string) string {
+	text = strings.TrimSpace(text)
+	for _, stage := range chain {
+		text = strings.TrimSpace(stage(text))
+	}
+	return text
+}
+
+// Post-processing stages POSTPROCESS may name
+const (
+	PostProcessScratchpad  = "scratchpad"
+	PostProcessCodeFence   = "code_fence"
+	PostProcessBoilerplate = "boilerplate"
+	PostProcessPlaintext   = "plaintext"
+)
+
+// postProcessNone in POSTPROCESS disables post-processing entirely
+const postProcessNone = "none"
+
+// postProcessor returns the named stage. Stages read the client's settings
+// when they run, so later changes to them apply.
+func (c *DeepseekClient) postProcessor(name string) (PostProcessor, bool) {
+	switch name {
+	case PostProcessScratchpad:
+		return func(text string) string { return stripScratchpad(text, c.ScratchpadDelimiter) }, true
+	case PostProcessCodeFence:
+		return stripCodeFence, true
+	case PostProcessBoilerplate:
+		return func(text string) string { return stripBoilerplate(text, c.BoilerplatePatterns) }, true
+	case PostProcessPlaintext:
+		return toPlainText, true
+	}
+	return nil, false
+}
+
+// parsePostProcessors builds the chain named by a comma-separated POSTPROCESS
+// value. Unset returns nil, keeping each operation's default chain; "none"
+// returns an empty chain. Unknown stages are skipped.
+func (c *DeepseekClient) parsePostProcessors(spec string) PostProcessChain {
+	if strings.TrimSpace(spec) == "" {
+		return nil
+	}
+	chain := PostProcessChain{}
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || name == postProcessNone {
+			continue
+		}
+		stage, ok := c.postProcessor(name)
+		if !ok {
+			log.Printf("Ignoring unknown POSTPROCESS stage %q", name)
+			continue
+		}
+		chain = append(chain, stage)
+	}
+	return chain
+}
+
+// newPostProcessorsFromEnv reads POSTPROCESS for c
+func (c *DeepseekClient) newPostProcessorsFromEnv() PostProcessChain {
+	return c.parsePostProcessors(os.Getenv("POSTPROCESS"))
+}
+
+// chainOf builds a chain from stage names known to exist
+func (c *DeepseekClient) chainOf(names ...string) PostProcessChain {
+	chain := make(PostProcessChain, 0, len(names))
+	for _, name := range names {
+		stage, _ := c.postProcessor(name)
+		chain = append(chain, stage)
+	}
+	return chain
+}
+
+// summaryPostProcessors returns the configured chain, or by default
+// scratchpad removal, boilerplate stripping and, with SummarizePlaintext,
+// plain text conversion
+func (c *DeepseekClient) summaryPostProcessors() PostProcessChain {
+	if c.PostProcessors != nil {
+		return c.PostProcessors
+	}
+	if c.SummarizePlaintext {
+		return c.chainOf(PostProcessScratchpad, PostProcessBoilerplate, PostProcessPlaintext)
+	}
+	return c.chainOf(PostProcessScratchpad, PostProcessBoilerplate)
+}
+
+// draftPostProcessors returns the configured chain, or by default scratchpad
+// removal, code fence removal and boilerplate stripping
+func (c *DeepseekClient) draftPostProcessors() PostProcessChain {
+	if c.PostProcessors != nil {
+		return c.PostProcessors
+	}
+	return c.chainOf(PostProcessScratchpad, PostProcessCodeFence, PostProcessBoilerplate)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPostProcessChainOrder(t *testing.T) {
+	appendTo := func(suffix string) PostProcessor {
+		return func(text string) string { return text + suffix }
+	}
+	tests := []struct {
+		name  string
+		chain PostProcessChain
+		want  string
+	}{
+		{"empty", PostProcessChain{}, "draft"},
+		{"a then b", PostProcessChain{appendTo(" a"), appendTo(" b")}, "draft a b"},
+		{"b then a", PostProcessChain{appendTo(" b"), appendTo(" a")}, "draft b a"},
+		{"trims between stages", PostProcessChain{appendTo("  "), appendTo("!")}, "draft!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.chain.Apply("  draft "); got != tt.want {
+				t.Errorf("Apply = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePostProcessors(t *testing.T) {
+	c := &DeepseekClient{}
+	tests := []struct {
+		spec  string
+		unset bool
+		len   int
+	}{
+		{"", true, 0},
+		{"none", false, 0},
+		{"code_fence, PLAINTEXT", false, 2},
+		{"scratchpad,unknown,boilerplate", false, 2},
+	}
+	for _, tt := range tests {
+		got := c.parsePostProcessors(tt.spec)
+		if (got == nil) != tt.unset || len(got) != tt.len {
+			t.Errorf("parsePostProcessors(%q) = %d stages (nil %v), want %d (nil %v)", tt.spec, len(got), got == nil, tt.len, tt.unset)
+		}
+	}
+}
+
+func TestSummaryPostProcessEnv(t *testing.T) {
+	const reply = "```\n**Ship** on *Friday*\n```"
+	tests := []struct {
+		name string
+		env  string
+		want string
+	}{
+		{"none keeps the raw reply", "none", reply},
+		{"code fence only", "code_fence", "**Ship** on *Friday*"},
+		{"code fence then plaintext", "code_fence,plaintext", "Ship on Friday"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("POSTPROCESS", tt.env)
+			c := newTestClient(t, replying(reply))
+			out, err := c.SummarizeEmail(context.Background(), "The launch moves to Friday.", SummarizeOptions{})
+			if err != nil {
+				t.Fatalf("SummarizeEmail: %v", err)
+			}
+			if out.Summary != tt.want {
+				t.Errorf("summary = %q, want %q", out.Summary, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// maxSystemPromptLength bounds a caller-supplied system prompt
+const maxSystemPromptLength = 4000
+
+// systemPromptKey is the context key under which a caller's system prompt is stored
+type systemPromptKey struct{}
+
+// withSystemPrompt returns a copy of ctx whose model calls use prompt in
+// place of the built-in system prompt
+func withSystemPrompt(ctx context.Context, prompt string) context.Context {
+	return context.WithValue(ctx, systemPromptKey{}, prompt)
+}
+
+// systemPromptFromContext returns the caller's system prompt, if any
+func systemPromptFromContext(ctx context.Context) (string, bool) {
+	prompt, ok := ctx.Value(systemPromptKey{}).(string)
+	return prompt, ok && prompt != ""
+}
+
+// systemPrompt returns builtin, or the caller's override followed by
+// formatInstruction so the response can still be parsed
+func systemPrompt(ctx context.Context, builtin, formatInstruction string) string {
+	override, ok := systemPromptFromContext(ctx)
+	if !ok {
+		return builtin
+	}
+	if formatInstruction == "" {
+		return override
+	}
+	return override + " " + formatInstruction
+}
+
+// validateSystemPrompt checks a caller-supplied system prompt
+func validateSystemPrompt(prompt string) error {
+	if len(prompt) > maxSystemPromptLength {
+		return fmt.Errorf("system_prompt must be at most %d characters", maxSystemPromptLength)
+	}
+	return nil
+}
+
+// applySystemPrompt stores prompt in ctx when overrides are allowed; the
+// field is ignored otherwise
+func (s *Server) applySystemPrompt(ctx context.Context, prompt string) context.Context {
+	if !s.allowPromptOverride || strings.TrimSpace(prompt) == "" {
+		return ctx
+	}
+	return withSystemPrompt(ctx, strings.TrimSpace(prompt))
+}
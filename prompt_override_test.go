@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// systemMessage returns the content of the first message of the i-th request
+func systemMessage(f *fakeUpstream, i int) string {
+	msgs, _ := f.body(i)["messages"].([]interface{})
+	if len(msgs) == 0 {
+		return ""
+	}
+	first, _ := msgs[0].(map[string]interface{})
+	content, _ := first["content"].(string)
+	return content
+}
+
+func TestSummarizeSystemPromptOverride(t *testing.T) {
+	const override = "You are a pirate. Summarize in pirate speak."
+	body := `{"subject":"Launch","body":"The launch moves to Friday.","system_prompt":"` + override + `"}`
+	tests := []struct {
+		name    string
+		env     string
+		applied bool
+	}{
+		{"default ignores the field", "", false},
+		{"enabled replaces the system prompt", "true", true},
+		{"disabled ignores the field", "false", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("ALLOW_PROMPT_OVERRIDE", tt.env)
+			}
+			upstream := replying("Arr, the launch be Friday.")
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			s.SummarizeHandler(rec, postJSON("/summarize", body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			if got := strings.HasPrefix(systemMessage(upstream, 0), override); got != tt.applied {
+				t.Errorf("system prompt = %q, override applied = %v, want %v", systemMessage(upstream, 0), got, tt.applied)
+			}
+		})
+	}
+}
+
+func TestSummarizeSystemPromptTooLong(t *testing.T) {
+	t.Setenv("ALLOW_PROMPT_OVERRIDE", "true")
+	s := newTestServer(t, replying("unused"))
+	body := `{"body":"Hello","system_prompt":"` + strings.Repeat("x", maxSystemPromptLength+1) + `"}`
+	rec := httptest.NewRecorder()
+	s.SummarizeHandler(rec, postJSON("/summarize", body))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Provider names accepted by the X-LLM-Provider header
+const (
+	ProviderDeepseek = "deepseek"
+	ProviderOpenAI   = "openai"
+)
+
+// providerHeader selects the provider for a single request
+const providerHeader = "X-LLM-Provider"
+
+// defaultOpenAIModel is the chat model used when OPENAI_MODEL is not set
+const defaultOpenAIModel = "gpt-4o-mini"
+
+// LLMClient is implemented by every chat provider the server can route to
+type LLMClient interface {
+	SummarizeEmail(ctx context.Context, content string, opts SummarizeOptions) (*SummaryResponse, error)
+	SummarizeChunk(ctx context.Context, chunk string) (string, error)
+	CombineSummaries(ctx context.Context, partials []string, opts SummarizeOptions) (*SummaryResponse, error)
+	ClassifyEmailsBatch(ctx context.Context, emails []EmailRequest, opts ClassifyOptions) ([]BatchClassificationResult, error)
+	ReclassifyEmail(ctx context.Context, content string, labels []string, opts ClassifyOptions) (*ClassifyResponse, error)
+	DraftReply(ctx context.Context, content string, opts DraftOptions) (*DraftResponse, error)
+	DraftFromTemplate(ctx context.Context, content, template string) (*TemplateDraftResponse, error)
+	SuggestReplies(ctx context.Context, content string) (*SuggestionsResponse, error)
+	AnalyzeEmail(ctx context.Context, content string) (*AnalyzeResponse, error)
+	Model() string
+	AllowsModel(model string) bool
+	ContentTokenBudget(ctx context.Context) int
+	EstimateUsage(ctx context.Context, operation, content string) UsageEstimate
+	Ping(ctx context.Context) error
+}
+
+// NewOpenAIClient creates a client for the OpenAI chat completions API. OpenAI
+// speaks the same wire format as DeepSeek, so it reuses DeepseekClient with
+// OpenAI credentials and model.
+func NewOpenAIClient(baseURL, apiKey string) *DeepseekClient {
+	c := NewDeepseekClient(baseURL, apiKey)
+	model := envString("OPENAI_MODEL", defaultOpenAIModel)
+	c.AllowedModels = envList("OPENAI_ALLOWED_MODELS", []string{model})
+	if !containsString(c.AllowedModels, model) {
+		c.AllowedModels = append(c.AllowedModels, model)
+	}
+	c.model.Store(&model)
+	c.FallbackLargeModel = envString("OPENAI_FALLBACK_LARGE_MODEL", "")
+	return c
+}
+
+// newProvidersFromEnv builds the provider registry. DeepSeek is always present;
+// OpenAI is added when OPENAI_API_KEY is set.
+func newProvidersFromEnv(deepseek *DeepseekClient) map[string]LLMClient {
+	providers := map[string]LLMClient{ProviderDeepseek: deepseek}
+	apiKey, err := loadSecret("OPENAI_API_KEY")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if apiKey != "" {
+		baseURL := envString("OPENAI_API_URL", "https://api.openai.com")
+		log.Printf("OpenAI provider configured (%s)", baseURL)
+		openai := NewOpenAIClient(baseURL, apiKey)
+		// Share one cache so admin flushes cover every provider; keys include the model
+		openai.Cache = deepseek.Cache
+		providers[ProviderOpenAI] = openai
+	}
+	return providers
+}
+
+// providerNames returns the configured provider names in sorted order
+func (s *Server) providerNames() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// clientFor returns the provider selected by the X-LLM-Provider header, or the
+// default provider when the header is absent
+func (s *Server) clientFor(r *http.Request) (LLMClient, error) {
+	name := strings.ToLower(strings.TrimSpace(r.Header.Get(providerHeader)))
+	if name == "" {
+		name = s.defaultProvider
+	}
+	client, ok := s.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q (configured: %s)", name, strings.Join(s.providerNames(), ", "))
+	}
+	return client, nil
+}
+
+// PinModel middleware pins the active model of the request's provider in the
+// context, so every model call the request makes (retries, batch items,
+// follow-up calls, async jobs) uses the model it started with even when
+// POST /admin/model switches it midway. An unknown provider is left to the
+// handler to report.
+func (s *Server) PinModel(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if client, err := s.clientFor(r); err == nil {
+			r = r.WithContext(withModel(r.Context(), client.Model()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProviderHeader(t *testing.T) {
+	tests := []struct {
+		name            string
+		defaultProvider string
+		header          string
+		status          int
+		want            string // provider expected to be called
+	}{
+		{"default", "", "", http.StatusOK, ProviderDeepseek},
+		{"deepseek", "", "deepseek", http.StatusOK, ProviderDeepseek},
+		{"openai", "", "openai", http.StatusOK, ProviderOpenAI},
+		{"case and space", "", " OpenAI ", http.StatusOK, ProviderOpenAI},
+		{"configured default", ProviderOpenAI, "", http.StatusOK, ProviderOpenAI},
+		{"header overrides default", ProviderOpenAI, "deepseek", http.StatusOK, ProviderDeepseek},
+		{"unknown", "", "anthropic", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OPENAI_API_KEY", "openai-key")
+			if tt.defaultProvider != "" {
+				t.Setenv("LLM_PROVIDER", tt.defaultProvider)
+			}
+			upstreams := map[string]*fakeUpstream{
+				ProviderDeepseek: replying("Summary from DeepSeek."),
+				ProviderOpenAI:   replying("Summary from OpenAI."),
+			}
+			s := newTestServer(t, upstreams[ProviderDeepseek])
+			s.providers[ProviderOpenAI].(*DeepseekClient).HTTPClient = upstreams[ProviderOpenAI]
+
+			req := postJSON("/summarize", `{"body":"The launch moves to Friday because QA found a bug."}`)
+			if tt.header != "" {
+				req.Header.Set(providerHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			s.SummarizeHandler(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			for name, upstream := range upstreams {
+				want := 0
+				if name == tt.want {
+					want = 1
+				}
+				if got := upstream.calls(); got != want {
+					t.Errorf("%s calls = %d, want %d", name, got, want)
+				}
+			}
+			if tt.want == ProviderOpenAI {
+				req := upstreams[ProviderOpenAI].requests[0]
+				if got := req.Header.Get("Authorization"); got != "Bearer openai-key" {
+					t.Errorf("openai Authorization = %q, want the OpenAI key", got)
+				}
+				if got := upstreams[ProviderOpenAI].body(0)["model"]; got != defaultOpenAIModel {
+					t.Errorf("openai model = %v, want %q", got, defaultOpenAIModel)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenAIClassifyBatch(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "openai-key")
+	t.Setenv("SERVER_MAX_RETRIES", "0")
+	t.Setenv("CLASSIFY_JSON_REPAIR", "false")
+	t.Setenv("BATCH_DEDUP_ENABLED", "false")
+	openai := &fakeUpstream{reply: func(_ int, _ *http.Request, body map[string]interface{}) (*http.Response, error) {
+		msgs := body["messages"].([]interface{})
+		content := msgs[len(msgs)-1].(map[string]interface{})["content"].(string)
+		switch {
+		case strings.Contains(content, "Invoice"):
+			return chatReply(`{"labels":[{"label":"finance","score":0.9}]}`), nil
+		case strings.Contains(content, "Lunch"):
+			return chatReply(`{"labels":[{"label":"personal","score":0.8}]}`), nil
+		case strings.Contains(content, "Outage"):
+			return newResponse(http.StatusInternalServerError, `{"error":"boom"}`), nil
+		default:
+			return chatReply("I cannot classify this."), nil
+		}
+	}}
+	deepseek := replying(`{"labels":[{"label":"urgent","score":0.9}]}`)
+	s := newTestServer(t, deepseek)
+	s.providers[ProviderOpenAI].(*DeepseekClient).HTTPClient = openai
+
+	req := postJSON("/classify", `{"emails":[
+		{"id":"a","content":"Invoice 42 is attached"},
+		{"id":"b","content":"Outage in the EU region"},
+		{"id":"c","content":"Lunch on Thursday?"},
+		{"id":"d","content":"Hmm"}]}`)
+	req.Header.Set(providerHeader, ProviderOpenAI)
+	rec := httptest.NewRecorder()
+	s.ClassifyHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+	}
+	var resp BatchClassifyResponse
+	decodeResponse(t, rec, &resp)
+
+	want := []struct {
+		id, label, err string
+	}{
+		{"a", "finance", ""},
+		{"b", "", "classification failed"},
+		{"c", "personal", ""},
+		{"d", "", "model returned an unparseable classification"},
+	}
+	if len(resp.Results) != len(want) {
+		t.Fatalf("results = %+v, want %d", resp.Results, len(want))
+	}
+	for i, w := range want {
+		got := resp.Results[i]
+		label := ""
+		if len(got.Labels) > 0 {
+			label = got.Labels[0].Label
+		}
+		if got.ID != w.id || label != w.label || got.Error != w.err {
+			t.Errorf("result %d = %+v, want id %q label %q error %q", i, got, w.id, w.label, w.err)
+		}
+	}
+	if deepseek.calls() != 0 {
+		t.Errorf("deepseek calls = %d, want 0", deepseek.calls())
+	}
+	if got := openai.requests[0].Header.Get("Authorization"); got != "Bearer openai-key" {
+		t.Errorf("openai Authorization = %q, want the OpenAI key", got)
+	}
+	if got := openai.body(0)["model"]; got != defaultOpenAIModel {
+		t.Errorf("openai model = %v, want %s", got, defaultOpenAIModel)
+	}
+}
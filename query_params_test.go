@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStrictQueryParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		status  int
+		message string
+	}{
+		{"no params", "/summarize", http.StatusOK, ""},
+		{"known params", "/summarize?max_words=20&split_history=true", http.StatusOK, ""},
+		{"global params", "/draft?temperature=0.5&seed=1&n=2", http.StatusOK, ""},
+		{"typo", "/summarize?max_word=20", http.StatusBadRequest, `Unexpected query parameter(s): "max_word"`},
+		{"another endpoint's param", "/classify?max_words=20&single_label=true", http.StatusBadRequest, `Unexpected query parameter(s): "max_words"`},
+		{"several listed in order", "/draft?zeta=1&alpha=2&n=2", http.StatusBadRequest, `Unexpected query parameter(s): "alpha", "zeta"`},
+		{"unlisted path accepts none", "/health?verbose=1", http.StatusBadRequest, `Unexpected query parameter(s): "verbose"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			handler := StrictQueryParams(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true }))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.target, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if reached != (tt.status == http.StatusOK) {
+				t.Errorf("handler reached = %v", reached)
+			}
+			if tt.message != "" {
+				var resp ErrorResponse
+				decodeResponse(t, rec, &resp)
+				if resp.Message != tt.message {
+					t.Errorf("message = %q, want %q", resp.Message, tt.message)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryParamsLenientByDefault(t *testing.T) {
+	upstream := replying("Launch moves to Friday.")
+	s := newTestServer(t, upstream)
+	rec := httptest.NewRecorder()
+	s.SummarizeHandler(rec, postJSON("/summarize?max_word=20", `{"body":"The launch moves to Friday because QA found a bug."}`))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want the unexpected parameter ignored (body %q)", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrCodeQuotaExceeded marks a 402 response for an API key past its monthly quota
+const ErrCodeQuotaExceeded = "quota_exceeded"
+
+// KeyQuota is the monthly allowance of one API key; a zero field is unlimited
+type KeyQuota struct {
+	Requests int64
+	Tokens   int64
+}
+
+// parseKeyQuotas parses comma-separated key=requests[:tokens] pairs
+func parseKeyQuotas(spec string) map[string]KeyQuota {
+	quotas := make(map[string]KeyQuota)
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		key, limits, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		requests, tokens, hasTokens := strings.Cut(limits, ":")
+		var quota KeyQuota
+		var err, tokenErr error
+		quota.Requests, err = strconv.ParseInt(strings.TrimSpace(requests), 10, 64)
+		if hasTokens {
+			quota.Tokens, tokenErr = strconv.ParseInt(strings.TrimSpace(tokens), 10, 64)
+		}
+		if !ok || key == "" || err != nil || tokenErr != nil || quota.Requests < 0 || quota.Tokens < 0 {
+			// Do not log the pair itself: it holds an API key
+			log.Printf("Ignoring malformed API_KEY_QUOTAS entry")
+			continue
+		}
+		quotas[key] = quota
+	}
+	return quotas
+}
+
+// QuotaUsage is what a key has used in one quota period
+type QuotaUsage struct {
+	Requests int64
+	Tokens   int64
+}
+
+// QuotaStore keeps per-key usage counts for each quota period
+type QuotaStore interface {
+	// Usage returns the usage of key in period
+	Usage(ctx context.Context, key, period string) (QuotaUsage, error)
+	// Add adds to the usage of key in period and returns the new total
+	Add(ctx context.Context, key, period string, delta QuotaUsage) (QuotaUsage, error)
+}
+
+// MemoryQuotaStore is an in-process QuotaStore. Counts of past periods are
+// dropped when a key is first used in a new period.
+type MemoryQuotaStore struct {
+	mu     sync.Mutex
+	usage  map[string]QuotaUsage
+	period map[string]string
+}
+
+// NewMemoryQuotaStore creates an empty MemoryQuotaStore
+func NewMemoryQuotaStore() *MemoryQuotaStore {
+	return &MemoryQuotaStore{usage: make(map[string]QuotaUsage), period: make(map[string]string)}
+}
+
+// Usage returns the usage of key in period
+func (m *MemoryQuotaStore) Usage(ctx context.Context, key, period string) (QuotaUsage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.period[key] != period {
+		return QuotaUsage{}, nil
+	}
+	return m.usage[key], nil
+}
+
+// Add adds delta to the usage of key in period
+func (m *MemoryQuotaStore) Add(ctx context.Context, key, period string, delta QuotaUsage) (QuotaUsage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usage := m.usage[key]
+	if m.period[key] != period {
+		usage = QuotaUsage{}
+		m.period[key] = period
+	}
+	usage.Requests += delta.Requests
+	usage.Tokens += delta.Tokens
+	m.usage[key] = usage
+	return usage, nil
+}
+
+// FileQuotaStore is a QuotaStore that keeps its counts in memory and writes
+// them to a JSON file after every change, so usage survives restarts. Keys
+// are stored as SHA-256 hashes rather than in plain text.
+type FileQuotaStore struct {
+	mu   sync.Mutex
+	path string
+	mem  *MemoryQuotaStore
+}
+
+// quotaFileEntry is the usage of one key in the quota store file
+type quotaFileEntry struct {
+	Period   string `json:"period"`
+	Requests int64  `json:"requests"`
+	Tokens   int64  `json:"tokens"`
+}
+
+// NewFileQuotaStore creates a FileQuotaStore at path, loading the counts it
+// already holds; a missing file starts empty
+func NewFileQuotaStore(path string) (*FileQuotaStore, error) {
+	f := &FileQuotaStore{path: path, mem: NewMemoryQuotaStore()}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read QUOTA_STORE_FILE: %w", err)
+	}
+	var entries map[string]quotaFileEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse QUOTA_STORE_FILE: %w", err)
+	}
+	for hash, entry := range entries {
+		f.mem.usage[hash] = QuotaUsage{Requests: entry.Requests, Tokens: entry.Tokens}
+		f.mem.period[hash] = entry.Period
+	}
+	return f, nil
+}
+
+// Usage returns the usage of key in period
+func (f *FileQuotaStore) Usage(ctx context.Context, key, period string) (QuotaUsage, error) {
+	return f.mem.Usage(ctx, contentHash(key), period)
+}
+
+// Add adds delta to the usage of key in period and saves the file. The new
+// total is returned even when saving fails.
+func (f *FileQuotaStore) Add(ctx context.Context, key, period string, delta QuotaUsage) (QuotaUsage, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	usage, _ := f.mem.Add(ctx, contentHash(key), period, delta)
+	return usage, f.save()
+}
+
+// save writes every count to the file, replacing it atomically
+func (f *FileQuotaStore) save() error {
+	f.mem.mu.Lock()
+	entries := make(map[string]quotaFileEntry, len(f.mem.usage))
+	for hash, usage := range f.mem.usage {
+		entries[hash] = quotaFileEntry{Period: f.mem.period[hash], Requests: usage.Requests, Tokens: usage.Tokens}
+	}
+	f.mem.mu.Unlock()
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), ".quota-*")
+	if err != nil {
+		return fmt.Errorf("failed to save quota counts: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save quota counts: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save quota counts: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to save quota counts: %w", err)
+	}
+	return nil
+}
+
+// QuotaCounter reports one dimension of a quota
+type QuotaCounter struct {
+	Limit     int64 `json:"limit"`
+	Used      int64 `json:"used"`
+	Remaining int64 `json:"remaining"`
+}
+
+// QuotaStatus is the state of a key's quota in the current period
+type QuotaStatus struct {
+	Period   string        `json:"period"`
+	Requests *QuotaCounter `json:"requests,omitempty"`
+	Tokens   *QuotaCounter `json:"tokens,omitempty"`
+}
+
+// quotaCounter returns the counter for limit, or nil when it is unlimited
+func quotaCounter(limit, used int64) *QuotaCounter {
+	if limit <= 0 {
+		return nil
+	}
+	return &QuotaCounter{Limit: limit, Used: used, Remaining: max(limit-used, 0)}
+}
+
+// KeyQuotas meters the requests and upstream tokens of each API key against
+// a monthly quota
+type KeyQuotas struct {
+	quotas map[string]KeyQuota
+	store  QuotaStore
+	now    func() time.Time
+}
+
+// NewKeyQuotas creates quotas from a key -> allowance map, counted in store
+func NewKeyQuotas(quotas map[string]KeyQuota, store QuotaStore) *KeyQuotas {
+	return &KeyQuotas{quotas: quotas, store: store, now: time.Now}
+}
+
+// newKeyQuotasFromEnv builds per-key quotas from API_KEY_QUOTAS (or
+// API_KEY_QUOTAS_FILE). It returns nil when none are configured.
+func newKeyQuotasFromEnv() *KeyQuotas {
+	spec, err := loadSecret("API_KEY_QUOTAS")
+	if err != nil {
+		log.Fatal(err)
+	}
+	quotas := parseKeyQuotas(spec)
+	if len(quotas) == 0 {
+		return nil
+	}
+	var store QuotaStore = NewMemoryQuotaStore()
+	if path := strings.TrimSpace(os.Getenv("QUOTA_STORE_FILE")); path != "" {
+		if store, err = NewFileQuotaStore(path); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		log.Printf("Warning: QUOTA_STORE_FILE is not set; quota counts are kept in memory and reset on restart")
+	}
+	log.Printf("Monthly quotas configured for %d API keys", len(quotas))
+	return NewKeyQuotas(quotas, store)
+}
+
+// Keys returns the API keys that have a quota
+func (k *KeyQuotas) Keys() []string {
+	keys := make([]string, 0, len(k.quotas))
+	for key := range k.quotas {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// period returns the current quota period, the calendar month in UTC
+func (k *KeyQuotas) period() string {
+	return k.now().UTC().Format("2006-01")
+}
+
+// status returns the quota state of key for usage in period
+func (k *KeyQuotas) status(quota KeyQuota, period string, usage QuotaUsage) QuotaStatus {
+	return QuotaStatus{
+		Period:   period,
+		Requests: quotaCounter(quota.Requests, usage.Requests),
+		Tokens:   quotaCounter(quota.Tokens, usage.Tokens),
+	}
+}
+
+// setQuotaHeaders reports the remaining quota on the response
+func setQuotaHeaders(w http.ResponseWriter, status QuotaStatus) {
+	if status.Requests != nil {
+		w.Header().Set("X-Quota-Limit", strconv.FormatInt(status.Requests.Limit, 10))
+		w.Header().Set("X-Quota-Remaining", strconv.FormatInt(status.Requests.Remaining, 10))
+	}
+	if status.Tokens != nil {
+		w.Header().Set("X-Quota-Token-Limit", strconv.FormatInt(status.Tokens.Limit, 10))
+		w.Header().Set("X-Quota-Tokens-Remaining", strconv.FormatInt(status.Tokens.Remaining, 10))
+	}
+}
+
+// exceeded reports whether usage, which includes the current request, goes
+// past either limit. Tokens are only known after a request completes, so a
+// key is stopped once it has used its whole token allowance.
+func (q KeyQuota) exceeded(usage QuotaUsage) bool {
+	return (q.Requests > 0 && usage.Requests > q.Requests) || (q.Tokens > 0 && usage.Tokens >= q.Tokens)
+}
+
+// quotaExempt reports whether r is served without counting against a quota:
+// reads that do no model work, such as polling or streaming an async job
+// (whose emails were counted when it started), health checks and metrics
+func quotaExempt(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	switch r.URL.Path {
+	case "/health", "/health/providers", "/metrics":
+		return true
+	}
+	return strings.HasPrefix(r.URL.Path, "/jobs/")
+}
+
+// Middleware counts each request against its key's monthly quota and rejects
+// it with 402 when that takes the key past its quota; the rejected request is
+// then uncounted. The count and the check are one store operation, so
+// concurrent requests cannot all slip under the limit. The upstream tokens of
+// an accepted request are counted once it completes, including any async job
+// it started. It relies on
+// RequireAPIKey having authenticated the key; a key without a quota, and
+// quotaExempt requests, are not metered. A store error lets the request
+// through rather than failing it.
+func (k *KeyQuotas) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(apiKeyHeader)
+		quota, ok := k.quotas[key]
+		if !ok || quotaExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		period := k.period()
+		usage, err := k.store.Add(ctx, key, period, QuotaUsage{Requests: 1})
+		if err != nil {
+			log.Printf("[%s] Warning: failed to count request against quota: %v", requestIDFromContext(ctx), err)
+		}
+		if quota.exceeded(usage) {
+			usage.Requests--
+			if _, err := k.store.Add(ctx, key, period, QuotaUsage{Requests: -1}); err != nil {
+				log.Printf("[%s] Warning: failed to uncount rejected request: %v", requestIDFromContext(ctx), err)
+			}
+			status := k.status(quota, period, usage)
+			setQuotaHeaders(w, status)
+			writeErrorResponse(w, ErrorResponse{
+				Error:   http.StatusText(http.StatusPaymentRequired),
+				Code:    ErrCodeQuotaExceeded,
+				Message: "Monthly quota for this API key is exhausted",
+				Quota:   &status,
+			}, http.StatusPaymentRequired)
+			return
+		}
+		setQuotaHeaders(w, k.status(quota, period, usage))
+
+		ctx = withTokenMeter(ctx)
+		ctx, work := withRequestWork(ctx)
+		defer work.after(func() {
+			tokens := meteredTokens(ctx)
+			if tokens <= 0 {
+				return
+			}
+			// The request may outlive its context; count the tokens regardless
+			if _, err := k.store.Add(context.WithoutCancel(ctx), key, period, QuotaUsage{Tokens: tokens}); err != nil {
+				log.Printf("[%s] Warning: failed to count tokens against quota: %v", requestIDFromContext(ctx), err)
+			}
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// tokenMeter accumulates the upstream tokens one request uses
+type tokenMeter struct {
+	tokens atomic.Int64
+}
+
+// tokenMeterKey is the context key under which the request's tokenMeter is stored
+type tokenMeterKey struct{}
+
+// withTokenMeter returns a copy of ctx carrying a fresh tokenMeter
+func withTokenMeter(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tokenMeterKey{}, &tokenMeter{})
+}
+
+// recordUpstreamTokens adds the tokens of a model call to the request's meter, if any
+func recordUpstreamTokens(ctx context.Context, tokens int) {
+	if m, ok := ctx.Value(tokenMeterKey{}).(*tokenMeter); ok && tokens > 0 {
+		m.tokens.Add(int64(tokens))
+	}
+}
+
+// meteredTokens returns the upstream tokens recorded so far for ctx
+func meteredTokens(ctx context.Context) int64 {
+	if m, ok := ctx.Value(tokenMeterKey{}).(*tokenMeter); ok {
+		return m.tokens.Load()
+	}
+	return 0
+}
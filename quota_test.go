@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// quotaHandler returns q's middleware around a handler that uses tokens
+// upstream tokens per request
+func quotaHandler(q *KeyQuotas, tokens int) http.Handler {
+	q.now = func() time.Time { return time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC) }
+	return q.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recordUpstreamTokens(r.Context(), tokens)
+	}))
+}
+
+// serveKey sends a request with API key through h
+func serveKey(h http.Handler, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/classify", nil)
+	req.Header.Set(apiKeyHeader, key)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestKeyQuotasRequests(t *testing.T) {
+	store := NewMemoryQuotaStore()
+	h := quotaHandler(NewKeyQuotas(map[string]KeyQuota{"alpha": {Requests: 2}}, store), 0)
+	tests := []struct {
+		status    int
+		remaining string
+	}{
+		{http.StatusOK, "1"},
+		{http.StatusOK, "0"},
+		{http.StatusPaymentRequired, "0"},
+		{http.StatusPaymentRequired, "0"},
+	}
+	for i, tt := range tests {
+		rec := serveKey(h, "alpha")
+		if rec.Code != tt.status {
+			t.Errorf("request %d: status = %d, want %d", i+1, rec.Code, tt.status)
+		}
+		if got := rec.Header().Get("X-Quota-Remaining"); got != tt.remaining {
+			t.Errorf("request %d: X-Quota-Remaining = %q, want %q", i+1, got, tt.remaining)
+		}
+	}
+	usage, _ := store.Usage(context.Background(), "alpha", "2026-03")
+	if usage.Requests != 2 {
+		t.Errorf("counted requests = %d, want 2: rejected requests must not be counted", usage.Requests)
+	}
+
+	rec := serveKey(h, "alpha")
+	var resp ErrorResponse
+	decodeResponse(t, rec, &resp)
+	if resp.Code != ErrCodeQuotaExceeded || resp.Quota == nil || resp.Quota.Requests.Used != 2 {
+		t.Errorf("402 body = %+v, want code %s with 2 used", resp, ErrCodeQuotaExceeded)
+	}
+}
+
+func TestKeyQuotasConcurrentRequests(t *testing.T) {
+	const limit, attempts = 5, 50
+	h := quotaHandler(NewKeyQuotas(map[string]KeyQuota{"alpha": {Requests: limit}}, NewMemoryQuotaStore()), 0)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	accepted := 0
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if serveKey(h, "alpha").Code == http.StatusOK {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != limit {
+		t.Errorf("accepted %d of %d concurrent requests, want %d", accepted, attempts, limit)
+	}
+}
+
+func TestKeyQuotasTokens(t *testing.T) {
+	h := quotaHandler(NewKeyQuotas(map[string]KeyQuota{"alpha": {Tokens: 150}}, NewMemoryQuotaStore()), 100)
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusPaymentRequired} {
+		if got := serveKey(h, "alpha").Code; got != want {
+			t.Errorf("request %d: status = %d, want %d", i+1, got, want)
+		}
+	}
+}
+
+func TestKeyQuotasExemptReads(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		counted bool
+	}{
+		{"job status poll", http.MethodGet, "/jobs/abc", false},
+		{"job stream", http.MethodGet, "/jobs/abc/stream", false},
+		{"health check", http.MethodGet, "/health", false},
+		{"metrics scrape", http.MethodGet, "/metrics", false},
+		{"classification", http.MethodPost, "/classify", true},
+		{"async classification", http.MethodPost, "/classify?async=true", true},
+		{"non-read under /jobs", http.MethodPost, "/jobs/abc", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryQuotaStore()
+			h := quotaHandler(NewKeyQuotas(map[string]KeyQuota{"alpha": {Requests: 1}}, store), 0)
+			for i := 0; i < 3; i++ {
+				req := httptest.NewRequest(tt.method, tt.path, nil)
+				req.Header.Set(apiKeyHeader, "alpha")
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, req)
+				// Only the first counted request fits the quota of 1
+				want := http.StatusOK
+				if tt.counted && i > 0 {
+					want = http.StatusPaymentRequired
+				}
+				if rec.Code != want {
+					t.Errorf("request %d: status = %d, want %d", i+1, rec.Code, want)
+				}
+			}
+			usage, _ := store.Usage(context.Background(), "alpha", "2026-03")
+			want := int64(0)
+			if tt.counted {
+				want = 1
+			}
+			if usage.Requests != want {
+				t.Errorf("counted requests = %d, want %d", usage.Requests, want)
+			}
+		})
+	}
+}
+
+func TestKeyQuotasUnmeteredKey(t *testing.T) {
+	store := NewMemoryQuotaStore()
+	h := quotaHandler(NewKeyQuotas(map[string]KeyQuota{"alpha": {Requests: 1}}, store), 0)
+	for i := 0; i < 3; i++ {
+		if got := serveKey(h, "beta").Code; got != http.StatusOK {
+			t.Fatalf("status = %d, want 200", got)
+		}
+	}
+	if usage, _ := store.Usage(context.Background(), "beta", "2026-03"); usage.Requests != 0 {
+		t.Errorf("beta usage = %+v, want none", usage)
+	}
+}
+
+func TestServerAPIKeysIncludeQuotaKeys(t *testing.T) {
+	t.Setenv("API_KEY_CONCURRENCY", "alpha=1")
+	t.Setenv("API_KEY_QUOTAS", "beta=10,alpha=5")
+	s := newTestServer(t, replying())
+	keys := s.apiKeys()
+	if len(keys) != 2 || keys[0] != "alpha" || keys[1] != "beta" {
+		t.Errorf("apiKeys() = %v, want [alpha beta]", keys)
+	}
+	h := RequireAPIKey(keys)(s.keyQuotas.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+	for key, want := range map[string]int{"beta": http.StatusOK, "gamma": http.StatusForbidden, "": http.StatusUnauthorized} {
+		if got := serveKey(h, key).Code; got != want {
+			t.Errorf("key %q: status = %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestFileQuotaStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "quota.json")
+	store, err := NewFileQuotaStore(path)
+	if err != nil {
+		t.Fatalf("NewFileQuotaStore: %v", err)
+	}
+	if _, err := store.Add(ctx, "secret-key", "2026-03", QuotaUsage{Requests: 3, Tokens: 40}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read store file: %v", err)
+	}
+	if strings.Contains(string(data), "secret-key") {
+		t.Errorf("store file holds the API key in plain text: %s", data)
+	}
+
+	reloaded, err := NewFileQuotaStore(path)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	tests := []struct {
+		period string
+		want   QuotaUsage
+	}{
+		{"2026-03", QuotaUsage{Requests: 3, Tokens: 40}},
+		{"2026-04", QuotaUsage{}},
+	}
+	for _, tt := range tests {
+		got, err := reloaded.Usage(ctx, "secret-key", tt.period)
+		if err != nil || got != tt.want {
+			t.Errorf("Usage(%s) = %+v, %v, want %+v", tt.period, got, err, tt.want)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileQuotaStore(path); err == nil {
+		t.Error("NewFileQuotaStore accepted a corrupt file")
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for reading upstream rate-limit headers and throttling on them
+const (
+	defaultRateLimitHeaderPrefix = "x-ratelimit-"
+	defaultThrottleThreshold     = 0.1
+	defaultThrottleMaxDelay      = 2 * time.Second
+)
+
+// RateLimitWindow is the upstream's last reported state of one rate limit
+type RateLimitWindow struct {
+	// Limit is 0 when the upstream did not report it
+	Limit     int64
+	Remaining int64
+	// Reset is when the limit replenishes; zero when not reported
+	Reset time.Time
+}
+
+// low reports whether less than threshold of the limit remains. Without a
+// limit to compare against, only an exhausted window counts as low.
+func (w RateLimitWindow) low(threshold float64) bool {
+	if w.Remaining <= 0 {
+		return true
+	}
+	return w.Limit > 0 && float64(w.Remaining) < threshold*float64(w.Limit)
+}
+
+// UpstreamRateLimits tracks the remaining request and token quota the
+// upstream reports in its rate-limit headers, and, when throttling is
+// enabled, delays calls while either is running low instead of waiting to be
+// answered with 429
+type UpstreamRateLimits struct {
+	// prefix starts each header name, as in <prefix>remaining-requests
+	prefix    string
+	throttle  bool
+	threshold float64
+	maxDelay  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	requests *RateLimitWindow
+	tokens   *RateLimitWindow
+
+	throttled atomic.Int64
+}
+
+// newUpstreamRateLimitsFromEnv builds the rate-limit tracker from
+// RATE_LIMIT_HEADER_PREFIX and PROACTIVE_THROTTLE* settings
+func newUpstreamRateLimitsFromEnv() *UpstreamRateLimits {
+	threshold := envFloat("PROACTIVE_THROTTLE_THRESHOLD", defaultThrottleThreshold)
+	if threshold > 1 {
+		threshold = defaultThrottleThreshold
+	}
+	return &UpstreamRateLimits{
+		prefix:    strings.ToLower(envString("RATE_LIMIT_HEADER_PREFIX", defaultRateLimitHeaderPrefix)),
+		throttle:  envBool("PROACTIVE_THROTTLE", false),
+		threshold: threshold,
+		maxDelay:  envDuration("PROACTIVE_THROTTLE_MAX_DELAY", defaultThrottleMaxDelay),
+		now:       time.Now,
+	}
+}
+
+// parseRateLimitReset parses a reset header given as a duration such as
+// "6m0s" or "20ms", or as a number of seconds
+func parseRateLimitReset(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return d, true
+	}
+	if secs, err := strconv.ParseFloat(value, 64); err == nil && secs >= 0 {
+		return time.Duration(secs * float64(time.Second)), true
+	}
+	return 0, false
+}
+
+// window parses the headers of one limit, such as requests or tokens. It
+// returns nil when the remaining count is absent or malformed.
+func (u *UpstreamRateLimits) window(h http.Header, name string, now time.Time) *RateLimitWindow {
+	remaining, err := strconv.ParseInt(strings.TrimSpace(h.Get(u.prefix+"remaining-"+name)), 10, 64)
+	if err != nil {
+		return nil
+	}
+	w := &RateLimitWindow{Remaining: remaining}
+	if limit, err := strconv.ParseInt(strings.TrimSpace(h.Get(u.prefix+"limit-"+name)), 10, 64); err == nil && limit > 0 {
+		w.Limit = limit
+	}
+	if reset, ok := parseRateLimitReset(h.Get(u.prefix + "reset-" + name)); ok {
+		w.Reset = now.Add(reset)
+	}
+	return w
+}
+
+// Observe records the rate-limit headers of an upstream response. Limits a
+// response does not report keep their last known state.
+func (u *UpstreamRateLimits) Observe(h http.Header) {
+	if u == nil {
+		return
+	}
+	now := u.now()
+	requests, tokens := u.window(h, "requests", now), u.window(h, "tokens", now)
+	if requests == nil && tokens == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if requests != nil {
+		u.requests = requests
+	}
+	if tokens != nil {
+		u.tokens = tokens
+	}
+}
+
+// Snapshot returns the last reported request and token limits; either is
+// nil until the upstream has reported it
+func (u *UpstreamRateLimits) Snapshot() (requests, tokens *RateLimitWindow) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.requests != nil {
+		r := *u.requests
+		requests = &r
+	}
+	if u.tokens != nil {
+		t := *u.tokens
+		tokens = &t
+	}
+	return requests, tokens
+}
+
+// Delay returns how long to hold back the next upstream call: until the
+// reset of a limit that is running low, at most maxDelay, or maxDelay when
+// the upstream gave no reset time. It is 0 when throttling is disabled or no
+// limit is low. A low limit whose reset has passed no longer counts.
+func (u *UpstreamRateLimits) Delay() time.Duration {
+	if u == nil || !u.throttle {
+		return 0
+	}
+	now := u.now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var delay time.Duration
+	for _, w := range []*RateLimitWindow{u.requests, u.tokens} {
+		if w == nil || !w.low(u.threshold) {
+			continue
+		}
+		wait := u.maxDelay
+		if !w.Reset.IsZero() {
+			wait = min(w.Reset.Sub(now), u.maxDelay)
+		}
+		delay = max(delay, wait)
+	}
+	if delay > 0 {
+		u.throttled.Add(1)
+	}
+	return delay
+}
+
+// writeRateLimitMetrics writes the last reported upstream limits and the
+// number of throttled calls
+func writeRateLimitMetrics(w io.Writer, u *UpstreamRateLimits) error {
+	if _, err := fmt.Fprintf(w, `# HELP upstream_throttled_total Upstream calls delayed because a reported rate limit was running low.
+# TYPE upstream_throttled_total counter
+upstream_throttled_total %d
+`, u.throttled.Load()); err != nil {
+		return err
+	}
+	requests, tokens := u.Snapshot()
+	for _, limit := range []struct {
+		name   string
+		window *RateLimitWindow
+	}{{"requests", requests}, {"tokens", tokens}} {
+		if limit.window == nil {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, `# HELP upstream_ratelimit_remaining_%[1]s Remaining upstream %[1]s quota, as last reported by the upstream.
+# TYPE upstream_ratelimit_remaining_%[1]s gauge
+upstream_ratelimit_remaining_%[1]s %[2]d
+`, limit.name, limit.window.Remaining); err != nil {
+			return err
+		}
+		if limit.window.Limit == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, `# HELP upstream_ratelimit_limit_%[1]s Upstream %[1]s quota per window, as last reported by the upstream.
+# TYPE upstream_ratelimit_limit_%[1]s gauge
+upstream_ratelimit_limit_%[1]s %[2]d
+`, limit.name, limit.window.Limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		throttle bool
+		headers  map[string]string
+		advance  time.Duration
+		want     time.Duration
+	}{
+		{"plenty remaining", true, map[string]string{"x-ratelimit-limit-requests": "100", "x-ratelimit-remaining-requests": "60", "x-ratelimit-reset-requests": "1s"}, 0, 0},
+		{"low requests waits for reset", true, map[string]string{"x-ratelimit-limit-requests": "100", "x-ratelimit-remaining-requests": "5", "x-ratelimit-reset-requests": "1s"}, 0, time.Second},
+		{"wait capped", true, map[string]string{"x-ratelimit-limit-requests": "100", "x-ratelimit-remaining-requests": "5", "x-ratelimit-reset-requests": "6m0s"}, 0, 2 * time.Second},
+		{"low tokens", true, map[string]string{"x-ratelimit-limit-tokens": "10000", "x-ratelimit-remaining-tokens": "200", "x-ratelimit-reset-tokens": "1.5"}, 0, 1500 * time.Millisecond},
+		{"exhausted without limit or reset", true, map[string]string{"x-ratelimit-remaining-requests": "0"}, 0, 2 * time.Second},
+		{"reset already passed", true, map[string]string{"x-ratelimit-limit-requests": "100", "x-ratelimit-remaining-requests": "5", "x-ratelimit-reset-requests": "1s"}, 2 * time.Second, 0},
+		{"throttling disabled", false, map[string]string{"x-ratelimit-limit-requests": "100", "x-ratelimit-remaining-requests": "0", "x-ratelimit-reset-requests": "1s"}, 0, 0},
+		{"no headers", true, nil, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{t: time.Unix(1700000000, 0)}
+			u := &UpstreamRateLimits{prefix: defaultRateLimitHeaderPrefix, throttle: tt.throttle, threshold: defaultThrottleThreshold, maxDelay: defaultThrottleMaxDelay, now: clock.now}
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			u.Observe(h)
+			clock.t = clock.t.Add(tt.advance)
+			if got := u.Delay(); got != tt.want {
+				t.Errorf("Delay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProactiveThrottle(t *testing.T) {
+	t.Setenv("PROACTIVE_THROTTLE", "true")
+	t.Setenv("PROACTIVE_THROTTLE_MAX_DELAY", "1s")
+	const reset = 100 * time.Millisecond
+	upstream := scripted(
+		withHeader(withHeader(withHeader(func() (*http.Response, error) { return chatReply("Launch moves to Friday."), nil },
+			"x-ratelimit-limit-requests", "100"), "x-ratelimit-remaining-requests", "2"), "x-ratelimit-reset-requests", reset.String()),
+		func() (*http.Response, error) { return chatReply("Launch moves to Friday."), nil },
+	)
+	s := newTestServer(t, upstream)
+	logs := captureLog(t)
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		if _, err := s.client.SummarizeEmail(context.Background(), "The launch moves to Friday.", SummarizeOptions{}); err != nil {
+			t.Fatalf("SummarizeEmail: %v", err)
+		}
+		elapsed := time.Since(start)
+		if throttled := elapsed >= reset/2; throttled != (i == 1) {
+			t.Errorf("call %d took %v, throttled %v, want %v", i+1, elapsed, throttled, i == 1)
+		}
+	}
+	if !strings.Contains(logs.String(), "Upstream rate limit running low, throttling") {
+		t.Errorf("logs = %q, want a throttling message", logs.String())
+	}
+
+	rec := httptest.NewRecorder()
+	s.MetricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range []string{"upstream_throttled_total 1", "upstream_ratelimit_remaining_requests 2", "upstream_ratelimit_limit_requests 100"} {
+		if !strings.Contains(rec.Body.String(), line+"\n") {
+			t.Errorf("metrics missing %q", line)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxReclassifyLabels bounds the human-provided labels accepted by /reclassify
+const maxReclassifyLabels = 20
+
+// ReclassifyRequest carries an email and the labels a human says are correct
+type ReclassifyRequest struct {
+	Content string   `json:"content"`
+	Labels  []string `json:"labels"`
+	// IncludeRationale adds a rationale to each refined label
+	IncludeRationale bool `json:"include_rationale"`
+}
+
+// ReclassifyResponse holds the refined classification
+type ReclassifyResponse struct {
+	Labels []ClassificationLabel `json:"labels"`
+	// Degraded is true when the human labels were returned without calling the model
+	Degraded bool `json:"degraded,omitempty"`
+}
+
+// Validate checks the request and trims the provided labels
+func (req *ReclassifyRequest) Validate() error {
+	if strings.TrimSpace(req.Content) == "" {
+		return errors.New("content is required")
+	}
+	var labels []string
+	for _, label := range req.Labels {
+		if label = strings.TrimSpace(label); label != "" {
+			labels = append(labels, label)
+		}
+	}
+	if len(labels) == 0 {
+		return errors.New("at least one label is required")
+	}
+	if len(labels) > maxReclassifyLabels {
+		return fmt.Errorf("at most %d labels are allowed", maxReclassifyLabels)
+	}
+	req.Labels = labels
+	return nil
+}
+
+// reclassifyGuidance introduces the human-reviewed labels in the prompt
+const reclassifyGuidance = "A human reviewer has confirmed that the correct labels for this email are: %s. Treat these labels as ground truth and use them to refine the labels and scores you return."
+
+// buildReclassifyRequest is buildClassifyRequest with the human labels added
+// to the user message as ground truth
+func (c *DeepseekClient) buildReclassifyRequest(ctx context.Context, content string, labels []string, opts ClassifyOptions) chatRequest {
+	req := c.buildClassifyRequest(ctx, content, opts)
+	last := &req.Messages[len(req.Messages)-1]
+	last.Content += "\n\n" + fmt.Sprintf(reclassifyGuidance, strings.Join(labels, ", "))
+	return req
+}
+
+// ReclassifyEmail classifies content again using labels corrected by a human
+// as guidance. Results are not cached since they depend on the feedback.
+func (c *DeepseekClient) ReclassifyEmail(ctx context.Context, content string, labels []string, opts ClassifyOptions) (*ClassifyResponse, error) {
+	if c.degraded(ctx) {
+		out := &ClassifyResponse{Degraded: true}
+		for _, label := range labels {
+			out.Labels = append(out.Labels, ClassificationLabel{Label: label, Score: 1})
+		}
+		return out, nil
+	}
+	ctx, cancel := withTimeout(ctx, c.Settings().ClassifyTimeout)
+	defer cancel()
+	content = c.fitContent(ctx, content)
+	cr, err := c.chat(ctx, c.buildReclassifyRequest(ctx, content, labels, opts))
+	if err != nil {
+		return nil, err
+	}
+	out, err := c.parseClassifyChoice(ctx, cr.Choices[0])
+	if err != nil {
+		return nil, err
+	}
+	out.Labels = c.postProcessLabels(c.constrainLabels(out.Labels))
+	return out, nil
+}
+
+// ReclassifyHandler handles POST /reclassify
+func (s *Server) ReclassifyHandler(w http.ResponseWriter, r *http.Request) {
+	bodyBytes, err := readRequestBody(w, r, s.bodyReadTimeout)
+	if err != nil {
+		writeBodyReadError(w, err)
+		return
+	}
+
+	var req ReclassifyRequest
+	if err := s.decodeJSON(bodyBytes, &req); err != nil {
+		JSONDecodeError(w, bodyBytes, err)
+		return
+	}
+	if err := req.Validate(); err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.refuseSensitive(w, r, req.Content) {
+		return
+	}
+
+	client, err := s.clientFor(r)
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := client.ReclassifyEmail(r.Context(), req.Content, req.Labels, ClassifyOptions{IncludeRationale: req.IncludeRationale})
+	if err != nil {
+		log.Printf("Error calling Deepseek API for reclassify: %v", err)
+		writeUpstreamError(w, "Failed to reclassify email", err)
+		return
+	}
+
+	s.recordLabels(result.Labels...)
+	if err := writeEncodedJSON(w, ReclassifyResponse{Labels: result.Labels, Degraded: result.Degraded}); err != nil {
+		log.Printf("Error writing response: %v", err)
+		JSONError(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
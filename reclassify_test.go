@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReclassifyHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		reply   string
+		status  int
+		guided  string
+		want    []ClassificationLabel
+		message string
+	}{
+		{"labels guide the prompt", `{"content":"Please review the attached invoice by Friday","labels":[" action_required ","finance"]}`,
+			`{"labels":[{"label":"action_required","score":0.95}]}`, http.StatusOK, "action_required, finance",
+			[]ClassificationLabel{{Label: "action_required", Score: 0.95}}, ""},
+		{"fenced reply parses", `{"content":"Lunch on Thursday?","labels":["personal"]}`,
+			"```json\n{\"labels\":[{\"label\":\"personal\",\"score\":0.8}]}\n```", http.StatusOK, "personal",
+			[]ClassificationLabel{{Label: "personal", Score: 0.8}}, ""},
+		{"missing content", `{"labels":["personal"]}`, "", http.StatusBadRequest, "", nil, "content is required"},
+		{"blank labels", `{"content":"Lunch on Thursday?","labels":[" "]}`, "", http.StatusBadRequest, "", nil, "at least one label is required"},
+		{"too many labels", `{"content":"Lunch on Thursday?","labels":["a","b","c","d","e","f","g","h","i","j","k","l","m","n","o","p","q","r","s","t","u"]}`,
+			"", http.StatusBadRequest, "", nil, "at most 20 labels are allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying(tt.reply)
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			s.ReclassifyHandler(rec, postJSON("/reclassify", tt.body))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				var resp ErrorResponse
+				decodeResponse(t, rec, &resp)
+				if resp.Message != tt.message {
+					t.Errorf("message = %q, want %q", resp.Message, tt.message)
+				}
+				if upstream.calls() != 0 {
+					t.Errorf("upstream calls = %d, want 0", upstream.calls())
+				}
+				return
+			}
+			want := "A human reviewer has confirmed that the correct labels for this email are: " + tt.guided + "."
+			if prompt := upstream.messages(0); !strings.Contains(prompt, want) {
+				t.Errorf("prompt %q does not contain %q", prompt, want)
+			}
+			var resp ReclassifyResponse
+			decodeResponse(t, rec, &resp)
+			if !reflect.DeepEqual(resp.Labels, tt.want) || resp.Degraded {
+				t.Errorf("response = %+v, want labels %+v", resp, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// replyPrefixPattern matches the reply prefixes mail clients stack on a
+// subject, such as "Re:", "RE[2]:" and the localized "AW:" and "SV:"
+var replyPrefixPattern = regexp.MustCompile(`(?i)^\s*(re|aw|sv)(\[\d+\])?\s*:\s*`)
+
+// ReplyHeaders carries what a caller needs to send a draft as a MIME reply
+type ReplyHeaders struct {
+	// ReplySubject is the original subject with a single "Re:" prefix
+	ReplySubject string `json:"reply_subject,omitempty"`
+	// InReplyTo echoes the Message-ID of the email being replied to
+	InReplyTo string `json:"in_reply_to,omitempty"`
+}
+
+// replySubject returns subject prefixed with "Re:", collapsing any reply
+// prefixes it already carries so replies never read "Re: Re:". An empty
+// subject stays empty.
+func replySubject(subject string) string {
+	subject = strings.TrimSpace(subject)
+	for {
+		stripped := replyPrefixPattern.ReplaceAllString(subject, "")
+		if stripped == subject {
+			break
+		}
+		subject = stripped
+	}
+	if subject == "" {
+		return ""
+	}
+	return "Re: " + subject
+}
+
+// validateMessageID checks a caller-supplied Message-ID header value
+func validateMessageID(id string) error {
+	if len(id) > maxHeaderFieldLength {
+		return fmt.Errorf("message_id must be at most %d characters", maxHeaderFieldLength)
+	}
+	if strings.ContainsAny(id, "\r\n") {
+		return fmt.Errorf("message_id must be a single line")
+	}
+	return nil
+}
+
+// replyHeaders builds the reply headers for the draft request, using the
+// subject of the newest message that has one
+func (req DraftRequest) replyHeaders() ReplyHeaders {
+	subject := req.Subject
+	for i := len(req.Thread) - 1; strings.TrimSpace(subject) == "" && i >= 0; i-- {
+		subject = req.Thread[i].Subject
+	}
+	return ReplyHeaders{
+		ReplySubject: replySubject(subject),
+		InReplyTo:    strings.TrimSpace(req.MessageID),
+	}
+}
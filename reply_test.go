@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReplySubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{"Quarterly report", "Re: Quarterly report"},
+		{"Re: Quarterly report", "Re: Quarterly report"},
+		{"RE: re: Quarterly report", "Re: Quarterly report"},
+		{"Re[2]: Quarterly report", "Re: Quarterly report"},
+		{"AW: SV: Quarterly report", "Re: Quarterly report"},
+		{"  Re:Quarterly report  ", "Re: Quarterly report"},
+		{"Regarding the report", "Re: Regarding the report"},
+		{"Fwd: Quarterly report", "Re: Fwd: Quarterly report"},
+		{"", ""},
+		{"Re: ", ""},
+	}
+	for _, tt := range tests {
+		if got := replySubject(tt.subject); got != tt.want {
+			t.Errorf("replySubject(%q) = %q, want %q", tt.subject, got, tt.want)
+		}
+	}
+}
+
+func TestDraftReplyHeaders(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		status    int
+		subject   string
+		inReplyTo string
+	}{
+		{"subject and message id", `{"subject":"Re: Quarterly report","body":"Can you send it today?","message_id":" <abc@example.com> "}`, http.StatusOK, "Re: Quarterly report", "<abc@example.com>"},
+		{"subject from the thread", `{"body":"Any update?","thread":[{"subject":"Quarterly report","body":"Please send the report."},{"subject":"RE: Quarterly report","body":"Working on it."}]}`, http.StatusOK, "Re: Quarterly report", ""},
+		{"no subject", `{"body":"Can you send it today?"}`, http.StatusOK, "", ""},
+		{"multi-line message id", `{"body":"Can you send it today?","message_id":"<abc@example.com>\r\nBcc: x@example.com"}`, http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, replying("Sending it this afternoon."))
+			rec := httptest.NewRecorder()
+			s.DraftHandler(rec, postJSON("/draft", tt.body))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp DraftResponse
+			decodeResponse(t, rec, &resp)
+			if resp.ReplySubject != tt.subject || resp.InReplyTo != tt.inReplyTo {
+				t.Errorf("reply headers = %+v, want subject %q in_reply_to %q", resp.ReplyHeaders, tt.subject, tt.inReplyTo)
+			}
+		})
+	}
+}
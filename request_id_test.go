@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDReachesClientLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		inbound string
+	}{
+		{"propagated", "abc-123"},
+		{"generated", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logged := captureLog(t)
+			s := newTestServer(t, replying(`{"labels":[{"label":"urgent","score":0.9}]}`))
+			req := postJSON("/classify", `{"emails":[{"id":"1","content":"The server is down again"}]}`)
+			if tt.inbound != "" {
+				req.Header.Set(requestIDHeader, tt.inbound)
+			}
+			rec := httptest.NewRecorder()
+			RequestID(http.HandlerFunc(s.ClassifyHandler)).ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+
+			id := rec.Header().Get(requestIDHeader)
+			if tt.inbound != "" && id != tt.inbound {
+				t.Errorf("%s = %q, want %q", requestIDHeader, id, tt.inbound)
+			}
+			if tt.inbound == "" && !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(id) {
+				t.Errorf("generated %s = %q, want 32 hex digits", requestIDHeader, id)
+			}
+			if !strings.Contains(logged.String(), "["+id+"] DeepSeek API response content") {
+				t.Errorf("client log does not carry request ID %q:\n%s", id, logged.String())
+			}
+		})
+	}
+}
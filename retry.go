@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default retry ceilings per error class
+const (
+	defaultNetMaxRetries       = 3
+	defaultServerMaxRetries    = 3
+	defaultRateLimitMaxRetries = 2
+	defaultMaxRetryAfter       = 30 * time.Second
+)
+
+// RetryPolicy sets how many times doWithRetry retries each class of failure
+type RetryPolicy struct {
+	// NetMaxRetries applies to transport errors such as connection resets
+	NetMaxRetries int
+	// ServerMaxRetries applies to 5xx responses
+	ServerMaxRetries int
+	// RateLimitMaxRetries applies to 429 responses carrying a Retry-After header
+	RateLimitMaxRetries int
+	// MaxRetryAfter is the longest Retry-After delay that will be waited out
+	MaxRetryAfter time.Duration
+}
+
+// newRetryPolicyFromEnv builds the retry policy from *_MAX_RETRIES settings
+func newRetryPolicyFromEnv() RetryPolicy {
+	return RetryPolicy{
+		NetMaxRetries:       envNonNegativeInt("NET_MAX_RETRIES", defaultNetMaxRetries),
+		ServerMaxRetries:    envNonNegativeInt("SERVER_MAX_RETRIES", defaultServerMaxRetries),
+		RateLimitMaxRetries: envNonNegativeInt("RATE_LIMIT_MAX_RETRIES", defaultRateLimitMaxRetries),
+		MaxRetryAfter:       envDuration("RETRY_AFTER_MAX", defaultMaxRetryAfter),
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		d := at.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// permanentError wraps an error returned by a doWithRetry attempt that must
+// not be retried, such as a request that cannot be built
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// truncatedBodyError reports an upstream response whose body ended early,
+// as when the connection drops mid-body. doWithRetry retries it like any
+// other transport error.
+type truncatedBodyError struct {
+	err error
+}
+
+func (e *truncatedBodyError) Error() string { return "truncated response body: " + e.err.Error() }
+func (e *truncatedBodyError) Unwrap() error { return e.err }
+
+// isTruncation reports whether err means input ended before it was complete
+func isTruncation(err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// bufferResponse decompresses and reads the whole body of resp within the
+// attempt that received it, replacing it with an in-memory copy, so a body
+// cut short can be retried instead of failing later as a parse error. A
+// successful JSON response must also decode completely. A body that ends
+// early is a *truncatedBodyError, other read errors are returned as they are,
+// and a body that is not valid gzip is permanent.
+func bufferResponse(resp *http.Response) error {
+	defer resp.Body.Close()
+	if err := decompressResponse(resp); err != nil {
+		if isTruncation(err) {
+			return &truncatedBodyError{err}
+		}
+		return &permanentError{fmt.Errorf("failed to decompress response: %w", err)}
+	}
+	body, err := io.ReadAll(resp.Body)
+	if isTruncation(err) {
+		return &truncatedBodyError{err}
+	}
+	if err != nil {
+		return err
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if resp.StatusCode/100 == 2 && mediaType == "application/json" {
+		var value json.RawMessage
+		if err := json.NewDecoder(bytes.NewReader(body)).Decode(&value); isTruncation(err) {
+			return &truncatedBodyError{err}
+		}
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+// doWithRetry calls do until it succeeds, retrying each class of failure up to
+// the ceiling set in c.Settings().Retry: transport errors, including response
+// bodies cut short, and 5xx responses with exponential backoff, and 429
+// responses after their Retry-After delay. Each attempt is first held back
+// while c.RateLimits reports a low quota. do is called once per attempt and
+// must build a fresh request each time, including a new reader over the
+// body. Retried responses are closed; the final response is returned as is,
+// whatever its status, for the caller to handle.
+func (c *DeepseekClient) doWithRetry(ctx context.Context, do func() (*http.Response, error)) (*http.Response, error) {
+	policy := c.Settings().Retry
+	var delay time.Duration
+	netRetries, serverRetries, rateLimitRetries := 0, 0, 0
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, fmt.Errorf("canceled during backoff: %w", ctx.Err())
+			}
+		}
+
+		if wait := c.RateLimits.Delay(); wait > 0 {
+			c.logf(ctx, "Upstream rate limit running low, throttling for %v", wait)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, fmt.Errorf("canceled while throttled: %w", ctx.Err())
+			}
+		}
+
+		resp, err := do()
+		if err != nil {
+			var permanent *permanentError
+			if errors.As(err, &permanent) {
+				return nil, permanent.err
+			}
+			if ctx.Err() != nil || netRetries >= policy.NetMaxRetries {
+				return nil, fmt.Errorf("failed after %d retries: %w", attempt, err)
+			}
+			netRetries++
+			delay = c.backoffDelay(netRetries)
+			continue
+		}
+
+		c.RateLimits.Observe(resp.Header)
+
+		// Retry on 5xx errors
+		if resp.StatusCode >= 500 && resp.StatusCode < 600 && serverRetries < policy.ServerMaxRetries {
+			resp.Body.Close()
+			serverRetries++
+			delay = c.backoffDelay(serverRetries)
+			continue
+		}
+
+		// Retry on 429 only when the upstream says how long to wait
+		if resp.StatusCode == http.StatusTooManyRequests && rateLimitRetries < policy.RateLimitMaxRetries {
+			if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok && wait <= policy.MaxRetryAfter {
+				resp.Body.Close()
+				rateLimitRetries++
+				delay = wait
+				continue
+			}
+		}
+
+		return resp, nil
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingReader returns data and then err
+type failingReader struct {
+	data string
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestBufferResponse(t *testing.T) {
+	reset := errors.New("connection reset by peer")
+	tests := []struct {
+		name      string
+		body      io.Reader
+		encoding  string
+		truncated bool
+		permanent bool
+		err       error
+	}{
+		{name: "complete", body: strings.NewReader(`{"ok":true}`)},
+		{name: "body ends early", body: &failingReader{`{"ok":`, io.ErrUnexpectedEOF}, truncated: true, err: io.ErrUnexpectedEOF},
+		{name: "json cut short", body: strings.NewReader(`{"ok":`), truncated: true, err: io.ErrUnexpectedEOF},
+		{name: "other read error", body: &failingReader{`{"ok":`, reset}, err: reset},
+		{name: "invalid gzip", body: strings.NewReader("this is plain text, not gzip"), encoding: "gzip", permanent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       io.NopCloser(tt.body),
+			}
+			if tt.encoding != "" {
+				resp.Header.Set("Content-Encoding", tt.encoding)
+			}
+			err := bufferResponse(resp)
+			var truncated *truncatedBodyError
+			if got := errors.As(err, &truncated); got != tt.truncated {
+				t.Errorf("bufferResponse() = %v, truncated = %v, want %v", err, got, tt.truncated)
+			}
+			var permanent *permanentError
+			if got := errors.As(err, &permanent); got != tt.permanent {
+				t.Errorf("bufferResponse() = %v, permanent = %v, want %v", err, got, tt.permanent)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Errorf("bufferResponse() = %v, want %v", err, tt.err)
+			}
+			if err == nil && !tt.truncated && !tt.permanent && tt.err == nil {
+				if body, _ := io.ReadAll(resp.Body); string(body) != `{"ok":true}` {
+					t.Errorf("buffered body = %q", body)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryCeilings(t *testing.T) {
+	connReset := func() (*http.Response, error) { return nil, errors.New("connection reset by peer") }
+	rateLimited := withHeader(status(429, "slow down"), "Retry-After", "0")
+	ok := status(200, "{}")
+	tests := []struct {
+		name    string
+		replies []func() (*http.Response, error)
+		calls   int
+		status  int // final status, 0 for a transport error
+	}{
+		{"network errors", []func() (*http.Response, error){connReset}, 5, 0},
+		{"5xx", []func() (*http.Response, error){status(503, "busy")}, 3, 503},
+		{"429 with Retry-After", []func() (*http.Response, error){rateLimited}, 2, 429},
+		{"429 with a long Retry-After", []func() (*http.Response, error){withHeader(status(429, "slow down"), "Retry-After", "3600")}, 1, 429},
+		{"4xx not retried", []func() (*http.Response, error){status(400, "bad request")}, 1, 400},
+		{"classes counted separately", []func() (*http.Response, error){connReset, status(503, "busy"), connReset, rateLimited, status(502, "bad gateway"), connReset, ok}, 7, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NET_MAX_RETRIES", "4")
+			t.Setenv("SERVER_MAX_RETRIES", "2")
+			t.Setenv("RATE_LIMIT_MAX_RETRIES", "1")
+			t.Setenv("RETRY_AFTER_MAX", "1m")
+			upstream := scripted(tt.replies...)
+			c := newTestClient(t, upstream)
+			c.backoffBase = time.Millisecond
+
+			resp, err := c.doWithRetry(context.Background(), func() (*http.Response, error) {
+				return upstream.Do(httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+			})
+			if tt.status == 0 {
+				if err == nil {
+					t.Fatalf("doWithRetry succeeded with status %d, want a transport error", resp.StatusCode)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("doWithRetry: %v", err)
+				}
+				if resp.StatusCode != tt.status {
+					t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+				}
+			}
+			if got := upstream.calls(); got != tt.calls {
+				t.Errorf("upstream calls = %d, want %d", got, tt.calls)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"30", 30 * time.Second, true},
+		{" 5 ", 5 * time.Second, true},
+		{"-1", 0, false},
+		{now.Add(2 * time.Minute).Format(http.TimeFormat), 2 * time.Minute, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDoWithRetry(t *testing.T) {
+	buildFailed := errors.New("failed to create request")
+	tests := []struct {
+		name    string
+		replies []func() (*http.Response, error)
+		cancel  bool
+		calls   int
+		status  int
+		err     error
+	}{
+		{"success", []func() (*http.Response, error){status(200, "{}")}, false, 1, 200, nil},
+		{"5xx then success", []func() (*http.Response, error){status(502, "bad gateway"), status(200, "{}")}, false, 2, 200, nil},
+		{"permanent error not retried", []func() (*http.Response, error){func() (*http.Response, error) { return nil, &permanentError{buildFailed} }}, false, 1, 0, buildFailed},
+		{"canceled during backoff", []func() (*http.Response, error){status(503, "busy")}, true, 1, 0, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SERVER_MAX_RETRIES", "2")
+			upstream := scripted(tt.replies...)
+			c := newTestClient(t, upstream)
+			c.backoffBase = time.Millisecond
+			if tt.cancel {
+				c.backoffBase = time.Hour
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			resp, err := c.doWithRetry(ctx, func() (*http.Response, error) {
+				resp, err := upstream.Do(httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+				if tt.cancel {
+					cancel()
+				}
+				return resp, err
+			})
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("doWithRetry error = %v, want %v", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatalf("doWithRetry: %v", err)
+			} else if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got := upstream.calls(); got != tt.calls {
+				t.Errorf("upstream calls = %d, want %d", got, tt.calls)
+			}
+		})
+	}
+}
+
+func TestMakeRequestReusesBody(t *testing.T) {
+	t.Setenv("SERVER_MAX_RETRIES", "2")
+	upstream := scripted(status(503, "busy"), status(500, "oops"), status(200, "{}"))
+	c := newTestClient(t, upstream)
+	c.backoffBase = time.Millisecond
+
+	resp, err := c.makeRequest(context.Background(), http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"deepseek-chat"}`))
+	if err != nil {
+		t.Fatalf("makeRequest: %v", err)
+	}
+	resp.Body.Close()
+	if upstream.calls() != 3 {
+		t.Fatalf("upstream calls = %d, want 3", upstream.calls())
+	}
+	for i := 0; i < 3; i++ {
+		if got := upstream.body(i)["model"]; got != "deepseek-chat" {
+			t.Errorf("attempt %d body model = %v, want the full body on every attempt", i+1, got)
+		}
+	}
+}
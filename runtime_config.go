@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// RuntimeSettings is the part of the client configuration admins may change
+// while the server runs. The client swaps the whole value atomically, so a
+// model call sees either the old or the new settings, never a mix.
+type RuntimeSettings struct {
+	// Per-operation deadlines covering all attempts of a single model call
+	SummarizeTimeout time.Duration
+	ClassifyTimeout  time.Duration
+	DraftTimeout     time.Duration
+	// Per-operation sampling temperatures, used unless the request overrides them
+	SummarizeTemperature float64
+	ClassifyTemperature  float64
+	DraftTemperature     float64
+	// ReviewThreshold injects a needs_review label when the top score is below it; 0 disables
+	ReviewThreshold float64
+	// Retry sets the retry ceiling for each class of upstream failure
+	Retry RetryPolicy
+}
+
+// maxRetriesSetting bounds each retry ceiling settable at runtime
+const maxRetriesSetting = 10
+
+// Settings returns the client's current runtime settings
+func (c *DeepseekClient) Settings() RuntimeSettings {
+	if s := c.settings.Load(); s != nil {
+		return *s
+	}
+	return RuntimeSettings{}
+}
+
+// UpdateSettings applies update to a copy of the current settings and, if the
+// result is valid, replaces them. Concurrent updates are serialized by
+// retrying on top of whichever update won.
+func (c *DeepseekClient) UpdateSettings(update func(*RuntimeSettings) error) (RuntimeSettings, error) {
+	for {
+		current := c.settings.Load()
+		next := c.Settings()
+		if err := update(&next); err != nil {
+			return c.Settings(), err
+		}
+		if err := c.validateSettings(next); err != nil {
+			return c.Settings(), err
+		}
+		if c.settings.CompareAndSwap(current, &next) {
+			return next, nil
+		}
+	}
+}
+
+// attemptTimeout returns the per-attempt timeout of the HTTP client, or 0
+// when the client has none or is not an *http.Client
+func (c *DeepseekClient) attemptTimeout() time.Duration {
+	if hc, ok := c.HTTPClient.(*http.Client); ok {
+		return hc.Timeout
+	}
+	return 0
+}
+
+// validateSettings checks runtime settings before they are applied
+func (c *DeepseekClient) validateSettings(s RuntimeSettings) error {
+	limit := c.attemptTimeout()
+	for _, timeout := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"summarize_timeout", s.SummarizeTimeout},
+		{"classify_timeout", s.ClassifyTimeout},
+		{"draft_timeout", s.DraftTimeout},
+	} {
+		if timeout.d <= 0 {
+			return fmt.Errorf("%s must be positive", timeout.name)
+		}
+		if limit > 0 && timeout.d > limit {
+			return fmt.Errorf("%s must not exceed the upstream request timeout of %s", timeout.name, limit)
+		}
+	}
+	for _, temp := range []struct {
+		name string
+		t    float64
+	}{
+		{"summarize_temperature", s.SummarizeTemperature},
+		{"classify_temperature", s.ClassifyTemperature},
+		{"draft_temperature", s.DraftTemperature},
+	} {
+		if temp.t < 0 || temp.t > maxTemperature {
+			return fmt.Errorf("%s must be between 0 and %g", temp.name, maxTemperature)
+		}
+	}
+	if s.ReviewThreshold < 0 || s.ReviewThreshold > 1 {
+		return fmt.Errorf("review_threshold must be between 0 and 1")
+	}
+	for _, retries := range []struct {
+		name string
+		n    int
+	}{
+		{"net_max_retries", s.Retry.NetMaxRetries},
+		{"server_max_retries", s.Retry.ServerMaxRetries},
+		{"rate_limit_max_retries", s.Retry.RateLimitMaxRetries},
+	} {
+		if retries.n < 0 || retries.n > maxRetriesSetting {
+			return fmt.Errorf("%s must be between 0 and %d", retries.name, maxRetriesSetting)
+		}
+	}
+	if s.Retry.MaxRetryAfter < 0 {
+		return fmt.Errorf("retry_after_max must not be negative")
+	}
+	return nil
+}
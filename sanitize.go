@@ -0,0 +1,499 @@
+package main
+
+import (
+	"html"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	htmlBreakPattern    = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|li|h[1-6]|tr)>`)
+	htmlTagPattern      = regexp.MustCompile(`<[^>]+>`)
+	mdImagePattern      = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLinkPattern       = regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`)
+	mdHeaderPattern     = regexp.MustCompile(`(?m)^\s{0,3}#{1,6}\s+`)
+	mdBoldPattern       = regexp.MustCompile(`(\*\*|__)(\S(?:.*?\S)?)(\*\*|__)`)
+	mdItalicStarPattern = regexp.MustCompile(`\*(\S(?:[^*]*?\S)?)\*`)
+	mdItalicUndPattern  = regexp.MustCompile(`(^|[^\w])_(\S(?:[^_]*?\S)?)_([^\w]|$)`)
+	mdCodePattern       = regexp.MustCompile("`([^`]*)`")
+	mdRulePattern       = regexp.MustCompile(`(?m)^\s*([-*_]\s*){3,}$`)
+	blankLinesPattern   = regexp.MustCompile(`\n{3,}`)
+)
+
+// toPlainText strips HTML tags and markdown formatting (headers, bold, italic,
+// links, inline code) from model output while preserving line breaks
+func toPlainText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	// HTML: turn block-level breaks into newlines before dropping tags
+	text = htmlBreakPattern.ReplaceAllString(text, "\n")
+	text = htmlTagPattern.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	// Markdown
+	text = mdImagePattern.ReplaceAllString(text, "$1")
+	text = mdLinkPattern.ReplaceAllString(text, "$1")
+	text = mdHeaderPattern.ReplaceAllString(text, "")
+	text = mdRulePattern.ReplaceAllString(text, "")
+	text = mdBoldPattern.ReplaceAllString(text, "$2")
+	text = mdItalicStarPattern.ReplaceAllString(text, "$1")
+	text = mdItalicUndPattern.ReplaceAllString(text, "$1$2$3")
+	text = mdCodePattern.ReplaceAllString(text, "$1")
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	text = blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text)
+}
+
+// defaultBoilerplatePatterns match conversational prefaces models put before
+// their output. Prefaces must end with a colon on the first line so sentences
+// that merely start with "Here is" are left alone. Sign-offs are not stripped
+// because in a drafted reply they are usually legitimate content.
+var defaultBoilerplatePatterns = []string{
+	`(?i)\A\s*(sure|certainly|of course|absolutely|okay|ok)[!,.]?\s*(here('s| is| are)\b[^\n:]{0,80})?:\s*`,
+	`(?i)\A\s*here('s| is| are) (a|an|the|your|my)\b[^\n:]{0,80}:\s*`,
+	`(?i)\A\s*(summary|draft|reply|response):\s*\n`,
+}
+
+// boilerplatePatternSeparator separates regexes in BOILERPLATE_PATTERNS
+const boilerplatePatternSeparator = "||"
+
+// compileBoilerplatePatterns compiles BOILERPLATE_PATTERNS, falling back to the
+// defaults when unset. It returns nil unless STRIP_BOILERPLATE is set.
+func compileBoilerplatePatterns() []*regexp.Regexp {
+	if !envBool("STRIP_BOILERPLATE", false) {
+		return nil
+	}
+	patterns := defaultBoilerplatePatterns
+	if spec := strings.TrimSpace(os.Getenv("BOILERPLATE_PATTERNS")); spec != "" {
+		patterns = strings.Split(spec, boilerplatePatternSeparator)
+	}
+	var compiled []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(strings.TrimSpace(p))
+		if err != nil {
+			log.Printf("Ignoring invalid boilerplate pattern %q: %v", p, err)
+			continue
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled
+}
+
+// stripBoilerplate removes a leading preface matched by one of patterns from
+// text. Only a match at the very start of the text (after whitespace) is
+// removed, so a pattern that is not anchored still cannot cut sentences out
+// of the body. A removal that would leave nothing behind is skipped, since
+// then the "boilerplate" was the content.
+func stripBoilerplate(text string, patterns []*regexp.Regexp) string {
+	for _, re := range patterns {
+		loc := re.FindStringIndex(text)
+		if loc == nil || strings.TrimSpace(text[:loc[0]]) != "" {
+			continue
+		}
+		if stripped := strings.TrimSpace(text[loc[1]:]); stripped != "" {
+			text = stripped
+		}
+	}
+	return text
+}
+
+// compileScratchpadDelimiter compiles SCRATCHPAD_DELIMITER into a
+// case-insensitive literal pattern; nil disables scratchpad stripping
+func compileScratchpadDelimiter() *regexp.Regexp {
+	delimiter := os.Getenv("SCRATCHPAD_DELIMITER")
+	if strings.TrimSpace(delimiter) == "" {
+		return nil
+	}
+	return regexp.MustCompile("(?i)" + regexp.QuoteMeta(delimiter))
+}
+
+// stripScratchpad returns the trimmed text after the first match of
+// delimiter, so reasoning the model wrote before its final answer is not
+// shown. Later occurrences are part of the answer and are kept. Text
+// without the delimiter is returned trimmed.
+func stripScratchpad(text string, delimiter *regexp.Regexp) string {
+	text = strings.TrimSpace(text)
+	if delimiter == nil {
+		return text
+	}
+	match := delimiter.FindStringIndex(text)
+	if match == nil {
+		return text
+	}
+	return strings.TrimSpace(text[match[1]:])
+}
+
+var (
+	htmlCommentPattern     = regexp.MustCompile(`(?s)<!--.*?-->|<![^>]*>`)
+	htmlUnsafeBlockPattern = regexp.MustCompile(`(?is)<(script|style|iframe|object|embed|template|noscript|svg|math)\b[^>]*>.*?</(script|style|iframe|object|embed|template|noscript|svg|math)\s*>`)
+)
+
+// safeHTMLTags are the elements kept by sanitizeHTML; all attributes are
+// dropped except href on links
+var safeHTMLTags = map[string]bool{
+	"p": true, "br": true, "strong": true, "b": true, "em": true, "i": true, "u": true,
+	"ul": true, "ol": true, "li": true, "a": true, "blockquote": true,
+}
+
+// unsafeHTMLBlocks are the elements removed together with their contents
+var unsafeHTMLBlocks = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"template": true, "noscript": true, "svg": true, "math": true, "textarea": true, "title": true,
+}
+
+// htmlTag is a start or end tag read by readHTMLTag
+type htmlTag struct {
+	name  string
+	end   bool
+	attrs map[string]string
+}
+
+// sanitizeHTML reduces model-written HTML to an allowlisted subset. The
+// input is tokenized rather than pattern-matched: the output is rebuilt from
+// the allowed tags, written out canonically with only a safe href kept on
+// links, and from text, which is always escaped. A "<" that does not start a
+// well-formed tag is therefore text, so fragments such as
+// "<<x>script>" cannot reassemble into markup. Scripts, styles and embedded
+// content are removed with their contents, comments are dropped, and other
+// tags are dropped keeping their text.
+func sanitizeHTML(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		lt := strings.IndexByte(text[i:], '<')
+		if lt < 0 {
+			b.WriteString(escapeHTMLText(text[i:]))
+			break
+		}
+		b.WriteString(escapeHTMLText(text[i : i+lt]))
+		i += lt
+		if n := htmlMarkupDeclarationLen(text[i:]); n > 0 {
+			i += n
+			continue
+		}
+		tag, n, ok := readHTMLTag(text[i:])
+		if !ok {
+			b.WriteString("&lt;")
+			i++
+			continue
+		}
+		i += n
+		switch {
+		case unsafeHTMLBlocks[tag.name] && !tag.end:
+			i += htmlBlockLen(text[i:], tag.name)
+		case !safeHTMLTags[tag.name]:
+		case tag.end:
+			b.WriteString("</" + tag.name + ">")
+		case tag.name == "a" && safeHref(tag.attrs["href"]) != "":
+			b.WriteString(`<a href="` + html.EscapeString(safeHref(tag.attrs["href"])) + `">`)
+		default:
+			b.WriteString("<" + tag.name + ">")
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// escapeHTMLText escapes text, decoding entities first so they are not
+// escaped twice
+func escapeHTMLText(text string) string {
+	return html.EscapeString(html.UnescapeString(text))
+}
+
+// htmlMarkupDeclarationLen returns the length of a comment, doctype or
+// processing instruction at the start of s, or 0 if there is none. An
+// unterminated one runs to the end of s.
+func htmlMarkupDeclarationLen(s string) int {
+	terminator := ">"
+	switch {
+	case strings.HasPrefix(s, "<!--"):
+		terminator = "-->"
+	case strings.HasPrefix(s, "<!"), strings.HasPrefix(s, "<?"):
+	default:
+		return 0
+	}
+	if end := strings.Index(s[2:], terminator); end >= 0 {
+		return 2 + end + len(terminator)
+	}
+	return len(s)
+}
+
+// readHTMLTag reads the start or end tag at the start of s, returning it
+// and its length. ok is false when s does not start with a well-formed tag.
+// Attribute values may be quoted with either quote and may contain ">".
+func readHTMLTag(s string) (tag htmlTag, n int, ok bool) {
+	i := 1
+	if i < len(s) && s[i] == '/' {
+		tag.end = true
+		i++
+	}
+	start := i
+	for i < len(s) && isHTMLNameByte(s[i], i == start) {
+		i++
+	}
+	if i == start {
+		return htmlTag{}, 0, false
+	}
+	tag.name = strings.ToLower(s[start:i])
+	tag.attrs = make(map[string]string)
+	for {
+		for i < len(s) && (isSpaceByte(s[i]) || s[i] == '/' || s[i] == '\f') {
+			i++
+		}
+		if i >= len(s) {
+			return htmlTag{}, 0, false
+		}
+		if s[i] == '>' {
+			return tag, i + 1, true
+		}
+		if s[i] == '<' || s[i] == '"' || s[i] == '\'' || s[i] == '=' {
+			return htmlTag{}, 0, false
+		}
+		nameStart := i
+		for i < len(s) && !isSpaceByte(s[i]) && !strings.ContainsRune("/>=<\"'", rune(s[i])) {
+			i++
+		}
+		name := strings.ToLower(s[nameStart:i])
+		for i < len(s) && isSpaceByte(s[i]) {
+			i++
+		}
+		var value string
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && isSpaceByte(s[i]) {
+				i++
+			}
+			if i >= len(s) {
+				return htmlTag{}, 0, false
+			}
+			if quote := s[i]; quote == '"' || quote == '\'' {
+				end := strings.IndexByte(s[i+1:], quote)
+				if end < 0 {
+					return htmlTag{}, 0, false
+				}
+				value = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				valueStart := i
+				for i < len(s) && !isSpaceByte(s[i]) && s[i] != '>' {
+					if s[i] == '<' || s[i] == '"' || s[i] == '\'' {
+						return htmlTag{}, 0, false
+					}
+					i++
+				}
+				value = s[valueStart:i]
+			}
+		}
+		if _, seen := tag.attrs[name]; !seen {
+			tag.attrs[name] = html.UnescapeString(value)
+		}
+	}
+}
+
+// isHTMLNameByte reports whether ch may appear in a tag name; names start
+// with a letter
+func isHTMLNameByte(ch byte, first bool) bool {
+	if ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' {
+		return true
+	}
+	return !first && isDigit(ch)
+}
+
+// htmlBlockLen returns the length of the contents and end tag of the
+// element name, whose start tag was just read from before s. An unclosed
+// element runs to the end of s.
+func htmlBlockLen(s, name string) int {
+	lower := strings.ToLower(s)
+	for offset := 0; ; {
+		i := strings.Index(lower[offset:], "</"+name)
+		if i < 0 {
+			return len(s)
+		}
+		i += offset
+		if tag, n, ok := readHTMLTag(s[i:]); ok && tag.end && tag.name == name {
+			return i + n
+		}
+		offset = i + 2
+	}
+}
+
+// safeHref returns href if it uses an allowed scheme, or "" otherwise
+func safeHref(href string) string {
+	href = strings.TrimSpace(href)
+	lower := strings.ToLower(href)
+	for _, scheme := range []string{"http://", "https://", "mailto:"} {
+		if strings.HasPrefix(lower, scheme) {
+			return href
+		}
+	}
+	return ""
+}
+
+var (
+	htmlImgPattern       = regexp.MustCompile(`(?is)<img\b[^>]*>`)
+	imgDimensionPattern  = regexp.MustCompile(`(?i)(?:^|[\s"';])(width|height)\s*[=:]\s*["']?\s*(\d+)`)
+	urlPattern           = regexp.MustCompile(`https?://[^\s"'<>]+`)
+	trackingParamPattern = regexp.MustCompile(`(?i)^(utm_[a-z_]+|mc_cid|mc_eid|fbclid|gclid|dclid|gbraid|wbraid|msclkid|yclid|igshid|mkt_tok|_hsenc|_hsmi|__hssc|__hstc|__hsfp|oly_anon_id|oly_enc_id|vero_id|vero_conv|trk_[a-z_]+)$`)
+)
+
+// trackingPixelMaxSize is the largest width or height, in pixels, of an image
+// treated as a tracking pixel
+const trackingPixelMaxSize = 2
+
+// stripTracking removes tracking pixels (images no more than
+// trackingPixelMaxSize pixels wide or high) and well-known tracking query
+// parameters from links. Links themselves are never removed and all other
+// query parameters are kept in their original order.
+func stripTracking(text string) string {
+	text = htmlImgPattern.ReplaceAllStringFunc(text, func(tag string) string {
+		for _, m := range imgDimensionPattern.FindAllStringSubmatch(tag, -1) {
+			if n, err := strconv.Atoi(m[2]); err == nil && n <= trackingPixelMaxSize {
+				return ""
+			}
+		}
+		return tag
+	})
+	return urlPattern.ReplaceAllStringFunc(text, stripTrackingParams)
+}
+
+// stripTrackingParams drops tracking parameters from the query of rawURL
+func stripTrackingParams(rawURL string) string {
+	base, query, ok := strings.Cut(rawURL, "?")
+	if !ok {
+		return rawURL
+	}
+	query, fragment, hasFragment := strings.Cut(query, "#")
+
+	// Links inside HTML attributes have their ampersands escaped
+	sep := "&"
+	if strings.Contains(query, "&amp;") {
+		sep = "&amp;"
+	}
+	var kept []string
+	for _, param := range strings.Split(query, sep) {
+		key, _, _ := strings.Cut(param, "=")
+		if param == "" || trackingParamPattern.MatchString(key) {
+			continue
+		}
+		kept = append(kept, param)
+	}
+
+	out := base
+	if len(kept) > 0 {
+		out += "?" + strings.Join(kept, sep)
+	}
+	if hasFragment {
+		out += "#" + fragment
+	}
+	return out
+}
+
+// defaultDisclaimerPatterns match phrases typical of legal disclaimers and
+// confidentiality footers
+var defaultDisclaimerPatterns = []string{
+	`(?i)\b(confidentiality notice|legal disclaimer|disclaimer)\s*:`,
+	`(?i)\bthis (e-?mail|message|communication|transmission)\b[^.]{0,80}\b(is|are|may be|contains?)\b[^.]{0,40}\b(confidential|privileged|intended (solely|only|exclusively) for)`,
+	`(?i)\bif you (are not the intended recipient|have received this (e-?mail|message|communication|transmission) (in error|by mistake))`,
+	`(?i)\bany (unauthori[sz]ed )?(review|use|disclosure|dissemination|distribution|copying)\b[^.]{0,80}\b(prohibited|forbidden|not permitted)`,
+	`(?i)\bplease consider the environment before printing`,
+}
+
+// disclaimerSeparatorPattern matches a line made only of rule characters,
+// often placed above a footer
+var disclaimerSeparatorPattern = regexp.MustCompile(`^\s*[-_=*~]{3,}\s*$`)
+
+// paragraphBreakPattern splits text at blank lines
+var paragraphBreakPattern = regexp.MustCompile(`\n\s*\n`)
+
+// compileDisclaimerPatterns compiles DISCLAIMER_PATTERNS, falling back to the
+// defaults when unset. It returns nil when STRIP_DISCLAIMERS is false.
+func compileDisclaimerPatterns() []*regexp.Regexp {
+	if !envBool("STRIP_DISCLAIMERS", false) {
+		return nil
+	}
+	patterns := defaultDisclaimerPatterns
+	if spec := strings.TrimSpace(os.Getenv("DISCLAIMER_PATTERNS")); spec != "" {
+		patterns = strings.Split(spec, boilerplatePatternSeparator)
+	}
+	var compiled []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(strings.TrimSpace(p))
+		if err != nil {
+			log.Printf("Ignoring invalid disclaimer pattern %q: %v", p, err)
+			continue
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled
+}
+
+// stripDisclaimers removes disclaimer footers from the end of text. Only
+// trailing paragraphs that match a pattern (and rule lines above them) are
+// dropped, working back from the end and stopping at the first paragraph that
+// does not match, so a disclaimer-like sentence inside the message is kept.
+// A matching paragraph keeps any text above a rule line in it. The first
+// paragraph is never removed.
+func stripDisclaimers(text string, patterns []*regexp.Regexp) string {
+	if len(patterns) == 0 {
+		return text
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	paragraphs := paragraphBreakPattern.Split(text, -1)
+	keep, split := len(paragraphs), false
+	for keep > 1 {
+		paragraph := paragraphs[keep-1]
+		if strings.TrimSpace(paragraph) == "" || disclaimerSeparatorPattern.MatchString(paragraph) {
+			keep--
+			continue
+		}
+		if !matchesAny(paragraph, patterns) {
+			break
+		}
+		// Content above a rule line in the same paragraph, such as a
+		// signature, is kept and ends the footer
+		if above, ok := aboveLastRule(paragraph); ok {
+			paragraphs[keep-1], split = above, true
+			break
+		}
+		keep--
+	}
+	if keep == len(paragraphs) && !split {
+		return text
+	}
+	// A trailing disclaimer often follows a rule line within the last kept paragraph
+	kept := paragraphs[:keep]
+	lines := strings.Split(kept[keep-1], "\n")
+	for len(lines) > 1 && disclaimerSeparatorPattern.MatchString(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+	kept[keep-1] = strings.Join(lines, "\n")
+	return strings.TrimRight(strings.Join(kept, "\n\n"), " \t\n")
+}
+
+// aboveLastRule returns the non-blank text of paragraph above its last rule
+// line, if it has one
+func aboveLastRule(paragraph string) (string, bool) {
+	lines := strings.Split(paragraph, "\n")
+	for i := len(lines) - 1; i > 0; i-- {
+		if disclaimerSeparatorPattern.MatchString(lines[i]) {
+			above := strings.TrimRight(strings.Join(lines[:i], "\n"), " \t\n")
+			return above, strings.TrimSpace(above) != ""
+		}
+	}
+	return "", false
+}
+
+// matchesAny reports whether text matches one of patterns
+func matchesAny(text string, patterns []*regexp.Regexp) bool {
+	for _, re := range patterns {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"safe subset kept", `<p>Hi <strong>there</strong><br/></p>`, `<p>Hi <strong>there</strong><br></p>`},
+		{"attributes dropped", `<p class="x" onclick="evil()">Hi</p>`, `<p>Hi</p>`},
+		{"script removed with contents", `<p>a</p><script>alert(1)</script><p>b</p>`, `<p>a</p><p>b</p>`},
+		{"unclosed script removed", `<p>a</p><script>alert(1)`, `<p>a</p>`},
+		{"unknown tag keeps text", `<div><span>text</span></div>`, `text`},
+		{"comment dropped", `a<!-- <script>x</script> -->b`, `ab`},
+		{"safe link kept", `<a href="https://example.com/?a=1&amp;b=2" target="_blank">x</a>`, `<a href="https://example.com/?a=1&amp;b=2">x</a>`},
+		{"javascript link stripped", `<a href="javascript:alert(1)">x</a>`, `<a>x</a>`},
+		{"quoted > in attribute", `<a title="a>b" href="mailto:a@b.c">x</a>`, `<a href="mailto:a@b.c">x</a>`},
+		{"nested script fragments", `<<x>script>alert(1)<<x>/script>`, `&lt;script&gt;alert(1)&lt;/script&gt;`},
+		{"nested img fragments", `<<x>img src=x onerror=alert(1)>`, `&lt;img src=x onerror=alert(1)&gt;`},
+		{"stray angle brackets escaped", `1 < 2 > 0`, `1 &lt; 2 &gt; 0`},
+		{"entities not double escaped", `Tom &amp; Jerry`, `Tom &amp; Jerry`},
+		{"uppercase tags", `<P>Hi</P><SCRIPT>x</SCRIPT>`, `<p>Hi</p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeHTML(tt.in); got != tt.want {
+				t.Errorf("sanitizeHTML(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+// allowedMarkupPattern matches every tag sanitizeHTML may emit
+var allowedMarkupPattern = regexp.MustCompile(`^<(/?(p|br|strong|b|em|i|u|ul|ol|li|a|blockquote)|a href="(https?://|mailto:)[^"<>]*")>`)
+
+func TestSanitizeHTMLNeverEmitsUnsafeMarkup(t *testing.T) {
+	payloads := []string{
+		`<<x>script>alert(1)<<x>/script>`,
+		`<<x>img src=x onerror=alert(1)>`,
+		`<scr<script>ipt>alert(1)</script>`,
+		`<img src=x onerror=alert(1)//`,
+		`<a href="jav&#x61;script:alert(1)">x</a>`,
+		`<svg/onload=alert(1)>`,
+		`<p <script>>x</p>`,
+		`<a href=" https://ok" onmouseover="x">y</a>`,
+	}
+	for _, payload := range payloads {
+		got := sanitizeHTML(payload)
+		for i := strings.IndexByte(got, '<'); i >= 0; {
+			if !allowedMarkupPattern.MatchString(got[i:]) {
+				t.Errorf("sanitizeHTML(%q) = %q, has unexpected markup at %d", payload, got, i)
+				break
+			}
+			next := strings.IndexByte(got[i+1:], '<')
+			if next < 0 {
+				break
+			}
+			i += next + 1
+		}
+	}
+}
+
+func TestDraftReplyBothFormatSanitized(t *testing.T) {
+	upstream := replying(`<p>Thanks!</p><script>alert(1)</script><p onclick="x()">See you</p>`)
+	c := newTestClient(t, upstream)
+
+	out, err := c.DraftReply(context.Background(), "Can we meet on Monday?", DraftOptions{Format: DraftFormatBoth})
+	if err != nil {
+		t.Fatalf("DraftReply: %v", err)
+	}
+	if want := "<p>Thanks!</p><p>See you</p>"; out.DraftHTML != want {
+		t.Errorf("DraftHTML = %q, want %q", out.DraftHTML, want)
+	}
+	if out.Draft == "" || strings.Contains(out.Draft, "<") || strings.Contains(out.Draft, "alert") {
+		t.Errorf("Draft = %q, want plain text without the script", out.Draft)
+	}
+}
+
+func TestStripBoilerplate(t *testing.T) {
+	defaults := make([]*regexp.Regexp, len(defaultBoilerplatePatterns))
+	for i, p := range defaultBoilerplatePatterns {
+		defaults[i] = regexp.MustCompile(p)
+	}
+	unanchored := []*regexp.Regexp{regexp.MustCompile(`(?i)here is (a|the) summary:\s*`)}
+	tests := []struct {
+		name     string
+		text     string
+		patterns []*regexp.Regexp
+		want     string
+	}{
+		{"sure preface", "Sure! Here is the summary:\nThe launch moves to Friday.", defaults, "The launch moves to Friday."},
+		{"here is preface", "Here's your draft:\n\nHi Ana, Monday works.", defaults, "Hi Ana, Monday works."},
+		{"label line", "Summary:\nThe launch moves to Friday.", defaults, "The launch moves to Friday."},
+		{"sentence starting with here is", "Here is the plan we agreed on yesterday.", defaults, "Here is the plan we agreed on yesterday."},
+		{"colon past the first line", "Here is the plan\nfor Monday: review.", defaults, "Here is the plan\nfor Monday: review."},
+		{"unanchored pattern in the body", "Ana wrote: here is a summary: sales grew.", unanchored, "Ana wrote: here is a summary: sales grew."},
+		{"unanchored pattern at the start", "Here is a summary: sales grew.", unanchored, "sales grew."},
+		{"preface is the whole text", "Sure! Here is the summary:", defaults, "Sure! Here is the summary:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripBoilerplate(tt.text, tt.patterns); got != tt.want {
+				t.Errorf("stripBoilerplate(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileBoilerplatePatterns(t *testing.T) {
+	tests := []struct {
+		env  string
+		want int
+	}{
+		{"", 0},
+		{"false", 0},
+		{"true", len(defaultBoilerplatePatterns)},
+	}
+	for _, tt := range tests {
+		t.Setenv("STRIP_BOILERPLATE", tt.env)
+		if got := len(compileBoilerplatePatterns()); got != tt.want {
+			t.Errorf("STRIP_BOILERPLATE=%q: %d patterns, want %d", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestStripScratchpad(t *testing.T) {
+	final := regexp.MustCompile(`(?i)final:`)
+	tests := []struct {
+		name      string
+		text      string
+		delimiter *regexp.Regexp
+		want      string
+	}{
+		{"no delimiter configured", "  Thinking. Final: Done.  ", nil, "Thinking. Final: Done."},
+		{"delimiter absent", "The launch moves to Friday.", final, "The launch moves to Friday."},
+		{"reasoning dropped", "The user wants a date.\nFinal: Friday.", final, "Friday."},
+		{"case-insensitive", "Reasoning here. FINAL: Friday.", final, "Friday."},
+		{"later occurrence kept", "Reasoning.\nFinal: The memo's heading reads Final: Q3 numbers.", final, "The memo's heading reads Final: Q3 numbers."},
+		{"nothing after delimiter", "Reasoning. Final:", final, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripScratchpad(tt.text, tt.delimiter); got != tt.want {
+				t.Errorf("stripScratchpad(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToPlainText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain text unchanged", "The launch moves to Friday.", "The launch moves to Friday."},
+		{"bold and italic", "The **launch** moves to *Friday* and __QA__ is _done_.", "The launch moves to Friday and QA is done."},
+		{"header", "## Summary\nThe launch moves.", "Summary\nThe launch moves."},
+		{"link and image", "See [the plan](https://example.com) ![chart](c.png).", "See the plan chart."},
+		{"inline code", "Run `make test` first.", "Run make test first."},
+		{"horizontal rule", "Part one\n---\nPart two", "Part one\n\nPart two"},
+		{"snake case kept", "Set max_retries to 3.", "Set max_retries to 3."},
+		{"html tags and entities", "<p>Tom &amp; Jerry</p><p>agree</p>", "Tom & Jerry\nagree"},
+		{"html line breaks", "First<br>Second<br/>Third", "First\nSecond\nThird"},
+		{"line breaks preserved", "Line one\r\nLine two\n\n\n\nLine three", "Line one\nLine two\n\nLine three"},
+		{"mixed", "<div>**Decision:** ship on *Friday*</div>\n- [ticket](http://x/1)", "Decision: ship on Friday\n\n- ticket"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := toPlainText(tt.in); got != tt.want {
+				t.Errorf("toPlainText(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizePlaintext(t *testing.T) {
+	const reply = "## Summary\n**Launch** moves to <b>Friday</b>.\nSee [QA notes](https://example.com)."
+	tests := []struct {
+		name      string
+		plaintext bool
+		want      string
+	}{
+		{"off", false, reply},
+		{"on", true, "Summary\nLaunch moves to Friday.\nSee QA notes."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestClient(t, replying(reply))
+			c.SummarizePlaintext = tt.plaintext
+			out, err := c.SummarizeEmail(context.Background(), "The launch moves to Friday because QA found a bug.", SummarizeOptions{})
+			if err != nil {
+				t.Fatalf("SummarizeEmail: %v", err)
+			}
+			if out.Summary != tt.want {
+				t.Errorf("Summary = %q, want %q", out.Summary, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripTracking(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"pixel attributes", `<p>Hi</p><img src="https://t.example.com/o.gif" width="1" height="1"><p>Bye</p>`, `<p>Hi</p><p>Bye</p>`},
+		{"pixel style", `<img style="width:1px;height:1px" src="https://t.example.com/o.gif">`, ``},
+		{"real image kept", `<img src="https://example.com/logo.png" width="120" height="40">`, `<img src="https://example.com/logo.png" width="120" height="40">`},
+		{"image without size kept", `<img src="https://example.com/logo.png">`, `<img src="https://example.com/logo.png">`},
+		{"tracking params dropped", `https://shop.example.com/sale?utm_source=news&id=42&fbclid=abc`, `https://shop.example.com/sale?id=42`},
+		{"only tracking params", `See https://example.com/post?utm_campaign=x&utm_medium=email now`, `See https://example.com/post now`},
+		{"escaped ampersands", `<a href="https://example.com/?a=1&amp;utm_source=x&amp;b=2">x</a>`, `<a href="https://example.com/?a=1&amp;b=2">x</a>`},
+		{"fragment kept", `https://example.com/doc?gclid=1&page=2#intro`, `https://example.com/doc?page=2#intro`},
+		{"link without query", `https://example.com/unsubscribe`, `https://example.com/unsubscribe`},
+		{"lookalike params kept", `https://example.com/?utm=1&source=news`, `https://example.com/?utm=1&source=news`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripTracking(tt.in); got != tt.want {
+				t.Errorf("stripTracking(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeStripTracking(t *testing.T) {
+	const email = `<p>Big sale this week!</p>` +
+		`<p><a href="https://shop.example.com/sale?utm_source=newsletter&amp;utm_medium=email&amp;ref=spring">Shop now</a></p>` +
+		`<img src="https://track.example.com/open.gif?u=123" width="1" height="1" alt="">`
+	tests := []struct {
+		name     string
+		enabled  string
+		present  []string
+		excluded []string
+	}{
+		{"off", "false", []string{"utm_source=newsletter", "open.gif"}, nil},
+		{"on", "true", []string{"https://shop.example.com/sale?ref=spring"}, []string{"utm_", "open.gif"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("STRIP_TRACKING", tt.enabled)
+			upstream := replying("A spring sale.")
+			c := newTestClient(t, upstream)
+			if _, err := c.SummarizeEmail(context.Background(), email, SummarizeOptions{}); err != nil {
+				t.Fatalf("SummarizeEmail: %v", err)
+			}
+			prompt := upstream.messages(0)
+			for _, s := range tt.present {
+				if !strings.Contains(prompt, s) {
+					t.Errorf("prompt %q does not contain %q", prompt, s)
+				}
+			}
+			for _, s := range tt.excluded {
+				if strings.Contains(prompt, s) {
+					t.Errorf("prompt %q still contains %q", prompt, s)
+				}
+			}
+		})
+	}
+}
+
+func TestStripDisclaimers(t *testing.T) {
+	defaults := make([]*regexp.Regexp, len(defaultDisclaimerPatterns))
+	for i, p := range defaultDisclaimerPatterns {
+		defaults[i] = regexp.MustCompile(p)
+	}
+	const body = "Hi team,\n\nThe launch moves to Friday because QA found a bug.\n\nThanks,\nAna"
+	tests := []struct {
+		name     string
+		text     string
+		patterns []*regexp.Regexp
+		want     string
+	}{
+		{"confidentiality notice", body + "\n\n-----\nCONFIDENTIALITY NOTICE: This e-mail and any attachments are confidential and intended solely for the addressee.\n\nIf you are not the intended recipient, please delete it and notify the sender.", defaults, body},
+		{"received in error with rule line", body + "\n____________________\nThis message may contain privileged information.\n\nIf you have received this email in error, please notify us immediately. Any unauthorized review, use or distribution is strictly prohibited.\n\nPlease consider the environment before printing this email.", defaults, body},
+		{"footer below the signature", body + "\n-----\nThis email is confidential and intended only for the addressee.", defaults, body},
+		{"no footer", body, defaults, body},
+		{"confidential sentence in the body kept", "This message is confidential: the launch moves to Friday.\n\nThe date is not public yet.\n\nThanks,\nAna", defaults, "This message is confidential: the launch moves to Friday.\n\nThe date is not public yet.\n\nThanks,\nAna"},
+		{"first paragraph never removed", "Disclaimer: I am not a lawyer.", defaults, "Disclaimer: I am not a lawyer."},
+		{"disabled", body + "\n\nCONFIDENTIALITY NOTICE: do not forward.", nil, body + "\n\nCONFIDENTIALITY NOTICE: do not forward."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripDisclaimers(tt.text, tt.patterns); got != tt.want {
+				t.Errorf("stripDisclaimers() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeStripDisclaimers(t *testing.T) {
+	const email = "The launch moves to Friday.\n\nLEGAL DISCLAIMER: This communication is confidential and intended only for the named recipient."
+	tests := []struct {
+		name     string
+		enabled  string
+		patterns string
+		present  bool
+	}{
+		{"off", "false", "", true},
+		{"on", "true", "", false},
+		{"custom patterns", "true", `(?i)^internal use only||(?i)do not forward`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("STRIP_DISCLAIMERS", tt.enabled)
+			t.Setenv("DISCLAIMER_PATTERNS", tt.patterns)
+			upstream := replying("The launch moves to Friday.")
+			c := newTestClient(t, upstream)
+			if _, err := c.SummarizeEmail(context.Background(), email, SummarizeOptions{}); err != nil {
+				t.Fatalf("SummarizeEmail: %v", err)
+			}
+			prompt := upstream.messages(0)
+			if !strings.Contains(prompt, "The launch moves to Friday.") {
+				t.Errorf("prompt %q lost the message body", prompt)
+			}
+			if got := strings.Contains(prompt, "LEGAL DISCLAIMER"); got != tt.present {
+				t.Errorf("disclaimer in prompt = %v, want %v", got, tt.present)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSeedParameter(t *testing.T) {
+	operations := []struct {
+		name    string
+		reply   string
+		handler func(s *Server) http.HandlerFunc
+		target  string
+		body    string
+	}{
+		{"classify", `{"labels":[{"label":"urgent","score":0.9}]}`, func(s *Server) http.HandlerFunc { return s.ClassifyHandler }, "/classify", `{"emails":[{"id":"1","content":"The server is down again"}]}`},
+		{"summarize", "The server is down.", func(s *Server) http.HandlerFunc { return s.SummarizeHandler }, "/summarize", `{"body":"The server is down again"}`},
+		{"draft", "We are on it.", func(s *Server) http.HandlerFunc { return s.DraftHandler }, "/draft", `{"body":"The server is down again"}`},
+	}
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		want   interface{} // seed sent upstream; nil when omitted
+	}{
+		{"omitted", "", http.StatusOK, nil},
+		{"provided", "?seed=42", http.StatusOK, 42.0},
+		{"zero", "?seed=0", http.StatusOK, 0.0},
+		{"negative", "?seed=-7", http.StatusOK, -7.0},
+		{"not an integer", "?seed=lucky", http.StatusBadRequest, nil},
+	}
+	for _, op := range operations {
+		for _, tt := range tests {
+			op, tt := op, tt
+			t.Run(op.name+"/"+tt.name, func(t *testing.T) {
+				upstream := replying(op.reply)
+				s := newTestServer(t, upstream)
+				rec := httptest.NewRecorder()
+				SeedOverride(op.handler(s)).ServeHTTP(rec, postJSON(op.target+tt.query, op.body))
+				if rec.Code != tt.status {
+					t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+				}
+				if tt.status != http.StatusOK {
+					if upstream.calls() != 0 {
+						t.Errorf("rejected request called upstream")
+					}
+					return
+				}
+				seed, ok := upstream.body(0)["seed"]
+				if tt.want == nil {
+					if ok {
+						t.Errorf("seed = %v, want it omitted", seed)
+					}
+				} else if seed != tt.want {
+					t.Errorf("seed = %v, want %v", seed, tt.want)
+				}
+			})
+		}
+	}
+}
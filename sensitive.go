@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// SensitivePattern is a named regex signalling regulated data (PCI, HIPAA).
+// It also holds the no-reply heuristics used before drafting.
+type SensitivePattern struct {
+	Name    string
+	Pattern *regexp.Regexp
+	// Valid, when set, rejects regex matches that are not the data sought
+	Valid func(match string) bool
+}
+
+// paymentCardPattern names the pattern whose matches must pass the Luhn check
+const paymentCardPattern = "payment_card"
+
+// newSensitivePattern names re; payment card matches are Luhn-checked so
+// order numbers and other digit runs are not mistaken for cards
+func newSensitivePattern(name string, re *regexp.Regexp) SensitivePattern {
+	p := SensitivePattern{Name: name, Pattern: re}
+	if name == paymentCardPattern {
+		p.Valid = luhnValid
+	}
+	return p
+}
+
+// MatchString reports whether content contains a valid match of p
+func (p SensitivePattern) MatchString(content string) bool {
+	if p.Valid == nil {
+		return p.Pattern.MatchString(content)
+	}
+	for _, match := range p.Pattern.FindAllString(content, -1) {
+		if p.Valid(match) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplaceAllString replaces the valid matches of p in content with repl
+func (p SensitivePattern) ReplaceAllString(content, repl string) string {
+	if p.Valid == nil {
+		return p.Pattern.ReplaceAllString(content, repl)
+	}
+	return p.Pattern.ReplaceAllStringFunc(content, func(match string) string {
+		if p.Valid(match) {
+			return repl
+		}
+		return match
+	})
+}
+
+// luhnValid reports whether the digits in number pass the Luhn checksum used
+// by payment card numbers; separators are ignored
+func luhnValid(number string) bool {
+	sum, digits := 0, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+	}
+	return digits > 0 && sum%10 == 0
+}
+
+// defaultSensitivePatterns flag payment card numbers, US social security
+// numbers and medical record identifiers, as name=regex. Card numbers must
+// also pass the Luhn check.
+var defaultSensitivePatterns = []string{
+	`payment_card=\b(?:4\d{3}|5[1-5]\d{2}|2[2-7]\d{2}|6011|65\d{2})(?:[ -]?\d{4}){3}\b|\b3[47]\d{2}[ -]?\d{6}[ -]?\d{5}\b`,
+	`us_ssn=\b\d{3}-\d{2}-\d{4}\b`,
+	`medical_record=(?i)\b(medical record (number|no\.?|#)|MRN|patient (id|identifier)|ICD-?10)\b`,
+}
+
+// compileSensitivePatterns compiles SENSITIVE_PATTERNS ("||"-separated
+// name=regex entries), falling back to the defaults when unset. It returns nil
+// when REFUSE_SENSITIVE is false.
+func compileSensitivePatterns() []SensitivePattern {
+	if !envBool("REFUSE_SENSITIVE", false) {
+		return nil
+	}
+	specs := defaultSensitivePatterns
+	if spec := strings.TrimSpace(os.Getenv("SENSITIVE_PATTERNS")); spec != "" {
+		specs = strings.Split(spec, boilerplatePatternSeparator)
+	}
+	return compileNamedPatterns("sensitive", specs)
+}
+
+// compileNamedPatterns compiles name=regex entries, logging and skipping
+// malformed ones; kind names the setting in log messages
+func compileNamedPatterns(kind string, specs []string) []SensitivePattern {
+	var compiled []SensitivePattern
+	for _, spec := range specs {
+		name, expr, ok := strings.Cut(strings.TrimSpace(spec), "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			log.Printf("Ignoring %s pattern %q: expected name=regex", kind, spec)
+			continue
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			log.Printf("Ignoring invalid %s pattern %q: %v", kind, name, err)
+			continue
+		}
+		compiled = append(compiled, newSensitivePattern(strings.TrimSpace(name), re))
+	}
+	return compiled
+}
+
+// detectSensitive returns the names of the patterns content matches
+func detectSensitive(content string, patterns []SensitivePattern) []string {
+	var matched []string
+	for _, p := range patterns {
+		if p.MatchString(content) {
+			matched = append(matched, p.Name)
+		}
+	}
+	return matched
+}
+
+// refuseSensitive writes a 422 and returns true when content contains
+// regulated data, so it is never sent upstream
+func (s *Server) refuseSensitive(w http.ResponseWriter, r *http.Request, content string) bool {
+	matched := detectSensitive(content, s.sensitivePatterns)
+	if len(matched) == 0 {
+		return false
+	}
+	log.Printf("[%s] Refused content containing regulated data (%s)", requestIDFromContext(r.Context()), strings.Join(matched, ", "))
+	JSONError(w, fmt.Sprintf("Content refused: it appears to contain regulated data (%s) and this deployment does not process it", strings.Join(matched, ", ")), http.StatusUnprocessableEntity)
+	return true
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLuhnValid(t *testing.T) {
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{"4111111111111111", true},
+		{"4111 1111 1111 1111", true},
+		{"5500-0000-0000-0004", true},
+		{"378282246310005", true},
+		{"4111111111111112", false},
+		{"4000 1234 5678 9012", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := luhnValid(tt.number); got != tt.want {
+			t.Errorf("luhnValid(%q) = %v, want %v", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestDetectSensitive(t *testing.T) {
+	t.Setenv("REFUSE_SENSITIVE", "true")
+	patterns := compileSensitivePatterns()
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"clean", "Can we meet on Friday?", nil},
+		{"valid card", "My card is 4111 1111 1111 1111, please charge it.", []string{paymentCardPattern}},
+		{"card-shaped order number", "Order 4000 1234 5678 9012 has shipped.", nil},
+		{"invalid then valid card", "Not 4111111111111112 but 4111111111111111.", []string{paymentCardPattern}},
+		{"ssn", "SSN 123-45-6789", []string{"us_ssn"}},
+		{"medical record", "Patient ID attached", []string{"medical_record"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := detectSensitive(tt.content, patterns)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("detectSensitive(%q) = %v, want %v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefuseSensitive(t *testing.T) {
+	tests := []struct {
+		name    string
+		refuse  string
+		content string
+		status  int
+	}{
+		{"flagged", "true", "Please charge card 4111 1111 1111 1111 for the renewal.", http.StatusUnprocessableEntity},
+		{"clean", "true", "Please renew the subscription for another year.", http.StatusOK},
+		{"disabled", "false", "Please charge card 4111 1111 1111 1111 for the renewal.", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("REFUSE_SENSITIVE", tt.refuse)
+			upstream := replying("The customer asks to renew.")
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/summarize", strings.NewReader(tt.content))
+			s.SummarizeHandler(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if refused := tt.status != http.StatusOK; refused && upstream.calls() != 0 {
+				t.Errorf("refused content was sent upstream")
+			}
+		})
+	}
+}
+
+func TestDebugRedactsValidCards(t *testing.T) {
+	d := NewDebugRecorder(1, 1, 1)
+	got := d.redactText("Card 4111 1111 1111 1111, order 4000 1234 5678 9012, mail ana@example.com")
+	want := "Card [REDACTED:payment_card], order 4000 1234 5678 9012, mail [REDACTED:email]"
+	if got != want {
+		t.Errorf("redactText = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// shortMessageLabel classifies content too short to be worth a model call.
+// It is not part of the taxonomy and is returned even when custom labels are set.
+const shortMessageLabel = "short_message"
+
+// minContentLength returns the per-operation threshold for name, read from
+// <NAME>_MIN_CONTENT_LENGTH with MIN_CONTENT_LENGTH as the default; 0 disables it
+func minContentLength(name string) int {
+	return envNonNegativeInt(name+"_MIN_CONTENT_LENGTH", envNonNegativeInt("MIN_CONTENT_LENGTH", 0))
+}
+
+// contentTooShort reports whether content, as plain text, has fewer than
+// minLength characters
+func contentTooShort(content string, minLength int) bool {
+	return minLength > 0 && len([]rune(strings.TrimSpace(toPlainText(content)))) < minLength
+}
+
+// shortContentWarning explains a response produced without calling the model
+func shortContentWarning(minLength int) string {
+	return fmt.Sprintf("content is shorter than %d characters; the model was not called", minLength)
+}
+
+// shortSummary returns content as its own summary, since there is nothing to condense
+func shortSummary(content string, minLength int) *SummaryResponse {
+	return &SummaryResponse{
+		Summary:  strings.TrimSpace(toPlainText(content)),
+		Metadata: &ResponseMetadata{ProcessedAt: processedAt(), Warnings: []string{shortContentWarning(minLength)}},
+	}
+}
+
+// cannedDraft returns DegradedDraftText as the only draft in the requested format
+func (c *DeepseekClient) cannedDraft(format string) *DraftResponse {
+	draft := c.DegradedDraftText
+	if format == DraftFormatHTML || format == DraftFormatBoth {
+		draft = "<p>" + html.EscapeString(draft) + "</p>"
+	}
+	return newDraftResponse([]string{draft}, DraftOptions{Format: format})
+}
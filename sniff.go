@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Kinds of raw request body told apart by sniffBody
+const (
+	bodyKindText = "text"
+	bodyKindJSON = "json"
+	bodyKindHTML = "html"
+)
+
+// htmlSniffPattern matches markup that marks a body as HTML rather than text
+// that merely mentions a tag
+var htmlSniffPattern = regexp.MustCompile(`(?i)<!doctype html|<(html|head|body|div|p|br|span|table|tr|td|a|img|ul|ol|li|h[1-6]|strong|em|b|i|blockquote|font|center)\b[^>]*>`)
+
+// sniffBody guesses what a raw body holds: a JSON object with a string "body"
+// field or a "thread" array (the structured email shapes), HTML markup, or
+// plain text
+func sniffBody(body []byte) string {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(trimmed, &fields) == nil {
+			var text string
+			if raw, ok := fields["body"]; ok && json.Unmarshal(raw, &text) == nil {
+				return bodyKindJSON
+			}
+			var thread []json.RawMessage
+			if raw, ok := fields["thread"]; ok && json.Unmarshal(raw, &thread) == nil {
+				return bodyKindJSON
+			}
+		}
+	}
+	if htmlSniffPattern.Match(trimmed) {
+		return bodyKindHTML
+	}
+	return bodyKindText
+}
+
+// htmlToText turns an HTML email into plain text for the model: comments,
+// scripts, styles and embedded content are dropped, block-level breaks become
+// newlines and the remaining tags are removed
+func htmlToText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = htmlCommentPattern.ReplaceAllString(text, "")
+	text = htmlUnsafeBlockPattern.ReplaceAllString(text, "")
+	text = htmlBreakPattern.ReplaceAllString(text, "\n")
+	text = htmlTagPattern.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text = blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text)
+}
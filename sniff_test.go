@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSniffBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"plain text", "Hi team, the launch moves to Friday.", bodyKindText},
+		{"structured email", `{"subject":"Launch","body":"Moves to Friday"}`, bodyKindJSON},
+		{"thread", `{"thread":[{"body":"First"}]}`, bodyKindJSON},
+		{"json without body", `{"subject":"Launch"}`, bodyKindText},
+		{"json with non-string body", `{"body":42}`, bodyKindText},
+		{"html document", "<!DOCTYPE html><html><body><p>Hi</p></body></html>", bodyKindHTML},
+		{"html fragment", "<div>Hi <b>team</b></div>", bodyKindHTML},
+		{"text mentioning a tag", "Use the <summary> element for this.", bodyKindText},
+		{"comparison", "if a < b and c > d", bodyKindText},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sniffBody([]byte(tt.body)); got != tt.want {
+				t.Errorf("sniffBody(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeSniffing(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		body    string
+		sent    string
+		notSent string
+	}{
+		{"default sends raw html as is", "", "<div>Hi <b>team</b></div>", "<b>team</b>", ""},
+		{"enabled reduces html to text", "true", "<div>Hi <b>team</b></div>", "Hi team", "<b>"},
+		{"enabled reads structured json", "true", `{"subject":"Launch","body":"Moves to Friday"}`, "Subject: Launch", `"body"`},
+		{"default sends json text as is", "", `{"subject":"Launch","body":"Moves to Friday"}`, `"body"`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.env != "" {
+				t.Setenv("SNIFF_REQUEST_BODY", tt.env)
+			}
+			upstream := replying("A summary.")
+			s := newTestServer(t, upstream)
+			req := httptest.NewRequest(http.MethodPost, "/summarize", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "text/plain")
+			rec := httptest.NewRecorder()
+			s.SummarizeHandler(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			sent := upstream.messages(0)
+			if !strings.Contains(sent, tt.sent) {
+				t.Errorf("upstream prompt %q does not contain %q", sent, tt.sent)
+			}
+			if tt.notSent != "" && strings.Contains(sent, tt.notSent) {
+				t.Errorf("upstream prompt %q contains %q", sent, tt.notSent)
+			}
+		})
+	}
+}
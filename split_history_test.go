@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSplitQuotedHistory(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		latest  string
+		history string
+	}{
+		{"no history", "Friday works.", "Friday works.", ""},
+		{"angle quotes", "Friday works.\n> Can we meet?", "Friday works.\n", "> Can we meet?"},
+		{"attribution line", "Friday works.\nOn Mon, Ana wrote:\nCan we meet?", "Friday works.\n", "On Mon, Ana wrote:\nCan we meet?"},
+		{"original message", "Friday works.\n-----Original Message-----\nCan we meet?", "Friday works.\n", "-----Original Message-----\nCan we meet?"},
+		{"quote marker mid-line", "Use a > b here.", "Use a > b here.", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			latest, history := splitQuotedHistory(tt.content)
+			if latest != tt.latest || history != tt.history {
+				t.Errorf("splitQuotedHistory(%q) = %q, %q, want %q, %q", tt.content, latest, history, tt.latest, tt.history)
+			}
+		})
+	}
+}
+
+func TestSummarizeSplitHistory(t *testing.T) {
+	const email = `Friday works.\n> Can we meet this week?`
+	tests := []struct {
+		name  string
+		query string
+		body  string
+		want  bool
+	}{
+		{"off", "", `{"body":"` + email + `"}`, false},
+		{"query", "?split_history=true", `{"body":"` + email + `"}`, true},
+		{"json", "", `{"body":"` + email + `","split_history":true}`, true},
+		{"query with json false", "?split_history=true", `{"body":"` + email + `","split_history":false}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, replying("A meeting on Friday."))
+			rec := httptest.NewRecorder()
+			s.SummarizeHandler(rec, postJSON("/summarize"+tt.query, tt.body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+			}
+			var resp SummaryResponse
+			decodeResponse(t, rec, &resp)
+			if got := resp.LatestSummary != ""; got != tt.want {
+				t.Errorf("latest_summary present = %v, want %v (response %+v)", got, tt.want, resp)
+			}
+			if got := resp.Summary != ""; got == tt.want {
+				t.Errorf("summary present = %v, want %v", got, !tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// StreamingRequiredError is returned when the upstream rejects a
+// non-streaming request because it only serves streamed completions
+type StreamingRequiredError struct {
+	Model string
+	Body  string
+}
+
+func (e *StreamingRequiredError) Error() string {
+	return fmt.Sprintf("upstream requires streaming for model %s: %s", e.Model, e.Body)
+}
+
+// streamingRequiredPattern matches the messages gateways send when they
+// reject stream:false
+var streamingRequiredPattern = regexp.MustCompile(`(?i)stream(ing)?\s+(mode\s+)?(is\s+)?(required|mandatory)|stream\s+must\s+be\s+(set\s+to\s+)?true|only\s+supports?\s+stream(ing|ed)?|stream(ing)?[\s-]only`)
+
+// isStreamingRequiredError reports whether an upstream error response
+// rejects the request for not streaming
+func isStreamingRequiredError(status int, body []byte) bool {
+	return status >= http.StatusBadRequest && status < http.StatusInternalServerError &&
+		status != http.StatusTooManyRequests && streamingRequiredPattern.Match(body)
+}
+
+// chatStreamChunk is one server-sent event of a streamed chat completion
+type chatStreamChunk struct {
+	Choices []struct {
+		Index int `json:"index"`
+		Delta struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *chatUsage `json:"usage"`
+	Error *APIError  `json:"error"`
+}
+
+// parseStreamedChat accumulates the server-sent events of a streamed chat
+// completion into the response a non-streaming request would have returned
+func parseStreamedChat(body []byte) (*chatResponse, error) {
+	choices := make(map[int]*chatChoice)
+	content := make(map[int]*strings.Builder)
+	var usage chatUsage
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64<<10), len(body)+1)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+		if data == "" {
+			continue
+		}
+		var chunk chatStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("failed to decode stream event: %w", err)
+		}
+		if chunk.Error != nil {
+			return nil, chunk.Error
+		}
+		if chunk.Usage != nil {
+			usage = *chunk.Usage
+		}
+		for _, delta := range chunk.Choices {
+			choice, ok := choices[delta.Index]
+			if !ok {
+				choice = &chatChoice{Index: delta.Index, Message: chatMessage{Role: "assistant"}}
+				choices[delta.Index] = choice
+				content[delta.Index] = &strings.Builder{}
+			}
+			if delta.Delta.Role != "" {
+				choice.Message.Role = delta.Delta.Role
+			}
+			content[delta.Index].WriteString(delta.Delta.Content)
+			if delta.FinishReason != nil {
+				choice.FinishReason = *delta.FinishReason
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read stream: %w", err)
+	}
+
+	cr := &chatResponse{Choices: make([]chatChoice, 0, len(choices)), Usage: usage}
+	for index, choice := range choices {
+		choice.Message.Content = content[index].String()
+		cr.Choices = append(cr.Choices, *choice)
+	}
+	sort.Slice(cr.Choices, func(i, j int) bool { return cr.Choices[i].Index < cr.Choices[j].Index })
+	return cr, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// sseReply is a streamed completion of "Hello there." in two chunks
+const sseReply = "data: {\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"Hello \"}}]}\n\n" +
+	"data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"there.\"},\"finish_reason\":\"stop\"}]}\n\n" +
+	"data: {\"choices\":[],\"usage\":{\"total_tokens\":12}}\n\n" +
+	"data: [DONE]\n\n"
+
+// streamOnlyUpstream rejects non-streaming requests for streamOnlyModel and
+// answers the rest, streamed or not, with "Hello there."
+func streamOnlyUpstream(streamOnlyModel string) *fakeUpstream {
+	return &fakeUpstream{reply: func(_ int, _ *http.Request, body map[string]interface{}) (*http.Response, error) {
+		if body["stream"] == true {
+			resp := newResponse(http.StatusOK, sseReply)
+			resp.Header.Set("Content-Type", "text/event-stream")
+			return resp, nil
+		}
+		if body["model"] == streamOnlyModel {
+			return newResponse(http.StatusBadRequest, `{"error":{"message":"stream must be set to true for this model"}}`), nil
+		}
+		return chatReply("Hello there."), nil
+	}}
+}
+
+func TestParseStreamedChat(t *testing.T) {
+	cr, err := parseStreamedChat([]byte(sseReply))
+	if err != nil {
+		t.Fatalf("parseStreamedChat: %v", err)
+	}
+	if len(cr.Choices) != 1 || cr.Choices[0].Message.Content != "Hello there." || cr.Choices[0].FinishReason != "stop" {
+		t.Errorf("choices = %+v, want one \"Hello there.\" choice", cr.Choices)
+	}
+	if cr.Usage.TotalTokens != 12 {
+		t.Errorf("usage = %d, want 12", cr.Usage.TotalTokens)
+	}
+}
+
+func TestStreamFallback(t *testing.T) {
+	t.Setenv("UPSTREAM_STREAM_FALLBACK", "true")
+	upstream := streamOnlyUpstream("deepseek-chat")
+	c := newTestClient(t, upstream)
+	ctx := context.Background()
+	request := chatRequest{Messages: []chatMessage{{Role: "user", Content: "Hi"}}}
+
+	steps := []struct {
+		model  string
+		calls  int
+		stream []bool
+	}{
+		{"deepseek-chat", 2, []bool{false, true}},
+		{"deepseek-chat", 1, []bool{true}},
+		{"deepseek-reasoner", 1, []bool{false}},
+	}
+	for i, step := range steps {
+		if err := c.SetModel(step.model); err != nil {
+			t.Fatal(err)
+		}
+		before := upstream.calls()
+		cr, err := c.chat(ctx, request)
+		if err != nil {
+			t.Fatalf("step %d: chat: %v", i, err)
+		}
+		if got := cr.Choices[0].Message.Content; got != "Hello there." {
+			t.Errorf("step %d: content = %q, want the accumulated reply", i, got)
+		}
+		if got := upstream.calls() - before; got != step.calls {
+			t.Fatalf("step %d: upstream calls = %d, want %d", i, got, step.calls)
+		}
+		for j, want := range step.stream {
+			if got := upstream.body(before + j)["stream"] == true; got != want {
+				t.Errorf("step %d call %d: stream = %v, want %v", i, j, got, want)
+			}
+		}
+	}
+}
+
+func TestStreamFallbackDisabledByDefault(t *testing.T) {
+	upstream := streamOnlyUpstream("deepseek-chat")
+	c := newTestClient(t, upstream)
+	_, err := c.chat(context.Background(), chatRequest{Messages: []chatMessage{{Role: "user", Content: "Hi"}}})
+	var streamErr *StreamingRequiredError
+	if !errors.As(err, &streamErr) || !strings.Contains(err.Error(), "deepseek-chat") {
+		t.Errorf("error = %v, want a StreamingRequiredError", err)
+	}
+	if upstream.calls() != 1 {
+		t.Errorf("upstream calls = %d, want 1", upstream.calls())
+	}
+}
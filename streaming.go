@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// defaultSummarizeChunkBytes is the size of each piece of a streamed body
+// summarized on its own
+const defaultSummarizeChunkBytes = 16 << 10
+
+// Defaults for the most a streamed body may hold, so one request cannot run
+// up an unbounded number of model calls
+const (
+	defaultSummarizeStreamMaxChunks = 64
+	defaultSummarizeStreamMaxBytes  = 1 << 20
+)
+
+// chunkSummarySystemPrompt asks for a summary of one part of a long email
+const chunkSummarySystemPrompt = "You are an assistant that summarizes one part of a long email. Return a concise summary of this part in plain text."
+
+// combineSummariesSystemPrompt asks for one summary from the summaries of consecutive parts
+const combineSummariesSystemPrompt = "You are an assistant that combines summaries of consecutive parts of one long email into a single concise summary of the whole email in plain text."
+
+// chunkReader splits a stream into chunks of at most maxBytes, preferring to
+// cut at paragraph, line, sentence or word boundaries. It buffers at most one
+// chunk, so memory stays bounded however long the stream is.
+type chunkReader struct {
+	r        io.Reader
+	maxBytes int
+	buf      []byte
+	eof      bool
+	// beforeRead is called before every read, e.g. to extend a read deadline
+	beforeRead func()
+}
+
+// Next returns the next chunk, or io.EOF once the stream is exhausted
+func (c *chunkReader) Next() (string, error) {
+	for !c.eof && len(c.buf) < c.maxBytes {
+		if c.beforeRead != nil {
+			c.beforeRead()
+		}
+		if cap(c.buf) < c.maxBytes {
+			grown := make([]byte, len(c.buf), c.maxBytes)
+			copy(grown, c.buf)
+			c.buf = grown
+		}
+		n, err := c.r.Read(c.buf[len(c.buf):c.maxBytes])
+		c.buf = c.buf[:len(c.buf)+n]
+		if err == io.EOF {
+			c.eof = true
+		} else if err != nil {
+			return "", err
+		}
+	}
+	if len(c.buf) == 0 {
+		return "", io.EOF
+	}
+
+	cut := len(c.buf)
+	if !c.eof {
+		cut = chunkBoundary(c.buf)
+	}
+	chunk := string(c.buf[:cut])
+	c.buf = append(c.buf[:0], c.buf[cut:]...)
+	return chunk, nil
+}
+
+// chunkBoundary returns where to cut a full buffer: after the last paragraph
+// break, line break, sentence end or space in its second half, or at the last
+// rune boundary when there is none
+func chunkBoundary(buf []byte) int {
+	half := len(buf) / 2
+	for _, sep := range []string{"\n\n", "\n", ". ", " "} {
+		if i := bytes.LastIndex(buf, []byte(sep)); i >= half {
+			return i + len(sep)
+		}
+	}
+	cut := len(buf)
+	for cut > 0 && !utf8.RuneStart(buf[cut-1]) {
+		cut--
+	}
+	if cut > 0 && utf8.RuneStart(buf[cut-1]) && !utf8.FullRune(buf[cut-1:]) {
+		cut--
+	}
+	if cut == 0 {
+		return len(buf)
+	}
+	return cut
+}
+
+// SummarizeChunk summarizes one part of a long email
+func (c *DeepseekClient) SummarizeChunk(ctx context.Context, chunk string) (string, error) {
+	ctx, cancel := withTimeout(ctx, c.Settings().SummarizeTimeout)
+	defer cancel()
+	summary, _, err := c.summarizeText(ctx, c.fitContent(ctx, chunk), chunkSummarySystemPrompt, 0)
+	return summary, err
+}
+
+// CombineSummaries merges the summaries of consecutive parts into one summary.
+// A single part is returned as is, without another model call.
+func (c *DeepseekClient) CombineSummaries(ctx context.Context, partials []string, opts SummarizeOptions) (*SummaryResponse, error) {
+	if len(partials) == 1 {
+		summary := partials[0]
+		if opts.MaxWords > 0 {
+			summary = truncateWords(summary, opts.MaxWords)
+		}
+		return &SummaryResponse{Summary: summary}, nil
+	}
+	ctx, cancel := withTimeout(ctx, c.Settings().SummarizeTimeout)
+	defer cancel()
+
+	var parts strings.Builder
+	for i, partial := range partials {
+		fmt.Fprintf(&parts, "Part %d: %s\n\n", i+1, partial)
+	}
+	content := c.fitContent(ctx, parts.String())
+	summary, choice, err := c.summarizeText(ctx, content, combineSummariesSystemPrompt, opts.MaxWords)
+	if err != nil {
+		return nil, err
+	}
+	return &SummaryResponse{
+		Summary:  summary,
+		Metadata: c.responseMetadata(ctx, choice, content),
+	}, nil
+}
+
+// StreamSummaryEvent is one line of the NDJSON response of a streamed
+// summarization: a partial summary per chunk, then the final summary
+type StreamSummaryEvent struct {
+	Chunk          int               `json:"chunk,omitempty"`
+	PartialSummary string            `json:"partial_summary,omitempty"`
+	Summary        string            `json:"summary,omitempty"`
+	Chunks         int               `json:"chunks,omitempty"`
+	Metadata       *ResponseMetadata `json:"metadata,omitempty"`
+	Error          string            `json:"error,omitempty"`
+}
+
+// streamSummarize handles POST /summarize?stream_input=true. The body is read
+// and summarized chunk by chunk as it arrives, each partial summary is
+// written as an NDJSON line as soon as it is ready, and a final line carries
+// the combined summary. A body over SUMMARIZE_STREAM_MAX_BYTES (after
+// decompression) or SUMMARIZE_STREAM_MAX_CHUNKS chunks is rejected with 413,
+// or with an error line once partial summaries have been sent.
+func (s *Server) streamSummarize(w http.ResponseWriter, r *http.Request, opts SummarizeOptions) {
+	client, err := s.clientFor(r)
+	if err != nil {
+		JSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	maxChunks := envInt("SUMMARIZE_STREAM_MAX_CHUNKS", defaultSummarizeStreamMaxChunks)
+	maxBytes := envInt("SUMMARIZE_STREAM_MAX_BYTES", defaultSummarizeStreamMaxBytes)
+	tooLarge := fmt.Sprintf("Request body too large: stream_input accepts at most %d bytes in %d chunks", maxBytes, maxChunks)
+	if r.ContentLength > int64(maxBytes) && r.Header.Get("Content-Encoding") != "gzip" {
+		JSONError(w, tooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			JSONError(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	// Read one byte past the cap so an oversized body can be told apart
+	body = io.LimitReader(body, int64(maxBytes)+1)
+
+	// Respond while the body is still arriving; each read gets its own deadline
+	// so only a stalled client is cut off
+	rc := http.NewResponseController(w)
+	if err := rc.EnableFullDuplex(); err != nil {
+		log.Printf("[%s] Full duplex unavailable, partial summaries may be delayed: %v", requestIDFromContext(r.Context()), err)
+	}
+	defer rc.SetReadDeadline(time.Time{})
+	chunks := &chunkReader{
+		r:          body,
+		maxBytes:   envInt("SUMMARIZE_CHUNK_BYTES", defaultSummarizeChunkBytes),
+		beforeRead: func() { rc.SetReadDeadline(time.Now().Add(s.bodyReadTimeout)) },
+	}
+
+	var partials []string
+	var read int
+	started := false
+	enc := json.NewEncoder(w)
+	fail := func(status int, message string) {
+		if !started {
+			JSONError(w, message, status)
+			return
+		}
+		enc.Encode(StreamSummaryEvent{Error: message})
+	}
+	for {
+		raw, err := chunks.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fail(http.StatusBadRequest, fmt.Sprintf("Failed to read request body: %v", err))
+			return
+		}
+		if read += len(raw); read > maxBytes || len(partials) >= maxChunks {
+			fail(http.StatusRequestEntityTooLarge, tooLarge)
+			return
+		}
+		decoded, err := decodeBody([]byte(raw), r.Header.Get("Content-Type"))
+		if err != nil {
+			fail(http.StatusUnsupportedMediaType, fmt.Sprintf("Unsupported request body: %v", err))
+			return
+		}
+		chunk := string(decoded)
+		if strings.TrimSpace(chunk) == "" {
+			continue
+		}
+		if matched := detectSensitive(chunk, s.sensitivePatterns); len(matched) > 0 {
+			fail(http.StatusUnprocessableEntity, fmt.Sprintf("Content refused: it appears to contain regulated data (%s) and this deployment does not process it", strings.Join(matched, ", ")))
+			return
+		}
+
+		partial, err := client.SummarizeChunk(r.Context(), chunk)
+		if err != nil {
+			log.Printf("Error calling Deepseek API for chunk summary: %v", err)
+			if !started {
+				writeUpstreamError(w, "Failed to summarize email", err)
+				return
+			}
+			fail(http.StatusInternalServerError, "Failed to summarize email")
+			return
+		}
+		partials = append(partials, partial)
+
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			started = true
+		}
+		if err := enc.Encode(StreamSummaryEvent{Chunk: len(partials), PartialSummary: partial}); err != nil {
+			log.Printf("Error writing response: %v", err)
+			return
+		}
+		rc.Flush()
+	}
+
+	if len(partials) == 0 {
+		fail(http.StatusBadRequest, "Email content is required")
+		return
+	}
+	summary, err := client.CombineSummaries(r.Context(), partials, opts)
+	if err != nil {
+		log.Printf("Error calling Deepseek API for combined summary: %v", err)
+		fail(http.StatusInternalServerError, "Failed to summarize email")
+		return
+	}
+	if err := enc.Encode(StreamSummaryEvent{Summary: summary.Summary, Chunks: len(partials), Metadata: summary.Metadata}); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// gzipped returns s compressed with gzip
+func gzipped(s string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(s))
+	gz.Close()
+	return buf.Bytes()
+}
+
+// streamEvents decodes the NDJSON lines of a streamed summary
+func streamEvents(t *testing.T, body string) []StreamSummaryEvent {
+	t.Helper()
+	var events []StreamSummaryEvent
+	dec := json.NewDecoder(strings.NewReader(body))
+	for dec.More() {
+		var event StreamSummaryEvent
+		if err := dec.Decode(&event); err != nil {
+			t.Fatalf("decode event: %v in %q", err, body)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestStreamSummarizeLimits(t *testing.T) {
+	words := strings.Repeat("word ", 100)
+	tests := []struct {
+		name      string
+		body      string
+		gzip      bool
+		maxBytes  string
+		maxChunks string
+		status    int
+		partials  int
+		lastError string
+	}{
+		{"within limits", words[:150], false, "", "", http.StatusOK, 2, ""},
+		{"content length over max bytes", words, false, "300", "", http.StatusRequestEntityTooLarge, 0, ""},
+		{"gzip first chunk over max bytes", words, true, "50", "", http.StatusRequestEntityTooLarge, 0, ""},
+		{"gzip over max bytes mid-stream", words, true, "250", "", http.StatusOK, 2, "Request body too large"},
+		{"over max chunks mid-stream", words, false, "", "2", http.StatusOK, 2, "Request body too large"},
+		{"exactly max bytes", words[:300], false, "300", "", http.StatusOK, 3, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SUMMARIZE_CHUNK_BYTES", "100")
+			if tt.maxBytes != "" {
+				t.Setenv("SUMMARIZE_STREAM_MAX_BYTES", tt.maxBytes)
+			}
+			if tt.maxChunks != "" {
+				t.Setenv("SUMMARIZE_STREAM_MAX_CHUNKS", tt.maxChunks)
+			}
+			upstream := replying("A part.")
+			s := newTestServer(t, upstream)
+
+			body := []byte(tt.body)
+			if tt.gzip {
+				body = gzipped(tt.body)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/summarize?stream_input=true", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "text/plain")
+			if tt.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+			rec := httptest.NewRecorder()
+			s.SummarizeHandler(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				if upstream.calls() != 0 {
+					t.Errorf("upstream calls = %d, want none for a rejected body", upstream.calls())
+				}
+				return
+			}
+			events := streamEvents(t, rec.Body.String())
+			partials := 0
+			for _, event := range events {
+				if event.PartialSummary != "" {
+					partials++
+				}
+			}
+			if partials != tt.partials {
+				t.Errorf("partial summaries = %d, want %d", partials, tt.partials)
+			}
+			last := events[len(events)-1]
+			if tt.lastError == "" && (last.Error != "" || last.Summary == "") {
+				t.Errorf("last event = %+v, want the final summary", last)
+			}
+			if tt.lastError != "" && !strings.HasPrefix(last.Error, tt.lastError) {
+				t.Errorf("last event = %+v, want error %q", last, tt.lastError)
+			}
+		})
+	}
+}
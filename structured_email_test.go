@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStructuredEmailValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		email   StructuredEmail
+		wantErr string
+	}{
+		{"body only", StructuredEmail{Body: "Hi"}, ""},
+		{"all fields", StructuredEmail{Subject: "Launch", From: "a@x.io", To: "b@x.io", Date: "Mon, 2 Jun 2025", Body: "Hi"}, ""},
+		{"missing body", StructuredEmail{Subject: "Launch", Body: " "}, "body is required"},
+		{"multi-line header", StructuredEmail{Subject: "Launch\nBcc: c@x.io", Body: "Hi"}, "subject must be a single line"},
+		{"long header", StructuredEmail{From: strings.Repeat("a", maxHeaderFieldLength+1), Body: "Hi"}, "from must be at most 1000 characters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.email.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+			} else if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStructuredEmailFormat(t *testing.T) {
+	tests := []struct {
+		name  string
+		email StructuredEmail
+		want  string
+	}{
+		{"body only", StructuredEmail{Body: " Launch moves to Friday. "}, "Launch moves to Friday."},
+		{"all fields", StructuredEmail{Subject: "Launch", From: "a@x.io", To: "b@x.io", Date: "Mon, 2 Jun 2025", Body: "Launch moves to Friday."}, "Subject: Launch\nFrom: a@x.io\nTo: b@x.io\nDate: Mon, 2 Jun 2025\n\nLaunch moves to Friday."},
+		{"blank fields skipped", StructuredEmail{Subject: "Launch", To: " ", Body: "Launch moves to Friday."}, "Subject: Launch\n\nLaunch moves to Friday."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.email.Format(); got != tt.want {
+				t.Errorf("Format() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeStructuredPrompt(t *testing.T) {
+	const body = "The launch moves to Friday because QA found a bug."
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		want        string
+	}{
+		{"raw text", "text/plain", body, http.StatusOK, "Summarize this email (HTML allowed):\n\n" + body},
+		{"structured", "application/json", `{"subject":"Launch","from":"pm@example.com","to":"team@example.com","date":"Mon, 2 Jun 2025","body":"` + body + `"}`, http.StatusOK,
+			"Summarize this email (HTML allowed):\n\nSubject: Launch\nFrom: pm@example.com\nTo: team@example.com\nDate: Mon, 2 Jun 2025\n\n" + body},
+		{"structured body only", "application/json", `{"body":"` + body + `"}`, http.StatusOK, "Summarize this email (HTML allowed):\n\n" + body},
+		{"structured without body", "application/json", `{"subject":"Launch"}`, http.StatusBadRequest, ""},
+		{"header injection", "application/json", `{"subject":"Launch\nBcc: x@example.com","body":"` + body + `"}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying("Launch moves to Friday.")
+			s := newTestServer(t, upstream)
+			req := httptest.NewRequest(http.MethodPost, "/summarize", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			s.SummarizeHandler(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				if upstream.calls() != 0 {
+					t.Errorf("rejected request called upstream")
+				}
+				return
+			}
+			if got := upstream.messages(0); !strings.HasSuffix(got, tt.want) {
+				t.Errorf("prompt = %q, want it to end with %q", got, tt.want)
+			}
+		})
+	}
+}
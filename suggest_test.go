@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSuggestReplies(t *testing.T) {
+	long := strings.Repeat("a", maxSuggestionLength+10)
+	tests := []struct {
+		name   string
+		reply  string
+		status int
+		want   []string
+	}{
+		{"three", `{"suggestions":["Sounds good","Friday works","Can we do Monday?"]}`, http.StatusOK, []string{"Sounds good", "Friday works", "Can we do Monday?"}},
+		{"trimmed and capped", `{"suggestions":["  Sounds good ","Friday works","Thanks!","One more"]}`, http.StatusOK, []string{"Sounds good", "Friday works", "Thanks!"}},
+		{"duplicates and blanks dropped", `{"suggestions":["Sounds good","sounds good"," ","Thanks!"]}`, http.StatusOK, []string{"Sounds good", "Thanks!"}},
+		{"long suggestion shortened", `{"suggestions":["` + long + `"]}`, http.StatusOK, []string{long[:maxSuggestionLength]}},
+		{"fenced json", "```json\n{\"suggestions\":[\"Sounds good\"]}\n```", http.StatusOK, []string{"Sounds good"}},
+		{"invalid json", "Sounds good", http.StatusInternalServerError, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, replying(tt.reply))
+			rec := httptest.NewRecorder()
+			s.SuggestRepliesHandler(rec, httptest.NewRequest(http.MethodPost, "/suggest-replies", strings.NewReader("Can we meet on Friday?")))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp SuggestionsResponse
+			decodeResponse(t, rec, &resp)
+			if !reflect.DeepEqual(resp.Suggestions, tt.want) {
+				t.Errorf("suggestions = %q, want %q", resp.Suggestions, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxTaxonomyLabelLength is the longest label, in characters, a taxonomy may define
+const maxTaxonomyLabelLength = 64
+
+// TaxonomyLabel is a single classification label and what it means
+type TaxonomyLabel struct {
+	Label       string `json:"label"`
+	Description string `json:"description"`
+}
+
+// Taxonomy is the set of labels the classifier may choose from
+type Taxonomy struct {
+	Labels []TaxonomyLabel `json:"labels"`
+}
+
+// Validate returns every problem found in the taxonomy: a missing label set,
+// empty labels or descriptions, labels longer than maxTaxonomyLabelLength and
+// labels that duplicate an earlier one (case-insensitively). Problems with
+// the taxonomy as a whole have index -1.
+func (t Taxonomy) Validate() []ValidationError {
+	if len(t.Labels) == 0 {
+		return []ValidationError{{Index: -1, Field: "labels", Message: "Taxonomy must define at least one label"}}
+	}
+	var errs []ValidationError
+	firstIndex := make(map[string]int)
+	for i, entry := range t.Labels {
+		label := strings.TrimSpace(entry.Label)
+		switch {
+		case label == "":
+			errs = append(errs, ValidationError{Index: i, Field: "label", Message: "Label is empty"})
+		case len([]rune(label)) > maxTaxonomyLabelLength:
+			errs = append(errs, ValidationError{Index: i, Field: "label", Message: fmt.Sprintf("Label %q is longer than %d characters", label, maxTaxonomyLabelLength)})
+		}
+		if label != "" {
+			key := strings.ToLower(label)
+			if first, ok := firstIndex[key]; ok {
+				errs = append(errs, ValidationError{Index: i, Field: "label", Message: fmt.Sprintf("Duplicate label %q (first defined at index %d)", label, first)})
+			} else {
+				firstIndex[key] = i
+			}
+		}
+		if strings.TrimSpace(entry.Description) == "" {
+			errs = append(errs, ValidationError{Index: i, Field: "description", Message: "Description is empty"})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTaxonomyValidate(t *testing.T) {
+	long := strings.Repeat("x", maxTaxonomyLabelLength+1)
+	tests := []struct {
+		name     string
+		taxonomy Taxonomy
+		want     []ValidationError
+	}{
+		{"clean", Taxonomy{Labels: []TaxonomyLabel{{Label: "urgent", Description: "Needs action today"}, {Label: "spam", Description: "Unsolicited"}}}, nil},
+		{"no labels", Taxonomy{}, []ValidationError{{Index: -1, Field: "labels", Message: "Taxonomy must define at least one label"}}},
+		{
+			name: "duplicates and empties",
+			taxonomy: Taxonomy{Labels: []TaxonomyLabel{
+				{Label: "urgent", Description: "Needs action today"},
+				{Label: " ", Description: ""},
+				{Label: "Urgent", Description: "Again"},
+				{Label: long, Description: "Too long"},
+			}},
+			want: []ValidationError{
+				{Index: 1, Field: "label", Message: "Label is empty"},
+				{Index: 1, Field: "description", Message: "Description is empty"},
+				{Index: 2, Field: "label", Message: `Duplicate label "Urgent" (first defined at index 0)`},
+				{Index: 3, Field: "label", Message: `Label "` + long + `" is longer than 64 characters`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.taxonomy.Validate(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminTaxonomyValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		valid    bool
+		problems int
+	}{
+		{"clean", `{"labels":[{"label":"urgent","description":"Needs action today"},{"label":"spam","description":"Unsolicited"}]}`, http.StatusOK, true, 0},
+		{"duplicates and empties", `{"labels":[{"label":"urgent","description":"Needs action today"},{"label":"urgent","description":""},{"label":"","description":"x"}]}`, http.StatusOK, false, 3},
+		{"not json", `labels: urgent`, http.StatusBadRequest, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying()
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			s.AdminTaxonomyValidateHandler(rec, postJSON("/admin/taxonomy/validate", tt.body))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if upstream.calls() != 0 {
+				t.Errorf("taxonomy validation called upstream")
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp AdminTaxonomyValidateResponse
+			decodeResponse(t, rec, &resp)
+			if resp.Valid != tt.valid || len(resp.Problems) != tt.problems {
+				t.Errorf("response = %+v, want valid %v with %d problem(s)", resp, tt.valid, tt.problems)
+			}
+			if resp.Problems == nil {
+				t.Errorf("problems = null, want a list")
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClassifyTemperature(t *testing.T) {
+	tests := []struct {
+		name       string
+		strictness string
+		override   *float64
+		want       float64
+	}{
+		{"normal default", JSONStrictnessNormal, nil, 0.2},
+		{"normal override", JSONStrictnessNormal, temperature(1.5), 1.5},
+		{"strict default", JSONStrictnessStrict, nil, strictJSONTemperature},
+		{"strict ignores override", JSONStrictnessStrict, temperature(1.5), strictJSONTemperature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CLASSIFY_JSON_STRICTNESS", tt.strictness)
+			t.Setenv("CLASSIFY_TEMPERATURE", "0.2")
+			upstream := replying(`{"labels":[{"label":"urgent","score":0.9}]}`)
+			c := newTestClient(t, upstream)
+			ctx := context.Background()
+			if tt.override != nil {
+				ctx = context.WithValue(ctx, temperatureKey{}, *tt.override)
+			}
+			if _, err := c.ClassifyEmail(ctx, "The server is down again", ClassifyOptions{}); err != nil {
+				t.Fatalf("ClassifyEmail: %v", err)
+			}
+			if got := upstream.body(0)["temperature"]; got != tt.want {
+				t.Errorf("temperature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOperationTemperatures(t *testing.T) {
+	operations := []struct {
+		name    string
+		env     string
+		reply   string
+		handler func(s *Server) http.HandlerFunc
+		target  string
+		body    string
+		def     float64
+	}{
+		{"classify", "CLASSIFY_TEMPERATURE", `{"labels":[{"label":"urgent","score":0.9}]}`, func(s *Server) http.HandlerFunc { return s.ClassifyHandler }, "/classify", `{"emails":[{"id":"1","content":"The server is down again"}]}`, 0},
+		{"summarize", "SUMMARIZE_TEMPERATURE", "The server is down.", func(s *Server) http.HandlerFunc { return s.SummarizeHandler }, "/summarize", `{"body":"The server is down again"}`, 0.3},
+		{"draft", "DRAFT_TEMPERATURE", "We are on it.", func(s *Server) http.HandlerFunc { return s.DraftHandler }, "/draft", `{"body":"The server is down again"}`, 0.7},
+	}
+	tests := []struct {
+		name  string
+		env   string
+		query string
+		want  func(def float64) float64
+	}{
+		{"default", "", "", func(def float64) float64 { return def }},
+		{"env", "1.1", "", func(float64) float64 { return 1.1 }},
+		{"request override", "1.1", "?temperature=0.5", func(float64) float64 { return 0.5 }},
+	}
+	for _, op := range operations {
+		for _, tt := range tests {
+			op, tt := op, tt
+			t.Run(op.name+"/"+tt.name, func(t *testing.T) {
+				if tt.env != "" {
+					t.Setenv(op.env, tt.env)
+				}
+				upstream := replying(op.reply)
+				s := newTestServer(t, upstream)
+				rec := httptest.NewRecorder()
+				TemperatureOverride(op.handler(s)).ServeHTTP(rec, postJSON(op.target+tt.query, op.body))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+				}
+				if got, want := upstream.body(0)["temperature"], tt.want(op.def); got != want {
+					t.Errorf("temperature = %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
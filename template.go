@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxTemplatePlaceholders bounds the distinct placeholders in a reply template
+const maxTemplatePlaceholders = 50
+
+// templatePlaceholderPattern matches {{name}} placeholders in a reply template
+var templatePlaceholderPattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// templateExtractSystemPrompt asks the model to pull placeholder values out of an email
+const templateExtractSystemPrompt = "You fill in a canned reply template. Given an email and a list of placeholder names, extract the value for each placeholder from the email. Use only information stated in the email; use null when the email does not contain a value. Output strict JSON: {\"values\":{\"<placeholder>\":string|null}} with no extra text."
+
+// templateExtractTemperature keeps extraction deterministic
+const templateExtractTemperature = 0.0
+
+// TemplateDraftResponse is a reply template with its placeholders filled in
+type TemplateDraftResponse struct {
+	Draft string `json:"draft"`
+	// Values holds the extracted value of each filled placeholder
+	Values map[string]string `json:"values"`
+	// Unfilled lists placeholders the email had no value for; they are left
+	// as {{name}} in the draft
+	Unfilled []string          `json:"unfilled,omitempty"`
+	Metadata *ResponseMetadata `json:"metadata,omitempty"`
+	ReplyHeaders
+}
+
+// templatePlaceholders returns the distinct placeholder names in template, in
+// order of first appearance
+func templatePlaceholders(template string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range templatePlaceholderPattern.FindAllStringSubmatch(template, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// fillTemplate replaces each placeholder that has a value, leaving the others
+// marked as {{name}}
+func fillTemplate(template string, values map[string]string) string {
+	return templatePlaceholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := templatePlaceholderPattern.FindStringSubmatch(placeholder)[1]
+		if value, ok := values[name]; ok {
+			return value
+		}
+		return "{{" + name + "}}"
+	})
+}
+
+// DraftFromTemplate fills the {{placeholders}} of a canned reply with values
+// the model extracts from the email. The template text itself is never sent
+// back through the model, so everything outside the placeholders is kept
+// exactly as written.
+func (c *DeepseekClient) DraftFromTemplate(ctx context.Context, content, template string) (*TemplateDraftResponse, error) {
+	names := templatePlaceholders(template)
+	out := &TemplateDraftResponse{Values: map[string]string{}}
+	if len(names) == 0 {
+		out.Draft = template
+		return out, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, c.Settings().DraftTimeout)
+	defer cancel()
+	content = c.fitContent(ctx, content)
+
+	reqBody := chatRequest{
+		Model: c.Model(),
+		Messages: []chatMessage{
+			{Role: "system", Content: templateExtractSystemPrompt},
+			{Role: "user", Content: fmt.Sprintf("Placeholders: %s\n\nEmail (HTML allowed):\n\n%s", strings.Join(names, ", "), content)},
+		},
+		Temperature: temperature(templateExtractTemperature),
+	}
+	cr, err := c.chat(ctx, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	responseContent := stripCodeFence(strings.TrimSpace(cr.Choices[0].Message.Content))
+	var extracted struct {
+		Values map[string]*string `json:"values"`
+	}
+	if err := json.Unmarshal([]byte(responseContent), &extracted); err != nil {
+		c.logf(ctx, "Failed to parse template values from model response: %v, content: %s", err, responseContent)
+		return nil, fmt.Errorf("model did not return valid JSON for template values: %w", err)
+	}
+
+	// Only requested placeholders are filled; anything else the model returns is ignored
+	for _, name := range names {
+		if value := extracted.Values[name]; value != nil && strings.TrimSpace(*value) != "" {
+			out.Values[name] = strings.TrimSpace(*value)
+		} else {
+			out.Unfilled = append(out.Unfilled, name)
+		}
+	}
+	out.Draft = fillTemplate(template, out.Values)
+	out.Metadata = c.responseMetadata(ctx, cr.Choices[0], content)
+	return out, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTemplatePlaceholders(t *testing.T) {
+	tests := []struct {
+		template string
+		want     []string
+	}{
+		{"Thanks for writing.", nil},
+		{"Hi {{name}}, order {{ order_number }} ships soon, {{name}}.", []string{"name", "order_number"}},
+		{"{{a.b}} {{c-d}} {{not valid}} {single}", []string{"a.b", "c-d"}},
+	}
+	for _, tt := range tests {
+		if got := templatePlaceholders(tt.template); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("templatePlaceholders(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestDraftTemplate(t *testing.T) {
+	template := "Hi {{name}},\n\nYour order {{ order_number }} ships on {{ship_date}}. Thanks, {{name}}!"
+	tests := []struct {
+		name     string
+		reply    string
+		values   map[string]string
+		unfilled []string
+		draft    string
+	}{
+		{"all filled", `{"values":{"name":"Ana","order_number":"A-1042","ship_date":"Friday"}}`,
+			map[string]string{"name": "Ana", "order_number": "A-1042", "ship_date": "Friday"}, nil,
+			"Hi Ana,\n\nYour order A-1042 ships on Friday. Thanks, Ana!"},
+		{"null and blank stay marked", "```json\n{\"values\":{\"name\":\"Ana\",\"order_number\":null,\"ship_date\":\" \",\"extra\":\"ignored\"}}\n```",
+			map[string]string{"name": "Ana"}, []string{"order_number", "ship_date"},
+			"Hi Ana,\n\nYour order {{order_number}} ships on {{ship_date}}. Thanks, Ana!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying(tt.reply)
+			s := newTestServer(t, upstream)
+			body := fmt.Sprintf(`{"body":"Hi, this is Ana. Where is order A-1042?","template":%q}`, template)
+			rec := httptest.NewRecorder()
+			s.DraftHandler(rec, postJSON("/draft", body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+			}
+			var resp TemplateDraftResponse
+			decodeResponse(t, rec, &resp)
+			if !reflect.DeepEqual(resp.Values, tt.values) {
+				t.Errorf("values = %v, want %v", resp.Values, tt.values)
+			}
+			if !reflect.DeepEqual(resp.Unfilled, tt.unfilled) {
+				t.Errorf("unfilled = %q, want %q", resp.Unfilled, tt.unfilled)
+			}
+			if resp.Draft != tt.draft {
+				t.Errorf("draft = %q, want %q", resp.Draft, tt.draft)
+			}
+			prompt := upstream.messages(0)
+			if !strings.Contains(prompt, "Placeholders: name, order_number, ship_date") || strings.Contains(prompt, "Your order") {
+				t.Errorf("prompt %q should list the placeholders without the template text", prompt)
+			}
+		})
+	}
+}
+
+func TestDraftTemplateValidation(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i <= maxTemplatePlaceholders; i++ {
+		fmt.Fprintf(&b, "{{p%d}} ", i)
+	}
+	tests := []struct {
+		name     string
+		template string
+		status   int
+		calls    int
+	}{
+		{"no placeholders", "Thanks, we will be in touch.", http.StatusOK, 0},
+		{"too many placeholders", b.String(), http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying(`{"values":{}}`)
+			s := newTestServer(t, upstream)
+			rec := httptest.NewRecorder()
+			s.DraftHandler(rec, postJSON("/draft", fmt.Sprintf(`{"body":"Where is my order?","template":%q}`, tt.template)))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.status, rec.Body.String())
+			}
+			if upstream.calls() != tt.calls {
+				t.Errorf("upstream calls = %d, want %d", upstream.calls(), tt.calls)
+			}
+			if tt.status == http.StatusOK {
+				var resp TemplateDraftResponse
+				decodeResponse(t, rec, &resp)
+				if resp.Draft != tt.template {
+					t.Errorf("draft = %q, want the template unchanged", resp.Draft)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// defaultMaxThreadMessages is how many thread messages are sent to the model
+// verbatim; older ones are summarized
+const defaultMaxThreadMessages = 20
+
+// errThreadTooLarge is returned when even the most recent thread messages do
+// not fit in the model's context window
+var errThreadTooLarge = errors.New("thread too large")
+
+// validateThread checks each thread message, or the single email when there
+// is no thread
+func validateThread(email StructuredEmail, thread []StructuredEmail) error {
+	if len(thread) == 0 {
+		return email.Validate()
+	}
+	for i, msg := range thread {
+		if err := msg.Validate(); err != nil {
+			return fmt.Errorf("thread[%d]: %w", i, err)
+		}
+	}
+	if strings.TrimSpace(email.Body) != "" {
+		return email.Validate()
+	}
+	return nil
+}
+
+// threadMessages returns the thread in order, oldest first, with the email
+// itself appended as the newest message when it has a body
+func threadMessages(email StructuredEmail, thread []StructuredEmail) []StructuredEmail {
+	messages := append([]StructuredEmail(nil), thread...)
+	if strings.TrimSpace(email.Body) != "" {
+		messages = append(messages, email)
+	}
+	return messages
+}
+
+// formatThread renders messages with a numbered separator before each one
+func formatThread(messages []StructuredEmail, first, total int) string {
+	var b strings.Builder
+	for i, msg := range messages {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		fmt.Fprintf(&b, "--- Message %d of %d ---\n%s", first+i, total, msg.Format())
+	}
+	return b.String()
+}
+
+// threadContent renders a thread for the model. At most maxMessages of the
+// most recent messages are kept verbatim; older ones are replaced by a brief
+// summary. It returns errThreadTooLarge when the kept messages alone exceed
+// the client's content budget.
+func threadContent(ctx context.Context, client LLMClient, messages []StructuredEmail, maxMessages int) (string, error) {
+	total := len(messages)
+	older, recent := []StructuredEmail(nil), messages
+	if total > maxMessages {
+		older, recent = messages[:total-maxMessages], messages[total-maxMessages:]
+	}
+
+	content := formatThread(recent, len(older)+1, total)
+	if tokens, budget := countTokens(content), client.ContentTokenBudget(ctx); tokens > budget {
+		return "", fmt.Errorf("%w: the %d most recent messages need %d tokens, the budget is %d", errThreadTooLarge, len(recent), tokens, budget)
+	}
+	if len(older) == 0 {
+		return content, nil
+	}
+
+	summary, err := client.SummarizeChunk(ctx, formatThread(older, 1, total))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("--- Summary of the %d earlier messages ---\n%s\n\n%s", len(older), summary, content), nil
+}
+
+// writeThreadError reports a failure to prepare a thread
+func writeThreadError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errThreadTooLarge) {
+		JSONError(w, fmt.Sprintf("Thread does not fit in the model's context window (%v); send fewer or shorter messages", err), http.StatusBadRequest)
+		return
+	}
+	writeUpstreamError(w, "Failed to summarize earlier thread messages", err)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// threadBody returns a structured /summarize body with n thread messages
+func threadBody(n int, bodyWords int) string {
+	var msgs []string
+	for i := 1; i <= n; i++ {
+		text := strings.TrimSpace(strings.Repeat(fmt.Sprintf("word%d ", i), bodyWords))
+		msgs = append(msgs, fmt.Sprintf(`{"from":"a@example.com","subject":"s","body":%q}`, text))
+	}
+	return `{"thread":[` + strings.Join(msgs, ",") + `]}`
+}
+
+func TestSummarizeThreadCap(t *testing.T) {
+	tests := []struct {
+		name         string
+		messages     int
+		wantCalls    int
+		wantVerbatim []string
+		wantOmitted  []string
+	}{
+		{"at the cap", 3, 1, []string{"word1", "word2", "word3"}, nil},
+		{"over the cap", 5, 2, []string{"word3", "word4", "word5"}, []string{"word1", "word2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MAX_THREAD_MESSAGES", "3")
+			upstream := replying("A short summary of the thread.")
+			s := newTestServer(t, upstream)
+			if s.maxThreadMessages != 3 {
+				t.Fatalf("maxThreadMessages = %d, want 3", s.maxThreadMessages)
+			}
+
+			rec := httptest.NewRecorder()
+			s.SummarizeHandler(rec, postJSON("/summarize", threadBody(tt.messages, 3)))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+			}
+			if upstream.calls() != tt.wantCalls {
+				t.Fatalf("upstream calls = %d, want %d", upstream.calls(), tt.wantCalls)
+			}
+			final := upstream.messages(upstream.calls() - 1)
+			for _, word := range tt.wantVerbatim {
+				if !strings.Contains(final, word) {
+					t.Errorf("final prompt is missing %q", word)
+				}
+			}
+			for _, word := range tt.wantOmitted {
+				if strings.Contains(final, word) {
+					t.Errorf("final prompt contains summarized message %q", word)
+				}
+			}
+		})
+	}
+}
+
+func TestSummarizeThreadTooLarge(t *testing.T) {
+	t.Setenv("MAX_THREAD_MESSAGES", "2")
+	t.Setenv("MAX_INPUT_TOKENS", "50")
+	upstream := replying("unused")
+	s := newTestServer(t, upstream)
+
+	rec := httptest.NewRecorder()
+	s.SummarizeHandler(rec, postJSON("/summarize", threadBody(4, 200)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400; body %s", rec.Code, rec.Body)
+	}
+	var resp ErrorResponse
+	decodeResponse(t, rec, &resp)
+	if !strings.Contains(resp.Message+resp.Error, "Thread does not fit") {
+		t.Errorf("error = %+v, want the thread-too-large error", resp)
+	}
+	if upstream.calls() != 0 {
+		t.Errorf("upstream calls = %d, want 0", upstream.calls())
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOperationTimeouts(t *testing.T) {
+	t.Setenv("SUMMARIZE_TIMEOUT", "11s")
+	t.Setenv("CLASSIFY_TIMEOUT", "22s")
+	t.Setenv("DRAFT_TIMEOUT", "33s")
+	tests := []struct {
+		name  string
+		reply string
+		call  func(c *DeepseekClient) error
+		want  time.Duration
+	}{
+		{"summarize", "A summary.", func(c *DeepseekClient) error {
+			_, err := c.SummarizeEmail(context.Background(), "The launch moves to Friday.", SummarizeOptions{})
+			return err
+		}, 11 * time.Second},
+		{"classify", `{"labels":[{"label":"urgent","score":0.9}]}`, func(c *DeepseekClient) error {
+			_, err := c.ClassifyEmail(context.Background(), "The server is down again", ClassifyOptions{})
+			return err
+		}, 22 * time.Second},
+		{"draft", "Friday works.", func(c *DeepseekClient) error {
+			_, err := c.DraftReply(context.Background(), "Can we meet on Friday?", DraftOptions{N: 1})
+			return err
+		}, 33 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var remaining time.Duration
+			c := newTestClient(t, doerFunc(func(req *http.Request) (*http.Response, error) {
+				deadline, ok := req.Context().Deadline()
+				if !ok {
+					t.Error("upstream request has no deadline")
+				}
+				remaining = time.Until(deadline)
+				return chatReply(tt.reply), nil
+			}))
+			if err := tt.call(c); err != nil {
+				t.Fatal(err)
+			}
+			if remaining > tt.want || remaining < tt.want-time.Second {
+				t.Errorf("deadline in %v, want about %v", remaining, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamTransportTimeouts(t *testing.T) {
+	tests := []struct {
+		name        string
+		headerDelay time.Duration
+		bodyDelay   time.Duration
+		wantErr     bool
+	}{
+		{"slow headers fail", 500 * time.Millisecond, 0, true},
+		{"slow body after headers succeeds", 0, 150 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("UPSTREAM_RESPONSE_HEADER_TIMEOUT", "50ms")
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(tt.headerDelay):
+				case <-r.Context().Done():
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				w.(http.Flusher).Flush()
+				select {
+				case <-time.After(tt.bodyDelay):
+				case <-r.Context().Done():
+					return
+				}
+				io.WriteString(w, "generated")
+			}))
+			defer upstream.Close()
+
+			client := &http.Client{Transport: newUpstreamTransport()}
+			start := time.Now()
+			resp, err := client.Get(upstream.URL)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("request succeeded, want a response header timeout")
+				}
+				if elapsed := time.Since(start); elapsed >= tt.headerDelay {
+					t.Errorf("failed after %v, want before the %v header delay", elapsed, tt.headerDelay)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			defer resp.Body.Close()
+			if body, err := io.ReadAll(resp.Body); err != nil || string(body) != "generated" {
+				t.Errorf("body = %q, %v", body, err)
+			}
+		})
+	}
+}
+
+func TestUpstreamDialTimeout(t *testing.T) {
+	t.Setenv("UPSTREAM_DIAL_TIMEOUT", "100ms")
+	client := &http.Client{Transport: newUpstreamTransport()}
+	start := time.Now()
+	// 10.255.255.1 is unroutable, so the connect stalls until the dial timeout
+	resp, err := client.Get("http://10.255.255.1:81/")
+	elapsed := time.Since(start)
+	if err == nil {
+		resp.Body.Close()
+		t.Skip("unroutable address answered")
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Skipf("connect failed without stalling: %v", err)
+	}
+	if elapsed > 2*time.Second {
+		t.Errorf("dial failed after %v, want about 100ms", elapsed)
+	}
+}
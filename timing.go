@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// upstreamTimer accumulates the time one request spends waiting on the
+// upstream API, summed across all of its model calls
+type upstreamTimer struct {
+	nanos atomic.Int64
+}
+
+// upstreamTimerKey is the context key under which the request's upstreamTimer is stored
+type upstreamTimerKey struct{}
+
+// withUpstreamTimer returns a copy of ctx carrying a fresh upstreamTimer
+func withUpstreamTimer(ctx context.Context) context.Context {
+	return context.WithValue(ctx, upstreamTimerKey{}, &upstreamTimer{})
+}
+
+// upstreamTimerFromContext returns the request's upstreamTimer, or nil
+func upstreamTimerFromContext(ctx context.Context) *upstreamTimer {
+	if ctx == nil {
+		return nil
+	}
+	t, _ := ctx.Value(upstreamTimerKey{}).(*upstreamTimer)
+	return t
+}
+
+// recordUpstreamLatency adds the time since start to the request's timer, if any
+func recordUpstreamLatency(ctx context.Context, start time.Time) {
+	if t := upstreamTimerFromContext(ctx); t != nil {
+		t.nanos.Add(int64(time.Since(start)))
+	}
+}
+
+// upstreamLatency returns the upstream time recorded so far for ctx
+func upstreamLatency(ctx context.Context) time.Duration {
+	if t := upstreamTimerFromContext(ctx); t != nil {
+		return time.Duration(t.nanos.Load())
+	}
+	return 0
+}
+
+// processedAt returns the current time formatted for response metadata
+func processedAt() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// UpstreamTiming middleware gives every request its own upstream timer so
+// response metadata can report how long the upstream took
+func UpstreamTiming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(withUpstreamTimer(r.Context())))
+	})
+}
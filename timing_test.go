@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResponseTiming(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	slow := func(content string) doerFunc {
+		return func(*http.Request) (*http.Response, error) {
+			time.Sleep(delay)
+			return chatReply(content), nil
+		}
+	}
+	tests := []struct {
+		name     string
+		handler  func(s *Server) http.HandlerFunc
+		path     string
+		body     string
+		reply    string
+		metadata func(rec *httptest.ResponseRecorder) (string, int64)
+	}{
+		{"summarize", func(s *Server) http.HandlerFunc { return s.SummarizeHandler }, "/summarize",
+			`{"body":"The launch moves to Friday because QA found a bug."}`, "Launch moves to Friday.",
+			func(rec *httptest.ResponseRecorder) (string, int64) {
+				var resp SummaryResponse
+				decodeResponse(t, rec, &resp)
+				return resp.Metadata.ProcessedAt, resp.Metadata.UpstreamLatencyMS
+			}},
+		{"classify", func(s *Server) http.HandlerFunc { return s.ClassifyHandler }, "/classify",
+			`{"emails":[{"id":"1","content":"The server is down again"}]}`, `{"labels":[{"label":"urgent","score":0.9}]}`,
+			func(rec *httptest.ResponseRecorder) (string, int64) {
+				var resp BatchClassifyResponse
+				decodeResponse(t, rec, &resp)
+				return resp.Metadata.ProcessedAt, resp.Metadata.UpstreamLatencyMS
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CACHE_ENABLED", "true")
+			s := newTestServer(t, slow(tt.reply))
+			rec := httptest.NewRecorder()
+			start := time.Now().UTC().Truncate(time.Second)
+			UpstreamTiming(tt.handler(s)).ServeHTTP(rec, postJSON(tt.path, tt.body))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+			}
+			at, latency := tt.metadata(rec)
+			if latency < delay.Milliseconds() {
+				t.Errorf("upstream_latency_ms = %d, want at least %d", latency, delay.Milliseconds())
+			}
+			processed, err := time.Parse(time.RFC3339, at)
+			if err != nil {
+				t.Fatalf("processed_at %q is not RFC 3339: %v", at, err)
+			}
+			if processed.Before(start) || processed.After(time.Now().Add(time.Second)) {
+				t.Errorf("processed_at = %v, want between %v and now", processed, start)
+			}
+		})
+	}
+}
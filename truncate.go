@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// defaultMaxInputTokens is the default token budget for email content sent to the model
+const defaultMaxInputTokens = 24000
+
+// truncationMarker replaces content removed to fit the token budget
+const truncationMarker = "\n[... content truncated ...]\n"
+
+// tokenSafetyMarginPercent is the share of a content budget left unused,
+// because the model's own tokenizer can split text differently from tokenEncoding
+const tokenSafetyMarginPercent = 10
+
+// tokenEncoding is the BPE encoding tokens are counted with
+const tokenEncoding = "cl100k_base"
+
+// loadEncoding loads tokenEncoding once from the ranks embedded in the binary,
+// so counting tokens never downloads them at runtime
+var loadEncoding = sync.OnceValues(func() (*tiktoken.Tiktoken, error) {
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+	return tiktoken.GetEncoding(tokenEncoding)
+})
+
+// tokenPattern splits text into word, number, punctuation and space pieces,
+// where BPE tokenizers pre-tokenize
+var tokenPattern = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)| ?\pL+| ?\pN{1,3}| ?[^\s\pL\pN]+|\s+`)
+
+// quotedHistoryPattern matches the first line of quoted reply history
+var quotedHistoryPattern = regexp.MustCompile(`(?m)^(>|On .+wrote:\s*$|-{2,}\s*Original Message\s*-{2,})`)
+
+// tokenPieces splits text into pieces with their token counts. Pieces are
+// cut where the text may be split without breaking a character; their counts
+// need not add up to the count of the joined text, which callers recount.
+func tokenPieces(text string) ([]string, []int) {
+	pieces := tokenPattern.FindAllString(text, -1)
+	counts := make([]int, len(pieces))
+	for i, p := range pieces {
+		counts[i] = countTokens(p)
+	}
+	return pieces, counts
+}
+
+// countTokens returns the number of tokenEncoding tokens in text
+func countTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	enc, err := loadEncoding()
+	if err != nil {
+		// The ranks are embedded, so this only fails on a broken build
+		panic("loading " + tokenEncoding + ": " + err.Error())
+	}
+	return len(enc.EncodeOrdinary(text))
+}
+
+// splitQuotedHistory splits content into the latest message and the quoted
+// history that follows it. history is empty when nothing is quoted.
+func splitQuotedHistory(content string) (latest, history string) {
+	loc := quotedHistoryPattern.FindStringIndex(content)
+	if loc == nil {
+		return content, ""
+	}
+	return content[:loc[0]], content[loc[0]:]
+}
+
+// cutMiddle removes pieces from the middle of text so it fits within budget tokens,
+// keeping the head and tail and inserting truncationMarker in between. The
+// joined result is counted again, since pieces may split differently once
+// joined, and cut further until it fits.
+func cutMiddle(text string, budget int) string {
+	if countTokens(text) <= budget {
+		return text
+	}
+	pieces, counts := tokenPieces(text)
+	for room := budget - countTokens(truncationMarker); room > 0; {
+		out := keepHeadAndTail(pieces, counts, room)
+		tokens := countTokens(out)
+		if tokens <= budget {
+			return out
+		}
+		room -= tokens - budget
+	}
+	return ""
+}
+
+// keepHeadAndTail joins the first pieces worth half of room tokens and the
+// last pieces that fit in the rest around truncationMarker
+func keepHeadAndTail(pieces []string, counts []int, room int) string {
+	var head strings.Builder
+	used, i := 0, 0
+	for ; i < len(pieces) && used+counts[i] <= room/2; i++ {
+		head.WriteString(pieces[i])
+		used += counts[i]
+	}
+
+	j := len(pieces)
+	for j > i && used+counts[j-1] <= room {
+		j--
+		used += counts[j]
+	}
+	return head.String() + truncationMarker + strings.Join(pieces[j:], "")
+}
+
+// truncateToTokenBudget trims content to fit within budget tokens. When the email
+// contains quoted reply history, the middle of that history is cut first so the
+// new message and the most recent context survive. A budget of 0 or less
+// leaves nothing; it is never taken as "no limit".
+func truncateToTokenBudget(content string, budget int) string {
+	if countTokens(content) <= budget {
+		return content
+	}
+
+	if body, quoted := splitQuotedHistory(content); quoted != "" {
+		remaining := budget - countTokens(body)
+		if remaining > countTokens(truncationMarker) {
+			return body + cutMiddle(quoted, remaining)
+		}
+	}
+
+	return cutMiddle(content, budget)
+}
+
+// defaultContextWindow is used for models without a configured context window
+const defaultContextWindow = 65536
+
+// defaultCompletionTokens is the number of tokens reserved for the model's reply
+const defaultCompletionTokens = 4096
+
+// promptOverheadTokens reserves room for system prompts and message framing
+const promptOverheadTokens = 512
+
+// minContentTokens is the content budget used when a model's context window
+// leaves less than this after the prompt and completion reserve
+const minContentTokens = 256
+
+// TokenBudget splits a model's context window between the prompt, few-shot
+// examples, the email content and the completion
+type TokenBudget struct {
+	ContextWindow    int
+	CompletionTokens int
+	PromptTokens     int
+	ExampleTokens    int
+}
+
+// ContentTokens returns the tokens left for email content, never negative
+func (b TokenBudget) ContentTokens() int {
+	remaining := b.ContextWindow - b.CompletionTokens - b.PromptTokens - b.ExampleTokens
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// parseContextWindows parses comma-separated model=tokens pairs
+func parseContextWindows(spec string) map[string]int {
+	windows := make(map[string]int)
+	for _, pair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		model, tokens, ok := strings.Cut(pair, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(tokens))
+		if !ok || err != nil || n <= 0 {
+			log.Printf("Ignoring malformed MODEL_CONTEXT_WINDOWS entry %q", pair)
+			continue
+		}
+		windows[strings.TrimSpace(model)] = n
+	}
+	return windows
+}
+
+// tokenBudget returns the budget for the model used for ctx given the prompt
+// and example sizes of the operation
+func (c *DeepseekClient) tokenBudget(ctx context.Context, promptTokens, exampleTokens int) TokenBudget {
+	window, ok := c.ContextWindows[c.modelFor(ctx)]
+	if !ok {
+		window = defaultContextWindow
+	}
+	return TokenBudget{
+		ContextWindow:    window,
+		CompletionTokens: c.CompletionTokens,
+		PromptTokens:     promptTokens,
+		ExampleTokens:    exampleTokens,
+	}
+}
+
+// contentBudget returns the tokens available for email content: what the
+// model's context window leaves after the prompt and completion, further
+// capped by MaxInputTokens, less tokenSafetyMarginPercent. A window too small
+// for the completion reserve is clamped to minContentTokens.
+func (c *DeepseekClient) contentBudget(ctx context.Context) int {
+	budget := c.tokenBudget(ctx, promptOverheadTokens, 0).ContentTokens()
+	if budget < minContentTokens {
+		c.logf(ctx, "Warning: the context window of %s leaves %d tokens for content, using %d; check MODEL_CONTEXT_WINDOWS and COMPLETION_TOKEN_RESERVE", c.modelFor(ctx), budget, minContentTokens)
+		budget = minContentTokens
+	}
+	if c.MaxInputTokens > 0 && c.MaxInputTokens < budget {
+		budget = c.MaxInputTokens
+	}
+	return budget - budget*tokenSafetyMarginPercent/100
+}
+
+// ContentTokenBudget returns the tokens available for email content with the
+// model used for ctx
+func (c *DeepseekClient) ContentTokenBudget(ctx context.Context) int {
+	return c.contentBudget(ctx)
+}
+
+// fitContent prepares email content for the model: tracking pixels and
+// parameters and disclaimer footers are stripped when enabled, then content
+// is truncated to the client's input token budget
+func (c *DeepseekClient) fitContent(ctx context.Context, content string) string {
+	if c.StripTracking {
+		content = stripTracking(content)
+	}
+	content = stripDisclaimers(content, c.DisclaimerPatterns)
+	budget := c.contentBudget(ctx)
+	before := countTokens(content)
+	fitted := truncateToTokenBudget(content, budget)
+	if fitted != content {
+		c.logf(ctx, "Truncated content from %d to %d tokens (budget %d)", before, countTokens(fitted), budget)
+	} else {
+		c.logf(ctx, "Content token count: %d (budget %d)", before, budget)
+	}
+	return fitted
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCountTokens(t *testing.T) {
+	// Token ids published for cl100k_base by OpenAI's tiktoken
+	tests := []struct {
+		text string
+		want []int
+	}{
+		{"", nil},
+		{"hello world", []int{15339, 1917}},
+		{"tiktoken is great!", []int{83, 1609, 5963, 374, 2294, 0}},
+		{"antidisestablishmentarianism", []int{519, 85342, 34500, 479, 8997, 2191}},
+		{"2 + 2 = 4", []int{17, 489, 220, 17, 284, 220, 19}},
+		{"お誕生日おめでとう", []int{33334, 45918, 243, 21990, 9080, 33334, 62004, 16556, 78699}},
+	}
+	enc, err := loadEncoding()
+	if err != nil {
+		t.Fatalf("loading %s: %v", tokenEncoding, err)
+	}
+	for _, tt := range tests {
+		if got := enc.EncodeOrdinary(tt.text); len(tt.want) > 0 && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("encoding %q = %v, want %v", tt.text, got, tt.want)
+		}
+		if got := countTokens(tt.text); got != len(tt.want) {
+			t.Errorf("countTokens(%q) = %d, want %d", tt.text, got, len(tt.want))
+		}
+	}
+}
+
+func TestTruncateToTokenBudget(t *testing.T) {
+	long := strings.Repeat("alpha beta gamma delta. ", 200)
+	latest := "Please confirm the meeting on Monday.\n"
+	quoted := "On Mon, Jan 5, 2026 Ana wrote:\n" + strings.Repeat("> old quoted text here\n", 200)
+	tests := []struct {
+		name       string
+		content    string
+		budget     int
+		unchanged  bool
+		keepPrefix string
+	}{
+		{"fits", "short email", 100, true, ""},
+		{"cut from the middle", long, 100, false, "alpha beta"},
+		{"quoted history cut first", latest + quoted, 120, false, latest},
+		{"tiny budget", long, 5, false, ""},
+		{"zero budget is not unlimited", long, 0, false, ""},
+		{"negative budget is not unlimited", long, -1, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateToTokenBudget(tt.content, tt.budget)
+			if tt.unchanged {
+				if got != tt.content {
+					t.Errorf("content changed to %q", got)
+				}
+				return
+			}
+			if n := countTokens(got); n > max(tt.budget, 0) {
+				t.Errorf("result has %d tokens, over the budget of %d", n, tt.budget)
+			}
+			if got != "" && !strings.Contains(got, strings.TrimSpace(truncationMarker)) {
+				t.Errorf("result %q has no truncation marker", got)
+			}
+			if !strings.HasPrefix(got, tt.keepPrefix) {
+				t.Errorf("result %q does not keep %q", got, tt.keepPrefix)
+			}
+		})
+	}
+}
+
+func TestCutMiddleRecountsJoinedText(t *testing.T) {
+	// Pieces that merge when joined must not take the result over budget
+	texts := []string{
+		strings.Repeat("ab", 500),
+		strings.Repeat("a b ", 300),
+		strings.Repeat("héllo wörld ", 120),
+		strings.Repeat("x.", 400),
+	}
+	for _, text := range texts {
+		for budget := 1; budget < 60; budget++ {
+			if got := cutMiddle(text, budget); countTokens(got) > budget {
+				t.Fatalf("cutMiddle(%.20q..., %d) has %d tokens", text, budget, countTokens(got))
+			}
+		}
+	}
+}
+
+func TestContentBudgetSafetyMargin(t *testing.T) {
+	window := 10000 - defaultCompletionTokens - promptOverheadTokens
+	tests := []struct {
+		maxInput int
+		window   string
+		want     int
+	}{
+		{1000, "", 900},
+		{0, "deepseek-chat=10000", window - window/10},
+		{100000, "deepseek-chat=10000", window - window/10},
+		{0, "deepseek-chat=4096", minContentTokens - minContentTokens/10},
+		{100, "deepseek-chat=2000", 90},
+	}
+	for _, tt := range tests {
+		t.Setenv("MODEL_CONTEXT_WINDOWS", tt.window)
+		c := newTestClient(t, replying())
+		c.MaxInputTokens = tt.maxInput
+		if got := c.contentBudget(context.Background()); got != tt.want {
+			t.Errorf("contentBudget with MAX_INPUT_TOKENS %d and windows %q = %d, want %d", tt.maxInput, tt.window, got, tt.want)
+		}
+	}
+}
+
+func TestTokenBudgetContentTokens(t *testing.T) {
+	tests := []struct {
+		budget TokenBudget
+		want   int
+	}{
+		{TokenBudget{ContextWindow: 8192, CompletionTokens: 1024, PromptTokens: 512}, 6656},
+		{TokenBudget{ContextWindow: 32768, CompletionTokens: 4096, PromptTokens: 512, ExampleTokens: 2000}, 26160},
+		{TokenBudget{ContextWindow: 65536, CompletionTokens: 4096, PromptTokens: 512}, 60928},
+		{TokenBudget{ContextWindow: 128000, CompletionTokens: 8192, PromptTokens: 1000, ExampleTokens: 500}, 118308},
+		{TokenBudget{ContextWindow: 4096, CompletionTokens: 4096, PromptTokens: 512}, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.budget.ContentTokens(); got != tt.want {
+			t.Errorf("%+v.ContentTokens() = %d, want %d", tt.budget, got, tt.want)
+		}
+	}
+}
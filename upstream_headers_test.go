@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseUpstreamHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		want map[string]string
+	}{
+		{"empty", "", map[string]string{}},
+		{"pairs", "X-Tenant-Id=acme, x-route = eu-1", map[string]string{"X-Tenant-Id": "acme", "X-Route": "eu-1"}},
+		{"value with equals", "X-Token=a=b", map[string]string{"X-Token": "a=b"}},
+		{"malformed skipped", "X-Tenant-Id=acme,broken,=empty", map[string]string{"X-Tenant-Id": "acme"}},
+		{"authorization ignored", "authorization=Bearer evil,X-Route=eu-1", map[string]string{"X-Route": "eu-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseUpstreamHeaders(tt.spec); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseUpstreamHeaders(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		static   map[string]string
+		forward  []string
+		inbound  map[string]string
+		want     map[string]string
+		excluded []string
+	}{
+		{
+			name:   "configured",
+			static: map[string]string{"X-Tenant-Id": "acme"},
+			want:   map[string]string{"X-Tenant-Id": "acme", "Authorization": "Bearer test-key"},
+		},
+		{
+			name:     "forwarded allowlist",
+			forward:  []string{"x-route"},
+			inbound:  map[string]string{"X-Route": "eu-1", "X-Other": "nope"},
+			want:     map[string]string{"X-Route": "eu-1"},
+			excluded: []string{"X-Other"},
+		},
+		{
+			name:    "forwarded overrides configured",
+			static:  map[string]string{"X-Route": "us-1"},
+			forward: []string{"X-Route"},
+			inbound: map[string]string{"X-Route": "eu-1"},
+			want:    map[string]string{"X-Route": "eu-1"},
+		},
+		{
+			name:    "authorization never forwarded",
+			forward: []string{"Authorization"},
+			inbound: map[string]string{"Authorization": "Bearer caller"},
+			want:    map[string]string{"Authorization": "Bearer test-key"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := replying("A short summary.")
+			s := newTestServer(t, upstream)
+			s.client.APIKey = "test-key"
+			s.client.UpstreamHeaders = tt.static
+
+			req := postJSON("/summarize", `{"body":"The launch moves to Friday because QA found a bug."}`)
+			for key, value := range tt.inbound {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+			ForwardHeaders(tt.forward)(http.HandlerFunc(s.SummarizeHandler)).ServeHTTP(rec, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %q", rec.Code, rec.Body.String())
+			}
+			if upstream.calls() != 1 {
+				t.Fatalf("upstream calls = %d, want 1", upstream.calls())
+			}
+			got := upstream.requests[0].Header
+			for key, value := range tt.want {
+				if got.Get(key) != value {
+					t.Errorf("upstream %s = %q, want %q", key, got.Get(key), value)
+				}
+			}
+			for _, key := range tt.excluded {
+				if got.Get(key) != "" {
+					t.Errorf("upstream %s = %q, want it not forwarded", key, got.Get(key))
+				}
+			}
+		})
+	}
+}